@@ -3,20 +3,44 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 
 	"github.com/revelaction/privage/credential"
 	"github.com/revelaction/privage/header"
-	id "github.com/revelaction/privage/identity"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
+// addOptions are the options of the add command.
+type addOptions struct {
+	// from is the path of the file with the content, instead of the label.
+	from string
+
+	// stdin reads the content from the standard input.
+	stdin bool
+}
+
+// source reports whether the options set the source of the content.
+func (ao addOptions) source() bool {
+	return ao.stdin || ao.from != ""
+}
+
 // addCommand is a pure logic worker for adding encrypted files.
 // It assumes that category and label have been validated by the driver in main.go.
-func addCommand(s *setup.Setup, cat string, label string, ui UI) error {
+//
+// The content is read from stdin or from a file, if set in ao. Otherwise, a
+// credential is created from a template, and the content of a custom
+// category is read from the file named after the label.
+func addCommand(s *setup.Setup, cat string, label string, ao addOptions, stdin io.Reader, ui UI) error {
+
+	v, err := vault.New(s)
+	if err != nil {
+		return err
+	}
 
 	// Check label exists
-	exists, err := labelExists(label, s.Id)
+	exists, err := labelExists(v, label)
 	if err != nil {
 		return fmt.Errorf("failed to check if label exists: %w", err)
 	}
@@ -24,26 +48,24 @@ func addCommand(s *setup.Setup, cat string, label string, ui UI) error {
 		return fmt.Errorf("second argument (label) %q already exist", label)
 	}
 
-	h := &header.Header{Label: label}
+	h := &header.Header{Label: label, Category: cat}
 
-	switch cat {
-	case header.CategoryCredential:
-		h.Category = header.CategoryCredential
-		if err := addCredential(h, s, ui); err != nil {
-			return err
-		}
-	default:
-		h.Category = cat
-		if err := addCustomCategory(h, s, ui); err != nil {
-			return err
-		}
+	if cat == header.CategoryCredential && !ao.source() {
+		return addCredential(h, v, s, ui)
 	}
 
-	return nil
+	switch {
+	case ao.stdin:
+		return addContent(h, v, stdin, ui)
+	case ao.from != "":
+		return addFile(h, v, ao.from, ui)
+	default:
+		return addFile(h, v, label, ui)
+	}
 }
 
 // addCredential creates a encrypted credential file in the repository directory.
-func addCredential(h *header.Header, s *setup.Setup, ui UI) error {
+func addCredential(h *header.Header, v *vault.Vault, s *setup.Setup, ui UI) error {
 
 	cred, err := credential.New(s.C)
 	if err != nil {
@@ -55,7 +77,7 @@ func addCredential(h *header.Header, s *setup.Setup, ui UI) error {
 		return fmt.Errorf("could not encode credential: %w", err)
 	}
 
-	err = encryptSave(h, "", &buf, s)
+	err = v.Put(h, &buf)
 	if err != nil {
 		return err
 	}
@@ -76,11 +98,10 @@ func addCredential(h *header.Header, s *setup.Setup, ui UI) error {
 	return nil
 }
 
-// addCustomCategory creates an encrypted file of the contents of a file
-// present in the repository directory
-func addCustomCategory(h *header.Header, s *setup.Setup, ui UI) (err error) {
+// addFile creates an encrypted file of the contents of the file in path.
+func addFile(h *header.Header, v *vault.Vault, path string, ui UI) (err error) {
 
-	content, err := os.Open(h.Label)
+	content, err := os.Open(path)
 	if err != nil {
 		return err
 	}
@@ -90,8 +111,25 @@ func addCustomCategory(h *header.Header, s *setup.Setup, ui UI) (err error) {
 		}
 	}()
 
-	err = encryptSave(h, "", content, s)
-	if err != nil {
+	return addContent(h, v, content, ui)
+}
+
+// addContent creates an encrypted file of the content. The content of a
+// credential must be a valid credential file.
+func addContent(h *header.Header, v *vault.Vault, content io.Reader, ui UI) error {
+
+	if h.IsCredential() {
+		data, err := io.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		if err := credential.Validate(bytes.NewReader(data)); err != nil {
+			return fmt.Errorf("the content of the credential %s is not a valid credential file: %w", h.Label, err)
+		}
+		content = bytes.NewReader(data)
+	}
+
+	if err := v.Put(h, content); err != nil {
 		return err
 	}
 
@@ -100,21 +138,14 @@ func addCustomCategory(h *header.Header, s *setup.Setup, ui UI) (err error) {
 	return nil
 }
 
-func labelExists(label string, identity id.Identity) (bool, error) {
-	labels := map[string]struct{}{}
-
-	ch, err := headerGenerator(".", identity)
-	if err != nil {
-		return false, err
-	}
-	for h := range ch {
-		if _, ok := labels[h.Label]; !ok {
-			labels[h.Label] = struct{}{}
+// labelExists reports whether a file of any category with the bare label is
+// present in the vault.
+func labelExists(v *vault.Vault, label string) (bool, error) {
+	for h, err := range v.Headers() {
+		if err != nil {
+			return false, err
 		}
-	}
-
-	for k := range labels {
-		if k == label {
+		if h.Err == nil && h.Label == label {
 			return true, nil
 		}
 	}
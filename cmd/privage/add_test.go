@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -41,13 +42,13 @@ func TestAddCommand_CredentialSuccess(t *testing.T) {
 	var outBuf, errBuf bytes.Buffer
 	ui := UI{Out: &outBuf, Err: &errBuf}
 
-	err := addCommand(th.Setup, "credential", "my-cred", ui)
+	err := addCommand(th.Setup, "credential", "my-cred", addOptions{}, nil, ui)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	// Verify it was created
-	exists, err := labelExists("my-cred", th.Id)
+	exists, err := labelExists(th.Vault(), "my-cred")
 	if err != nil {
 		t.Fatalf("labelExists failed: %v", err)
 	}
@@ -68,7 +69,7 @@ func TestAddCommand_InstructionOutput(t *testing.T) {
 	var outBuf, errBuf bytes.Buffer
 	ui := UI{Out: &outBuf, Err: &errBuf}
 
-	err := addCommand(th.Setup, "credential", "my-cred", ui)
+	err := addCommand(th.Setup, "credential", "my-cred", addOptions{}, nil, ui)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -102,13 +103,13 @@ func TestAddCommand_CustomCategorySuccess(t *testing.T) {
 	var outBuf, errBuf bytes.Buffer
 	ui := UI{Out: &outBuf, Err: &errBuf}
 
-	err := addCommand(th.Setup, "my-cat", fileName, ui)
+	err := addCommand(th.Setup, "my-cat", fileName, addOptions{}, nil, ui)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
 	// Verify it was created
-	exists, err := labelExists(fileName, th.Id)
+	exists, err := labelExists(th.Vault(), fileName)
 	if err != nil {
 		t.Fatalf("labelExists failed: %v", err)
 	}
@@ -130,7 +131,7 @@ func TestAddCommand_LabelAlreadyExists(t *testing.T) {
 	var outBuf, errBuf bytes.Buffer
 	ui := UI{Out: &outBuf, Err: &errBuf}
 
-	err := addCommand(th.Setup, "credential", "existing", ui)
+	err := addCommand(th.Setup, "credential", "existing", addOptions{}, nil, ui)
 	if err == nil {
 		t.Fatal("expected error when adding existing label")
 	}
@@ -147,7 +148,7 @@ func TestAddCommand_CustomCategoryFileNotFound(t *testing.T) {
 	var outBuf, errBuf bytes.Buffer
 	ui := UI{Out: &outBuf, Err: &errBuf}
 
-	err := addCommand(th.Setup, "my-cat", "non-existent", ui)
+	err := addCommand(th.Setup, "my-cat", "non-existent", addOptions{}, nil, ui)
 	if err == nil {
 		t.Fatal("expected error for non-existent file")
 	}
@@ -156,3 +157,86 @@ func TestAddCommand_CustomCategoryFileNotFound(t *testing.T) {
 		t.Errorf("expected not exist error, got: %v", err)
 	}
 }
+
+func TestAddCommand_Source(t *testing.T) {
+	const validCred = "login = \"me\"\npassword = \"secret\"\n"
+
+	tests := []struct {
+		name    string
+		cat     string
+		ao      addOptions
+		stdin   string
+		wantErr string
+		want    string
+	}{
+		{
+			name:  "Stdin",
+			cat:   "backups",
+			ao:    addOptions{stdin: true},
+			stdin: "dump content",
+			want:  "dump content",
+		},
+		{
+			name: "From",
+			cat:  "backups",
+			ao:   addOptions{from: "source.txt"},
+			want: "file content",
+		},
+		{
+			name:    "FromNotFound",
+			cat:     "backups",
+			ao:      addOptions{from: "missing.txt"},
+			wantErr: "no such file",
+		},
+		{
+			name:  "CredentialStdin",
+			cat:   "credential",
+			ao:    addOptions{stdin: true},
+			stdin: validCred,
+			want:  validCred,
+		},
+		{
+			name:    "InvalidCredentialStdin",
+			cat:     "credential",
+			ao:      addOptions{stdin: true},
+			stdin:   "login =",
+			wantErr: "not a valid credential file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := NewTestHelper(t)
+			// The source file is outside of the repository
+			source := filepath.Join(t.TempDir(), "source.txt")
+			if err := os.WriteFile(source, []byte("file content"), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if tt.ao.from != "" {
+				tt.ao.from = filepath.Join(filepath.Dir(source), tt.ao.from)
+			}
+
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+
+			err := addCommand(th.Setup, tt.cat, "db.sql", tt.ao, strings.NewReader(tt.stdin), ui)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+				}
+				if exists, _ := labelExists(th.Vault(), "db.sql"); exists {
+					t.Error("expected no encrypted file")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			checkContent(t, th, "db.sql", tt.cat, tt.want)
+			if _, err := os.Stat(filepath.Join(th.Repository, "db.sql")); !os.IsNotExist(err) {
+				t.Errorf("expected no plaintext file in the repository, got %v", err)
+			}
+		})
+	}
+}
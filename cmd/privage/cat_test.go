@@ -22,6 +22,24 @@ func TestCatCommand(t *testing.T) {
 			label:          "secret.txt",
 			expectedOutput: "real secret content",
 		},
+		{
+			name: "Qualified Label",
+			setupData: func(th *TestHelper) {
+				th.AddEncryptedFile("secret.txt", "work", "work secret")
+				th.AddEncryptedFile("secret.txt", "home", "home secret")
+			},
+			label:          "home/secret.txt",
+			expectedOutput: "home secret",
+		},
+		{
+			name: "Ambiguous Label",
+			setupData: func(th *TestHelper) {
+				th.AddEncryptedFile("secret.txt", "work", "work secret")
+				th.AddEncryptedFile("secret.txt", "home", "home secret")
+			},
+			label:       "secret.txt",
+			expectedErr: ErrAmbiguousLabel,
+		},
 		{
 			name: "Label Not Found",
 			setupData: func(th *TestHelper) {
@@ -2,48 +2,39 @@ package main
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/revelaction/privage/credential"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 // clipboardCommand copies the password field of a credential file to the clipboard
 func clipboardCommand(s *setup.Setup, label string, ui UI) (err error) {
-	if s.Id.Id == nil {
-		return fmt.Errorf("found no privage key file: %w", s.Id.Err)
+	v, err := vault.New(s)
+	if err != nil {
+		return err
 	}
 
-	ch, err := headerGenerator(s.Repository, s.Id)
+	h, err := v.Get(label)
 	if err != nil {
 		return err
 	}
-	for h := range ch {
-		if h.Label == label {
-			f, err := os.Open(h.Path)
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-
-			r, err := contentReader(f, s.Id)
-			if err != nil {
-				return err
-			}
-
-			if err := credential.CopyClipboard(r); err != nil {
-				return err
-			}
-
-			_, _ = fmt.Fprintf(ui.Err, "The password for `%s` is in the clipboard\n", label)
-
-			return nil
+
+	r, err := v.Open(h)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
 		}
+	}()
+
+	if err := credential.CopyClipboard(r); err != nil {
+		return err
 	}
 
+	_, _ = fmt.Fprintf(ui.Err, "The password for `%s` is in the clipboard\n", label)
+
 	return nil
 }
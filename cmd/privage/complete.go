@@ -9,6 +9,7 @@ import (
 	"github.com/revelaction/privage/credential"
 	"github.com/revelaction/privage/header"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 var commands = []string{
@@ -17,13 +18,21 @@ var commands = []string{
 	"status",
 	"add",
 	"delete",
+	"mv",
+	"cp",
+	"recat",
 	"list",
 	"show",
 	"cat",
 	"clipboard",
 	"decrypt",
+	"edit",
 	"reencrypt",
 	"rotate",
+	"recipients",
+	"migrate",
+	"verify",
+	"fsck",
 	"bash",
 	"version",
 	"help",
@@ -54,7 +63,9 @@ func completeCommand(opts setup.Options, args []string, ui UI) error {
 	// Decouple the completion logic from file system and encryption
 	// dependencies by injecting dependencies via functions
 	listHeaders := func() ([]*header.Header, error) {
-		s, err := setupEnv(opts)
+		// Completion never prompts for the passphrase of the identity
+		opts.NoPrompt = true
+		s, err := setupEnv(opts, ui)
 		if err != nil {
 			return nil, err
 		}
@@ -62,15 +73,11 @@ func completeCommand(opts setup.Options, args []string, ui UI) error {
 			return nil, nil
 		}
 
-		ch, err := headerGenerator(s.Repository, s.Id)
+		v, err := vault.New(s)
 		if err != nil {
 			return nil, err
 		}
-		var headers []*header.Header
-		for h := range ch {
-			headers = append(headers, h)
-		}
-		return headers, nil
+		return v.List()
 	}
 
 	listFiles := func() ([]string, error) {
@@ -103,7 +110,7 @@ func getCompletions(args []string, listHeaders func() ([]*header.Header, error),
 			trimmed := strings.TrimLeft(arg, "-")
 			// TODO: Sync these cases with global flags defined in main.go
 			switch trimmed {
-			case "k", "key", "c", "conf", "p", "piv-slot", "r", "repository":
+			case "k", "key", "c", "conf", "p", "piv-slot", "piv-serial", "r", "repository", "wait":
 				commandIndex++ // Skip the flag value
 			}
 			continue
@@ -116,14 +123,7 @@ func getCompletions(args []string, listHeaders func() ([]*header.Header, error),
 	// 2. Decide what to complete based on cursor position relative to command position
 	if cursorIndex == commandIndex {
 		// User is typing the command itself
-		lastWord := args[cursorIndex]
-		var completions []string
-		for _, c := range commands {
-			if strings.HasPrefix(c, lastWord) {
-				completions = append(completions, c)
-			}
-		}
-		return completions, nil
+		return completeFromList(commands, args[cursorIndex]), nil
 	}
 
 	if cursorIndex > commandIndex {
@@ -146,7 +146,7 @@ func getCompletions(args []string, listHeaders func() ([]*header.Header, error),
 				return completeCredentialFields(headers, label, lastWord), nil
 			}
 			return nil, nil
-		case "cat", "delete", "clipboard", "decrypt":
+		case "cat", "delete", "clipboard", "decrypt", "edit":
 			headers, err := listHeaders()
 			if err != nil {
 				return nil, nil
@@ -158,6 +158,32 @@ func getCompletions(args []string, listHeaders func() ([]*header.Header, error),
 				return nil, nil
 			}
 			return completeCategoriesAndLabels(headers, lastWord), nil
+		case "mv", "cp":
+			if cursorIndex-commandIndex != 1 {
+				return nil, nil
+			}
+			headers, err := listHeaders()
+			if err != nil {
+				return nil, nil
+			}
+			return completeLabels(headers, lastWord), nil
+		case "recat":
+			headers, err := listHeaders()
+			if err != nil {
+				return nil, nil
+			}
+			switch cursorIndex - commandIndex {
+			case 1:
+				return completeLabels(headers, lastWord), nil
+			case 2:
+				return completeCategories(headers, lastWord), nil
+			}
+			return nil, nil
+		case "recipients":
+			if cursorIndex-commandIndex == 1 {
+				return completeFromList([]string{"add", "remove"}, lastWord), nil
+			}
+			return nil, nil
 		case "add":
 			// We ignore header errors to allow at least "credential" completion
 			headers, _ := listHeaders()
@@ -170,26 +196,41 @@ func getCompletions(args []string, listHeaders func() ([]*header.Header, error),
 	return nil, nil
 }
 
-func completeLabels(headers []*header.Header, prefix string) []string {
+func completeFromList(list []string, prefix string) []string {
 	var completions []string
-	for _, h := range headers {
-		if strings.HasPrefix(h.Label, prefix) {
-			completions = append(completions, h.Label)
+	for _, c := range list {
+		if strings.HasPrefix(c, prefix) {
+			completions = append(completions, c)
 		}
 	}
 	return completions
 }
 
-func completeCredentialFields(headers []*header.Header, label string, prefix string) []string {
-	var isCred bool
+// completeLabels returns the labels starting with prefix. A label of
+// several categories is completed in its qualified forms, category/label, as
+// are the labels whose qualified form starts with prefix.
+func completeLabels(headers []*header.Header, prefix string) []string {
+	counts := map[string]int{}
+	for _, h := range headers {
+		counts[h.Label]++
+	}
+
+	var completions []string
 	for _, h := range headers {
-		if h.Label == label {
-			isCred = h.IsCredential()
-			break
+		q := h.QualifiedLabel()
+		switch {
+		case strings.HasPrefix(h.Label, prefix) && counts[h.Label] == 1:
+			completions = append(completions, h.Label)
+		case strings.HasPrefix(h.Label, prefix), strings.HasPrefix(q, prefix):
+			completions = append(completions, q)
 		}
 	}
+	return completions
+}
 
-	if !isCred {
+func completeCredentialFields(headers []*header.Header, label string, prefix string) []string {
+	h, err := vault.Find(headers, label)
+	if err != nil || !h.IsCredential() {
 		return nil
 	}
 
@@ -222,35 +263,52 @@ func completeCategoriesAndLabels(headers []*header.Header, prefix string) []stri
 	return completions
 }
 
+// completeCategories returns the categories of the headers, and the
+// credential category, starting with prefix.
+func completeCategories(headers []*header.Header, prefix string) []string {
+	var completions []string
+	categories := map[string]struct{}{}
+	for _, h := range headers {
+		categories[h.Category] = struct{}{}
+	}
+	for cat := range categories {
+		if cat != header.CategoryCredential && strings.HasPrefix(cat, prefix) {
+			completions = append(completions, cat)
+		}
+	}
+	// Always suggest credential
+	if strings.HasPrefix(header.CategoryCredential, prefix) {
+		completions = append(completions, header.CategoryCredential)
+	}
+	return completions
+}
+
 func completeAdd(headers []*header.Header, files []string, args []string, commandIndex int, prefix string) []string {
 	// args[commandIndex] is "add"
-	// args[commandIndex+1] is category
-	// args[commandIndex+2] is label
+	// then the category and the label, and the options anywhere
+
+	relativeIndex := 1
+	fromValue := false
+	for _, arg := range args[commandIndex+1 : len(args)-1] {
+		switch {
+		case fromValue:
+			fromValue = false
+		case arg == "-from" || arg == "--from":
+			fromValue = true
+		case !strings.HasPrefix(arg, "-"):
+			relativeIndex++
+		}
+	}
 
-	relativeIndex := len(args) - 1 - commandIndex
+	if strings.HasPrefix(prefix, "-") {
+		return completeFromList([]string{"--from", "--stdin"}, prefix)
+	}
 
-	if relativeIndex == 1 {
-		var completions []string
-		// complete categories
-		if headers != nil {
-			categories := map[string]struct{}{}
-			for _, h := range headers {
-				categories[h.Category] = struct{}{}
-			}
-			for cat := range categories {
-				if strings.HasPrefix(cat, prefix) {
-					completions = append(completions, cat)
-				}
-			}
-		}
-		// Always suggest credential
-		if strings.HasPrefix(header.CategoryCredential, prefix) {
-			completions = append(completions, header.CategoryCredential)
-		}
-		return completions
+	if relativeIndex == 1 && !fromValue {
+		return completeCategories(headers, prefix)
 	}
 
-	if relativeIndex == 2 {
+	if relativeIndex == 2 || fromValue {
 		var completions []string
 		// suggest files in current directory
 		for _, f := range files {
@@ -292,7 +350,7 @@ func filesForAddCmd(root string) ([]string, error) {
 		}
 
 		ext := filepath.Ext(d.Name())
-		if ext == PrivageExtension {
+		if ext == vault.Extension {
 			return nil
 		}
 
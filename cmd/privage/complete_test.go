@@ -28,6 +28,18 @@ func TestCompleteCommand(t *testing.T) {
 			args:      []string{"--", "privage", "ve"},
 			contains:  []string{"version"},
 		},
+		{
+			name:      "Command completion (after global flag with value)",
+			setupData: func(th *TestHelper) {},
+			args:      []string{"--", "privage", "--wait", "30s", "ve"},
+			contains:  []string{"version", "verify"},
+		},
+		{
+			name:      "Recipients Action",
+			setupData: func(th *TestHelper) {},
+			args:      []string{"--", "privage", "recipients", "re"},
+			contains:  []string{"remove"},
+		},
 		{
 			name: "Show Label",
 			setupData: func(th *TestHelper) {
@@ -63,6 +75,69 @@ func TestCompleteCommand(t *testing.T) {
 			args:     []string{"--", "privage", "add", "work", "loc"},
 			contains: []string{"local.txt"},
 		},
+		{
+			name: "Mv Label",
+			setupData: func(th *TestHelper) {
+				th.AddEncryptedFile("mycred", "credential", "pass")
+			},
+			args:     []string{"--", "privage", "mv", "my"},
+			contains: []string{"mycred"},
+		},
+		{
+			name: "Label of several categories",
+			setupData: func(th *TestHelper) {
+				th.AddEncryptedFile("notes", "work", "doc")
+				th.AddEncryptedFile("notes", "home", "doc")
+				th.AddEncryptedFile("nothing", "home", "doc")
+			},
+			args:     []string{"--", "privage", "cat", "no"},
+			contains: []string{"work/notes", "home/notes", "nothing"},
+		},
+		{
+			name: "Qualified Label",
+			setupData: func(th *TestHelper) {
+				th.AddEncryptedFile("notes", "work", "doc")
+			},
+			args:     []string{"--", "privage", "cat", "work/"},
+			contains: []string{"work/notes"},
+		},
+		{
+			name: "Show Field (Qualified Credential)",
+			setupData: func(th *TestHelper) {
+				th.AddEncryptedFile("mysite", "credential", "login = \"me\"")
+				th.AddEncryptedFile("mysite", "work", "doc")
+			},
+			args:     []string{"--", "privage", "show", "credential/mysite", "pass"},
+			contains: []string{"password"},
+		},
+		{
+			name: "Recat Category",
+			setupData: func(th *TestHelper) {
+				th.AddEncryptedFile("work_stuff", "work", "doc")
+			},
+			args:     []string{"--", "privage", "recat", "work_stuff", "wo"},
+			contains: []string{"work"},
+		},
+		{
+			name: "Add File (After Option)",
+			setupData: func(th *TestHelper) {
+				th.AddFile("local.txt")
+			},
+			args:     []string{"--", "privage", "add", "--from", "loc"},
+			contains: []string{"local.txt"},
+		},
+		{
+			name:      "Add Category (After Option)",
+			setupData: func(th *TestHelper) {},
+			args:      []string{"--", "privage", "add", "--stdin", "cred"},
+			contains:  []string{"credential"},
+		},
+		{
+			name:      "Add Option",
+			setupData: func(th *TestHelper) {},
+			args:      []string{"--", "privage", "add", "work", "db.sql", "--st"},
+			contains:  []string{"--stdin"},
+		},
 		{
 			name: "Show Field (Credential)",
 			setupData: func(th *TestHelper) {
@@ -2,77 +2,64 @@ package main
 
 import (
 	"bufio"
-	"errors"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
-var ErrHeaderNotFound = errors.New("header not found")
-
 // decryptCommand decrypts an encrypted file
 func decryptCommand(s *setup.Setup, label string, ui UI) (retErr error) {
-	if s.Id.Id == nil {
-		return fmt.Errorf("found no privage key file: %w", s.Id.Err)
+	v, err := vault.New(s)
+	if err != nil {
+		return err
 	}
 
-	found := false
-	ch, err := headerGenerator(s.Repository, s.Id)
+	h, err := v.Get(label)
 	if err != nil {
 		return err
 	}
-	for h := range ch {
-		if h.Label == label {
-			found = true
-			w, err := os.Create(filepath.Join(s.Repository, label))
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if err := w.Close(); err != nil && retErr == nil {
-					retErr = err
-				}
-			}()
-
-			f, err := os.Open(h.Path)
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && retErr == nil {
-					retErr = cerr
-				}
-			}()
 
-			r, err := contentReader(f, s.Id)
-			if err != nil {
-				return err
-			}
+	r, err := v.Open(h)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && retErr == nil {
+			retErr = cerr
+		}
+	}()
 
-			bufFile := bufio.NewWriter(w)
+	path, ok := v.PlaintextPath(h)
+	if !ok {
+		return fmt.Errorf("the label %q is not a file name in the repository: copy the file to another label with \"privage cp\"", h.Label)
+	}
 
-			if _, err := io.Copy(bufFile, r); err != nil {
-				if err != io.ErrUnexpectedEOF {
-					return err
-				}
-			}
+	w, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := w.Close(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}()
 
-			if err := bufFile.Flush(); err != nil {
-				return err
-			}
+	bufFile := bufio.NewWriter(w)
 
-			break
+	if _, err := io.Copy(bufFile, r); err != nil {
+		if err != io.ErrUnexpectedEOF {
+			return err
 		}
 	}
 
-	if !found {
-		return fmt.Errorf("file %q not found in directory", label)
+	if err := bufFile.Flush(); err != nil {
+		return err
 	}
 
-	_, _ = fmt.Fprintf(ui.Err, "The file %s was decrypted in the directory %s.\n", label, s.Repository)
+	_, _ = fmt.Fprintf(ui.Err, "The file %s was decrypted in the directory %s.\n", h.Label, s.Repository)
 	_, _ = fmt.Fprintln(ui.Err)
 	_, _ = fmt.Fprintln(ui.Err, "(Use \"privage reencrypt --force\" to reencrypt all decrypted files)")
 	_, _ = fmt.Fprintln(ui.Err, "(Use \"privage reencrypt --clean\" to reencrypt and delete all decrypted files)")
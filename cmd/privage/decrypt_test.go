@@ -11,6 +11,7 @@ import (
 	"github.com/revelaction/privage/header"
 	"github.com/revelaction/privage/identity"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 func TestDecryptCommand(t *testing.T) {
@@ -21,7 +22,7 @@ func TestDecryptCommand(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := identity.GenerateAge(f); err != nil {
+	if err := identity.GenerateAge(f, false); err != nil {
 		t.Fatal(err)
 	}
 	if err := f.Close(); err != nil {
@@ -45,8 +46,12 @@ func TestDecryptCommand(t *testing.T) {
 	// 2. Encrypt a file
 	label := "target.txt"
 	content := "decrypted payload"
+	v, err := vault.New(s)
+	if err != nil {
+		t.Fatal(err)
+	}
 	h := &header.Header{Label: label}
-	if err := encryptSave(h, "", strings.NewReader(content), s); err != nil {
+	if err := v.Put(h, strings.NewReader(content)); err != nil {
 		t.Fatal(err)
 	}
 
@@ -92,3 +97,41 @@ func TestDecryptCommand_NotFound(t *testing.T) {
 		t.Errorf("expected key error, got %v", err)
 	}
 }
+
+func TestDecryptCommand_QualifiedLabel(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("target.txt", "work", "work payload")
+	th.AddEncryptedFile("target.txt", "home", "home payload")
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+
+	if err := decryptCommand(th.Setup, "home/target.txt", ui); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The file is named after the bare label
+	data, err := os.ReadFile(filepath.Join(th.Repository, "target.txt"))
+	if err != nil {
+		t.Fatalf("failed to read decrypted file: %v", err)
+	}
+	if string(data) != "home payload" {
+		t.Errorf("got content %q, want %q", data, "home payload")
+	}
+}
+
+func TestDecryptCommand_LabelNotLocal(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("../escape.txt", "work", "payload")
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+
+	err := decryptCommand(th.Setup, "../escape.txt", ui)
+	if err == nil || !strings.Contains(err.Error(), "is not a file name in the repository") {
+		t.Fatalf("expected label error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(th.Repository, "..", "escape.txt")); !os.IsNotExist(err) {
+		t.Errorf("expected no file outside the repository, got %v", err)
+	}
+}
@@ -25,18 +25,11 @@ func TestDeleteCommand_Success(t *testing.T) {
 	}
 
 	// Verify file is actually gone
-	found := false
-	ch, err := headerGenerator(th.Repository, th.Id)
+	exists, err := labelExists(th.Vault(), label)
 	if err != nil {
-		t.Fatalf("headerGenerator failed: %v", err)
+		t.Fatalf("labelExists failed: %v", err)
 	}
-	for h := range ch {
-		if h.Label == label {
-			found = true
-			break
-		}
-	}
-	if found {
+	if exists {
 		t.Error("expected file to be deleted, but it still exists")
 	}
 }
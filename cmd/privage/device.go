@@ -0,0 +1,19 @@
+//go:build !softpiv
+
+package main
+
+import (
+	"github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/identity/piv/yubikey"
+)
+
+// newPivDevice opens the yubikey with the serial number, or the first one
+// if serial is zero.
+func newPivDevice(serial uint32) (identity.Device, error) {
+	return yubikey.New(serial)
+}
+
+// pivDevices describes the detected yubikeys.
+func pivDevices() ([]identity.CardInfo, error) {
+	return yubikey.Devices()
+}
@@ -0,0 +1,38 @@
+//go:build softpiv
+
+package main
+
+import (
+	"os"
+
+	"github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/identity/piv/softpiv"
+	"github.com/revelaction/privage/identity/piv/yubikey"
+)
+
+// softPivEnv is the environment variable with the directory of the
+// software PIV devices used instead of the yubikeys, see package softpiv.
+// It is only supported in builds with the softpiv tag, for tests and CI: the
+// keys of the software devices are plain files.
+const softPivEnv = "PRIVAGE_SOFTPIV_DIR"
+
+// newPivDevice opens the yubikey with the serial number, or the first one
+// if serial is zero. With softPivEnv, it opens a software PIV device
+// instead.
+func newPivDevice(serial uint32) (identity.Device, error) {
+	if dir := os.Getenv(softPivEnv); dir != "" {
+		return softpiv.New(dir, serial)
+	}
+
+	return yubikey.New(serial)
+}
+
+// pivDevices describes the detected yubikeys, or the software PIV devices
+// with softPivEnv.
+func pivDevices() ([]identity.CardInfo, error) {
+	if dir := os.Getenv(softPivEnv); dir != "" {
+		return softpiv.Devices(dir)
+	}
+
+	return yubikey.Devices()
+}
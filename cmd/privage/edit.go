@@ -0,0 +1,199 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/revelaction/privage/credential"
+	"github.com/revelaction/privage/header"
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+const (
+	// editorEnv is the environment variable with the editor command of
+	// edit, with its arguments.
+	editorEnv = "EDITOR"
+
+	// defaultEditor is the editor if editorEnv is not set.
+	defaultEditor = "vi"
+)
+
+// memoryDirs returns the directories of memory file systems where edit
+// writes the decrypted file, in order of preference.
+func memoryDirs() []string {
+	return []string{os.Getenv("XDG_RUNTIME_DIR"), "/dev/shm"}
+}
+
+// editCommand decrypts the file of the label to a temporary file in a memory
+// file system, and opens it with the editor of the EDITOR environment
+// variable. If the content was changed, it is reencrypted. A credential is
+// validated first: if it is not valid, the editor is opened again if
+// confirmed in r. The temporary file is overwritten and removed.
+func editCommand(s *setup.Setup, label string, r io.Reader, ui UI) (err error) {
+	v, err := vault.New(s)
+	if err != nil {
+		return err
+	}
+
+	h, err := v.Get(label)
+	if err != nil {
+		return err
+	}
+
+	content, err := readContent(v, h)
+	if err != nil {
+		return err
+	}
+
+	dir, err := memoryDir()
+	if err != nil {
+		return err
+	}
+
+	tmpDir, err := os.MkdirTemp(dir, "privage-edit-")
+	if err != nil {
+		return fmt.Errorf("could not create temporary directory: %w", err)
+	}
+	defer func() {
+		err = errors.Join(err, wipeDir(tmpDir))
+	}()
+
+	name := filepath.Base(h.Label)
+	if h.IsCredential() {
+		name += ".toml"
+	}
+	path := filepath.Join(tmpDir, name)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		return fmt.Errorf("could not write temporary file: %w", err)
+	}
+
+	answers := bufio.NewScanner(r)
+	for {
+		if err := runEditor(path, ui); err != nil {
+			return err
+		}
+
+		edited, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read temporary file: %w", err)
+		}
+
+		if sha256.Sum256(edited) == sha256.Sum256(content) {
+			_, _ = fmt.Fprintf(ui.Err, "The file %s was not modified.\n", label)
+			return nil
+		}
+
+		if h.IsCredential() {
+			if verr := credential.Validate(bytes.NewReader(edited)); verr != nil {
+				_, _ = fmt.Fprintf(ui.Err, "💥 Invalid credential file %s. toml error: %v\n", label, verr)
+				_, _ = fmt.Fprint(ui.Err, "Edit it again? [Y/n] ")
+				if answers.Scan() && !strings.EqualFold(strings.TrimSpace(answers.Text()), "n") {
+					continue
+				}
+				return fmt.Errorf("the changes of the invalid credential file %s were discarded", label)
+			}
+		}
+
+		// The content type is detected again from the edited content
+		hv := *h
+		hv.ContentType = ""
+		if err := v.Replace(&hv, bytes.NewReader(edited)); err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(ui.Err, "🔐 The file %s was reencrypted ✔️\n", label)
+		return nil
+	}
+}
+
+// readContent returns the decrypted content of the file of header h.
+func readContent(v *vault.Vault, h *header.Header) (content []byte, err error) {
+	r, err := v.Open(h)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return io.ReadAll(r)
+}
+
+// memoryDir returns the first existing directory of memoryDirs. The
+// decrypted file is never written to a disk.
+func memoryDir() (string, error) {
+	for _, dir := range memoryDirs() {
+		if dir == "" {
+			continue
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir, nil
+		}
+	}
+
+	return "", errors.New("found no memory file system for the decrypted file: set XDG_RUNTIME_DIR")
+}
+
+// runEditor opens the file in path with the editor.
+func runEditor(path string, ui UI) error {
+	editor := strings.Fields(os.Getenv(editorEnv))
+	if len(editor) == 0 {
+		editor = []string{defaultEditor}
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = ui.Out
+	cmd.Stderr = ui.Err
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("the editor %s failed: %w", editor[0], err)
+	}
+
+	return nil
+}
+
+// wipeDir overwrites with zeros the files in dir, including the ones the
+// editor may have created, f. ex. backup or swap files, and removes it.
+func wipeDir(dir string) error {
+	var errs []error
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		errs = append(errs, wipeFile(path))
+		return nil
+	})
+
+	errs = append(errs, os.RemoveAll(dir))
+	return errors.Join(errs...)
+}
+
+// wipeFile overwrites the file in path with zeros.
+func wipeFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err == nil {
+		_, err = f.Write(make([]byte, info.Size()))
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+
+	return errors.Join(err, f.Close())
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/vault"
+)
+
+// editorScript is an editor that writes the content of the file in
+// $EDIT_SOURCE to the edited file, then the content of $EDIT_SOURCE.next in
+// the next run, and logs the path of the edited file in $EDIT_LOG.
+const editorScript = `#!/bin/sh
+cat "$EDIT_SOURCE" > "$1"
+if [ -f "$EDIT_SOURCE.next" ]; then mv "$EDIT_SOURCE.next" "$EDIT_SOURCE"; fi
+echo "$1" >> "$EDIT_LOG"
+`
+
+// setupEditor sets the editor to editorScript, writing the contents, and
+// returns the path of its log file.
+func setupEditor(t *testing.T, contents ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+
+	editor := filepath.Join(dir, "editor.sh")
+	if err := os.WriteFile(editor, []byte(editorScript), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	source := filepath.Join(dir, "source")
+	for i, content := range contents {
+		path := source
+		if i > 0 {
+			path += ".next"
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	log := filepath.Join(dir, "log")
+	t.Setenv(editorEnv, editor)
+	t.Setenv("EDIT_SOURCE", source)
+	t.Setenv("EDIT_LOG", log)
+	t.Setenv("XDG_RUNTIME_DIR", t.TempDir())
+	return log
+}
+
+// editedPaths returns the paths of the files opened by the editor.
+func editedPaths(t *testing.T, log string) []string {
+	t.Helper()
+	data, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Fields(string(data))
+}
+
+func TestEditCommand(t *testing.T) {
+	const valid = "login = \"new\"\npassword = \"secret\"\n"
+
+	tests := []struct {
+		name     string
+		category string
+		// contents are the contents written by the editor in each run.
+		contents []string
+		answers  string
+		wantErr  string
+		want     string
+		runs     int
+	}{
+		{
+			name:     "Modified",
+			category: "work",
+			contents: []string{"new content"},
+			want:     "new content",
+			runs:     1,
+		},
+		{
+			name:     "Unmodified",
+			category: "work",
+			contents: []string{"content"},
+			want:     "content",
+			runs:     1,
+		},
+		{
+			name:     "Credential",
+			category: "credential",
+			contents: []string{valid},
+			want:     valid,
+			runs:     1,
+		},
+		{
+			name:     "InvalidCredentialEditedAgain",
+			category: "credential",
+			contents: []string{"login = ", valid},
+			answers:  "\n",
+			want:     valid,
+			runs:     2,
+		},
+		{
+			name:     "InvalidCredentialDiscarded",
+			category: "credential",
+			contents: []string{"login = "},
+			answers:  "n\n",
+			wantErr:  "were discarded",
+			want:     "content",
+			runs:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := NewTestHelper(t)
+			th.AddEncryptedFile("label", tt.category, "content")
+			log := setupEditor(t, tt.contents...)
+
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			err := editCommand(th.Setup, "label", strings.NewReader(tt.answers), ui)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+
+			v := th.Vault()
+			h, err := v.Get("label")
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := readContent(v, h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tt.want {
+				t.Errorf("got content %q, want %q", content, tt.want)
+			}
+
+			paths := editedPaths(t, log)
+			if len(paths) != tt.runs {
+				t.Fatalf("expected %d runs of the editor, got %d", tt.runs, len(paths))
+			}
+			if !strings.HasPrefix(paths[0], os.Getenv("XDG_RUNTIME_DIR")) {
+				t.Errorf("expected the file in XDG_RUNTIME_DIR, got %s", paths[0])
+			}
+			if _, err := os.Stat(filepath.Dir(paths[0])); !os.IsNotExist(err) {
+				t.Errorf("expected the temporary directory to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestEditCommand_RecipientsChanged tests that the edited file replaces the
+// file written for other recipients.
+func TestEditCommand_RecipientsChanged(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("label", "work", "content")
+	setupEditor(t, "new content")
+
+	mate, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := th.Vault().Recipients()[0]
+	if err := vault.WriteRecipientsFile(th.Repository, []string{own, mate.Recipient().String()}); err != nil {
+		t.Fatal(err)
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+	if err := editCommand(th.Setup, "label", strings.NewReader(""), ui); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	headers, err := th.Vault().List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(headers))
+	}
+	checkContent(t, th, "label", "work", "new content")
+}
+
+// TestEditCommand_ContentType tests that the content type is detected from
+// the edited content.
+func TestEditCommand_ContentType(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("label", "work", "content")
+	setupEditor(t, "%PDF-1.7\n")
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+	if err := editCommand(th.Setup, "label", strings.NewReader(""), ui); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h, err := th.Vault().Get("label")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.ContentType != "application/pdf" {
+		t.Errorf("got content type %q, want application/pdf", h.ContentType)
+	}
+}
+
+func TestEditCommand_EditorFails(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("label", "work", "content")
+	setupEditor(t)
+	t.Setenv(editorEnv, "false")
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+	err := editCommand(th.Setup, "label", strings.NewReader(""), ui)
+	if err == nil || !strings.Contains(err.Error(), "the editor false failed") {
+		t.Fatalf("expected editor error, got %v", err)
+	}
+
+	entries, err := os.ReadDir(os.Getenv("XDG_RUNTIME_DIR"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected the temporary directory to be removed, got %d entries", len(entries))
+	}
+}
@@ -1,10 +1,17 @@
 package main
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/revelaction/privage/vault"
+)
 
 var (
 	// ErrFileNotFound is returned when a requested label does not exist in the directory.
-	ErrFileNotFound = errors.New("file not found in directory")
+	ErrFileNotFound = vault.ErrNotFound
+
+	// ErrAmbiguousLabel is returned when a bare label matches the files of several categories.
+	ErrAmbiguousLabel = vault.ErrAmbiguous
 
 	// ErrFieldNotFound is returned when a requested field (e.g. password) does not exist in the credential.
 	ErrFieldNotFound = errors.New("field not found in credential")
@@ -13,5 +20,5 @@ var (
 	ErrNotCredential = errors.New("file is not a credential")
 
 	// ErrNoIdentity is returned when the private key cannot be loaded.
-	ErrNoIdentity = errors.New("found no privage key file")
-)
\ No newline at end of file
+	ErrNoIdentity = vault.ErrNoIdentity
+)
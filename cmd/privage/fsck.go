@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+// fsckCommand checks every file of the repository and prints the problems
+// found. With repair, the problems that can be fixed safely are repaired.
+// It returns an error if problems are left.
+func fsckCommand(s *setup.Setup, repair bool, ui UI) error {
+	v, err := vault.New(s)
+	if err != nil {
+		return err
+	}
+
+	problems, err := v.Check()
+	if err != nil {
+		return err
+	}
+
+	if repair {
+		numRepaired := 0
+		for _, p := range problems {
+			if !p.Repairable() {
+				continue
+			}
+			if err := p.Repair(); err != nil {
+				return fmt.Errorf("could not repair %s: %w", filepath.Base(p.Path), err)
+			}
+			_, _ = fmt.Fprintf(ui.Out, "🔧 Repaired %s %s: %v\n", p.Kind, describeProblem(p), p.Err)
+			numRepaired++
+		}
+
+		if numRepaired > 0 {
+			_, _ = fmt.Fprintf(ui.Out, "Repaired %d problems\n", numRepaired)
+			_, _ = fmt.Fprintln(ui.Out)
+		}
+
+		if problems, err = v.Check(); err != nil {
+			return err
+		}
+	}
+
+	if len(problems) == 0 {
+		_, _ = fmt.Fprintf(ui.Out, "🔐  Found no problems in the repository %s ✔️\n", s.Repository)
+		return nil
+	}
+
+	numRepairable, numRotate := 0, 0
+	for _, p := range problems {
+		_, _ = fmt.Fprintf(ui.Out, "💥 %s %s: %v\n", p.Kind, describeProblem(p), p.Err)
+		if p.Repairable() {
+			numRepairable++
+		}
+		if p.Kind == vault.ProblemRotate {
+			numRotate++
+		}
+	}
+
+	_, _ = fmt.Fprintln(ui.Out)
+	if numRepairable > 0 {
+		_, _ = fmt.Fprintf(ui.Out, "(Use \"privage fsck --repair\" to repair %d of the problems)\n", numRepairable)
+	}
+	if _, err := os.Stat(filepath.Join(s.Repository, fileNameRotate)); numRotate > 0 && err == nil {
+		_, _ = fmt.Fprintln(ui.Out, "(A rotation is in progress: use \"privage rotate --resume\" to finish it or \"privage rotate --abort\" to undo it)")
+	}
+
+	return fmt.Errorf("found %d problems in the repository", len(problems))
+}
+
+// describeProblem returns the file name of the problem, with the label and
+// category if its header was decrypted.
+func describeProblem(p *vault.Problem) string {
+	if p.Header == nil || p.Header.Err != nil {
+		return filepath.Base(p.Path)
+	}
+
+	return fmt.Sprintf("%s %s", filepath.Base(p.Path), p.Header)
+}
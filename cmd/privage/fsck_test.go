@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFsckCommand(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("a.txt", "work", "content a")
+	th.AddEncryptedFile("b.txt", "work", "content b")
+
+	var outBuf bytes.Buffer
+	if err := fsckCommand(th.Setup, false, UI{Out: &outBuf, Err: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("fsckCommand failed: %v", err)
+	}
+	if !strings.Contains(outBuf.String(), "Found no problems") {
+		t.Errorf("unexpected output %q", outBuf.String())
+	}
+
+	// A leftover temporary file and decrypted files, one of them modified
+	h, err := th.Vault().Get("a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		filepath.Base(h.Path) + ".tmp": "partial",
+		"a.txt":                        "content a",
+		"b.txt":                        "edited content b",
+	} {
+		if err := os.WriteFile(filepath.Join(th.Repository, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outBuf.Reset()
+	err = fsckCommand(th.Setup, false, UI{Out: &outBuf, Err: &bytes.Buffer{}})
+	if err == nil || !strings.Contains(err.Error(), "found 3 problems") {
+		t.Fatalf("expected 3 problems, got %v", err)
+	}
+	for _, want := range []string{"💥 temp ", "💥 plaintext a.txt 💼 a.txt", "to repair 2 of the problems"} {
+		if !strings.Contains(outBuf.String(), want) {
+			t.Errorf("expected %q in output %q", want, outBuf.String())
+		}
+	}
+
+	outBuf.Reset()
+	err = fsckCommand(th.Setup, true, UI{Out: &outBuf, Err: &bytes.Buffer{}})
+	if err == nil || !strings.Contains(err.Error(), "found 1 problems") {
+		t.Fatalf("expected 1 problem left, got %v", err)
+	}
+	if !strings.Contains(outBuf.String(), "Repaired 2 problems") || !strings.Contains(outBuf.String(), "💥 plaintext b.txt") {
+		t.Errorf("unexpected output %q", outBuf.String())
+	}
+	if _, err := os.Stat(filepath.Join(th.Repository, "b.txt")); err != nil {
+		t.Errorf("expected the modified decrypted file to be kept: %v", err)
+	}
+}
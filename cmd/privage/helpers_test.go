@@ -1,14 +1,19 @@
 package main
 
 import (
+	"bytes"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
 
+	"filippo.io/age"
+
 	"github.com/revelaction/privage/header"
 	"github.com/revelaction/privage/identity"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 // TestHelper encapsulates the test environment and helper methods.
@@ -31,7 +36,7 @@ func NewTestHelper(t *testing.T) *TestHelper {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := identity.GenerateAge(f); err != nil {
+	if err := identity.GenerateAge(f, false); err != nil {
 		t.Fatal(err)
 	}
 	if err := f.Close(); err != nil {
@@ -55,11 +60,59 @@ func NewTestHelper(t *testing.T) *TestHelper {
 func (th *TestHelper) AddEncryptedFile(label, category, content string) {
 	th.t.Helper()
 	h := &header.Header{Label: label, Category: category}
-	if err := encryptSave(h, "", strings.NewReader(content), th.Setup); err != nil {
+	if err := th.Vault().Put(h, strings.NewReader(content)); err != nil {
 		th.t.Fatalf("failed to encrypt %s: %v", label, err)
 	}
 }
 
+// AddEncryptedFileV1 creates a valid encrypted file with a v1 header, as
+// written by previous versions of privage.
+func (th *TestHelper) AddEncryptedFileV1(label, category, content string) {
+	th.t.Helper()
+	h := &header.Header{Version: header.Version1, Label: label, Category: category}
+	recipient := th.Id.Recipient
+
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, recipient)
+	if err != nil {
+		th.t.Fatal(err)
+	}
+	padded, err := h.Pad()
+	if err != nil {
+		th.t.Fatal(err)
+	}
+	if _, err := w.Write(padded); err != nil {
+		th.t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		th.t.Fatal(err)
+	}
+	data, err := header.PadEncrypted(buf.Bytes())
+	if err != nil {
+		th.t.Fatal(err)
+	}
+
+	buf = bytes.NewBuffer(data)
+	w, err = age.Encrypt(buf, recipient)
+	if err != nil {
+		th.t.Fatal(err)
+	}
+	if _, err := io.WriteString(w, content); err != nil {
+		th.t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		th.t.Fatal(err)
+	}
+
+	hash, err := h.Hash(recipient.String())
+	if err != nil {
+		th.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(th.Repository, hash+vault.Extension), buf.Bytes(), 0600); err != nil {
+		th.t.Fatal(err)
+	}
+}
+
 // AddFile creates a plain file (for testing non-encrypted file listing).
 func (th *TestHelper) AddFile(name string) {
 	th.t.Helper()
@@ -75,3 +128,13 @@ func (th *TestHelper) AddFile(name string) {
 		th.t.Fatal(err)
 	}
 }
+
+// Vault returns a vault for the setup repository and identity.
+func (th *TestHelper) Vault() *vault.Vault {
+	th.t.Helper()
+	v, err := vault.New(th.Setup)
+	if err != nil {
+		th.t.Fatal(err)
+	}
+	return v
+}
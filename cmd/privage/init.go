@@ -9,7 +9,6 @@ import (
 	"github.com/revelaction/privage/config"
 	filesystem "github.com/revelaction/privage/fs"
 	id "github.com/revelaction/privage/identity"
-	"github.com/revelaction/privage/identity/piv/yubikey"
 )
 
 const (
@@ -18,12 +17,16 @@ const (
 
 # But not these files...
 !.gitignore
+!.privage-recipients
+!.privage-manifest
 !*.privage`
 )
 
 // initCommand is a pure logic worker for environment initialization.
 // It generates an age identity, a .gitignore, and a .privage.conf file.
-func initCommand(slot string, ui UI) (err error) {
+// The age identity is encrypted with the PIV key of the slot, if not empty,
+// or with a passphrase. It is a post-quantum hybrid identity if ko.pq.
+func initCommand(ko keyOptions, ui UI) (err error) {
 
 	// Pre-flight checks
 	configPath, err := filesystem.FindConfigFile()
@@ -55,60 +58,35 @@ func initCommand(slot string, ui UI) (err error) {
 	identityPath := currentDir + "/" + id.DefaultFileName
 	identityType := id.TypeAge
 
-	// piv key
-	if len(slot) > 0 {
+	var identitySlot uint64
+	if len(ko.slot) > 0 {
 		identityType = id.TypePiv
-
-		identitySlot, err := strconv.ParseUint(slot, 16, 32)
-		if err != nil {
-			return fmt.Errorf("could not convert slot %s to hex: %v", slot, err)
-		}
-
-		yk, err := yubikey.New()
-		if err != nil {
-			return fmt.Errorf("could not connect to yubikey: %w", err)
-		}
-		defer func() {
-			if cerr := yk.Close(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}()
-
-		f, err := filesystem.CreateFile(identityPath, 0600)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if cerr := f.Close(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}()
-
-		err = id.GeneratePiv(f, yk, uint32(identitySlot))
+		identitySlot, err = strconv.ParseUint(ko.slot, 16, 32)
 		if err != nil {
-			return fmt.Errorf("error creating encrypted age key in slot %s: %w", slot, err)
+			return fmt.Errorf("could not convert slot %s to hex: %v", ko.slot, err)
 		}
+	}
+	if ko.passphrase {
+		identityType = id.TypePassphrase
+	}
 
-		_, _ = fmt.Fprintf(ui.Err, "🔑 Generated encrypted age key file `%s` with PIV slot %s ✔️\n", identityPath, slot)
-	} else {
-		// normal age key
-		f, err := filesystem.CreateFile(identityPath, 0600)
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if cerr := f.Close(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}()
-		err = id.GenerateAge(f)
-		if err != nil {
-			return err
-		}
+	if _, err := createIdentity(identityPath, uint32(identitySlot), ko, ui); err != nil {
+		return err
+	}
 
+	switch identityType {
+	case id.TypePiv:
+		_, _ = fmt.Fprintf(ui.Err, "🔑 Generated encrypted age key file `%s` with PIV slot %s ✔️\n", identityPath, ko.slot)
+	case id.TypePassphrase:
+		_, _ = fmt.Fprintf(ui.Err, "🔑 Generated passphrase encrypted age key file `%s` ✔️\n", identityPath)
+	default:
 		_, _ = fmt.Fprintf(ui.Err, "🔑 Generated age key file `%s` ✔️\n", identityPath)
 	}
 
+	if ko.pq {
+		_, _ = fmt.Fprintln(ui.Err, "🔑 The age key is a post-quantum hybrid key ✔️")
+	}
+
 	//
 	// gitignore
 	//
@@ -146,10 +124,11 @@ func initCommand(slot string, ui UI) (err error) {
 	}()
 
 	conf := &config.Config{
-		IdentityPath:    identityPath,
-		IdentityType:    identityType,
-		IdentityPivSlot: slot,
-		RepositoryPath:  currentDir,
+		IdentityPath:      identityPath,
+		IdentityType:      identityType,
+		IdentityPivSlot:   ko.slot,
+		IdentityPivSerial: ko.serial,
+		RepositoryPath:    currentDir,
 	}
 
 	if err := conf.Encode(f); err != nil {
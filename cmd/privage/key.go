@@ -1,12 +1,21 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/revelaction/privage/fs"
 	id "github.com/revelaction/privage/identity"
-	"github.com/revelaction/privage/identity/piv/yubikey"
+	"github.com/revelaction/privage/identity/paper"
+	"github.com/revelaction/privage/identity/shamir"
 	"github.com/revelaction/privage/setup"
 )
 
@@ -21,15 +30,10 @@ func keyCommand(s *setup.Setup, ui UI) (err error) {
 		return fmt.Errorf("could not convert slot %s to hex: %v", s.C.IdentityPivSlot, err)
 	}
 
-	device, err := yubikey.New()
+	serial, err := parsePivSerial(s.C.IdentityPivSerial)
 	if err != nil {
-		return fmt.Errorf("could not create yubikey device: %w", err)
+		return err
 	}
-	defer func() {
-		if cerr := device.Close(); cerr != nil && err == nil {
-			err = cerr
-		}
-	}()
 
 	f, err := fs.OpenFile(s.Id.Path)
 	if err != nil {
@@ -41,7 +45,7 @@ func keyCommand(s *setup.Setup, ui UI) (err error) {
 		}
 	}()
 
-	ageKey, err := id.DecryptPiv(f, device, uint32(ps))
+	ageKey, err := id.DecryptPiv(f, openPiv(serial), uint32(ps), pivAuth(false, ui))
 	if err != nil {
 		return fmt.Errorf("could not decrypt age key: %w", err)
 	}
@@ -53,3 +57,462 @@ func keyCommand(s *setup.Setup, ui UI) (err error) {
 
 	return nil
 }
+
+// keyDevicesCommand lists the detected yubikeys, with their serial number,
+// firmware version and the certificates of their slots.
+func keyDevicesCommand(ui UI) error {
+	cards, err := pivDevices()
+	if err != nil {
+		return err
+	}
+
+	printDevices(ui.Out, cards)
+	return nil
+}
+
+// printDevices prints the description of the cards to w.
+func printDevices(w io.Writer, cards []id.CardInfo) {
+	if len(cards) == 0 {
+		_, _ = fmt.Fprintln(w, "🔑 🚫 Found no yubikeys")
+		return
+	}
+
+	for _, c := range cards {
+		_, _ = fmt.Fprintf(w, "🔑 %s\n", c.Name)
+		_, _ = fmt.Fprintf(w, "%4s Serial: %d\n", "", c.Serial)
+		_, _ = fmt.Fprintf(w, "%4s Firmware: %s\n", "", c.Version)
+		if len(c.Slots) == 0 {
+			_, _ = fmt.Fprintf(w, "%4s No certificates in the retired key management slots\n", "")
+		}
+		for _, slot := range c.Slots {
+			if slot.NotAfter.IsZero() {
+				_, _ = fmt.Fprintf(w, "%4s Slot %x: %s %s\n", "", slot.Slot, slot.Algorithm, slot.Subject)
+				continue
+			}
+			_, _ = fmt.Fprintf(w, "%4s Slot %x: %s %s, expires %s\n", "", slot.Slot, slot.Algorithm, slot.Subject, slot.NotAfter.Format(time.DateOnly))
+		}
+	}
+}
+
+// parsePivSerial returns the yubikey serial number of the decimal string s,
+// or zero, any yubikey, if s is empty.
+func parsePivSerial(s string) (uint32, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	serial, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid yubikey serial %q: %w", s, err)
+	}
+
+	return uint32(serial), nil
+}
+
+// openPiv returns the opener of the yubikeys of the stanzas of a PIV key
+// file. The stanza of a key file of an older version, without serial
+// number, is decrypted with the yubikey with serial, or any if zero.
+func openPiv(serial uint32) id.OpenDevice {
+	return func(stanzaSerial uint32) (id.Device, error) {
+		if stanzaSerial == 0 {
+			stanzaSerial = serial
+		}
+
+		device, err := newPivDevice(stanzaSerial)
+		if err != nil {
+			return nil, fmt.Errorf("could not create yubikey device: %w", err)
+		}
+		return device, nil
+	}
+}
+
+// keyEnrollCommand encrypts the age key of the PIV key file of the setup
+// also with the PIV key of the slot of the yubikey with serial ko.serial, so
+// that any of the enrolled yubikeys decrypts it. The slot defaults to the
+// one of the config file.
+func keyEnrollCommand(s *setup.Setup, ko keyOptions, ui UI) (err error) {
+	if s.C == nil || len(s.C.IdentityPivSlot) == 0 {
+		return fmt.Errorf("found no piv slot in conf")
+	}
+
+	ps, err := strconv.ParseUint(s.C.IdentityPivSlot, 16, 32)
+	if err != nil {
+		return fmt.Errorf("could not convert slot %s to hex: %v", s.C.IdentityPivSlot, err)
+	}
+
+	slot := ps
+	if len(ko.slot) > 0 {
+		slot, err = strconv.ParseUint(ko.slot, 16, 32)
+		if err != nil {
+			return fmt.Errorf("could not convert slot %s to hex: %v", ko.slot, err)
+		}
+	}
+
+	serial, err := parsePivSerial(s.C.IdentityPivSerial)
+	if err != nil {
+		return err
+	}
+	newSerial, err := parsePivSerial(ko.serial)
+	if err != nil {
+		return err
+	}
+
+	data, err := os.ReadFile(s.Id.Path)
+	if err != nil {
+		return fmt.Errorf("could not read key file: %w", err)
+	}
+	pk, err := id.ReadPivKey(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	ageKey, err := pk.Decrypt(openPiv(serial), uint32(ps), pivAuth(false, ui))
+	if err != nil {
+		return fmt.Errorf("could not decrypt age key: %w", err)
+	}
+
+	device, err := newPivDevice(newSerial)
+	if err != nil {
+		return fmt.Errorf("could not create yubikey device: %w", err)
+	}
+	eerr := pk.Enroll(ageKey, device, uint32(slot))
+	if err := errors.Join(eerr, device.Close()); err != nil {
+		return fmt.Errorf("could not enroll yubikey %d: %w", newSerial, err)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := pk.Encode(buf); err != nil {
+		return err
+	}
+
+	tmpPath := s.Id.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("could not write key file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.Id.Path); err != nil {
+		return fmt.Errorf("could not write key file: %w", err)
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "🔑 Enrolled the slot %x of the yubikey %d in the key file %s ✔️\n", slot, newSerial, s.Id.Path)
+	_, _ = fmt.Fprintf(ui.Err, "%4s The key file can be decrypted with %d yubikey slots\n", "", len(pk.Stanzas))
+	return nil
+}
+
+// keySplitCommand splits the age secret key of the setup in ko.shares
+// Shamir shares, any ko.threshold of them recovering it, and prints them one
+// per line.
+func keySplitCommand(s *setup.Setup, ko keyOptions, ui UI) error {
+	if s.Id.Id == nil {
+		return fmt.Errorf("could not load the key file: %w", s.Id.Err)
+	}
+
+	secret, err := s.Id.SecretKey()
+	if err != nil {
+		return err
+	}
+
+	shares, err := shamir.Split([]byte(secret), ko.shares, ko.threshold)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "🔑 Split the age key of %s in %d shares, any %d of them recover it with \"privage key combine\" ✔️\n", s.Id.Path, ko.shares, ko.threshold)
+	_, _ = fmt.Fprintln(ui.Err, "⚠ Keep each share in a different place: each one is a part of the secret key")
+	for _, share := range shares {
+		if _, err := fmt.Fprintln(ui.Out, share); err != nil {
+			return fmt.Errorf("could not copy to the console: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// keyCombineCommand recovers the age key file in dir from the Shamir shares
+// read from r, one per line, until the threshold of the shares is reached.
+// An existing key file is renamed to a backup file first.
+func keyCombineCommand(r io.Reader, dir string, ui UI) error {
+	var shares []shamir.Share
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		share, err := shamir.ParseShare(line)
+		if err != nil {
+			return err
+		}
+		shares = append(shares, share)
+		if len(shares) == shares[0].Threshold {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read the shares: %w", err)
+	}
+
+	secret, err := shamir.Combine(shares)
+	if err != nil {
+		return err
+	}
+
+	ident := id.LoadAge(bytes.NewReader(secret), "")
+	if ident.Err != nil {
+		return fmt.Errorf("the shares do not recover an age key: %w", ident.Err)
+	}
+
+	path, err := writeRecoveredKey(dir, ident, ui)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "🔑 Recovered the age key file %s from %d shares ✔️\n", path, len(shares))
+	printPlainKeyHint(ui)
+	return nil
+}
+
+// keyExportCommand prints the paper key of the age secret key of the setup,
+// and with ko.qr its QR code, with ASCII characters if ko.ascii.
+func keyExportCommand(s *setup.Setup, ko keyOptions, ui UI) error {
+	if s.Id.Id == nil {
+		return fmt.Errorf("could not load the key file: %w", s.Id.Err)
+	}
+
+	secret, err := s.Id.SecretKey()
+	if err != nil {
+		return err
+	}
+
+	if err := paper.Write(ui.Out, secret, s.Id.Recipient.String()); err != nil {
+		return fmt.Errorf("could not copy to the console: %w", err)
+	}
+
+	if ko.qr {
+		_, _ = fmt.Fprintln(ui.Out)
+		if err := paper.WriteQR(ui.Out, secret, ko.ascii); err != nil {
+			return err
+		}
+	}
+
+	_, _ = fmt.Fprintln(ui.Err, "⚠ The paper key is the secret key in plain text: print it, do not store it")
+	return nil
+}
+
+// keyImportCommand restores the age key file in dir from the paper key
+// read from r. An existing key file is renamed to a backup file first.
+func keyImportCommand(r io.Reader, dir string, ui UI) error {
+	secret, err := paper.Read(r)
+	if err != nil {
+		return err
+	}
+
+	ident := id.LoadAge(strings.NewReader(secret), "")
+	if ident.Err != nil {
+		return fmt.Errorf("the paper key is not a valid age key: %w", ident.Err)
+	}
+
+	path, err := writeRecoveredKey(dir, ident, ui)
+	if err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "🔑 Restored the age key file %s from the paper key ✔️\n", path)
+	printPlainKeyHint(ui)
+	return nil
+}
+
+// writeRecoveredKey writes the age key file of ident in dir. An existing key
+// file is renamed to a backup file first.
+func writeRecoveredKey(dir string, ident id.Identity, ui UI) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := id.WriteAge(buf, ident); err != nil {
+		return "", err
+	}
+
+	path := filepath.Join(dir, id.DefaultFileName)
+	if _, err := os.Stat(path); err == nil {
+		backupPath := id.BackupFilePath(dir)
+		if err := os.Rename(path, backupPath); err != nil {
+			return "", fmt.Errorf("could not rename the key file %s: %w", path, err)
+		}
+		_, _ = fmt.Fprintf(ui.Err, "Renamed the key file %s to %s\n", path, backupPath)
+	}
+
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return "", fmt.Errorf("could not write key file: %w", err)
+	}
+
+	return path, nil
+}
+
+func printPlainKeyHint(ui UI) {
+	_, _ = fmt.Fprintln(ui.Err, "⚠ The key file is not encrypted: encrypt it with \"privage key passwd\", or")
+	_, _ = fmt.Fprintf(ui.Err, "%4s make sure the config file has the line identity_type = \"%s\"\n", "", id.TypeAge)
+}
+
+// keyPasswdCommand changes the passphrase of the key file of the setup, or
+// encrypts a plain key file with a passphrase.
+//
+// The current passphrase is read from PRIVAGE_PASSPHRASE and the new one
+// from PRIVAGE_NEW_PASSPHRASE. Otherwise they are read from the file
+// descriptor of PRIVAGE_PASSPHRASE_FD, one per line, or prompted for.
+func keyPasswdCommand(s *setup.Setup, ui UI) error {
+	if len(s.Id.Path) == 0 {
+		return fmt.Errorf("found no key file: %w", s.Id.Err)
+	}
+	if s.C != nil && s.C.IdentityType == id.TypePiv {
+		return fmt.Errorf("the key file %s is encrypted with a yubikey", s.Id.Path)
+	}
+
+	data, err := os.ReadFile(s.Id.Path)
+	if err != nil {
+		return fmt.Errorf("could not read key file: %w", err)
+	}
+
+	if id.IsSsh(data) {
+		return fmt.Errorf("the key file %s is an SSH key, change its passphrase with ssh-keygen -p", s.Id.Path)
+	}
+
+	isPassphrase := id.IsPassphrase(data)
+	if isPassphrase {
+		current, err := readPassphrase(passphraseEnv, fmt.Sprintf("Enter passphrase for %s: ", s.Id.Path))
+		if err != nil {
+			return err
+		}
+		data, err = id.DecryptPassphrase(bytes.NewReader(data), current)
+		if err != nil {
+			return err
+		}
+	} else if ident := id.LoadAge(bytes.NewReader(data), s.Id.Path); ident.Err != nil {
+		return fmt.Errorf("could not parse key file %s: %w", s.Id.Path, ident.Err)
+	}
+
+	passphrase, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := id.EncryptPassphrase(buf, data, passphrase); err != nil {
+		return err
+	}
+
+	tmpPath := s.Id.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("could not write key file: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.Id.Path); err != nil {
+		return fmt.Errorf("could not write key file: %w", err)
+	}
+
+	if isPassphrase {
+		_, _ = fmt.Fprintf(ui.Err, "🔑 Changed the passphrase of the key file %s ✔️\n", s.Id.Path)
+		return nil
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "🔑 Encrypted the key file %s with a passphrase ✔️\n", s.Id.Path)
+	if s.C != nil && len(s.C.Path) > 0 {
+		_, _ = fmt.Fprintf(ui.Err, "⚠ Make sure the config file %s has this line:\n", s.C.Path)
+		_, _ = fmt.Fprintln(ui.Err)
+		_, _ = fmt.Fprintf(ui.Err, "    identity_type = \"%s\"\n", id.TypePassphrase)
+		_, _ = fmt.Fprintln(ui.Err)
+	}
+
+	return nil
+}
+
+// keyOptions are the options of the age key files created by init and
+// rotate, and of the key actions.
+type keyOptions struct {
+	// slot is the yubikey PIV slot, in hex, whose key encrypts the age key.
+	slot string
+
+	// serial is the serial number of the yubikey, if several are plugged
+	// in.
+	serial string
+
+	// passphrase encrypts the age key with a passphrase.
+	passphrase bool
+
+	// pq generates a post-quantum hybrid age key.
+	pq bool
+
+	// shares is the number of shares key split splits the age key in.
+	shares int
+
+	// threshold is the number of shares that recover the age key.
+	threshold int
+
+	// paper exports or imports the age key as a paper key.
+	paper bool
+
+	// qr prints also the QR code of the age key, with ASCII characters if
+	// ascii.
+	qr    bool
+	ascii bool
+}
+
+// createIdentity generates a new age key in a new key file at path, and
+// returns its identity. The key is encrypted with the PIV key of the
+// yubikey slot if pivSlot is not zero, or with a new passphrase if
+// ko.passphrase. It is a post-quantum hybrid key if ko.pq.
+func createIdentity(path string, pivSlot uint32, ko keyOptions, ui UI) (ident id.Identity, err error) {
+	var passphrase string
+	if ko.passphrase {
+		passphrase, err = newPassphrase()
+		if err != nil {
+			return id.Identity{}, err
+		}
+	}
+
+	f, err := fs.CreateFile(path, 0600)
+	if err != nil {
+		return id.Identity{}, fmt.Errorf("could not create key file %s: %w", path, err)
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if pivSlot > 0 {
+		serial, serr := parsePivSerial(ko.serial)
+		if serr != nil {
+			return id.Identity{}, serr
+		}
+		device, derr := newPivDevice(serial)
+		if derr != nil {
+			return id.Identity{}, fmt.Errorf("could not create yubikey device: %w", derr)
+		}
+
+		buf := new(bytes.Buffer)
+		gerr := id.GeneratePiv(buf, device, pivSlot, ko.pq)
+		if err := errors.Join(gerr, device.Close()); err != nil {
+			return id.Identity{}, fmt.Errorf("error creating encrypted age key in slot %x: %w", pivSlot, err)
+		}
+		if _, err := f.Write(buf.Bytes()); err != nil {
+			return id.Identity{}, fmt.Errorf("could not write key file %s: %w", path, err)
+		}
+
+		// The key file stanza has the serial number of the device
+		ident = id.LoadPiv(bytes.NewReader(buf.Bytes()), path, openPiv(serial), pivSlot, pivAuth(false, ui))
+		return ident, ident.Err
+	}
+
+	buf := new(bytes.Buffer)
+	if err := id.GenerateAge(buf, ko.pq); err != nil {
+		return id.Identity{}, fmt.Errorf("could not generate age key: %w", err)
+	}
+
+	if ko.passphrase {
+		err = id.EncryptPassphrase(f, buf.Bytes(), passphrase)
+	} else {
+		_, err = f.Write(buf.Bytes())
+	}
+	if err != nil {
+		return id.Identity{}, fmt.Errorf("could not write key file %s: %w", path, err)
+	}
+
+	ident = id.LoadAge(buf, path)
+	return ident, ident.Err
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+)
+
+func TestKeyPasswdCommand(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("secret.txt", "work", "content")
+	opts := setup.Options{KeyFile: th.Id.Path, RepoPath: th.Repository}
+
+	t.Run("Encrypt", func(t *testing.T) {
+		t.Setenv(newPassphraseEnv, "first")
+		var errBuf bytes.Buffer
+		if err := keyPasswdCommand(th.Setup, UI{Out: &bytes.Buffer{}, Err: &errBuf}); err != nil {
+			t.Fatalf("keyPasswdCommand failed: %v", err)
+		}
+		if !strings.Contains(errBuf.String(), "Encrypted the key file") {
+			t.Errorf("unexpected output %q", errBuf.String())
+		}
+
+		data, err := os.ReadFile(th.Id.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !identity.IsPassphrase(data) {
+			t.Fatalf("expected passphrase protected key file")
+		}
+	})
+
+	t.Run("Change", func(t *testing.T) {
+		t.Setenv(passphraseEnv, "first")
+		t.Setenv(newPassphraseEnv, "second")
+		var errBuf bytes.Buffer
+		if err := keyPasswdCommand(th.Setup, UI{Out: &bytes.Buffer{}, Err: &errBuf}); err != nil {
+			t.Fatalf("keyPasswdCommand failed: %v", err)
+		}
+		if !strings.Contains(errBuf.String(), "Changed the passphrase") {
+			t.Errorf("unexpected output %q", errBuf.String())
+		}
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		t.Setenv(passphraseEnv, "second")
+		s, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
+		if err != nil {
+			t.Fatalf("setupEnv failed: %v", err)
+		}
+		if s.Id.Err != nil {
+			t.Fatalf("could not load identity: %v", s.Id.Err)
+		}
+		if s.Id.Recipient.String() != th.Id.Recipient.String() {
+			t.Errorf("expected the same key after changing the passphrase")
+		}
+
+		var outBuf bytes.Buffer
+		if err := catCommand(s, "secret.txt", UI{Out: &outBuf, Err: &bytes.Buffer{}}); err != nil {
+			t.Fatalf("catCommand failed: %v", err)
+		}
+		if outBuf.String() != "content" {
+			t.Errorf("unexpected content %q", outBuf.String())
+		}
+	})
+
+	t.Run("WrongPassphrase", func(t *testing.T) {
+		t.Setenv(passphraseEnv, "first")
+		t.Setenv(newPassphraseEnv, "third")
+		err := keyPasswdCommand(th.Setup, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
+		if !errors.Is(err, identity.ErrPassphrase) {
+			t.Errorf("expected ErrPassphrase, got %v", err)
+		}
+	})
+
+	t.Run("NoPrompt", func(t *testing.T) {
+		opts := opts
+		opts.NoPrompt = true
+		s, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
+		if err != nil {
+			t.Fatalf("setupEnv failed: %v", err)
+		}
+		if s.Id.Id != nil || !errors.Is(s.Id.Err, errNoPassphrase) || s.Id.Path != th.Id.Path {
+			t.Errorf("expected identity not loaded, got %+v", s.Id)
+		}
+	})
+}
+
+func TestKeySplitCombine(t *testing.T) {
+	th := NewTestHelper(t)
+	secret, err := th.Id.SecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outBuf bytes.Buffer
+	ko := keyOptions{shares: 5, threshold: 3}
+	if err := keySplitCommand(th.Setup, ko, UI{Out: &outBuf, Err: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("keySplitCommand failed: %v", err)
+	}
+	shares := strings.Split(strings.TrimSpace(outBuf.String()), "\n")
+	if len(shares) != 5 || strings.Contains(outBuf.String(), secret) {
+		t.Fatalf("expected 5 shares, got %q", outBuf.String())
+	}
+
+	t.Run("Combine", func(t *testing.T) {
+		dir := t.TempDir()
+		in := "# shares 1, 3 and 5\n" + shares[0] + "\n\n" + shares[2] + "\n" + shares[4] + "\n"
+		var errBuf bytes.Buffer
+		if err := keyCombineCommand(strings.NewReader(in), dir, UI{Out: &bytes.Buffer{}, Err: &errBuf}); err != nil {
+			t.Fatalf("keyCombineCommand failed: %v", err)
+		}
+		if !strings.Contains(errBuf.String(), "Recovered the age key file") {
+			t.Errorf("unexpected output %q", errBuf.String())
+		}
+
+		f, err := os.Open(filepath.Join(dir, identity.DefaultFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		ident := identity.LoadAge(f, "")
+		if got, _ := ident.SecretKey(); got != secret {
+			t.Errorf("expected the secret key %q, got %q", secret, got)
+		}
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, identity.DefaultFileName)
+		if err := os.WriteFile(path, []byte("old"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		in := strings.Join(shares[2:], "\n")
+		if err := keyCombineCommand(strings.NewReader(in), dir, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}}); err != nil {
+			t.Fatalf("keyCombineCommand failed: %v", err)
+		}
+
+		backups, err := filepath.Glob(filepath.Join(dir, identity.DefaultFileName+"-*.bak"))
+		if err != nil || len(backups) != 1 {
+			t.Fatalf("expected a backup of the key file, got %v, %v", backups, err)
+		}
+		if data, _ := os.ReadFile(backups[0]); string(data) != "old" {
+			t.Errorf("unexpected backup content %q", data)
+		}
+	})
+
+	t.Run("TooFew", func(t *testing.T) {
+		in := strings.Join(shares[:2], "\n")
+		err := keyCombineCommand(strings.NewReader(in), t.TempDir(), UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
+		if err == nil || !strings.Contains(err.Error(), "3 are needed") {
+			t.Errorf("expected error with too few shares, got %v", err)
+		}
+	})
+
+	t.Run("Typo", func(t *testing.T) {
+		typo := strings.Replace(shares[0], "PRIVAGE-SHARE-3-1-", "PRIVAGE-SHARE-3-2-", 1)
+		err := keyCombineCommand(strings.NewReader(typo), t.TempDir(), UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
+		if err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("expected checksum error, got %v", err)
+		}
+	})
+}
+
+func TestKeyExportImport(t *testing.T) {
+	th := NewTestHelper(t)
+	secret, err := th.Id.SecretKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var outBuf bytes.Buffer
+	ko := keyOptions{paper: true, qr: true, ascii: true}
+	if err := keyExportCommand(th.Setup, ko, UI{Out: &outBuf, Err: &bytes.Buffer{}}); err != nil {
+		t.Fatalf("keyExportCommand failed: %v", err)
+	}
+	if !strings.Contains(outBuf.String(), th.Id.Recipient.String()) || !strings.Contains(outBuf.String(), "##") {
+		t.Fatalf("expected the public key and the QR code, got %q", outBuf.String())
+	}
+	paperKey := outBuf.String()
+
+	t.Run("Import", func(t *testing.T) {
+		dir := t.TempDir()
+		var errBuf bytes.Buffer
+		if err := keyImportCommand(strings.NewReader(paperKey), dir, UI{Out: &bytes.Buffer{}, Err: &errBuf}); err != nil {
+			t.Fatalf("keyImportCommand failed: %v", err)
+		}
+		if !strings.Contains(errBuf.String(), "Restored the age key file") {
+			t.Errorf("unexpected output %q", errBuf.String())
+		}
+
+		f, err := os.Open(filepath.Join(dir, identity.DefaultFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = f.Close()
+		}()
+		ident := identity.LoadAge(f, "")
+		if got, _ := ident.SecretKey(); got != secret {
+			t.Errorf("expected the secret key %q, got %q", secret, got)
+		}
+	})
+
+	t.Run("Typo", func(t *testing.T) {
+		lines := strings.Split(paperKey, "\n")
+		for i, l := range lines {
+			if strings.HasPrefix(l, "02  ") {
+				lines[i] = "02  X" + l[5:]
+				if lines[i] == l {
+					lines[i] = "02  Y" + l[5:]
+				}
+			}
+		}
+		err := keyImportCommand(strings.NewReader(strings.Join(lines, "\n")), t.TempDir(), UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
+		if err == nil || !strings.Contains(err.Error(), "line 5") || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("expected checksum error in line 5, got %v", err)
+		}
+	})
+}
+
+func TestPrintDevices(t *testing.T) {
+	var buf bytes.Buffer
+	printDevices(&buf, []identity.CardInfo{
+		{
+			Name:    "Yubico YubiKey CCID 00 00",
+			Serial:  12345678,
+			Version: "5.4.3",
+			Slots: []identity.SlotInfo{
+				{Slot: 0x86, Subject: "CN=privage", Algorithm: "RSA-2048", NotAfter: time.Date(2034, 1, 2, 0, 0, 0, 0, time.UTC)},
+			},
+		},
+		{Name: "Yubico YubiKey CCID 01 00", Serial: 87654321, Version: "5.7.1"},
+		{Name: "Software PIV device 1111", Serial: 1111, Version: "software", Slots: []identity.SlotInfo{{Slot: 0x9a, Subject: "CN=softpiv", Algorithm: "RSA-2048"}}},
+	})
+
+	for _, want := range []string{
+		"🔑 Yubico YubiKey CCID 00 00\n",
+		"Serial: 12345678\n",
+		"Firmware: 5.4.3\n",
+		"Slot 86: RSA-2048 CN=privage, expires 2034-01-02\n",
+		"Serial: 87654321\n",
+		"No certificates in the retired key management slots\n",
+		"Slot 9a: RSA-2048 CN=softpiv\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in output:\n%s", want, buf.String())
+		}
+	}
+
+	buf.Reset()
+	printDevices(&buf, nil)
+	if !strings.Contains(buf.String(), "Found no yubikeys") {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestParsePivSerial(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    uint32
+		wantErr bool
+	}{
+		{"", 0, false},
+		{"12345678", 12345678, false},
+		{"4294967295", 4294967295, false},
+		{"4294967296", 0, true},
+		{"0x1234", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePivSerial(tt.s)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parsePivSerial(%q) = %d, %v, want %d, error %v", tt.s, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
@@ -3,31 +3,38 @@ package main
 import (
 	"fmt"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 
 	"github.com/revelaction/privage/header"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 // listCommand list encripted files
 func listCommand(s *setup.Setup, filter string, ui UI) error {
 	headers := []*header.Header{}
 	failures := []*header.Header{}
+	restricted := []*header.Header{}
 
-	if s.Id.Id == nil {
-		return fmt.Errorf("%w: %v", ErrNoIdentity, s.Id.Err)
+	v, err := vault.New(s)
+	if err != nil {
+		return err
 	}
 
-	ch, err := headerGenerator(s.Repository, s.Id)
+	all, err := v.List()
 	if err != nil {
 		return err
 	}
 
-	for h := range ch {
-		if h.Err != nil {
+	for _, h := range all {
+		switch {
+		case h.Err != nil:
 			failures = append(failures, h)
-		} else {
+		case v.Restricted(h):
+			restricted = append(restricted, h)
+		default:
 			headers = append(headers, h)
 		}
 	}
@@ -72,6 +79,19 @@ func listCommand(s *setup.Setup, filter string, ui UI) error {
 		}
 	}
 
+	if filter != "" {
+		restricted = slices.DeleteFunc(restricted, func(h *header.Header) bool {
+			return !strings.Contains(h.Category, filter) && !strings.Contains(h.Label, filter)
+		})
+	}
+
+	if len(restricted) > 0 {
+		_, _ = fmt.Fprintf(ui.Out, "\nFound %d files restricted by category policy:\n", len(restricted))
+		for _, h := range sortList(restricted) {
+			_, _ = fmt.Fprintf(ui.Out, "🔒 %s\n", h)
+		}
+	}
+
 	if len(failures) > 0 {
 		_, _ = fmt.Fprintf(ui.Out, "\nFound %d files with errors:\n", len(failures))
 		for _, f := range failures {
@@ -80,6 +100,8 @@ func listCommand(s *setup.Setup, filter string, ui UI) error {
 		}
 	}
 
+	warnManifest(v, ui)
+
 	return nil
 }
 
@@ -5,6 +5,14 @@ import (
 	"errors"
 	"strings"
 	"testing"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/config"
+	"github.com/revelaction/privage/header"
+	"github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 func TestList_All(t *testing.T) {
@@ -135,6 +143,66 @@ func TestList_Filter(t *testing.T) {
 	}
 }
 
+func TestList_Restricted(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("notes.txt", "work", "content")
+
+	ops, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opsKey := ops.Recipient().String()
+	ownKey := th.Id.Recipient.String()
+	policy := map[string]config.CategoryPolicy{"prod-db": {Recipients: []string{opsKey}}}
+
+	if err := vault.WriteRecipientsFile(th.Repository, []string{ownKey, opsKey}); err != nil {
+		t.Fatal(err)
+	}
+	th.C = &config.Config{Categories: policy}
+
+	// ops trusts the manifest and writes a file of the restricted category
+	opsSetup := &setup.Setup{
+		Id:         identity.New(ops, "ops-key"),
+		Repository: th.Repository,
+		C:          &config.Config{Categories: policy, ManifestSigners: []string{th.Vault().SignerKey()}},
+	}
+	opsVault, err := vault.New(opsSetup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opsVault.Put(&header.Header{Label: "db.conf", Category: "prod-db"}, strings.NewReader("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	var outBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &bytes.Buffer{}}
+	if err := listCommand(th.Setup, "", ui); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	output := outBuf.String()
+	for _, s := range []string{
+		"Found 1 total encrypted tracked files",
+		"Found 1 files restricted by category policy",
+		"🔒 💼 db.conf",
+	} {
+		if !strings.Contains(output, s) {
+			t.Errorf("expected output to contain %q, got:\n%s", s, output)
+		}
+	}
+	if strings.Contains(output, "errors") {
+		t.Errorf("expected no errors, got:\n%s", output)
+	}
+
+	outBuf.Reset()
+	if err := listCommand(th.Setup, "notes", ui); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(outBuf.String(), "db.conf") {
+		t.Errorf("expected filter to exclude restricted file, got:\n%s", outBuf.String())
+	}
+}
+
 func TestList_Error(t *testing.T) {
 	th := NewTestHelper(t)
 	// Corrupt identity
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+// withLock runs the command fn, that modifies the repository, with the lock
+// of the repository, waiting up to wait for another privage process to
+// release it.
+func withLock(s *setup.Setup, wait time.Duration, fn func() error) (err error) {
+	l, err := vault.LockRepository(s.Repository, wait)
+	if errors.Is(err, vault.ErrLocked) {
+		return fmt.Errorf("%w\n    (Use \"privage --wait 30s ...\" to wait for it to be released)", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		err = errors.Join(err, l.Unlock())
+	}()
+
+	return fn()
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/revelaction/privage/vault"
+)
+
+func TestWithLock(t *testing.T) {
+	th := NewTestHelper(t)
+	lockPath := filepath.Join(th.Repository, vault.LockFileName)
+
+	err := withLock(th.Setup, 0, func() error {
+		if _, err := os.Stat(lockPath); err != nil {
+			t.Errorf("expected the lock file while running: %v", err)
+		}
+
+		// A concurrent command does not wait by default
+		err := withLock(th.Setup, 0, func() error { return nil })
+		if !errors.Is(err, vault.ErrLocked) || !strings.Contains(err.Error(), "--wait") {
+			t.Errorf("expected ErrLocked with a hint, got %v", err)
+		}
+
+		return errors.New("command failed")
+	})
+	if err == nil || err.Error() != "command failed" {
+		t.Fatalf("expected the error of the command, got %v", err)
+	}
+
+	if _, err := os.Stat(lockPath); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the lock file to be released, got %v", err)
+	}
+}
@@ -63,8 +63,10 @@ func parseMainArgs(args []string, ui UI) (string, []string, setup.Options, error
 	fs.StringVar(&opts.KeyFile, "k", "", "alias for -key")
 	fs.StringVar(&opts.PivSlot, "piv-slot", "", "The PIV slot for decryption of the age key")
 	fs.StringVar(&opts.PivSlot, "p", "", "alias for -piv-slot")
+	fs.StringVar(&opts.PivSerial, "piv-serial", "", "The serial number of the yubikey, if several are plugged in")
 	fs.StringVar(&opts.RepoPath, "repository", "", "Use file path as path for the encrypted files")
 	fs.StringVar(&opts.RepoPath, "r", "", "alias for -repository")
+	fs.DurationVar(&opts.Wait, "wait", 0, "Wait for the lock of the repository to be released")
 
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
@@ -118,7 +120,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 
 	// 2. Bootstrap commands (Needs raw Options, not Setup)
 	case "init":
-		slot, err := parseInitArgs(args, ui)
+		ko, err := parseInitArgs(args, ui)
 		if err != nil {
 			if errors.Is(err, flag.ErrHelp) {
 				return nil
@@ -126,7 +128,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			return err
 		}
 
-		return initCommand(slot, ui)
+		return initCommand(ko, ui)
 
 	// 3. Operational commands (Require full Setup)
 	case "cat":
@@ -138,7 +140,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			return err
 		}
 
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
@@ -146,7 +148,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 		return catCommand(s, label, ui)
 
 	case "add":
-		cat, label, err := parseAddArgs(args, ui)
+		cat, label, ao, err := parseAddArgs(args, ui)
 		if err != nil {
 			if errors.Is(err, flag.ErrHelp) {
 				return nil
@@ -154,12 +156,14 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			return err
 		}
 
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
 
-		return addCommand(s, cat, label, ui)
+		return withLock(s, opts.Wait, func() error {
+			return addCommand(s, cat, label, ao, os.Stdin, ui)
+		})
 
 	case "show":
 		label, fieldName, err := parseShowArgs(args, ui)
@@ -170,7 +174,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			return err
 		}
 
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
@@ -186,24 +190,59 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			return err
 		}
 
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
 
-		return deleteCommand(s, label, ui)
+		return withLock(s, opts.Wait, func() error {
+			return deleteCommand(s, label, ui)
+		})
 
 	case "key":
-		if err := parseKeyArgs(args, ui); err != nil {
+		action, ko, err := parseKeyArgs(args, ui)
+		if err != nil {
 			if errors.Is(err, flag.ErrHelp) {
 				return nil
 			}
 			return err
 		}
-		s, setupErr := setupEnv(opts)
+		if action == "devices" {
+			return keyDevicesCommand(ui)
+		}
+		if action == "combine" {
+			// The key file is lost, so there is no setup
+			dir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("could not get current directory: %w", err)
+			}
+			return keyCombineCommand(os.Stdin, dir, ui)
+		}
+		if action == "import" {
+			dir, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("could not get current directory: %w", err)
+			}
+			return keyImportCommand(os.Stdin, dir, ui)
+		}
+		if action == "passwd" || action == "enroll" {
+			// The key file is decrypted by the command itself
+			opts.NoPrompt = true
+		}
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
+		switch action {
+		case "passwd":
+			return keyPasswdCommand(s, ui)
+		case "enroll":
+			return keyEnrollCommand(s, ko, ui)
+		case "split":
+			return keySplitCommand(s, ko, ui)
+		case "export":
+			return keyExportCommand(s, ko, ui)
+		}
 		return keyCommand(s, ui)
 
 	case "status":
@@ -213,7 +252,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			}
 			return err
 		}
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
@@ -227,7 +266,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			}
 			return err
 		}
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
@@ -241,7 +280,7 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			}
 			return err
 		}
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
@@ -255,12 +294,76 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			}
 			return err
 		}
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
 		return decryptCommand(s, label, ui)
 
+	case "mv":
+		label, newLabel, err := parseMvArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return moveCommand(s, label, newLabel, ui)
+		})
+
+	case "cp":
+		label, newLabel, err := parseCpArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return copyCommand(s, label, newLabel, ui)
+		})
+
+	case "recat":
+		label, cat, err := parseRecatArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return recatCommand(s, label, cat, ui)
+		})
+
+	case "edit":
+		label, err := parseEditArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return editCommand(s, label, os.Stdin, ui)
+		})
+
 	case "reencrypt":
 		force, clean, err := parseReencryptArgs(args, ui)
 		if err != nil {
@@ -269,25 +372,108 @@ func runCommand(cmd string, args []string, opts setup.Options, ui UI) error {
 			}
 			return err
 		}
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		if !force && !clean {
+			return reencryptCommand(s, force, clean, ui)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return reencryptCommand(s, force, clean, ui)
+		})
+
+	case "recipients":
+		action, key, err := parseRecipientsArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		if action == "" {
+			return recipientsCommand(s, action, key, ui)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return recipientsCommand(s, action, key, ui)
+		})
+
+	case "migrate":
+		force, err := parseMigrateArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
-		return reencryptCommand(s, force, clean, ui)
+		if !force {
+			return migrateCommand(s, force, ui)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return migrateCommand(s, force, ui)
+		})
+
+	case "verify":
+		sign, err := parseVerifyArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		if !sign {
+			return verifyCommand(s, sign, ui)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return verifyCommand(s, sign, ui)
+		})
+
+	case "fsck":
+		repair, err := parseFsckArgs(args, ui)
+		if err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil
+			}
+			return err
+		}
+		s, setupErr := setupEnv(opts, ui)
+		if setupErr != nil {
+			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
+		}
+		if !repair {
+			return fsckCommand(s, repair, ui)
+		}
+		return withLock(s, opts.Wait, func() error {
+			return fsckCommand(s, repair, ui)
+		})
 
 	case "rotate":
-		clean, slot, err := parseRotateArgs(args, ui)
+		action, ko, err := parseRotateArgs(args, ui)
 		if err != nil {
 			if errors.Is(err, flag.ErrHelp) {
 				return nil
 			}
 			return err
 		}
-		s, setupErr := setupEnv(opts)
+		s, setupErr := setupEnv(opts, ui)
 		if setupErr != nil {
 			return fmt.Errorf("unable to setup environment configuration: %w", setupErr)
 		}
-		return rotateCommand(s, clean, slot, ui)
+		return withLock(s, opts.Wait, func() error {
+			return rotateCommand(s, action, ko, ui)
+		})
 	}
 
 	return fmt.Errorf("unknown command: %s", cmd)
@@ -299,17 +485,25 @@ func setupUsage(fs *flag.FlagSet) {
 		_, _ = fmt.Fprintf(output, "Usage: %s [global options] command [command options] [arguments...]\n", os.Args[0])
 		_, _ = fmt.Fprintf(output, "\nCommands:\n")
 		_, _ = fmt.Fprintf(output, "  init       Add a .gitignore, age/yubikey key file to the current directory. Add a config file in the home directory.\n")
-		_, _ = fmt.Fprintf(output, "  key        Decrypt the age private key with the PIV key defined in the .privage.conf file.\n")
+		_, _ = fmt.Fprintf(output, "  key        Decrypt the age private key with the PIV key defined in the .privage.conf file. Change its passphrase with passwd. List the yubikeys with devices. Add a backup yubikey with enroll. Split the key in shares with split and recover it with combine. Print it as a paper key with export and restore it with import.\n")
 		_, _ = fmt.Fprintf(output, "  status     Provide information about the current configuration.\n")
 		_, _ = fmt.Fprintf(output, "  add        Add a new encrypted file.\n")
 		_, _ = fmt.Fprintf(output, "  delete     Delete an encrypted file.\n")
+		_, _ = fmt.Fprintf(output, "  mv         Change the label of an encrypted file\n")
+		_, _ = fmt.Fprintf(output, "  cp         Copy an encrypted file to a new label\n")
+		_, _ = fmt.Fprintf(output, "  recat      Change the category of an encrypted file\n")
 		_, _ = fmt.Fprintf(output, "  list       list metadata of all/some encrypted files.\n")
 		_, _ = fmt.Fprintf(output, "  show       Show the contents the an encripted file.\n")
 		_, _ = fmt.Fprintf(output, "  cat        Print the full contents of an encrypted file to stdout.\n")
 		_, _ = fmt.Fprintf(output, "  clipboard  Copy the credential password to the clipboard\n")
 		_, _ = fmt.Fprintf(output, "  decrypt    Decrypt a file and write its content in a file named after the label\n")
+		_, _ = fmt.Fprintf(output, "  edit       Edit an encrypted file in memory with $EDITOR and reencrypt it\n")
 		_, _ = fmt.Fprintf(output, "  reencrypt  Reencrypt all decrypted files that are already encrypted. (default is dry-run)\n")
 		_, _ = fmt.Fprintf(output, "  rotate     Create a new age key and reencrypt every file with the new key\n")
+		_, _ = fmt.Fprintf(output, "  recipients List, add or remove the age public keys the files are encrypted to\n")
+		_, _ = fmt.Fprintf(output, "  migrate    Upgrade the headers of the encrypted files to the current version. (default is dry-run)\n")
+		_, _ = fmt.Fprintf(output, "  verify     Verify the encrypted files against the signed manifest of the repository\n")
+		_, _ = fmt.Fprintf(output, "  fsck       Check the integrity of every file of the repository. Repair the safe problems with --repair\n")
 		_, _ = fmt.Fprintf(output, "  bash       Dump bash complete script.\n")
 		_, _ = fmt.Fprintf(output, "  version    Show version information\n")
 		_, _ = fmt.Fprintf(output, "  help       Show help for a command.\n")
@@ -318,7 +512,9 @@ func setupUsage(fs *flag.FlagSet) {
 		_, _ = fmt.Fprintf(output, "  -c, -conf string       Use file as privage configuration file\n")
 		_, _ = fmt.Fprintf(output, "  -k, -key string        Use file path for private key\n")
 		_, _ = fmt.Fprintf(output, "  -p, -piv-slot string   The PIV slot for decryption of the age key\n")
+		_, _ = fmt.Fprintf(output, "  -piv-serial string     The serial number of the yubikey, if several are plugged in\n")
 		_, _ = fmt.Fprintf(output, "  -r, -repository string Use file path as path for the encrypted files\n")
+		_, _ = fmt.Fprintf(output, "  -wait duration         Wait for the lock of the repository to be released, f. ex. 30s\n")
 		_, _ = fmt.Fprintf(output, "\nVersion: %s, commit %s, yubikey %s\n", BuildTag, BuildCommit, YubikeySupport)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/revelaction/privage/setup"
 )
@@ -29,6 +30,18 @@ func TestParseMainArgs(t *testing.T) {
 		}
 	})
 
+	t.Run("Wait", func(t *testing.T) {
+		var out, err bytes.Buffer
+		ui := UI{Out: &out, Err: &err}
+		cmd, _, opts, parseErr := parseMainArgs([]string{"--wait", "30s", "add"}, ui)
+		if parseErr != nil {
+			t.Fatalf("unexpected error: %v", parseErr)
+		}
+		if cmd != "add" || opts.Wait != 30*time.Second {
+			t.Errorf("got cmd %q and wait %v, want add and 30s", cmd, opts.Wait)
+		}
+	})
+
 	t.Run("Help", func(t *testing.T) {
 		var out, err bytes.Buffer
 		ui := UI{Out: &out, Err: &err}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/revelaction/privage/header"
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+// migrateCommand upgrades the headers of the encrypted files to the current
+// header version.
+func migrateCommand(s *setup.Setup, isForce bool, ui UI) error {
+	v, err := vault.New(s)
+	if err != nil {
+		return err
+	}
+
+	return migrate(v, isForce, ui)
+}
+
+// migrate rewrites the files with headers of a previous version in the
+// Repository directory.
+func migrate(v *vault.Vault, isForce bool, ui UI) error {
+
+	headers, err := v.List()
+	if err != nil {
+		return err
+	}
+
+	toMigrate := []*header.Header{}
+	for _, h := range headers {
+		if h.Err == nil && h.Version != header.CurrentVersion {
+			toMigrate = append(toMigrate, h)
+		}
+	}
+
+	if len(toMigrate) == 0 {
+		_, _ = fmt.Fprintf(ui.Err, "Found no files to migrate to header version %s.\n", header.CurrentVersion)
+		return nil
+	}
+
+	// show only, if not force
+	if !isForce {
+		_, _ = fmt.Fprintf(ui.Err, "Found the following files to be migrated to header version %s:\n", header.CurrentVersion)
+		logFilesToBeProcessed(toMigrate, ui)
+		_, _ = fmt.Fprintln(ui.Err, "(Use \"privage migrate --force\" to migrate all files)")
+		return nil
+	}
+
+	for _, h := range toMigrate {
+		if err := v.Migrate(h); err != nil {
+			return fmt.Errorf("could not migrate %s: %w", h.Label, err)
+		}
+	}
+
+	_, _ = fmt.Fprintln(ui.Err, "The following files were migrated:")
+	logFilesToBeProcessed(toMigrate, ui)
+
+	return nil
+}
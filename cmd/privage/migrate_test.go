@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/revelaction/privage/header"
+)
+
+func TestMigrateCommand(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFileV1("old_file", "work", "old content")
+	th.AddEncryptedFile("new_file", "work", "new content")
+
+	t.Run("DryRun", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		if err := migrateCommand(th.Setup, false, ui); err != nil {
+			t.Fatalf("migrateCommand failed: %v", err)
+		}
+
+		out := errBuf.String()
+		if !strings.Contains(out, "old_file") || strings.Contains(out, "new_file") {
+			t.Errorf("expected only old_file to be listed, got %q", out)
+		}
+
+		h, err := th.Vault().Get("old_file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Version != header.Version1 {
+			t.Errorf("dry run must not migrate, got version %s", h.Version)
+		}
+	})
+
+	t.Run("Force", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		if err := migrateCommand(th.Setup, true, ui); err != nil {
+			t.Fatalf("migrateCommand failed: %v", err)
+		}
+
+		h, err := th.Vault().Get("old_file")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Version != header.CurrentVersion {
+			t.Errorf("expected version %s, got %s", header.CurrentVersion, h.Version)
+		}
+
+		outBuf.Reset()
+		if err := catCommand(th.Setup, "old_file", ui); err != nil {
+			t.Fatalf("catCommand failed: %v", err)
+		}
+		if outBuf.String() != "old content" {
+			t.Errorf("expected content preserved, got %q", outBuf.String())
+		}
+	})
+
+	t.Run("NothingToMigrate", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		if err := migrateCommand(th.Setup, true, ui); err != nil {
+			t.Fatalf("migrateCommand failed: %v", err)
+		}
+		if !strings.Contains(errBuf.String(), "Found no files to migrate") {
+			t.Errorf("unexpected output %q", errBuf.String())
+		}
+	})
+}
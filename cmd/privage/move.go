@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/revelaction/privage/credential"
+	"github.com/revelaction/privage/header"
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+// moveCommand changes the label of an encrypted file. The file is
+// reencrypted with the new header, and the old file removed.
+func moveCommand(s *setup.Setup, label, newLabel string, ui UI) error {
+	v, h, err := getForNewLabel(s, label, newLabel)
+	if err != nil {
+		return err
+	}
+
+	if err := v.Rename(h, &header.Header{Label: newLabel, Category: h.Category}); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "moved encrypted file %s to %s\n", label, newLabel)
+	return nil
+}
+
+// copyCommand copies an encrypted file to a new label.
+func copyCommand(s *setup.Setup, label, newLabel string, ui UI) error {
+	v, h, err := getForNewLabel(s, label, newLabel)
+	if err != nil {
+		return err
+	}
+
+	if err := v.Copy(h, &header.Header{Label: newLabel, Category: h.Category}); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "copied encrypted file %s to %s\n", label, newLabel)
+	return nil
+}
+
+// recatCommand changes the category of an encrypted file. The file is
+// reencrypted with the new header, and the old file removed. A file moved to
+// the credential category must be a valid credential file.
+func recatCommand(s *setup.Setup, label, cat string, ui UI) error {
+	v, err := vault.New(s)
+	if err != nil {
+		return err
+	}
+
+	h, err := v.Get(label)
+	if err != nil {
+		return err
+	}
+
+	if h.Category == cat {
+		return fmt.Errorf("the file %s is already in the category %s", h.Label, cat)
+	}
+
+	if cat == header.CategoryCredential {
+		if err := validateCredential(v, h); err != nil {
+			return err
+		}
+	}
+
+	if err := v.Rename(h, &header.Header{Label: h.Label, Category: cat}); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "moved encrypted file %s from category %s to %s\n", h.Label, h.Category, cat)
+	return nil
+}
+
+// getForNewLabel returns the vault and the header of the file of label,
+// checking that no file has the new label.
+func getForNewLabel(s *setup.Setup, label, newLabel string) (*vault.Vault, *header.Header, error) {
+	v, err := vault.New(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h, err := v.Get(label)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	exists, err := labelExists(v, newLabel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check if label exists: %w", err)
+	}
+	if exists {
+		return nil, nil, fmt.Errorf("second argument (label) %q already exist", newLabel)
+	}
+
+	return v, h, nil
+}
+
+// validateCredential returns an error if the content of the file of header
+// h is not a valid credential file.
+func validateCredential(v *vault.Vault, h *header.Header) (err error) {
+	r, err := v.Open(h)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if err := credential.Validate(r); err != nil {
+		return fmt.Errorf("the file %s is not a valid credential file: %w", h.Label, err)
+	}
+
+	return nil
+}
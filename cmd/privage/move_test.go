@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/revelaction/privage/vault"
+)
+
+// checkContent checks that the file of label has the category and content.
+func checkContent(t *testing.T, th *TestHelper, label, category, content string) {
+	t.Helper()
+	v := th.Vault()
+	h, err := v.Get(label)
+	if err != nil {
+		t.Fatalf("Get %s failed: %v", label, err)
+	}
+	if h.Category != category {
+		t.Errorf("got category %q of %s, want %q", h.Category, label, category)
+	}
+	got, err := readContent(v, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != content {
+		t.Errorf("got content %q of %s, want %q", got, label, content)
+	}
+}
+
+func TestMoveCommand(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("old", "work", "content")
+	th.AddEncryptedFile("taken", "personal", "other")
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+
+	err := moveCommand(th.Setup, "old", "taken", ui)
+	if err == nil || !strings.Contains(err.Error(), "already exist") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+
+	if err := moveCommand(th.Setup, "old", "new", ui); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(errBuf.String(), "moved encrypted file old to new") {
+		t.Errorf("expected success message, got: %s", errBuf.String())
+	}
+
+	checkContent(t, th, "new", "work", "content")
+	if _, err := th.Vault().Get("old"); !errors.Is(err, vault.ErrNotFound) {
+		t.Errorf("expected the old label to be removed, got %v", err)
+	}
+
+	if err := moveCommand(th.Setup, "old", "other", ui); !errors.Is(err, vault.ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("old", "work", "content")
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+
+	if err := copyCommand(th.Setup, "old", "new", ui); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	checkContent(t, th, "old", "work", "content")
+	checkContent(t, th, "new", "work", "content")
+
+	err := copyCommand(th.Setup, "old", "new", ui)
+	if err == nil || !strings.Contains(err.Error(), "already exist") {
+		t.Fatalf("expected collision error, got %v", err)
+	}
+}
+
+func TestRecatCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		cat     string
+		wantErr string
+		// wantCat is the category of the file after the command.
+		wantCat string
+	}{
+		{
+			name:    "Success",
+			content: "content",
+			cat:     "personal",
+			wantCat: "personal",
+		},
+		{
+			name:    "SameCategory",
+			content: "content",
+			cat:     "work",
+			wantErr: "already in the category",
+			wantCat: "work",
+		},
+		{
+			name:    "Credential",
+			content: "login = \"me\"\n",
+			cat:     "credential",
+			wantCat: "credential",
+		},
+		{
+			name:    "InvalidCredential",
+			content: "login =",
+			cat:     "credential",
+			wantErr: "not a valid credential file",
+			wantCat: "work",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := NewTestHelper(t)
+			th.AddEncryptedFile("label", "work", tt.content)
+
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+
+			err := recatCommand(th.Setup, "label", tt.cat, ui)
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("expected error containing %q, got %v", tt.wantErr, err)
+			}
+
+			checkContent(t, th, "label", tt.wantCat, tt.content)
+			headers, err := th.Vault().List()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(headers) != 1 {
+				t.Errorf("expected 1 file, got %d", len(headers))
+			}
+		})
+	}
+}
@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
+
+	"github.com/revelaction/privage/header"
 )
 
 func parseCatArgs(args []string, ui UI) (string, error) {
@@ -41,77 +44,130 @@ func parseCatArgs(args []string, ui UI) (string, error) {
 	return catArgs[0], nil
 }
 
-func parseInitArgs(args []string, ui UI) (string, error) {
+func parseInitArgs(args []string, ui UI) (keyOptions, error) {
 	fs := flag.NewFlagSet("init", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
-	var slot string
-	fs.StringVar(&slot, "piv-slot", "", "Use the yubikey slot key to encrypt the age private key")
-	fs.StringVar(&slot, "p", "", "alias for -piv-slot")
+	var ko keyOptions
+	fs.StringVar(&ko.slot, "piv-slot", "", "Use the yubikey slot key to encrypt the age private key")
+	fs.StringVar(&ko.slot, "p", "", "alias for -piv-slot")
+	fs.StringVar(&ko.serial, "piv-serial", "", "Use the yubikey with this serial number, if several are plugged in")
+	fs.BoolVar(&ko.passphrase, "passphrase", false, "Encrypt the age private key with a passphrase")
+	fs.BoolVar(&ko.pq, "pq", false, "Generate a post-quantum hybrid age key")
 	fs.Usage = func() {
 		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s init [options]\n", os.Args[0])
 		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  Add a .gitignore, age/yubikey key file to the current directory. Add a config file in the home directory.\n")
 		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  -p, -piv-slot string  Use the yubikey slot key to encrypt the age private key\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -piv-serial string    Use the yubikey with this serial number, if several are plugged in\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -passphrase           Encrypt the age private key with a passphrase\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -pq                   Generate a post-quantum hybrid age key\n")
 	}
 
 	if parseErr := fs.Parse(args); parseErr != nil {
 		if errors.Is(parseErr, flag.ErrHelp) {
 			fs.SetOutput(ui.Out)
 			fs.Usage()
-			return "", parseErr
+			return keyOptions{}, parseErr
 		}
 		fs.SetOutput(ui.Err)
 		FprintErr(ui.Err, parseErr)
 		fs.Usage()
-		return "", parseErr
+		return keyOptions{}, parseErr
+	}
+
+	if len(ko.slot) > 0 && ko.passphrase {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, errors.New("flags -piv-slot and -passphrase are incompatible")
 	}
 
-	return slot, nil
+	if len(ko.serial) > 0 && len(ko.slot) == 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, errors.New("flag -piv-serial needs the flag -piv-slot")
+	}
+
+	return ko, nil
 }
 
-func parseAddArgs(args []string, ui UI) (string, string, error) {
+func parseAddArgs(args []string, ui UI) (string, string, addOptions, error) {
 	fs := flag.NewFlagSet("add", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
+
+	var ao addOptions
+	fs.StringVar(&ao.from, "from", "", "Encrypt the content of the file in path")
+	fs.BoolVar(&ao.stdin, "stdin", false, "Encrypt the content read from the standard input")
+
 	fs.Usage = func() {
-		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s add [category] [label]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s add [options] [category] [label]\n", os.Args[0])
 		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
-		_, _ = fmt.Fprintf(fs.Output(), "  Add a new encrypted file.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Add a new encrypted file. Without options, the content of a custom\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  category is read from the file named after the label, and a credential\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  is created from a template.\n")
 		_, _ = fmt.Fprintf(fs.Output(), "\nArguments:\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  category  A category (e.g., 'credential' or any custom string)\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  label     A label for credentials, or an existing file path\n")
-	}
-
-	if parseErr := fs.Parse(args); parseErr != nil {
-		if errors.Is(parseErr, flag.ErrHelp) {
-			fs.SetOutput(ui.Out)
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -from string  Encrypt the content of the file in path\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -stdin        Encrypt the content read from the standard input\n")
+	}
+
+	// The options can also follow the arguments, as in
+	// "privage add backups db.sql --stdin"
+	var addArgs []string
+	for {
+		if parseErr := fs.Parse(args); parseErr != nil {
+			if errors.Is(parseErr, flag.ErrHelp) {
+				fs.SetOutput(ui.Out)
+				fs.Usage()
+				return "", "", addOptions{}, parseErr
+			}
+			fs.SetOutput(ui.Err)
+			FprintErr(ui.Err, parseErr)
 			fs.Usage()
-			return "", "", parseErr
+			return "", "", addOptions{}, parseErr
 		}
+		if fs.NArg() == 0 {
+			break
+		}
+		addArgs = append(addArgs, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+
+	if len(addArgs) != 2 {
 		fs.SetOutput(ui.Err)
-		FprintErr(ui.Err, parseErr)
 		fs.Usage()
-		return "", "", parseErr
+		return "", "", addOptions{}, errors.New("add command needs two arguments: <category> <label>")
 	}
 
-	addArgs := fs.Args()
-	if len(addArgs) != 2 {
+	if ao.stdin && ao.from != "" {
 		fs.SetOutput(ui.Err)
 		fs.Usage()
-		return "", "", errors.New("add command needs two arguments: <category> <label>")
+		return "", "", addOptions{}, errors.New("flags -from and -stdin are incompatible")
 	}
 
 	cat := addArgs[0]
 	if len(cat) > 32 {
-		return "", "", errors.New("first argument (category) length is greater than max allowed")
+		return "", "", addOptions{}, errors.New("first argument (category) length is greater than max allowed")
+	}
+	if strings.Contains(cat, header.CategorySeparator) {
+		return "", "", addOptions{}, fmt.Errorf("first argument (category) can not contain %q", header.CategorySeparator)
 	}
 
 	label := addArgs[1]
 	if len(label) > 128 {
-		return "", "", errors.New("second argument (label) length is greater than max allowed")
+		return "", "", addOptions{}, errors.New("second argument (label) length is greater than max allowed")
+	}
+	// Without a source, the label of a custom category is the path of
+	// the file.
+	if ao.source() || cat == header.CategoryCredential {
+		if err := header.ValidateLabel(label); err != nil {
+			return "", "", addOptions{}, fmt.Errorf("second argument (label): %w", err)
+		}
 	}
 
-	return cat, label, nil
+	return cat, label, ao, nil
 }
 
 func parseShowArgs(args []string, ui UI) (string, string, error) {
@@ -188,27 +244,223 @@ func parseDeleteArgs(args []string, ui UI) (string, error) {
 	return deleteArgs[0], nil
 }
 
-func parseKeyArgs(args []string, ui UI) error {
+func parseKeyArgs(args []string, ui UI) (string, keyOptions, error) {
 	fs := flag.NewFlagSet("key", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
 	fs.Usage = func() {
-		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s key\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s key [passwd|devices|enroll|split|combine|export|import]\n", os.Args[0])
 		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  Decrypt the age private key with the PIV key defined in the .privage.conf file.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nArguments:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  passwd   Change the passphrase of the age private key, or set one for a plain key\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  devices  List the detected yubikeys, with serial number, firmware and slot certificates\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  enroll   Encrypt the age private key also with the PIV key of a backup yubikey\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  split    Split the age private key in shares, to recover it with a number of them\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  combine  Recover the age private key file from the shares read from stdin\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  export   Print the age private key as a paper key, to restore it with import\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  import   Restore the age private key file from the paper key read from stdin\n")
 	}
 
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			fs.SetOutput(ui.Out)
 			fs.Usage()
-			return err
+			return "", keyOptions{}, err
 		}
 		fs.SetOutput(ui.Err)
 		_, _ = fmt.Fprintf(ui.Err, "Error: %v\n", err)
 		fs.Usage()
-		return err
+		return "", keyOptions{}, err
 	}
-	return nil
+
+	keyArgs := fs.Args()
+	if len(keyArgs) == 0 {
+		return "", keyOptions{}, nil
+	}
+
+	switch keyArgs[0] {
+	case "enroll":
+		ko, err := parseKeyEnrollArgs(keyArgs[1:], ui)
+		return keyArgs[0], ko, err
+	case "split":
+		ko, err := parseKeySplitArgs(keyArgs[1:], ui)
+		return keyArgs[0], ko, err
+	case "export":
+		ko, err := parseKeyExportArgs(keyArgs[1:], ui)
+		return keyArgs[0], ko, err
+	case "import":
+		ko, err := parseKeyImportArgs(keyArgs[1:], ui)
+		return keyArgs[0], ko, err
+	}
+
+	if len(keyArgs) > 1 || (keyArgs[0] != "passwd" && keyArgs[0] != "devices" && keyArgs[0] != "combine") {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", keyOptions{}, fmt.Errorf("unknown key action: %s", strings.Join(keyArgs, " "))
+	}
+
+	return keyArgs[0], keyOptions{}, nil
+}
+
+func parseKeyEnrollArgs(args []string, ui UI) (keyOptions, error) {
+	fs := flag.NewFlagSet("key enroll", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var ko keyOptions
+	fs.StringVar(&ko.slot, "piv-slot", "", "Use the slot key of the yubikey to encrypt the age private key")
+	fs.StringVar(&ko.slot, "p", "", "alias for -piv-slot")
+	fs.StringVar(&ko.serial, "piv-serial", "", "The serial number of the yubikey to enroll")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s key enroll -piv-serial serial [options]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Encrypt the age private key also with the PIV key of a backup yubikey, without rotating the files.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  The key can then be decrypted with any of the enrolled yubikeys.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -piv-serial string    The serial number of the yubikey to enroll, see key devices\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -p, -piv-slot string  Use the slot key of the yubikey, by default the slot of the config file\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return keyOptions{}, err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return keyOptions{}, err
+	}
+
+	if len(ko.serial) == 0 || fs.NArg() > 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, errors.New("key enroll needs the flag -piv-serial")
+	}
+
+	return ko, nil
+}
+
+func parseKeySplitArgs(args []string, ui UI) (keyOptions, error) {
+	fs := flag.NewFlagSet("key split", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var ko keyOptions
+	fs.IntVar(&ko.shares, "n", 5, "Number of shares")
+	fs.IntVar(&ko.threshold, "k", 3, "Number of shares that recover the key")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s key split [options]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Split the age private key in Shamir shares, one per line with a checksum.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Any k of the n shares recover the key file with key combine.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -n int  Number of shares (default 5)\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -k int  Number of shares that recover the key (default 3)\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return keyOptions{}, err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return keyOptions{}, err
+	}
+
+	if fs.NArg() > 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, fmt.Errorf("unknown key split argument: %s", strings.Join(fs.Args(), " "))
+	}
+
+	if ko.threshold < 2 || ko.threshold > ko.shares || ko.shares > 255 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, errors.New("flag -k must be between 2 and the flag -n, at most 255")
+	}
+
+	return ko, nil
+}
+
+func parseKeyExportArgs(args []string, ui UI) (keyOptions, error) {
+	fs := flag.NewFlagSet("key export", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var ko keyOptions
+	fs.BoolVar(&ko.paper, "paper", false, "Print the age private key as a paper key")
+	fs.BoolVar(&ko.qr, "qr", false, "Print also the QR code of the age private key")
+	fs.BoolVar(&ko.ascii, "ascii", false, "Print the QR code with ASCII characters")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s key export -paper [options]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Print the age private key as a paper key, in numbered lines with a checksum.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  A typo in a line is detected when restoring the key file with key import.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -paper  Print the age private key as a paper key\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -qr     Print also the QR code of the age private key\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -ascii  Print the QR code with ASCII characters, for printers\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return keyOptions{}, err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return keyOptions{}, err
+	}
+
+	if !ko.paper || fs.NArg() > 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, errors.New("key export needs the flag -paper")
+	}
+
+	if ko.ascii && !ko.qr {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, errors.New("flag -ascii needs the flag -qr")
+	}
+
+	return ko, nil
+}
+
+func parseKeyImportArgs(args []string, ui UI) (keyOptions, error) {
+	fs := flag.NewFlagSet("key import", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var ko keyOptions
+	fs.BoolVar(&ko.paper, "paper", false, "Read a paper key from stdin")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s key import -paper\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Restore the age private key file from the paper key typed in stdin.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  A line with a typo is reported by its checksum.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -paper  Read a paper key from stdin\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return keyOptions{}, err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return keyOptions{}, err
+	}
+
+	if !ko.paper || fs.NArg() > 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return keyOptions{}, errors.New("key import needs the flag -paper")
+	}
+
+	return ko, nil
 }
 
 func parseStatusArgs(args []string, ui UI) error {
@@ -331,6 +583,161 @@ func parseDecryptArgs(args []string, ui UI) (string, error) {
 	return decArgs[0], nil
 }
 
+func parseMvArgs(args []string, ui UI) (string, string, error) {
+	fs := flag.NewFlagSet("mv", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s mv [label] [newlabel]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Change the label of an encrypted file. The file is reencrypted with the\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  new label and the old file is removed.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nArguments:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  label     The label of the file to rename\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  newlabel  The new label of the file\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return "", "", err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return "", "", err
+	}
+
+	if fs.NArg() != 2 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", "", errors.New("mv command needs two arguments: <label> <newlabel>")
+	}
+
+	if len(fs.Arg(1)) > 128 {
+		return "", "", errors.New("second argument (label) length is greater than max allowed")
+	}
+	if err := header.ValidateLabel(fs.Arg(1)); err != nil {
+		return "", "", fmt.Errorf("second argument (label): %w", err)
+	}
+
+	return fs.Arg(0), fs.Arg(1), nil
+}
+
+func parseCpArgs(args []string, ui UI) (string, string, error) {
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s cp [label] [newlabel]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Copy an encrypted file to a new label.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nArguments:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  label     The label of the file to copy\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  newlabel  The label of the copy\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return "", "", err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return "", "", err
+	}
+
+	if fs.NArg() != 2 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", "", errors.New("cp command needs two arguments: <label> <newlabel>")
+	}
+
+	if len(fs.Arg(1)) > 128 {
+		return "", "", errors.New("second argument (label) length is greater than max allowed")
+	}
+	if err := header.ValidateLabel(fs.Arg(1)); err != nil {
+		return "", "", fmt.Errorf("second argument (label): %w", err)
+	}
+
+	return fs.Arg(0), fs.Arg(1), nil
+}
+
+func parseRecatArgs(args []string, ui UI) (string, string, error) {
+	fs := flag.NewFlagSet("recat", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s recat [label] [category]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Change the category of an encrypted file. The file is reencrypted with the\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  new category and the old file is removed. A file moved to the credential\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  category must be a valid credential file.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nArguments:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  label     The label of the file\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  category  The new category of the file (e.g., 'credential' or any custom string)\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return "", "", err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return "", "", err
+	}
+
+	if fs.NArg() != 2 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", "", errors.New("recat command needs two arguments: <label> <category>")
+	}
+
+	if len(fs.Arg(1)) > 32 {
+		return "", "", errors.New("second argument (category) length is greater than max allowed")
+	}
+	if strings.Contains(fs.Arg(1), header.CategorySeparator) {
+		return "", "", fmt.Errorf("second argument (category) can not contain %q", header.CategorySeparator)
+	}
+
+	return fs.Arg(0), fs.Arg(1), nil
+}
+
+func parseEditArgs(args []string, ui UI) (string, error) {
+	fs := flag.NewFlagSet("edit", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s edit [label]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Decrypt a file to a temporary file in memory, open it with $EDITOR and\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  reencrypt it if it was modified. The temporary file is then removed.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nArguments:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  label  The label of the file to edit\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return "", err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return "", err
+	}
+
+	if fs.NArg() != 1 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", errors.New("edit command needs one argument (label)")
+	}
+	return fs.Arg(0), nil
+}
+
 func parseReencryptArgs(args []string, ui UI) (bool, bool, error) {
 	fs := flag.NewFlagSet("reencrypt", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
@@ -362,36 +769,230 @@ func parseReencryptArgs(args []string, ui UI) (bool, bool, error) {
 	return force, clean, nil
 }
 
-func parseRotateArgs(args []string, ui UI) (bool, string, error) {
+func parseMigrateArgs(args []string, ui UI) (bool, error) {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var force bool
+	fs.BoolVar(&force, "force", false, "Force migration of the files.")
+	fs.BoolVar(&force, "f", false, "alias for -force")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s migrate [options]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Upgrade the headers of all encrypted files to the current version. (default is dry-run)\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -f, -force  Force migration of the files.\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return false, err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return false, err
+	}
+	return force, nil
+}
+
+func parseFsckArgs(args []string, ui UI) (bool, error) {
+	fs := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var repair bool
+	fs.BoolVar(&repair, "repair", false, "Repair the problems that can be fixed safely.")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s fsck [options]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Check every encrypted file of the repository: padding, header and content\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  decryption, file name and duplicate labels. Find leftover temporary, rotation\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  and decrypted files. Exit with an error if problems are found.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -repair  Repair the problems that can be fixed safely.\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return false, err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return false, err
+	}
+
+	if fs.NArg() > 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return false, fmt.Errorf("unknown fsck argument: %s", strings.Join(fs.Args(), " "))
+	}
+
+	return repair, nil
+}
+
+func parseVerifyArgs(args []string, ui UI) (bool, error) {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	var sign bool
+	fs.BoolVar(&sign, "sign", false, "Sign the manifest of the current files.")
+	fs.BoolVar(&sign, "s", false, "alias for -sign")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s verify [options]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Verify the encrypted files against the signed manifest of the repository.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Report unknown, missing and changed (f. ex. rolled back) files.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -s, -sign  Sign the manifest of the current files, after checking them.\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return false, err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return false, err
+	}
+
+	if fs.NArg() > 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return false, fmt.Errorf("unknown verify argument: %s", strings.Join(fs.Args(), " "))
+	}
+
+	return sign, nil
+}
+
+func parseRecipientsArgs(args []string, ui UI) (string, string, error) {
+	fs := flag.NewFlagSet("recipients", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s recipients [add|remove public_key]\n", os.Args[0])
+		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  List, add or remove the age or ssh-ed25519 public keys the files are encrypted to.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  Adding or removing a recipient reencrypts all files.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "\nArguments:\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  add public_key     Add the age public key to the recipients file of the repository\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  remove public_key  Remove the age public key from the recipients file of the repository\n")
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fs.SetOutput(ui.Out)
+			fs.Usage()
+			return "", "", err
+		}
+		fs.SetOutput(ui.Err)
+		FprintErr(ui.Err, err)
+		fs.Usage()
+		return "", "", err
+	}
+
+	recipientsArgs := fs.Args()
+	if len(recipientsArgs) == 0 {
+		return "", "", nil
+	}
+
+	action := recipientsArgs[0]
+	if action != "add" && action != "remove" {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", "", fmt.Errorf("unknown recipients action: %s", action)
+	}
+
+	// An unquoted SSH public key is split in its type, key and comment
+	if len(recipientsArgs) > 2 && strings.HasPrefix(recipientsArgs[1], "ssh-") {
+		return action, strings.Join(recipientsArgs[1:], " "), nil
+	}
+
+	if len(recipientsArgs) != 2 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", "", fmt.Errorf("recipients %s needs one argument (public key)", action)
+	}
+
+	return action, recipientsArgs[1], nil
+}
+
+func parseRotateArgs(args []string, ui UI) (string, keyOptions, error) {
 	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
 	fs.SetOutput(io.Discard)
-	var clean bool
-	var slot string
+	var clean, resume, abort bool
+	var ko keyOptions
 	fs.BoolVar(&clean, "clean", false, "Delete old Key's encrypted files. Rename new encrypted files and the new key")
 	fs.BoolVar(&clean, "c", false, "alias for -clean")
-	fs.StringVar(&slot, "piv-slot", "", "Use the yubikey slot to encrypt the age private key with the RSA Key")
-	fs.StringVar(&slot, "p", "", "alias for -piv-slot")
+	fs.BoolVar(&resume, "resume", false, "Finish an interrupted rotation")
+	fs.BoolVar(&abort, "abort", false, "Undo an interrupted rotation, if the old files were not deleted yet")
+	fs.StringVar(&ko.slot, "piv-slot", "", "Use the yubikey slot to encrypt the age private key with the RSA Key")
+	fs.StringVar(&ko.slot, "p", "", "alias for -piv-slot")
+	fs.StringVar(&ko.serial, "piv-serial", "", "Use the yubikey with this serial number, if several are plugged in")
+	fs.BoolVar(&ko.passphrase, "passphrase", false, "Encrypt the age private key with a passphrase")
+	fs.BoolVar(&ko.pq, "pq", false, "Generate a post-quantum hybrid age key")
 	fs.Usage = func() {
 		_, _ = fmt.Fprintf(fs.Output(), "Usage: %s rotate [options]\n", os.Args[0])
 		_, _ = fmt.Fprintf(fs.Output(), "\nDescription:\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  Create a new age key and reencrypt every file with the new key.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  The new key is encrypted with a passphrase if the identity_type of the config file is PASSPHRASE.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  The new key is a post-quantum hybrid key if the current one is.\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  The steps are recorded in an encrypted journal: an interrupted rotation\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  can be finished with -resume, or undone with -abort.\n")
 		_, _ = fmt.Fprintf(fs.Output(), "\nOptions:\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  -c, -clean           Delete old Key's encrypted files. Rename new encrypted files and the new key\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -resume               Finish an interrupted rotation\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -abort                Undo an interrupted rotation, if the old files were not deleted yet\n")
 		_, _ = fmt.Fprintf(fs.Output(), "  -p, -piv-slot string  Use the yubikey slot to encrypt the age private key with the RSA Key\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -piv-serial string    Use the yubikey with this serial number, if several are plugged in\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -passphrase           Encrypt the age private key with a passphrase\n")
+		_, _ = fmt.Fprintf(fs.Output(), "  -pq                   Generate a post-quantum hybrid age key\n")
 	}
 
 	if err := fs.Parse(args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			fs.SetOutput(ui.Out)
 			fs.Usage()
-			return false, "", err
+			return "", keyOptions{}, err
 		}
 		fs.SetOutput(ui.Err)
 		FprintErr(ui.Err, err)
 		fs.Usage()
-		return false, "", err
+		return "", keyOptions{}, err
+	}
+
+	if len(ko.slot) > 0 && ko.passphrase {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", keyOptions{}, errors.New("flags -piv-slot and -passphrase are incompatible")
 	}
-	return clean, slot, nil
+
+	if len(ko.serial) > 0 && len(ko.slot) == 0 {
+		fs.SetOutput(ui.Err)
+		fs.Usage()
+		return "", keyOptions{}, errors.New("flag -piv-serial needs the flag -piv-slot")
+	}
+
+	action := ""
+	for _, a := range []struct {
+		set  bool
+		name string
+	}{{clean, "clean"}, {resume, "resume"}, {abort, "abort"}} {
+		if !a.set {
+			continue
+		}
+		if action != "" {
+			fs.SetOutput(ui.Err)
+			fs.Usage()
+			return "", keyOptions{}, fmt.Errorf("flags -%s and -%s are incompatible", action, a.name)
+		}
+		action = a.name
+	}
+
+	return action, ko, nil
 }
 
 func parseBashArgs(args []string, ui UI) error {
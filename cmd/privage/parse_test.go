@@ -6,6 +6,8 @@ import (
 	"flag"
 	"strings"
 	"testing"
+
+	"github.com/revelaction/privage/header"
 )
 
 func TestParseCatArgs(t *testing.T) {
@@ -62,24 +64,78 @@ func TestParseInitArgs(t *testing.T) {
 	t.Run("SuccessEmpty", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		slot, err := parseInitArgs([]string{}, ui)
+		ko, err := parseInitArgs([]string{}, ui)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if slot != "" {
-			t.Errorf("got slot %q, want empty", slot)
+		if ko != (keyOptions{}) {
+			t.Errorf("got %+v, want empty options", ko)
 		}
 	})
 
 	t.Run("SuccessSlot", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		slot, err := parseInitArgs([]string{"-p", "9c"}, ui)
+		ko, err := parseInitArgs([]string{"-p", "9c"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ko.slot != "9c" {
+			t.Errorf("got slot %q, want %q", ko.slot, "9c")
+		}
+	})
+
+	t.Run("SuccessPassphrase", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		ko, err := parseInitArgs([]string{"-passphrase"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ko.slot != "" || !ko.passphrase {
+			t.Errorf("got slot %q, passphrase %v, want empty/true", ko.slot, ko.passphrase)
+		}
+	})
+
+	t.Run("SuccessPq", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		ko, err := parseInitArgs([]string{"-pq", "-passphrase"}, ui)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if slot != "9c" {
-			t.Errorf("got slot %q, want %q", slot, "9c")
+		if !ko.pq || !ko.passphrase {
+			t.Errorf("got pq %v, passphrase %v, want true/true", ko.pq, ko.passphrase)
+		}
+	})
+
+	t.Run("SuccessSerial", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		ko, err := parseInitArgs([]string{"-p", "9c", "-piv-serial", "12345678"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ko.slot != "9c" || ko.serial != "12345678" {
+			t.Errorf("got slot %q, serial %q, want 9c/12345678", ko.slot, ko.serial)
+		}
+	})
+
+	t.Run("SerialWithoutSlot", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, err := parseInitArgs([]string{"-piv-serial", "12345678"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "needs the flag -piv-slot") {
+			t.Fatalf("expected missing slot error, got %v", err)
+		}
+	})
+
+	t.Run("SlotAndPassphrase", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, err := parseInitArgs([]string{"-p", "9c", "-passphrase"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "incompatible") {
+			t.Fatalf("expected incompatible flags error, got %v", err)
 		}
 	})
 
@@ -112,7 +168,7 @@ func TestParseAddArgs(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		cat, lab, err := parseAddArgs([]string{"cred", "mylabel"}, ui)
+		cat, lab, _, err := parseAddArgs([]string{"cred", "mylabel"}, ui)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
@@ -124,7 +180,7 @@ func TestParseAddArgs(t *testing.T) {
 	t.Run("Help", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		_, _, err := parseAddArgs([]string{"--help"}, ui)
+		_, _, _, err := parseAddArgs([]string{"--help"}, ui)
 		if !errors.Is(err, flag.ErrHelp) {
 			t.Fatalf("expected flag.ErrHelp, got %v", err)
 		}
@@ -136,7 +192,7 @@ func TestParseAddArgs(t *testing.T) {
 	t.Run("MissingArgs", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		_, _, err := parseAddArgs([]string{"cred"}, ui)
+		_, _, _, err := parseAddArgs([]string{"cred"}, ui)
 		if err == nil {
 			t.Fatal("expected error for missing argument")
 		}
@@ -148,7 +204,7 @@ func TestParseAddArgs(t *testing.T) {
 	t.Run("CategoryTooLong", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		_, _, err := parseAddArgs([]string{strings.Repeat("a", 33), "lab"}, ui)
+		_, _, _, err := parseAddArgs([]string{strings.Repeat("a", 33), "lab"}, ui)
 		if err == nil {
 			t.Fatal("expected error for long category")
 		}
@@ -157,11 +213,67 @@ func TestParseAddArgs(t *testing.T) {
 	t.Run("LabelTooLong", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		_, _, err := parseAddArgs([]string{"cat", strings.Repeat("a", 129)}, ui)
+		_, _, _, err := parseAddArgs([]string{"cat", strings.Repeat("a", 129)}, ui)
 		if err == nil {
 			t.Fatal("expected error for long label")
 		}
 	})
+
+	t.Run("CategorySeparator", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, _, err := parseAddArgs([]string{"work/home", "lab"}, ui)
+		if err == nil {
+			t.Fatal("expected error for category with separator")
+		}
+	})
+
+	t.Run("Source", func(t *testing.T) {
+		tests := []struct {
+			args []string
+			want addOptions
+		}{
+			{args: []string{"--stdin", "backups", "db.sql"}, want: addOptions{stdin: true}},
+			{args: []string{"backups", "db.sql", "--stdin"}, want: addOptions{stdin: true}},
+			{args: []string{"backups", "--from", "/tmp/dump", "db.sql"}, want: addOptions{from: "/tmp/dump"}},
+		}
+		for _, tt := range tests {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			cat, lab, ao, err := parseAddArgs(tt.args, ui)
+			if err != nil {
+				t.Fatalf("unexpected error for %v: %v", tt.args, err)
+			}
+			if cat != "backups" || lab != "db.sql" || ao != tt.want {
+				t.Errorf("got %q %q %+v for %v", cat, lab, ao, tt.args)
+			}
+		}
+	})
+
+	t.Run("InvalidLabel", func(t *testing.T) {
+		for _, args := range [][]string{
+			{"backups", "../db.sql", "--stdin"},
+			{"backups", "a/db.sql", "--from", "dump"},
+			{"credential", "..", "--stdin"},
+			{"credential", "site.com/me"},
+		} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			_, _, _, err := parseAddArgs(args, ui)
+			if !errors.Is(err, header.ErrInvalidLabel) {
+				t.Errorf("expected ErrInvalidLabel for %v, got %v", args, err)
+			}
+		}
+	})
+
+	t.Run("IncompatibleSources", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, _, err := parseAddArgs([]string{"backups", "db.sql", "--stdin", "--from", "dump"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "incompatible") {
+			t.Fatalf("expected incompatible flags error, got %v", err)
+		}
+	})
 }
 
 func TestParseShowArgs(t *testing.T) {
@@ -264,28 +376,256 @@ func TestParseReencryptArgs(t *testing.T) {
 	})
 }
 
+func TestParseMigrateArgs(t *testing.T) {
+	t.Run("SuccessDryRun", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		force, err := parseMigrateArgs([]string{}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if force {
+			t.Error("got force=true, want false")
+		}
+	})
+
+	t.Run("SuccessForce", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		force, err := parseMigrateArgs([]string{"-f"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !force {
+			t.Error("got force=false, want true")
+		}
+	})
+
+	t.Run("Help", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, err := parseMigrateArgs([]string{"--help"}, ui)
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+		if outBuf.Len() == 0 {
+			t.Error("expected usage output in Out buffer")
+		}
+	})
+
+	t.Run("UnknownFlag", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, err := parseMigrateArgs([]string{"--foo"}, ui)
+		if err == nil {
+			t.Fatal("expected error for unknown flag")
+		}
+		if errBuf.Len() == 0 {
+			t.Error("expected error message in Err buffer")
+		}
+	})
+}
+
+func TestParseFsckArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantRepair bool
+		wantErr    bool
+	}{
+		{"Check", []string{}, false, false},
+		{"Repair", []string{"--repair"}, true, false},
+		{"UnknownFlag", []string{"--foo"}, false, true},
+		{"UnknownArgument", []string{"foo"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			repair, err := parseFsckArgs(tt.args, ui)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if repair != tt.wantRepair {
+				t.Errorf("got repair=%v, want %v", repair, tt.wantRepair)
+			}
+		})
+	}
+}
+
+func TestParseVerifyArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		wantSign bool
+		wantErr  bool
+	}{
+		{"Verify", []string{}, false, false},
+		{"Sign", []string{"-sign"}, true, false},
+		{"SignAlias", []string{"-s"}, true, false},
+		{"UnknownFlag", []string{"--foo"}, false, true},
+		{"UnknownArgument", []string{"foo"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			sign, err := parseVerifyArgs(tt.args, ui)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if sign != tt.wantSign {
+				t.Errorf("got sign=%v, want %v", sign, tt.wantSign)
+			}
+		})
+	}
+}
+
+func TestParseRecipientsArgs(t *testing.T) {
+	tests := []struct {
+		name       string
+		args       []string
+		wantAction string
+		wantKey    string
+		wantErr    bool
+	}{
+		{name: "List", args: []string{}},
+		{name: "Add", args: []string{"add", "age1key"}, wantAction: "add", wantKey: "age1key"},
+		{name: "Remove", args: []string{"remove", "age1key"}, wantAction: "remove", wantKey: "age1key"},
+		{name: "AddSsh", args: []string{"add", "ssh-ed25519", "AAAAkey", "user@host"}, wantAction: "add", wantKey: "ssh-ed25519 AAAAkey user@host"},
+		{name: "TooManyArgs", args: []string{"add", "age1key", "age1other"}, wantErr: true},
+		{name: "MissingKey", args: []string{"add"}, wantErr: true},
+		{name: "UnknownAction", args: []string{"rename", "age1key"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			action, key, err := parseRecipientsArgs(tt.args, ui)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if action != tt.wantAction || key != tt.wantKey {
+				t.Errorf("got action=%q key=%q, want %q %q", action, key, tt.wantAction, tt.wantKey)
+			}
+		})
+	}
+
+	t.Run("Help", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseRecipientsArgs([]string{"--help"}, ui)
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+		if outBuf.Len() == 0 {
+			t.Error("expected usage output in Out buffer")
+		}
+	})
+}
+
 func TestParseRotateArgs(t *testing.T) {
 	t.Run("SuccessDefault", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		clean, slot, err := parseRotateArgs([]string{}, ui)
+		action, ko, err := parseRotateArgs([]string{}, ui)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if clean || slot != "" {
-			t.Errorf("got clean=%v, slot=%q, want false/empty", clean, slot)
+		if action != "" || ko != (keyOptions{}) {
+			t.Errorf("got action=%q, options=%+v, want empty", action, ko)
 		}
 	})
 
 	t.Run("SuccessCleanAndSlot", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		clean, slot, err := parseRotateArgs([]string{"--clean", "--piv-slot", "9e"}, ui)
+		action, ko, err := parseRotateArgs([]string{"--clean", "--piv-slot", "9e"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "clean" || ko.slot != "9e" {
+			t.Errorf("got action=%q, slot=%q, want clean/9e", action, ko.slot)
+		}
+	})
+
+	t.Run("SuccessResumeAndAbort", func(t *testing.T) {
+		for _, want := range []string{"resume", "abort"} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			action, _, err := parseRotateArgs([]string{"--" + want}, ui)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if action != want {
+				t.Errorf("got action=%q, want %q", action, want)
+			}
+		}
+	})
+
+	t.Run("ResumeAndAbort", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseRotateArgs([]string{"--resume", "--abort"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "-resume and -abort are incompatible") {
+			t.Fatalf("expected incompatible flags error, got %v", err)
+		}
+	})
+
+	t.Run("SuccessSerial", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, ko, err := parseRotateArgs([]string{"--piv-slot", "9e", "--piv-serial", "87654321"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ko.serial != "87654321" {
+			t.Errorf("got serial %q, want 87654321", ko.serial)
+		}
+	})
+
+	t.Run("SerialWithoutSlot", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseRotateArgs([]string{"--piv-serial", "87654321"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "needs the flag -piv-slot") {
+			t.Fatalf("expected missing slot error, got %v", err)
+		}
+	})
+
+	t.Run("SuccessPassphrase", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, ko, err := parseRotateArgs([]string{"--passphrase"}, ui)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
-		if !clean || slot != "9e" {
-			t.Errorf("got clean=%v, slot=%q, want true/9e", clean, slot)
+		if !ko.passphrase {
+			t.Errorf("expected passphrase")
+		}
+	})
+
+	t.Run("SuccessPq", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, ko, err := parseRotateArgs([]string{"--pq"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !ko.pq {
+			t.Errorf("expected pq")
+		}
+	})
+
+	t.Run("SlotAndPassphrase", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseRotateArgs([]string{"--passphrase", "-p", "9e"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "incompatible") {
+			t.Fatalf("expected incompatible flags error, got %v", err)
 		}
 	})
 
@@ -402,20 +742,385 @@ func TestParseDeleteArgs(t *testing.T) {
 	})
 }
 
+func TestParseMvArgs(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		label, newLabel, err := parseMvArgs([]string{"mylabel", "other"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if label != "mylabel" || newLabel != "other" {
+			t.Errorf("got %q %q, want %q %q", label, newLabel, "mylabel", "other")
+		}
+	})
+
+	t.Run("Help", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseMvArgs([]string{"--help"}, ui)
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+		if outBuf.Len() == 0 {
+			t.Error("expected usage output in Out buffer")
+		}
+	})
+
+	t.Run("WrongArgs", func(t *testing.T) {
+		for _, args := range [][]string{{"mylabel"}, {"a", "b", "c"}} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			_, _, err := parseMvArgs(args, ui)
+			if err == nil {
+				t.Fatalf("expected error for args %v", args)
+			}
+			if errBuf.Len() == 0 {
+				t.Error("expected usage output in Err buffer")
+			}
+		}
+	})
+
+	t.Run("LabelTooLong", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseMvArgs([]string{"mylabel", strings.Repeat("a", 129)}, ui)
+		if err == nil {
+			t.Fatal("expected error for too long label")
+		}
+	})
+
+	t.Run("InvalidLabel", func(t *testing.T) {
+		for _, newLabel := range []string{"", "..", "work/other", "../other"} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			_, _, err := parseMvArgs([]string{"mylabel", newLabel}, ui)
+			if !errors.Is(err, header.ErrInvalidLabel) {
+				t.Errorf("expected ErrInvalidLabel for %q, got %v", newLabel, err)
+			}
+		}
+	})
+}
+
+func TestParseCpArgs(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		label, newLabel, err := parseCpArgs([]string{"mylabel", "other"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if label != "mylabel" || newLabel != "other" {
+			t.Errorf("got %q %q, want %q %q", label, newLabel, "mylabel", "other")
+		}
+	})
+
+	t.Run("Help", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseCpArgs([]string{"--help"}, ui)
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+		if outBuf.Len() == 0 {
+			t.Error("expected usage output in Out buffer")
+		}
+	})
+
+	t.Run("WrongArgs", func(t *testing.T) {
+		for _, args := range [][]string{{"mylabel"}, {"a", "b", "c"}} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			_, _, err := parseCpArgs(args, ui)
+			if err == nil {
+				t.Fatalf("expected error for args %v", args)
+			}
+			if errBuf.Len() == 0 {
+				t.Error("expected usage output in Err buffer")
+			}
+		}
+	})
+
+	t.Run("LabelTooLong", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseCpArgs([]string{"mylabel", strings.Repeat("a", 129)}, ui)
+		if err == nil {
+			t.Fatal("expected error for too long label")
+		}
+	})
+
+	t.Run("InvalidLabel", func(t *testing.T) {
+		for _, newLabel := range []string{"", "..", "work/other", "../other"} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			_, _, err := parseCpArgs([]string{"mylabel", newLabel}, ui)
+			if !errors.Is(err, header.ErrInvalidLabel) {
+				t.Errorf("expected ErrInvalidLabel for %q, got %v", newLabel, err)
+			}
+		}
+	})
+}
+
+func TestParseRecatArgs(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		label, cat, err := parseRecatArgs([]string{"mylabel", "other"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if label != "mylabel" || cat != "other" {
+			t.Errorf("got %q %q, want %q %q", label, cat, "mylabel", "other")
+		}
+	})
+
+	t.Run("Help", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseRecatArgs([]string{"--help"}, ui)
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+		if outBuf.Len() == 0 {
+			t.Error("expected usage output in Out buffer")
+		}
+	})
+
+	t.Run("WrongArgs", func(t *testing.T) {
+		for _, args := range [][]string{{"mylabel"}, {"a", "b", "c"}} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			_, _, err := parseRecatArgs(args, ui)
+			if err == nil {
+				t.Fatalf("expected error for args %v", args)
+			}
+			if errBuf.Len() == 0 {
+				t.Error("expected usage output in Err buffer")
+			}
+		}
+	})
+
+	t.Run("CategoryTooLong", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseRecatArgs([]string{"mylabel", strings.Repeat("a", 33)}, ui)
+		if err == nil {
+			t.Fatal("expected error for too long category")
+		}
+	})
+
+	t.Run("CategorySeparator", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseRecatArgs([]string{"mylabel", "work/home"}, ui)
+		if err == nil {
+			t.Fatal("expected error for category with separator")
+		}
+	})
+}
+
+func TestParseEditArgs(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		label, err := parseEditArgs([]string{"mylabel"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if label != "mylabel" {
+			t.Errorf("got label %q, want %q", label, "mylabel")
+		}
+	})
+
+	t.Run("Help", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, err := parseEditArgs([]string{"--help"}, ui)
+		if !errors.Is(err, flag.ErrHelp) {
+			t.Fatalf("expected flag.ErrHelp, got %v", err)
+		}
+		if outBuf.Len() == 0 {
+			t.Error("expected usage output in Out buffer")
+		}
+	})
+
+	t.Run("WrongArgs", func(t *testing.T) {
+		for _, args := range [][]string{{}, {"a", "b"}} {
+			var outBuf, errBuf bytes.Buffer
+			ui := UI{Out: &outBuf, Err: &errBuf}
+			_, err := parseEditArgs(args, ui)
+			if err == nil {
+				t.Fatalf("expected error for args %v", args)
+			}
+			if errBuf.Len() == 0 {
+				t.Error("expected usage output in Err buffer")
+			}
+		}
+	})
+}
+
 func TestParseKeyArgs(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		err := parseKeyArgs([]string{}, ui)
+		action, _, err := parseKeyArgs([]string{}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "" {
+			t.Errorf("got action %q, want empty", action)
+		}
+	})
+
+	t.Run("Passwd", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		action, _, err := parseKeyArgs([]string{"passwd"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "passwd" {
+			t.Errorf("got action %q, want passwd", action)
+		}
+	})
+
+	t.Run("Devices", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		action, _, err := parseKeyArgs([]string{"devices"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "devices" {
+			t.Errorf("got action %q, want devices", action)
+		}
+	})
+
+	t.Run("Enroll", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		action, ko, err := parseKeyArgs([]string{"enroll", "-p", "9b", "-piv-serial", "2222"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "enroll" {
+			t.Errorf("got action %q, want enroll", action)
+		}
+		if ko.slot != "9b" || ko.serial != "2222" {
+			t.Errorf("got slot %q and serial %q, want 9b and 2222", ko.slot, ko.serial)
+		}
+	})
+
+	t.Run("EnrollWithoutSerial", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseKeyArgs([]string{"enroll", "-p", "9b"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "-piv-serial") {
+			t.Fatalf("expected -piv-serial error, got %v", err)
+		}
+	})
+
+	t.Run("Split", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		action, ko, err := parseKeyArgs([]string{"split", "-n", "4", "-k", "2"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "split" || ko.shares != 4 || ko.threshold != 2 {
+			t.Errorf("got action %q, %d of %d shares, want split, 2 of 4", action, ko.threshold, ko.shares)
+		}
+	})
+
+	t.Run("SplitDefault", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, ko, err := parseKeyArgs([]string{"split"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if ko.shares != 5 || ko.threshold != 3 {
+			t.Errorf("got %d of %d shares, want 3 of 5", ko.threshold, ko.shares)
+		}
+	})
+
+	t.Run("SplitInvalidThreshold", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseKeyArgs([]string{"split", "-n", "2", "-k", "3"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "flag -k") {
+			t.Fatalf("expected flag -k error, got %v", err)
+		}
+	})
+
+	t.Run("Combine", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		action, _, err := parseKeyArgs([]string{"combine"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "combine" {
+			t.Errorf("got action %q, want combine", action)
+		}
+	})
+
+	t.Run("Export", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		action, ko, err := parseKeyArgs([]string{"export", "-paper", "-qr"}, ui)
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
+		if action != "export" || !ko.paper || !ko.qr || ko.ascii {
+			t.Errorf("got action %q, options %+v, want export -paper -qr", action, ko)
+		}
+	})
+
+	t.Run("ExportWithoutPaper", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseKeyArgs([]string{"export", "-qr"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "-paper") {
+			t.Fatalf("expected -paper error, got %v", err)
+		}
+	})
+
+	t.Run("ExportAsciiWithoutQR", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseKeyArgs([]string{"export", "-paper", "-ascii"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "-qr") {
+			t.Fatalf("expected -qr error, got %v", err)
+		}
+	})
+
+	t.Run("Import", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		action, ko, err := parseKeyArgs([]string{"import", "-paper"}, ui)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if action != "import" || !ko.paper {
+			t.Errorf("got action %q, options %+v, want import -paper", action, ko)
+		}
+	})
+
+	t.Run("UnknownAction", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+		_, _, err := parseKeyArgs([]string{"foo"}, ui)
+		if err == nil || !strings.Contains(err.Error(), "unknown key action") {
+			t.Fatalf("expected unknown key action error, got %v", err)
+		}
 	})
 
 	t.Run("Help", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		err := parseKeyArgs([]string{"--help"}, ui)
+		_, _, err := parseKeyArgs([]string{"--help"}, ui)
 		if !errors.Is(err, flag.ErrHelp) {
 			t.Fatalf("expected flag.ErrHelp, got %v", err)
 		}
@@ -427,7 +1132,7 @@ func TestParseKeyArgs(t *testing.T) {
 	t.Run("UnknownFlag", func(t *testing.T) {
 		var outBuf, errBuf bytes.Buffer
 		ui := UI{Out: &outBuf, Err: &errBuf}
-		err := parseKeyArgs([]string{"--foo"}, ui)
+		_, _, err := parseKeyArgs([]string{"--foo"}, ui)
 		if err == nil {
 			t.Fatal("expected error for unknown flag")
 		}
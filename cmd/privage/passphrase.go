@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	// passphraseEnv is the environment variable with the passphrase of the
+	// identity, for automation.
+	passphraseEnv = "PRIVAGE_PASSPHRASE"
+
+	// newPassphraseEnv is the environment variable with the passphrase of
+	// the identities created by init and rotate, and changed by key passwd.
+	newPassphraseEnv = "PRIVAGE_NEW_PASSPHRASE"
+
+	// passphraseFdEnv is the environment variable with a file descriptor
+	// the passphrases are read from, one per line.
+	passphraseFdEnv = "PRIVAGE_PASSPHRASE_FD"
+)
+
+// errNoPassphrase is returned when a passphrase is needed but can not be
+// read.
+var errNoPassphrase = fmt.Errorf("could not read passphrase: no terminal, set %s or %s", passphraseEnv, passphraseFdEnv)
+
+// fdReader reads lines from the file descriptor of the environment
+// variable env. The reader is kept, so that consecutive lines are read from
+// the same buffer.
+type fdReader struct {
+	env string
+	fd  string
+	r   *bufio.Reader
+}
+
+// passphraseFd is the reader of the file descriptor of passphraseFdEnv.
+var passphraseFd = &fdReader{env: passphraseFdEnv}
+
+// readPassphrase returns a passphrase, from the environment variable env,
+// from the file descriptor of PRIVAGE_PASSPHRASE_FD or from the terminal,
+// with the given prompt.
+func readPassphrase(env, prompt string) (string, error) {
+	if p, ok := os.LookupEnv(env); ok {
+		return p, nil
+	}
+
+	if fd := os.Getenv(passphraseFdEnv); fd != "" {
+		return passphraseFd.readLine(fd)
+	}
+
+	return readPassphraseTerminal(prompt)
+}
+
+// newPassphrase returns a new, not empty, passphrase. In the terminal, it
+// is asked twice.
+func newPassphrase() (string, error) {
+	_, fromEnv := os.LookupEnv(newPassphraseEnv)
+	p, err := readPassphrase(newPassphraseEnv, "Enter new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if p == "" {
+		return "", errors.New("the passphrase must not be empty")
+	}
+
+	if fromEnv || os.Getenv(passphraseFdEnv) != "" {
+		return p, nil
+	}
+
+	confirm, err := readPassphraseTerminal("Confirm new passphrase: ")
+	if err != nil {
+		return "", err
+	}
+	if confirm != p {
+		return "", errors.New("the passphrases do not match")
+	}
+
+	return p, nil
+}
+
+// readLine returns the next line of the file descriptor fd.
+func (f *fdReader) readLine(fd string) (string, error) {
+	if f.fd != fd {
+		n, err := strconv.Atoi(fd)
+		if err != nil {
+			return "", fmt.Errorf("invalid %s %q: %w", f.env, fd, err)
+		}
+		f.fd = fd
+		f.r = bufio.NewReader(os.NewFile(uintptr(n), f.env))
+	}
+
+	line, err := f.r.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		return "", fmt.Errorf("could not read from %s: %w", f.env, err)
+	}
+
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// readPassphraseTerminal prompts for a passphrase on the terminal, without
+// echo.
+func readPassphraseTerminal(prompt string) (p string, err error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return "", errNoPassphrase
+	}
+	defer func() {
+		if cerr := tty.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if _, err := fmt.Fprint(tty, prompt); err != nil {
+		return "", err
+	}
+	b, err := term.ReadPassword(int(tty.Fd()))
+	_, _ = fmt.Fprintln(tty)
+	if err != nil {
+		return "", fmt.Errorf("could not read passphrase: %w", err)
+	}
+
+	return string(b), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/revelaction/privage/identity"
+)
+
+func init() {
+	// Keep the scrypt work factor of passphrase protected keys low in tests
+	identity.ScryptWorkFactor = 10
+}
+
+// passphrasePipe sets PRIVAGE_PASSPHRASE_FD to a pipe with the given
+// passphrases, one per line.
+func passphrasePipe(t *testing.T, passphrases ...string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+
+	if _, err := w.WriteString(strings.Join(passphrases, "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv(passphraseFdEnv, strconv.Itoa(int(r.Fd())))
+}
+
+func TestReadPassphrase(t *testing.T) {
+	t.Run("Env", func(t *testing.T) {
+		t.Setenv(passphraseEnv, "from env")
+		passphrasePipe(t, "from fd")
+
+		p, err := readPassphrase(passphraseEnv, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p != "from env" {
+			t.Errorf("got %q, want %q", p, "from env")
+		}
+	})
+
+	t.Run("Fd", func(t *testing.T) {
+		passphrasePipe(t, "first", "second\r", "", "last")
+
+		for _, want := range []string{"first", "second", "", "last"} {
+			p, err := readPassphrase(passphraseEnv, "")
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if p != want {
+				t.Errorf("got %q, want %q", p, want)
+			}
+		}
+
+		if _, err := readPassphrase(passphraseEnv, ""); err == nil {
+			t.Error("expected error reading past the last passphrase")
+		}
+	})
+
+	t.Run("InvalidFd", func(t *testing.T) {
+		t.Setenv(passphraseFdEnv, "stdin")
+		if _, err := readPassphrase(passphraseEnv, ""); err == nil || !strings.Contains(err.Error(), "invalid") {
+			t.Errorf("expected invalid fd error, got %v", err)
+		}
+	})
+}
+
+func TestNewPassphrase(t *testing.T) {
+	t.Run("Env", func(t *testing.T) {
+		t.Setenv(newPassphraseEnv, "new")
+		p, err := newPassphrase()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if p != "new" {
+			t.Errorf("got %q, want %q", p, "new")
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		t.Setenv(newPassphraseEnv, "")
+		if _, err := newPassphrase(); err == nil || !strings.Contains(err.Error(), "empty") {
+			t.Errorf("expected empty passphrase error, got %v", err)
+		}
+	})
+}
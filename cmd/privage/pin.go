@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/revelaction/privage/identity"
+)
+
+const (
+	// pivPinFdEnv is the environment variable with a file descriptor the
+	// PIN of the yubikey is read from, one per line.
+	pivPinFdEnv = "PRIVAGE_PIV_PIN_FD"
+
+	// pinentryEnv is the environment variable with the pinentry program
+	// the PIN of the yubikey is requested with. It defaults to pinentry in
+	// the PATH.
+	pinentryEnv = "PRIVAGE_PINENTRY"
+
+	// pinDesc is the description shown by pinentry when requesting the PIN.
+	pinDesc = "Enter the PIN of the yubikey to decrypt the privage key"
+)
+
+// errNoPin is returned when the PIN of the yubikey is needed but can not be
+// read.
+var errNoPin = fmt.Errorf("could not read the PIN of the yubikey: no terminal or pinentry, set %s", pivPinFdEnv)
+
+// pinFd is the reader of the file descriptor of pivPinFdEnv.
+var pinFd = &fdReader{env: pivPinFdEnv}
+
+// pinReplacer escapes the Assuan protocol special characters of a pinentry
+// command parameter.
+var pinReplacer = strings.NewReplacer("%", "%25", "\n", "%0A", "\r", "%0D")
+
+// pivAuth returns the functions to request the PIN of the yubikey and to
+// tell the user to touch it. The PIN is read from the file descriptor of
+// PRIVAGE_PIV_PIN_FD, or, unless noPrompt is set, requested with a pinentry
+// program or prompted for in the terminal. The user is told to touch it in
+// ui.Err.
+func pivAuth(noPrompt bool, ui UI) identity.Auth {
+	return identity.Auth{
+		PIN: func() (string, error) {
+			return readPin(noPrompt)
+		},
+		Touch: func() {
+			_, _ = fmt.Fprintln(ui.Err, "👆 Touch your yubikey to decrypt the privage key")
+		},
+	}
+}
+
+// readPin returns the PIN of the yubikey, from the file descriptor of
+// PRIVAGE_PIV_PIN_FD, from the pinentry program of PRIVAGE_PINENTRY or the
+// PATH, or from the terminal.
+func readPin(noPrompt bool) (string, error) {
+	if fd := os.Getenv(pivPinFdEnv); fd != "" {
+		return pinFd.readLine(fd)
+	}
+
+	if noPrompt {
+		return "", errNoPin
+	}
+
+	if program := pinentryProgram(); program != "" {
+		return readPinentry(program, pinDesc)
+	}
+
+	pin, err := readPassphraseTerminal("Enter PIN for yubikey: ")
+	if errors.Is(err, errNoPassphrase) {
+		return "", errNoPin
+	}
+	return pin, err
+}
+
+// pinentryProgram returns the pinentry program of PRIVAGE_PINENTRY, or
+// pinentry if it is in the PATH, or an empty string.
+func pinentryProgram() string {
+	if program := os.Getenv(pinentryEnv); program != "" {
+		return program
+	}
+
+	program, err := exec.LookPath("pinentry")
+	if err != nil {
+		return ""
+	}
+	return program
+}
+
+// readPinentry requests the PIN with the pinentry program, that shows the
+// description desc.
+func readPinentry(program, desc string) (pin string, err error) {
+	cmd := exec.Command(program)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return "", fmt.Errorf("could not run %s: %w", program, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", fmt.Errorf("could not run %s: %w", program, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return "", fmt.Errorf("could not run %s: %w", program, err)
+	}
+	defer func() {
+		_ = stdin.Close()
+		if werr := cmd.Wait(); werr != nil && err == nil {
+			err = fmt.Errorf("%s failed: %w", program, werr)
+		}
+	}()
+
+	return pinentry(stdout, stdin, desc)
+}
+
+// pinentry requests the PIN with the Assuan protocol of pinentry, reading
+// the responses from r and writing the commands to w.
+func pinentry(r io.Reader, w io.Writer, desc string) (string, error) {
+	br := bufio.NewReader(r)
+	if _, err := assuanResponse(br); err != nil {
+		return "", err
+	}
+
+	for _, command := range []string{
+		"SETTITLE privage",
+		"SETDESC " + pinReplacer.Replace(desc),
+		"SETPROMPT PIN:",
+	} {
+		if _, err := assuanCommand(br, w, command); err != nil {
+			return "", err
+		}
+	}
+
+	pin, err := assuanCommand(br, w, "GETPIN")
+	if err != nil {
+		return "", err
+	}
+
+	_, _ = fmt.Fprintln(w, "BYE")
+	return pin, nil
+}
+
+// assuanCommand writes the command to w and returns the data of its
+// response, read from br.
+func assuanCommand(br *bufio.Reader, w io.Writer, command string) (string, error) {
+	if _, err := fmt.Fprintln(w, command); err != nil {
+		return "", fmt.Errorf("could not write to pinentry: %w", err)
+	}
+
+	return assuanResponse(br)
+}
+
+// assuanResponse reads an Assuan response from br, and returns its data
+// lines, decoded. Status and comment lines are skipped.
+func assuanResponse(br *bufio.Reader) (string, error) {
+	var data strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("could not read from pinentry: %w", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "OK" || strings.HasPrefix(line, "OK "):
+			return data.String(), nil
+		case strings.HasPrefix(line, "ERR "):
+			return "", fmt.Errorf("pinentry: %s", strings.TrimPrefix(line, "ERR "))
+		case strings.HasPrefix(line, "D "):
+			d, err := url.PathUnescape(strings.TrimPrefix(line, "D "))
+			if err != nil {
+				return "", fmt.Errorf("invalid pinentry data: %w", err)
+			}
+			data.WriteString(d)
+		case strings.HasPrefix(line, "S ") || strings.HasPrefix(line, "#"):
+		default:
+			return "", fmt.Errorf("unexpected pinentry response %q", line)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakePinentry writes a pinentry program that answers GETPIN with the
+// given response lines, and sets PRIVAGE_PINENTRY to it.
+func fakePinentry(t *testing.T, getpin string) {
+	t.Helper()
+	script := `#!/bin/sh
+echo "OK Pleased to meet you"
+while read -r cmd rest; do
+	case "$cmd" in
+	GETPIN) printf '` + getpin + `' ;;
+	BYE) echo OK; exit 0 ;;
+	*) echo OK ;;
+	esac
+done
+`
+	path := filepath.Join(t.TempDir(), "pinentry")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(pinentryEnv, path)
+}
+
+func TestReadPin(t *testing.T) {
+	t.Run("Fd", func(t *testing.T) {
+		r, w, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = r.Close()
+		})
+		if _, err := w.WriteString("123456\n654321\n"); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		t.Setenv(pivPinFdEnv, strconv.Itoa(int(r.Fd())))
+
+		for _, want := range []string{"123456", "654321"} {
+			pin, err := readPin(true)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if pin != want {
+				t.Errorf("got %q, want %q", pin, want)
+			}
+		}
+	})
+
+	t.Run("NoPrompt", func(t *testing.T) {
+		t.Setenv(pivPinFdEnv, "")
+		fakePinentry(t, `D 123456\nOK\n`)
+
+		if _, err := readPin(true); !errors.Is(err, errNoPin) {
+			t.Errorf("expected errNoPin, got %v", err)
+		}
+	})
+
+	t.Run("Pinentry", func(t *testing.T) {
+		t.Setenv(pivPinFdEnv, "")
+		fakePinentry(t, `S PASSWORD_FROMCACHE\nD 12%%2534\nOK\n`)
+
+		pin, err := readPin(false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if pin != "12%34" {
+			t.Errorf("got %q, want %q", pin, "12%34")
+		}
+	})
+
+	t.Run("PinentryCancelled", func(t *testing.T) {
+		t.Setenv(pivPinFdEnv, "")
+		fakePinentry(t, `ERR 83886179 Operation cancelled <Pinentry>\n`)
+
+		if _, err := readPin(false); err == nil || !strings.Contains(err.Error(), "Operation cancelled") {
+			t.Errorf("expected cancelled error, got %v", err)
+		}
+	})
+}
+
+func TestPinentry_Commands(t *testing.T) {
+	responses := strings.NewReader("OK hello\nOK\nOK\nOK\nD 1234\nOK\n")
+	var commands bytes.Buffer
+
+	pin, err := pinentry(responses, &commands, "100% sure\nreally")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pin != "1234" {
+		t.Errorf("got %q, want %q", pin, "1234")
+	}
+
+	want := "SETTITLE privage\nSETDESC 100%25 sure%0Areally\nSETPROMPT PIN:\nGETPIN\nBYE\n"
+	if got := commands.String(); got != want {
+		t.Errorf("got commands %q, want %q", got, want)
+	}
+}
+
+func TestPivAuth_Touch(t *testing.T) {
+	var outBuf, errBuf bytes.Buffer
+	pivAuth(true, UI{Out: &outBuf, Err: &errBuf}).Touch()
+
+	if !strings.Contains(errBuf.String(), "Touch your yubikey") {
+		t.Errorf("expected the touch message, got %q", errBuf.String())
+	}
+	if outBuf.Len() != 0 {
+		t.Errorf("expected no output, got %q", outBuf.String())
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	id "github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+// recipientsCommand lists, adds or removes the recipients of the
+// repository.
+func recipientsCommand(s *setup.Setup, action, key string, ui UI) error {
+	v, err := vault.New(s)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "add":
+		return addRecipient(s, v, key, ui)
+	case "remove":
+		return removeRecipient(s, v, key, ui)
+	}
+
+	own := v.Recipients()[0]
+	for _, r := range v.Recipients() {
+		if r == own {
+			_, _ = fmt.Fprintf(ui.Out, "%s (key %s)\n", r, s.Id.Path)
+			continue
+		}
+		_, _ = fmt.Fprintln(ui.Out, r)
+	}
+
+	return nil
+}
+
+// addRecipient adds the recipient key to the recipients file of the
+// repository and reencrypts all files.
+func addRecipient(s *setup.Setup, v *vault.Vault, key string, ui UI) error {
+	r, err := id.ParseRecipient(key)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", key, err)
+	}
+	key = r.String()
+
+	if slices.Contains(v.Recipients(), key) {
+		return fmt.Errorf("%s is already a recipient", key)
+	}
+
+	fileRecipients, err := vault.ReadRecipientsFile(s.Repository)
+	if err != nil {
+		return err
+	}
+
+	// The recipients file is shared, it must contain all recipients,
+	// including the own one.
+	own := v.Recipients()[0]
+	if !slices.Contains(fileRecipients, own) {
+		fileRecipients = append([]string{own}, fileRecipients...)
+	}
+
+	num, err := v.SetRecipients(append(v.Recipients()[1:], key))
+	if err != nil {
+		return err
+	}
+
+	if err := vault.WriteRecipientsFile(s.Repository, append(fileRecipients, key)); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "Added recipient %s to %s\n", key, vault.RecipientsFileName)
+	_, _ = fmt.Fprintf(ui.Err, "🔐  Reencrypted %d files for %d recipients\n", num, len(v.Recipients()))
+
+	return nil
+}
+
+// removeRecipient removes the recipient key from the recipients file of the
+// repository and reencrypts all files.
+func removeRecipient(s *setup.Setup, v *vault.Vault, key string, ui UI) error {
+	key = canonicalRecipient(key)
+	if key == v.Recipients()[0] {
+		return fmt.Errorf("can not remove the recipient of the key %s", s.Id.Path)
+	}
+
+	isKey := func(r string) bool { return canonicalRecipient(r) == key }
+	if s.C != nil && slices.ContainsFunc(s.C.Recipients, isKey) {
+		return fmt.Errorf("recipient %s is set in the config file %s", key, s.C.Path)
+	}
+
+	fileRecipients, err := vault.ReadRecipientsFile(s.Repository)
+	if err != nil {
+		return err
+	}
+
+	if !slices.ContainsFunc(fileRecipients, isKey) {
+		return fmt.Errorf("%s is not a recipient", key)
+	}
+
+	recipients := slices.DeleteFunc(slices.Clone(v.Recipients()[1:]), isKey)
+	num, err := v.SetRecipients(recipients)
+	if err != nil {
+		return err
+	}
+
+	fileRecipients = slices.DeleteFunc(fileRecipients, isKey)
+	if err := vault.WriteRecipientsFile(s.Repository, fileRecipients); err != nil {
+		return err
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "Removed recipient %s from %s\n", key, vault.RecipientsFileName)
+	_, _ = fmt.Fprintf(ui.Err, "🔐  Reencrypted %d files for %d recipients\n", num, len(v.Recipients()))
+	_, _ = fmt.Fprintln(ui.Err, "⚠ The removed recipient can still decrypt previous copies of the files (f. ex. in the git history)")
+
+	return nil
+}
+
+// canonicalRecipient returns the canonical encoding of the public key, f.
+// ex. without the comment of an SSH public key. Keys that can not be parsed
+// are returned as is.
+func canonicalRecipient(key string) string {
+	r, err := id.ParseRecipient(key)
+	if err != nil {
+		return key
+	}
+
+	return r.String()
+}
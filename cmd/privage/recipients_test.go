@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"slices"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+func TestRecipientsCommand(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("shared_file", "team", "shared content")
+
+	mate, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mateKey := mate.Recipient().String()
+	mateSetup := &setup.Setup{Id: identity.New(mate, "mate-key"), Repository: th.Repository}
+
+	t.Run("Add", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		if err := recipientsCommand(th.Setup, "add", mateKey, ui); err != nil {
+			t.Fatalf("recipientsCommand failed: %v", err)
+		}
+		if !strings.Contains(errBuf.String(), "Reencrypted 1 files for 2 recipients") {
+			t.Errorf("unexpected output %q", errBuf.String())
+		}
+
+		recipients, err := vault.ReadRecipientsFile(th.Repository)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(recipients) != 2 || recipients[1] != mateKey {
+			t.Errorf("unexpected recipients file %v", recipients)
+		}
+
+		// The teammate reads the file with the shared recipients file
+		outBuf.Reset()
+		if err := catCommand(mateSetup, "shared_file", ui); err != nil {
+			t.Fatalf("teammate catCommand failed: %v", err)
+		}
+		if outBuf.String() != "shared content" {
+			t.Errorf("unexpected content %q", outBuf.String())
+		}
+	})
+
+	t.Run("AddTwice", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		err := recipientsCommand(th.Setup, "add", mateKey, ui)
+		if err == nil || !strings.Contains(err.Error(), "already a recipient") {
+			t.Errorf("expected already a recipient error, got %v", err)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		if err := recipientsCommand(th.Setup, "", "", ui); err != nil {
+			t.Fatalf("recipientsCommand failed: %v", err)
+		}
+		if !strings.Contains(outBuf.String(), mateKey) || !strings.Contains(outBuf.String(), th.Id.Path) {
+			t.Errorf("unexpected output %q", outBuf.String())
+		}
+	})
+
+	t.Run("RemoveOwn", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		err := recipientsCommand(th.Setup, "remove", th.Id.Recipient.String(), ui)
+		if err == nil {
+			t.Error("expected error removing the own recipient")
+		}
+	})
+
+	t.Run("Remove", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		if err := recipientsCommand(th.Setup, "remove", mateKey, ui); err != nil {
+			t.Fatalf("recipientsCommand failed: %v", err)
+		}
+
+		if err := catCommand(mateSetup, "shared_file", ui); err == nil {
+			t.Error("expected removed teammate not to read the file")
+		}
+
+		outBuf.Reset()
+		if err := catCommand(th.Setup, "shared_file", ui); err != nil {
+			t.Fatalf("catCommand failed: %v", err)
+		}
+		if outBuf.String() != "shared content" {
+			t.Errorf("unexpected content %q", outBuf.String())
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		var outBuf, errBuf bytes.Buffer
+		ui := UI{Out: &outBuf, Err: &errBuf}
+
+		err := recipientsCommand(th.Setup, "add", "not-a-key", ui)
+		if err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+			t.Errorf("expected invalid recipient error, got %v", err)
+		}
+	})
+}
+
+func TestRecipientsCommand_Ssh(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFile("shared_file", "team", "shared content")
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pk, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk)))
+
+	var outBuf, errBuf bytes.Buffer
+	ui := UI{Out: &outBuf, Err: &errBuf}
+
+	// The comment is not kept
+	if err := recipientsCommand(th.Setup, "add", sshKey+" user@host", ui); err != nil {
+		t.Fatalf("recipientsCommand failed: %v", err)
+	}
+	recipients, err := vault.ReadRecipientsFile(th.Repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(recipients, []string{th.Id.Recipient.String(), sshKey}) {
+		t.Errorf("unexpected recipients file %v", recipients)
+	}
+
+	if err := recipientsCommand(th.Setup, "remove", sshKey+" other@host", ui); err != nil {
+		t.Fatalf("recipientsCommand failed: %v", err)
+	}
+	recipients, err = vault.ReadRecipientsFile(th.Repository)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Contains(recipients, sshKey) {
+		t.Errorf("expected SSH key to be removed, got %v", recipients)
+	}
+}
@@ -7,34 +7,31 @@ import (
 	"github.com/revelaction/privage/credential"
 	"github.com/revelaction/privage/header"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 // reencryptCommand reencrypts modified files
 func reencryptCommand(s *setup.Setup, isForce, isClean bool, ui UI) error {
-	if s.Id.Id == nil {
-		return fmt.Errorf("found no privage key file: %w", s.Id.Err)
+	v, err := vault.New(s)
+	if err != nil {
+		return err
 	}
 
-	return reencrypt(s, isForce, isClean, ui)
+	return reencrypt(v, isForce, isClean, ui)
 }
 
 // reencrypt reencrypts modified (decrypted) files in the Repository directory.
-func reencrypt(s *setup.Setup, isForce, isClean bool, ui UI) error {
-
-	headers := []*header.Header{}
+func reencrypt(v *vault.Vault, isForce, isClean bool, ui UI) error {
 
-	ch, err := headerGenerator(s.Repository, s.Id)
+	headers, err := v.List()
 	if err != nil {
 		return err
 	}
-	for h := range ch {
-		headers = append(headers, h)
-	}
 
 	toEncrypt := []*header.Header{}
 	for _, h := range headers {
 		//if label exist as file add to list to encrypt
-		if _, err := os.Stat(s.Repository + "/" + h.Label); !os.IsNotExist(err) {
+		if plaintextExists(v, h) {
 			toEncrypt = append(toEncrypt, h)
 		}
 	}
@@ -56,21 +53,22 @@ func reencrypt(s *setup.Setup, isForce, isClean bool, ui UI) error {
 
 	for _, h := range toEncrypt {
 
-		f, err := os.Open(s.Repository + "/" + h.Label)
+		path, _ := v.PlaintextPath(h)
+		f, err := os.Open(path)
 		if err != nil {
 			return err
 		}
 
 		// if is credential category -> validate as toml
 		if header.CategoryCredential == h.Category {
-			err := credential.ValidateFile(s.Repository + "/" + h.Label)
+			err := credential.ValidateFile(path)
 			if err != nil {
 				return fmt.Errorf("invalid credential file %s. toml error: %w", h.Label, err)
 			}
 		}
 
 		//encrypt and save the file
-		err = encryptSave(h, "", f, s)
+		err = v.Replace(h, f)
 		if err != nil {
 			return err
 		}
@@ -84,28 +82,23 @@ func reencrypt(s *setup.Setup, isForce, isClean bool, ui UI) error {
 	logFilesToBeProcessed(toEncrypt, ui)
 
 	if isClean {
-		return clean(s, true, ui)
+		return clean(v, true, ui)
 	}
 
 	return nil
 }
 
-func clean(s *setup.Setup, isForce bool, ui UI) error {
-	headers := []*header.Header{}
-
-	ch, err := headerGenerator(s.Repository, s.Id)
+func clean(v *vault.Vault, isForce bool, ui UI) error {
+	headers, err := v.List()
 	if err != nil {
 		return err
 	}
-	for h := range ch {
-		headers = append(headers, h)
-	}
 
 	toClean := []*header.Header{}
 	for _, h := range headers {
 
 		//if label exist as file, then add to list to encrypt
-		if _, err := os.Stat(s.Repository + "/" + h.Label); !os.IsNotExist(err) {
+		if plaintextExists(v, h) {
 			toClean = append(toClean, h)
 		}
 	}
@@ -127,7 +120,8 @@ func clean(s *setup.Setup, isForce bool, ui UI) error {
 	for _, h := range toClean {
 
 		// contents as []byte
-		err := os.Remove(s.Repository + "/" + h.Label)
+		path, _ := v.PlaintextPath(h)
+		err := os.Remove(path)
 		if err != nil {
 			return err
 		}
@@ -147,3 +141,15 @@ func logFilesToBeProcessed(toEncrypt []*header.Header, ui UI) {
 
 	_, _ = fmt.Fprintln(ui.Err)
 }
+
+// plaintextExists reports whether the decrypted file of header h is in the
+// repository.
+func plaintextExists(v *vault.Vault, h *header.Header) bool {
+	path, ok := v.PlaintextPath(h)
+	if !ok {
+		return false
+	}
+
+	_, err := os.Stat(path)
+	return !os.IsNotExist(err)
+}
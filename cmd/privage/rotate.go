@@ -3,201 +3,123 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io"
 	"os"
+	"path/filepath"
 	"strconv"
-	"strings"
 
 	"filippo.io/age"
 
-	"github.com/revelaction/privage/fs"
 	id "github.com/revelaction/privage/identity"
-	"github.com/revelaction/privage/identity/piv/yubikey"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 const (
-	suffix         = ".rotate"
 	fileNameRotate = "privage-key-rotate.txt"
 )
 
 // rotateCommand generates a new age key and reencrypts all present encrypted
-// fields with the new key.
-func rotateCommand(s *setup.Setup, isClean bool, slot string, ui UI) (err error) {
-	return rotate(s, isClean, slot, ui)
-}
-
-func rotate(s *setup.Setup, isClean bool, slot string, ui UI) (err error) {
-
-	if s.Id.Id == nil {
-		return fmt.Errorf("found no privage key file: %w", s.Id.Err)
+// fields with the new key. With the action clean, it also finishes the
+// rotation. The actions resume and abort finish or undo an interrupted
+// rotation, following its journal.
+//
+// The new key is encrypted with the PIV key of the slot, if not empty, or
+// with a passphrase if ko.passphrase or if the identity type of the
+// configuration is PASSPHRASE. It is a post-quantum hybrid key if ko.pq or
+// if the current key is one. Without ko.serial, the yubikey of the
+// configuration is used.
+func rotateCommand(s *setup.Setup, action string, ko keyOptions, ui UI) (err error) {
+	if len(ko.slot) == 0 && s.C != nil && s.C.IdentityType == id.TypePassphrase {
+		ko.passphrase = true
 	}
-
-	numFiles, err := numFilesForIdentity(s.Repository, s.Id)
-	if err != nil {
-		return fmt.Errorf("failed to count files: %w", err)
+	if s.Id.PostQuantum() {
+		ko.pq = true
 	}
-	if numFiles == 0 {
-		return fmt.Errorf("found no encrypted files with key %s", s.Id.Path)
+	if len(ko.slot) > 0 && len(ko.serial) == 0 && s.C != nil {
+		ko.serial = s.C.IdentityPivSerial
 	}
 
-	_, _ = fmt.Fprintf(ui.Err, "Found %d files encrypted with key %s\n", numFiles, s.Id.Path)
-	_, _ = fmt.Fprintln(ui.Err)
+	switch action {
+	case "resume":
+		return resumeRotate(s, ko, ui)
+	case "abort":
+		return abortRotate(s, ko, ui)
+	}
 
-	var idRotate id.Identity
-	idRotatePath := s.Repository + "/" + fileNameRotate
-	var pivSlot uint32
+	return rotate(s, action == "clean", ko, ui)
+}
 
-	if len(slot) > 0 {
-		ps, err := strconv.ParseUint(slot, 16, 32)
-		if err != nil {
-			return fmt.Errorf("could not convert slot %s to hex: %v", slot, err)
-		}
+func rotate(s *setup.Setup, isClean bool, ko keyOptions, ui UI) (err error) {
 
-		pivSlot = uint32(ps)
+	v, err := vault.New(s)
+	if err != nil {
+		return err
 	}
 
-	if pivSlot > 0 {
-		device, err := yubikey.New()
-		if err != nil {
-			return fmt.Errorf("could not create yubikey device: %w", err)
-		}
-		defer func() {
-			if cerr := device.Close(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}()
-
-		f, err := fs.OpenFile(idRotatePath)
-		if err != nil {
-			return fmt.Errorf("could not open key file %s: %w", idRotatePath, err)
-		}
-		defer func() {
-			if cerr := f.Close(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}()
+	// The next key was already renamed by an interrupted clean
+	if _, err := v.RotateJournal(); err == nil {
+		return fmt.Errorf("%w\n    (Use \"privage rotate --resume\" to finish it)", vault.ErrRotationCleaning)
+	}
 
-		idRotate = id.LoadPiv(f, idRotatePath, device, pivSlot)
-	} else {
-		f, err := fs.OpenFile(idRotatePath)
-		if err != nil {
-			return fmt.Errorf("could not open key file %s: %w", idRotatePath, err)
-		}
-		defer func() {
-			if cerr := f.Close(); cerr != nil && err == nil {
-				err = cerr
-			}
-		}()
-		idRotate = id.LoadAge(f, idRotatePath)
+	// maybe we are in a rerun of the command rotate, after a failing
+	// process: the key file of the previous run is used, with the new
+	// passphrase, and the journal tells what was done.
+	idRotate, err := loadRotateIdentity(s, ko, ui)
+	if err != nil {
+		return err
 	}
 
-	numFilesRotate := 0
 	if idRotate.Err == nil {
-		numFilesRotate, err = numFilesForIdentity(s.Repository, idRotate)
-		if err != nil {
-			return fmt.Errorf("failed to count rotated files: %w", err)
-		}
-		_, _ = fmt.Fprintf(ui.Err, "Found %d files encrypted with the rotated key %s\n", numFilesRotate, idRotate.Path)
-
-		if numFiles == numFilesRotate {
+		j, err := rotateJournal(s, idRotate)
+		switch {
+		case errors.Is(err, vault.ErrNoRotation):
+			// The key was created, no file was reencrypted yet
+		case err != nil:
+			return err
+		case j.Phase == vault.RotatePhaseReencrypted:
+			_, _ = fmt.Fprintf(ui.Err, "Found %d files reencrypted with the rotated key %s\n", len(j.Files), idRotate.Path)
 
 			// the rotate process is completed. Run clean if flag
 			if isClean {
-				err = cleanRotate(s, idRotate, slot, ui)
-				if err != nil {
-					return err
-				}
-
-				return nil
+				return cleanRotate(s, idRotate, ko, ui)
 			}
 
 			_, _ = fmt.Fprintln(ui.Err)
 			_, _ = fmt.Fprintln(ui.Err, "rotate is completed ✔️")
 			_, _ = fmt.Fprintln(ui.Err, "(Use \"privage rotate --clean\" to clean up old encrypted files and rename the new ones.)")
 			return nil
+		case j.Phase != vault.RotatePhaseReencrypt:
+			return fmt.Errorf("%w\n    (Use \"privage rotate --resume\" to finish it)", vault.ErrRotationCleaning)
 		}
 	}
 
 	if idRotate.Err != nil {
+		numFiles, err := numFilesForVault(v)
+		if err != nil {
+			return fmt.Errorf("failed to count files: %w", err)
+		}
+		if numFiles == 0 {
+			return fmt.Errorf("found no encrypted files with key %s", s.Id.Path)
+		}
 
-		// Create
-		if pivSlot > 0 {
-			f, err := fs.CreateFile(idRotatePath, 0600)
-			if err != nil {
-				return fmt.Errorf("could not create key file %s: %w", idRotatePath, err)
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
+		_, _ = fmt.Fprintf(ui.Err, "Found %d files encrypted with key %s\n", numFiles, s.Id.Path)
+		_, _ = fmt.Fprintln(ui.Err)
 
-			device, err := yubikey.New()
+		var pivSlot uint32
+		if len(ko.slot) > 0 {
+			ps, err := strconv.ParseUint(ko.slot, 16, 32)
 			if err != nil {
-				return fmt.Errorf("could not create yubikey device: %w", err)
+				return fmt.Errorf("could not convert slot %s to hex: %v", ko.slot, err)
 			}
-			defer func() {
-				if cerr := device.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-
-			err = id.GeneratePiv(f, device, pivSlot)
-		} else {
-			var f io.WriteCloser
-			f, err = fs.CreateFile(idRotatePath, 0600)
-			if err != nil {
-				return fmt.Errorf("could not create key file %s: %w", idRotatePath, err)
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-			err = id.GenerateAge(f)
+
+			pivSlot = uint32(ps)
 		}
 
+		idRotate, err = createIdentity(filepath.Join(s.Repository, fileNameRotate), pivSlot, ko, ui)
 		if err != nil {
 			return fmt.Errorf("could not create age key file: %w", err)
 		}
 
-		// Load
-		if pivSlot > 0 {
-			device, err := yubikey.New()
-			if err != nil {
-				return fmt.Errorf("could not create yubikey device: %w", err)
-			}
-			defer func() {
-				if cerr := device.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-
-			f, err := fs.OpenFile(idRotatePath)
-			if err != nil {
-				return fmt.Errorf("could not open key file %s: %w", idRotatePath, err)
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-
-			idRotate = id.LoadPiv(f, idRotatePath, device, pivSlot)
-		} else {
-			f, err := fs.OpenFile(idRotatePath)
-			if err != nil {
-				return fmt.Errorf("could not open key file %s: %w", idRotatePath, err)
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-			idRotate = id.LoadAge(f, idRotatePath)
-		}
-
 		_, _ = fmt.Fprintf(ui.Err, "🔑 Created new age key file %s✔️\n", idRotate.Path)
 	}
 
@@ -206,48 +128,10 @@ func rotate(s *setup.Setup, isClean bool, slot string, ui UI) (err error) {
 	// maybe we are in a rerun of the command rotate, after a failing process.
 	// some age files present in the repo will be encrypted with the old key and
 	// some with the new one.
-	numReencrypted := 0
-	ch, err := headerGenerator(s.Repository, s.Id)
+	numReencrypted, err := v.Rotate(idRotate)
 	if err != nil {
 		return err
 	}
-	for h := range ch {
-		if h.Err != nil {
-			var e *age.NoIdentityMatchError
-			if errors.As(h.Err, &e) {
-				continue
-			}
-
-			return h.Err
-		}
-
-		err = func() (err error) {
-			f, err := os.Open(h.Path)
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-
-			r, err := contentReader(f, s.Id)
-			if err != nil {
-				return err
-			}
-
-			// Need a setup with the repo and the new idRotate
-			sRotate := s.Copy()
-			sRotate.Id = idRotate
-			return encryptSave(h, suffix, r, sRotate)
-		}()
-
-		if err != nil {
-			return err
-		}
-		numReencrypted++
-	}
 
 	_, _ = fmt.Fprintf(ui.Err, "🔐  Reencrypted %d files with new key %s\n", numReencrypted, idRotate.Path)
 	_, _ = fmt.Fprintln(ui.Err)
@@ -258,107 +142,163 @@ func rotate(s *setup.Setup, isClean bool, slot string, ui UI) (err error) {
 		return nil
 	}
 
-	err = cleanRotate(s, idRotate, slot, ui)
+	return cleanRotate(s, idRotate, ko, ui)
+}
+
+// resumeRotate finishes an interrupted rotation, from the phase of its
+// journal.
+func resumeRotate(s *setup.Setup, ko keyOptions, ui UI) error {
+	idRotate, err := loadRotateIdentity(s, ko, ui)
 	if err != nil {
 		return err
 	}
 
-	return nil
-}
-
-// cleanRotate removes all encrypted files with the old key
-// it also renames all age encrypted files of new key to standard form (without rotated suffix)
-// it also renames the keys (old and new).
-func cleanRotate(s *setup.Setup, idRotate id.Identity, slot string, ui UI) error {
+	if idRotate.Err != nil {
+		// The next key was already renamed to the key of the setup
+		j, err := rotateJournal(s, s.Id)
+		if err != nil {
+			return err
+		}
 
-	_, _ = fmt.Fprintln(ui.Err)
-	_, _ = fmt.Fprintln(ui.Err, "Cleaning files...")
-	_, _ = fmt.Fprintln(ui.Err)
+		_, _ = fmt.Fprintf(ui.Err, "Resuming the rotation in the phase %s\n", j.Phase)
+		return cleanRotate(s, s.Id, ko, ui)
+	}
 
-	// 1) remove all age encrypted fields of old key
-	numDeleted := 0
-	ch, err := headerGenerator(s.Repository, s.Id)
-	if err != nil {
+	j, err := rotateJournal(s, idRotate)
+	if err != nil && !errors.Is(err, vault.ErrNoRotation) {
 		return err
 	}
-	for h := range ch {
-		if h.Err != nil {
-			var e *age.NoIdentityMatchError
-			if !errors.As(h.Err, &e) {
-				return h.Err
-			}
 
-			continue
+	if j == nil || j.Phase == vault.RotatePhaseReencrypt {
+		_, _ = fmt.Fprintf(ui.Err, "Resuming the rotation in the phase %s\n", vault.RotatePhaseReencrypt)
+		v, err := vault.New(s)
+		if err != nil {
+			return err
 		}
-
-		err := os.Remove(h.Path)
+		numReencrypted, err := v.Rotate(idRotate)
 		if err != nil {
-			_, _ = fmt.Fprintf(ui.Err, "%8s Error while deleting h.Path %s: %s\n", "", h.Path, err)
 			return err
 		}
-
-		numDeleted++
+		_, _ = fmt.Fprintf(ui.Err, "🔐  Reencrypted %d files with new key %s\n", numReencrypted, idRotate.Path)
+	} else {
+		_, _ = fmt.Fprintf(ui.Err, "Resuming the rotation in the phase %s\n", j.Phase)
 	}
 
-	_, _ = fmt.Fprintf(ui.Err, "Deleted %d files encrypted with key %s\n", numDeleted, s.Id.Path)
-	_, _ = fmt.Fprintln(ui.Err)
+	return cleanRotate(s, idRotate, ko, ui)
+}
 
-	// 2) rename
-	numRenamed := 0
-	ch2, err := headerGenerator(s.Repository, idRotate)
+// abortRotate removes the files reencrypted by an interrupted rotation, and
+// its new key, if the files of the old key were not removed yet.
+func abortRotate(s *setup.Setup, ko keyOptions, ui UI) error {
+	v, err := vault.New(s)
 	if err != nil {
 		return err
 	}
-	for h := range ch2 {
-		if h.Err != nil {
-			var e *age.NoIdentityMatchError
-			if errors.As(h.Err, &e) {
-				_, _ = fmt.Fprintf(ui.Err, "NoIdentityMatchError: Could not decrupt with curreent key %s\n", h.Err)
-				continue
-			}
 
-			return h.Err
-		}
+	idRotate, err := loadRotateIdentity(s, ko, ui)
+	if err != nil {
+		return err
+	}
 
-		// The file currently has a path like:  .../hash.rotate.privage
-		// We want to remove the .rotate suffix.
-		// Since fileName() now uses PrivageExtension, we construct the standard name with it.
-		stardardPath := strings.TrimSuffix(h.Path, suffix+PrivageExtension) + PrivageExtension
-		err := os.Rename(h.Path, stardardPath)
-		if err != nil {
+	if idRotate.Err != nil {
+		// The next key was already renamed to the key of the setup
+		if _, err := v.RotateJournal(); err != nil {
 			return err
 		}
+		return fmt.Errorf("%w\n    (Use \"privage rotate --resume\" to finish it)", vault.ErrRotationCommitted)
+	}
 
-		numRenamed++
+	err = v.AbortRotate(idRotate)
+	if errors.Is(err, vault.ErrRotationCommitted) {
+		return fmt.Errorf("%w\n    (Use \"privage rotate --resume\" to finish it)", err)
+	}
+	if err != nil {
+		return err
 	}
 
-	_, _ = fmt.Fprintf(ui.Err, "Renamed %d rotated files to %s extension\n", numRenamed, PrivageExtension)
+	_, _ = fmt.Fprintf(ui.Err, "Removed the reencrypted files and the new key %s\n", idRotate.Path)
 	_, _ = fmt.Fprintln(ui.Err)
+	_, _ = fmt.Fprintf(ui.Err, "rotate is aborted, the files are encrypted with key %s ✔️\n", s.Id.Path)
+
+	return nil
+}
+
+// loadRotateIdentity loads the new key of a rotation, decrypted with the
+// yubikey of ko or with the new passphrase. If there is no new key, the
+// returned identity has the error os.ErrNotExist.
+func loadRotateIdentity(s *setup.Setup, ko keyOptions, ui UI) (id.Identity, error) {
+	idRotatePath := filepath.Join(s.Repository, fileNameRotate)
+	if _, err := os.Stat(idRotatePath); err != nil {
+		return id.Identity{Err: os.ErrNotExist}, nil
+	}
 
-	// 3) rename old key to back
-	backupKeyPath := id.BackupFilePath(s.Repository)
-	err = os.Rename(s.Id.Path, backupKeyPath)
+	idRotate := loadIdentityEnv(idRotatePath, ko.slot, ko.serial, newPassphraseEnv, false, ui)
+	if idRotate.Err != nil {
+		return idRotate, fmt.Errorf("could not load key file %s: %w", idRotatePath, idRotate.Err)
+	}
+
+	return idRotate, nil
+}
+
+// rotateVault returns the vault of the repository of s with the new key
+// idRotate of a rotation.
+func rotateVault(s *setup.Setup, idRotate id.Identity) (*vault.Vault, error) {
+	sRotate := s.Copy()
+	sRotate.Id = idRotate
+	return vault.New(sRotate)
+}
+
+// rotateJournal returns the journal of the rotation to the key idRotate.
+func rotateJournal(s *setup.Setup, idRotate id.Identity) (*vault.RotateJournal, error) {
+	vRotate, err := rotateVault(s, idRotate)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	_, _ = fmt.Fprintf(ui.Err, "Renamed old key %s to %s\n", s.Id.Path, backupKeyPath)
+	return vRotate.RotateJournal()
+}
+
+// cleanRotate finishes the rotation to the new key idRotate: it removes all
+// encrypted files of the old key, renames the files of the new key to
+// standard form (without rotated suffix), backs up the old key, renames the
+// new key, and signs the manifest with the new key.
+func cleanRotate(s *setup.Setup, idRotate id.Identity, ko keyOptions, ui UI) error {
+
+	_, _ = fmt.Fprintln(ui.Err)
+	_, _ = fmt.Fprintln(ui.Err, "Cleaning files...")
 	_, _ = fmt.Fprintln(ui.Err)
 
-	// 4) rename new key
-	err = os.Rename(idRotate.Path, s.Id.Path)
+	vRotate, err := rotateVault(s, idRotate)
 	if err != nil {
 		return err
 	}
-	_, _ = fmt.Fprintf(ui.Err, "Renamed new key %s to %s\n", idRotate.Path, s.Id.Path)
-	_, _ = fmt.Fprintln(ui.Err)
 
-	_, _ = fmt.Fprintf(ui.Err, "The new key is a %s\n", id.FmtType(slot))
+	j, err := vRotate.CleanRotate()
+	if err != nil {
+		return fmt.Errorf("%w\n    (Use \"privage rotate --resume\" to finish the rotation)", err)
+	}
+
+	_, _ = fmt.Fprintf(ui.Err, "Deleted %d files encrypted with key %s\n", len(j.Files), j.KeyPath)
+	_, _ = fmt.Fprintf(ui.Err, "Renamed %d rotated files to %s extension\n", len(j.Files), vault.Extension)
+	_, _ = fmt.Fprintf(ui.Err, "Copied old key %s to %s\n", j.KeyPath, j.BackupKeyPath)
+	_, _ = fmt.Fprintf(ui.Err, "Renamed new key %s to %s\n", j.NextKeyPath, j.KeyPath)
+	_, _ = fmt.Fprintf(ui.Err, "Signed the manifest of %d files with the new key\n", len(j.Files))
+	_, _ = fmt.Fprintln(ui.Err)
+	_, _ = fmt.Fprintf(ui.Err, "The new key is a %s\n", id.FmtType(ko.slot))
+	if idRotate.PostQuantum() {
+		_, _ = fmt.Fprintln(ui.Err, "The new key is a post-quantum hybrid key")
+	}
 	_, _ = fmt.Fprintf(ui.Err, "⚠ Make sure the config file %s has these lines:\n", s.C.Path)
 	_, _ = fmt.Fprintln(ui.Err)
-	if len(slot) > 0 {
+	if len(ko.slot) > 0 {
 		_, _ = fmt.Fprintln(ui.Err, "    identity_type = \"PIV\"")
-		_, _ = fmt.Fprintf(ui.Err, "    identity_piv_slot = \"%s\"\n", slot)
+		_, _ = fmt.Fprintf(ui.Err, "    identity_piv_slot = \"%s\"\n", ko.slot)
+		if len(ko.serial) > 0 {
+			_, _ = fmt.Fprintf(ui.Err, "    identity_piv_serial = \"%s\"\n", ko.serial)
+		}
+	} else if ko.passphrase {
+		_, _ = fmt.Fprintf(ui.Err, "    identity_type = \"%s\"\n", id.TypePassphrase)
+		_, _ = fmt.Fprintln(ui.Err, "    identity_piv_slot = \"\"")
 	} else {
 		_, _ = fmt.Fprintln(ui.Err, "    identity_type = \"\"")
 		_, _ = fmt.Fprintln(ui.Err, "    identity_piv_slot = \"\"")
@@ -370,13 +310,12 @@ func cleanRotate(s *setup.Setup, idRotate id.Identity, slot string, ui UI) error
 	return nil
 }
 
-func numFilesForIdentity(repoDir string, identity id.Identity) (int, error) {
+func numFilesForVault(v *vault.Vault) (int, error) {
 	num := 0
-	ch, err := headerGenerator(repoDir, identity)
-	if err != nil {
-		return 0, err
-	}
-	for h := range ch {
+	for h, err := range v.Headers() {
+		if err != nil {
+			return 0, err
+		}
 		if h.Err != nil {
 			var e *age.NoIdentityMatchError
 			if errors.As(h.Err, &e) {
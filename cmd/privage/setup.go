@@ -1,6 +1,8 @@
 package main
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -8,33 +10,40 @@ import (
 	"github.com/revelaction/privage/config"
 	"github.com/revelaction/privage/fs"
 	"github.com/revelaction/privage/identity"
-	"github.com/revelaction/privage/identity/piv/yubikey"
 	"github.com/revelaction/privage/setup"
 )
 
+// keyPeekSize is the number of bytes read from the start of a key file to
+// detect its format.
+const keyPeekSize = 64
+
 // setupEnv loads the identity file (secret key) and the repository command
 // arguments have preference. Explicite -k, -r arguments have preference over
 // the -c argument.  If no arguments provided, standard paths are searched for
 // a configuration file .privage.conf
-func setupEnv(opts setup.Options) (*setup.Setup, error) {
+func setupEnv(opts setup.Options, ui UI) (*setup.Setup, error) {
 	// Validate options first
 	if err := opts.Validate(); err != nil {
 		return &setup.Setup{}, err
 	}
 
+	// With NoPrompt, the age plugins can not prompt for values, like a PIN,
+	// either.
+	identity.PluginUI = identity.NewPluginUI(!opts.NoPrompt)
+
 	// Determine which case we're in using explicit methods
 	switch {
 	case opts.WithKeyRepo():
 		// Case 1: -k -r with optional -p
-		return setupFromKeyRepo(opts.KeyFile, opts.RepoPath, opts.PivSlot)
+		return setupFromKeyRepo(opts.KeyFile, opts.RepoPath, opts.PivSlot, opts.PivSerial, opts.NoPrompt, ui)
 
 	case opts.WithConfig():
 		// Case 2: -c only
-		return setupFromConfig(opts.ConfigFile)
+		return setupFromConfig(opts.ConfigFile, opts.PivSerial, opts.NoPrompt, ui)
 
 	case opts.NoKeyRepoConfig():
 		// Case 3: Nothing - try config file first, then identity file
-		return setupFromDiscovery(opts.PivSlot)
+		return setupFromDiscovery(opts.PivSlot, opts.PivSerial, opts.NoPrompt, ui)
 
 	default:
 		// This should never happen due to Validate()
@@ -42,8 +51,8 @@ func setupEnv(opts setup.Options) (*setup.Setup, error) {
 	}
 }
 
-func setupFromKeyRepo(keyPath, repoPath, pivSlot string) (*setup.Setup, error) {
-	id := loadIdentity(keyPath, pivSlot)
+func setupFromKeyRepo(keyPath, repoPath, pivSlot, pivSerial string, noPrompt bool, ui UI) (*setup.Setup, error) {
+	id := loadIdentity(keyPath, pivSlot, pivSerial, noPrompt, ui)
 
 	exists, err := fs.DirExists(repoPath)
 	if err != nil {
@@ -53,10 +62,12 @@ func setupFromKeyRepo(keyPath, repoPath, pivSlot string) (*setup.Setup, error) {
 		return &setup.Setup{}, fmt.Errorf("repository directory %s does not exist", repoPath)
 	}
 
-	return &setup.Setup{C: &config.Config{}, Id: id, Repository: repoPath}, nil
+	return &setup.Setup{C: &config.Config{IdentityPivSerial: pivSerial}, Id: id, Repository: repoPath}, nil
 }
 
-func setupFromConfig(path string) (*setup.Setup, error) {
+// setupFromConfig loads the config file of path. A not empty pivSerial
+// overrides the yubikey serial number of the config file.
+func setupFromConfig(path, pivSerial string, noPrompt bool, ui UI) (*setup.Setup, error) {
 	f, err := os.Open(path)
 	if err != nil {
 		return &setup.Setup{}, err
@@ -71,15 +82,18 @@ func setupFromConfig(path string) (*setup.Setup, error) {
 	}
 
 	conf.Path = path
+	if pivSerial != "" {
+		conf.IdentityPivSerial = pivSerial
+	}
 
 	return &setup.Setup{
 		C:          conf,
-		Id:         loadIdentity(conf.IdentityPath, conf.IdentityPivSlot),
+		Id:         loadIdentity(conf.IdentityPath, conf.IdentityPivSlot, conf.IdentityPivSerial, noPrompt, ui),
 		Repository: conf.RepositoryPath,
 	}, nil
 }
 
-func setupFromDiscovery(pivSlot string) (*setup.Setup, error) {
+func setupFromDiscovery(pivSlot, pivSerial string, noPrompt bool, ui UI) (*setup.Setup, error) {
 	configPath, err := fs.FindConfigFile()
 	if err != nil {
 		// A real system error occurred (permission denied, etc)
@@ -88,7 +102,7 @@ func setupFromDiscovery(pivSlot string) (*setup.Setup, error) {
 
 	if configPath != "" {
 		// Config file found - use it
-		return setupFromConfig(configPath)
+		return setupFromConfig(configPath, pivSerial, noPrompt, ui)
 	}
 
 	// No config file found (and no error) - search for identity file
@@ -108,8 +122,10 @@ func setupFromDiscovery(pivSlot string) (*setup.Setup, error) {
 
 	// Create setup with identity file and current directory as repo
 	// For auto-discovery we assume standard file-based identity (no PIV slot)
-	id := loadIdentity(idPath, pivSlot)
-	if id.Err != nil {
+	// A passphrase protected identity not loaded because of noPrompt keeps
+	// its path.
+	id := loadIdentity(idPath, pivSlot, pivSerial, noPrompt, ui)
+	if id.Err != nil && !errors.Is(id.Err, errNoPassphrase) {
 		return &setup.Setup{}, id.Err
 	}
 
@@ -120,7 +136,21 @@ func setupFromDiscovery(pivSlot string) (*setup.Setup, error) {
 	}, nil
 }
 
-func loadIdentity(keyPath, pivSlot string) (ident identity.Identity) {
+// loadIdentity loads the age identity of the key file: encrypted with the
+// PIV key of the yubikey slot if pivSlot is not empty, of the yubikey with
+// the serial number pivSerial if not empty, encrypted with a
+// passphrase, in plain text, an age plugin identity file, or an OpenSSH
+// ed25519 private key, optionally protected with a passphrase.
+//
+// The passphrase is read from the environment or prompted for, unless
+// noPrompt is set.
+func loadIdentity(keyPath, pivSlot, pivSerial string, noPrompt bool, ui UI) identity.Identity {
+	return loadIdentityEnv(keyPath, pivSlot, pivSerial, passphraseEnv, noPrompt, ui)
+}
+
+// loadIdentityEnv is like loadIdentity, with the passphrase in the
+// environment variable env.
+func loadIdentityEnv(keyPath, pivSlot, pivSerial, env string, noPrompt bool, ui UI) (ident identity.Identity) {
 
 	if pivSlot == "" {
 		f, err := fs.OpenFile(keyPath)
@@ -132,7 +162,28 @@ func loadIdentity(keyPath, pivSlot string) (ident identity.Identity) {
 				ident.Err = cerr
 			}
 		}()
-		return identity.LoadAge(f, keyPath)
+
+		passphrase := func() (string, error) {
+			if _, ok := os.LookupEnv(env); noPrompt && !ok {
+				return "", errNoPassphrase
+			}
+			return readPassphrase(env, fmt.Sprintf("Enter passphrase for %s: ", keyPath))
+		}
+
+		r := bufio.NewReader(f)
+		start, _ := r.Peek(keyPeekSize)
+		switch {
+		case identity.IsSsh(start):
+			return identity.LoadSsh(r, keyPath, passphrase)
+		case !identity.IsPassphrase(start):
+			return identity.LoadAge(r, keyPath)
+		}
+
+		p, err := passphrase()
+		if err != nil {
+			return identity.Identity{Path: keyPath, Err: err}
+		}
+		return identity.LoadPassphrase(r, keyPath, p)
 	}
 
 	slot, err := strconv.ParseUint(pivSlot, 16, 32)
@@ -140,15 +191,10 @@ func loadIdentity(keyPath, pivSlot string) (ident identity.Identity) {
 		return identity.Identity{Err: fmt.Errorf("could not convert slot %d to hex: %v", slot, err)}
 	}
 
-	device, err := yubikey.New()
+	serial, err := parsePivSerial(pivSerial)
 	if err != nil {
-		return identity.Identity{Err: fmt.Errorf("could not create yubikey device: %w", err)}
+		return identity.Identity{Err: err}
 	}
-	defer func() {
-		if cerr := device.Close(); cerr != nil && ident.Err == nil {
-			ident.Err = cerr
-		}
-	}()
 
 	f, err := fs.OpenFile(keyPath)
 	if err != nil {
@@ -160,5 +206,5 @@ func loadIdentity(keyPath, pivSlot string) (ident identity.Identity) {
 		}
 	}()
 
-	return identity.LoadPiv(f, keyPath, device, uint32(slot))
+	return identity.LoadPiv(f, keyPath, openPiv(serial), uint32(slot), pivAuth(noPrompt, ui))
 }
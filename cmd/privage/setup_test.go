@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"os"
 	"path/filepath"
 	"strings"
@@ -21,7 +22,7 @@ func TestSetupEnv_ExplicitFlags(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	if err := identity.GenerateAge(f); err != nil {
+	if err := identity.GenerateAge(f, false); err != nil {
 		t.Fatal(err)
 	}
 	_ = f.Close()
@@ -37,7 +38,7 @@ func TestSetupEnv_ExplicitFlags(t *testing.T) {
 		RepoPath: repoPath,
 	}
 
-	s, err := setupEnv(opts)
+	s, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -63,7 +64,7 @@ func TestSetupEnv_ConfigFlag(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
-	_ = identity.GenerateAge(f)
+	_ = identity.GenerateAge(f, false)
 	_ = f.Close()
 
 	// 2. Create repository
@@ -84,7 +85,7 @@ func TestSetupEnv_ConfigFlag(t *testing.T) {
 		ConfigFile: confPath,
 	}
 
-	s, err := setupEnv(opts)
+	s, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -104,7 +105,7 @@ func TestSetupEnv_DiscoveryConfig(t *testing.T) {
 	// Create identity and repo
 	idPath := filepath.Join(tmpDir, "key.txt")
 	f, _ := os.Create(idPath)
-	_ = identity.GenerateAge(f)
+	_ = identity.GenerateAge(f, false)
 	_ = f.Close()
 
 	repoPath := filepath.Join(tmpDir, "repo")
@@ -122,7 +123,7 @@ func TestSetupEnv_DiscoveryConfig(t *testing.T) {
 
 	opts := setup.Options{} // No flags
 
-	s, err := setupEnv(opts)
+	s, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -148,12 +149,12 @@ func TestSetupEnv_DiscoveryIdentity(t *testing.T) {
 	// Create identity in HOME/privage-key.txt (FindIdentityFile searches here)
 	idPath := filepath.Join(tmpDir, identity.DefaultFileName)
 	f, _ := os.Create(idPath)
-	_ = identity.GenerateAge(f)
+	_ = identity.GenerateAge(f, false)
 	_ = f.Close()
 
 	opts := setup.Options{}
 
-	s, err := setupEnv(opts)
+	s, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -188,7 +189,7 @@ func TestSetupEnv_PriorityFlagsOverConfig(t *testing.T) {
 	_ = os.Mkdir(goodRepo, 0755)
 	goodKey := filepath.Join(tmpDir, "good-key.txt")
 	f, _ := os.Create(goodKey)
-	_ = identity.GenerateAge(f)
+	_ = identity.GenerateAge(f, false)
 	_ = f.Close()
 
 	// 3. Provide explicit flags. They should overrule the discovery of the config file.
@@ -198,7 +199,7 @@ func TestSetupEnv_PriorityFlagsOverConfig(t *testing.T) {
 		RepoPath: goodRepo,
 	}
 
-	s, err := setupEnv(opts)
+	s, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -214,7 +215,7 @@ func TestSetupEnv_Validation(t *testing.T) {
 		KeyFile:    "b", // Incompatible
 	}
 
-	_, err := setupEnv(opts)
+	_, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
 	if err == nil {
 		t.Fatal("expected validation error")
 	}
@@ -229,7 +230,7 @@ func TestSetupEnv_PIV_ParsingOnly(t *testing.T) {
 
 	idPath := filepath.Join(tmpDir, "key.txt")
 	f, _ := os.Create(idPath)
-	_ = identity.GenerateAge(f)
+	_ = identity.GenerateAge(f, false)
 	_ = f.Close()
 
 	opts := setup.Options{
@@ -238,7 +239,7 @@ func TestSetupEnv_PIV_ParsingOnly(t *testing.T) {
 		PivSlot:  "9c",
 	}
 
-	_, err := setupEnv(opts)
+	_, err := setupEnv(opts, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
 	if err == nil {
 		// It might succeed if a Yubikey is actually plugged in and slot 9c is valid.
 		// But in most CI/dev envs it will fail.
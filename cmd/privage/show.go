@@ -2,64 +2,55 @@ package main
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/revelaction/privage/credential"
 	"github.com/revelaction/privage/header"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 // showCommand prints in the terminal partially/all the contents of an encrypted
 // file.
 func showCommand(s *setup.Setup, label string, fieldName string, ui UI) (err error) {
-	if s.Id.Id == nil {
-		return fmt.Errorf("%w: %v", ErrNoIdentity, s.Id.Err)
+	v, err := vault.New(s)
+	if err != nil {
+		return err
 	}
 
-	ch, err := headerGenerator(s.Repository, s.Id)
+	h, err := v.Get(label)
 	if err != nil {
 		return err
 	}
-	for h := range ch {
-		if h.Label == label {
-			f, err := os.Open(h.Path)
-			if err != nil {
-				return err
-			}
-			defer func() {
-				if cerr := f.Close(); cerr != nil && err == nil {
-					err = cerr
-				}
-			}()
-
-			r, err := contentReader(f, s.Id)
-			if err != nil {
-				return err
-			}
 
-			if h.Category != header.CategoryCredential {
-				return fmt.Errorf("%w: file '%s' is not a credential. Use 'privage cat %s' to view its contents", ErrNotCredential, label, label)
-			}
+	if h.Category != header.CategoryCredential {
+		return fmt.Errorf("%w: file '%s' is not a credential. Use 'privage cat %s' to view its contents", ErrNotCredential, label, label)
+	}
 
-			cred, err := credential.Decode(r)
-			if err != nil {
-				return err
-			}
+	r, err := v.Open(h)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
 
-			if fieldName != "" {
-				val, ok := cred.GetField(fieldName)
-				if !ok {
-					return fmt.Errorf("%w: field '%s' not found in credential '%s'", ErrFieldNotFound, fieldName, label)
-				}
-				if _, err := fmt.Fprint(ui.Out, val); err != nil {
-					return err
-				}
-				return nil
-			}
+	cred, err := credential.Decode(r)
+	if err != nil {
+		return err
+	}
 
-			return cred.FprintBasic(ui.Out)
+	if fieldName != "" {
+		val, ok := cred.GetField(fieldName)
+		if !ok {
+			return fmt.Errorf("%w: field '%s' not found in credential '%s'", ErrFieldNotFound, fieldName, label)
+		}
+		if _, err := fmt.Fprint(ui.Out, val); err != nil {
+			return err
 		}
+		return nil
 	}
 
-	return fmt.Errorf("%w: %q", ErrFileNotFound, label)
+	return cred.FprintBasic(ui.Out)
 }
@@ -5,6 +5,7 @@ import (
 
 	"github.com/revelaction/privage/config"
 	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
 )
 
 // statusCommand prints on the terminal a status of the privage command
@@ -14,6 +15,7 @@ func statusCommand(s *setup.Setup, ui UI) error {
 
 	if s.Id.Id != nil {
 		_, _ = fmt.Fprintf(ui.Out, "🔑 Found age key file in %s ✔️\n", s.Id.Path)
+		_, _ = fmt.Fprintf(ui.Out, "%4s The key is of kind %s\n", "", s.Id.Kind())
 	} else {
 		_, _ = fmt.Fprintln(ui.Out, "🔑 🚫 Could not find an age key")
 	}
@@ -45,16 +47,12 @@ func statusCommand(s *setup.Setup, ui UI) error {
 
 	}
 
-	cnt := 0
-	if s.Id.Id != nil {
-		ch, err := headerGenerator(s.Repository, s.Id)
+	if v, err := vault.New(s); err == nil {
+		headers, err := v.List()
 		if err != nil {
 			_, _ = fmt.Fprintf(ui.Out, "🔐  Could not count files: %v\n", err)
 		} else {
-			for range ch {
-				cnt++
-			}
-			_, _ = fmt.Fprintf(ui.Out, "🔐  Found %d encrypted files for the age key %s\n", cnt, s.Id.Path)
+			_, _ = fmt.Fprintf(ui.Out, "🔐  Found %d encrypted files for the age key %s\n", len(headers), s.Id.Path)
 		}
 	}
 
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"path/filepath"
+
+	"github.com/revelaction/privage/header"
+	"github.com/revelaction/privage/setup"
+	"github.com/revelaction/privage/vault"
+)
+
+// verifyCommand verifies the encrypted files of the repository against the
+// signed manifest. With sign, it signs the manifest of the current files
+// instead.
+func verifyCommand(s *setup.Setup, sign bool, ui UI) error {
+	v, err := vault.New(s)
+	if err != nil {
+		return err
+	}
+
+	if sign {
+		num, err := v.SignManifest()
+		if err != nil {
+			return err
+		}
+
+		_, _ = fmt.Fprintf(ui.Err, "📜 Signed the manifest %s of %d files with the key %s ✔️\n", vault.ManifestFileName, num, s.Id.Path)
+		_, _ = fmt.Fprintf(ui.Err, "(Teammates trust it by adding %s to the manifest_signers of their configuration)\n", v.SignerKey())
+		return nil
+	}
+
+	r, err := v.VerifyManifest()
+	if errors.Is(err, vault.ErrNoManifest) {
+		return fmt.Errorf("%w: check the files and sign them with \"privage verify --sign\"", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	headers, err := v.List()
+	if err != nil {
+		return err
+	}
+	byPath := map[string]*header.Header{}
+	for _, h := range headers {
+		byPath[h.Path] = h
+	}
+
+	// describe returns the file name of path, with the label and category
+	// if its header can be decrypted.
+	describe := func(path string) string {
+		if h, ok := byPath[path]; ok && h.Err == nil {
+			return fmt.Sprintf("%s %s", filepath.Base(path), h)
+		}
+		return filepath.Base(path)
+	}
+
+	signer := base64.StdEncoding.EncodeToString(r.Signer)
+	switch {
+	case r.Trusted && signer == v.SignerKey():
+		_, _ = fmt.Fprintf(ui.Out, "📜 The manifest of generation %d is signed by the key %s ✔️\n", r.Generation, s.Id.Path)
+	case r.Trusted:
+		_, _ = fmt.Fprintf(ui.Out, "📜 The manifest of generation %d is signed by the trusted key %s ✔️\n", r.Generation, signer)
+	default:
+		_, _ = fmt.Fprintf(ui.Out, "⚠ The manifest of generation %d is signed by another key %s\n", r.Generation, signer)
+	}
+
+	for _, path := range r.Unknown {
+		_, _ = fmt.Fprintf(ui.Out, "❓ Unknown file, not in the manifest: %s\n", describe(path))
+	}
+	for _, path := range r.Missing {
+		_, _ = fmt.Fprintf(ui.Out, "🚫 Missing file of the manifest: %s\n", describe(path))
+	}
+	for _, path := range r.Changed {
+		_, _ = fmt.Fprintf(ui.Out, "💥 Changed file, modified or rolled back: %s\n", describe(path))
+	}
+
+	if !r.OK() {
+		return errors.New("the repository does not match the signed manifest")
+	}
+
+	_, _ = fmt.Fprintf(ui.Out, "🔐  The %d encrypted files match the manifest ✔️\n", len(headers))
+	return nil
+}
+
+// warnManifest prints a warning if the files of the repository do not
+// match the signed manifest. A repository without manifest is not checked.
+func warnManifest(v *vault.Vault, ui UI) {
+	r, err := v.VerifyManifest()
+	switch {
+	case errors.Is(err, vault.ErrNoManifest):
+		return
+	case err != nil:
+		_, _ = fmt.Fprintf(ui.Err, "⚠ %v, check it with \"privage verify\"\n", err)
+	case !r.Trusted:
+		_, _ = fmt.Fprintln(ui.Err, "⚠ The manifest is signed by another key, check it with \"privage verify\"")
+	case !r.OK():
+		_, _ = fmt.Fprintf(ui.Err, "⚠ Found %d unknown, %d missing and %d changed files, check them with \"privage verify\"\n", len(r.Unknown), len(r.Missing), len(r.Changed))
+	}
+}
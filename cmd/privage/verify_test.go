@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/revelaction/privage/vault"
+)
+
+func TestVerifyCommand(t *testing.T) {
+	tests := []struct {
+		name     string
+		tamper   func(th *TestHelper)
+		wantErr  bool
+		contains string
+	}{
+		{
+			name:     "Match",
+			tamper:   func(th *TestHelper) {},
+			contains: "The 2 encrypted files match the manifest",
+		},
+		{
+			name: "Unknown",
+			tamper: func(th *TestHelper) {
+				th.AddEncryptedFileV1("forged.txt", "work", "forged")
+			},
+			wantErr:  true,
+			contains: "Unknown file, not in the manifest: ",
+		},
+		{
+			name: "Missing",
+			tamper: func(th *TestHelper) {
+				h, err := th.Vault().Get("b.txt")
+				if err != nil {
+					th.t.Fatal(err)
+				}
+				if err := os.Remove(h.Path); err != nil {
+					th.t.Fatal(err)
+				}
+			},
+			wantErr:  true,
+			contains: "Missing file of the manifest: ",
+		},
+		{
+			name: "RolledBack",
+			tamper: func(th *TestHelper) {
+				h, err := th.Vault().Get("a.txt")
+				if err != nil {
+					th.t.Fatal(err)
+				}
+				old, err := os.ReadFile(h.Path)
+				if err != nil {
+					th.t.Fatal(err)
+				}
+				th.AddEncryptedFile("a.txt", "work", "new content a")
+				if err := os.WriteFile(h.Path, old, 0600); err != nil {
+					th.t.Fatal(err)
+				}
+			},
+			wantErr:  true,
+			contains: "Changed file, modified or rolled back: ",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			th := NewTestHelper(t)
+			th.AddEncryptedFile("a.txt", "work", "content a")
+			th.AddEncryptedFile("b.txt", "work", "content b")
+			tt.tamper(th)
+
+			var outBuf bytes.Buffer
+			err := verifyCommand(th.Setup, false, UI{Out: &outBuf, Err: &bytes.Buffer{}})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(outBuf.String(), tt.contains) {
+				t.Errorf("expected %q in output, got %q", tt.contains, outBuf.String())
+			}
+
+			var errBuf bytes.Buffer
+			if err := listCommand(th.Setup, "", UI{Out: &bytes.Buffer{}, Err: &errBuf}); err != nil {
+				t.Fatalf("listCommand failed: %v", err)
+			}
+			if warned := strings.Contains(errBuf.String(), "privage verify"); warned != tt.wantErr {
+				t.Errorf("expected list warning %v, got %q", tt.wantErr, errBuf.String())
+			}
+		})
+	}
+}
+
+func TestVerifyCommand_Sign(t *testing.T) {
+	th := NewTestHelper(t)
+	th.AddEncryptedFileV1("legacy.txt", "work", "content")
+
+	err := verifyCommand(th.Setup, false, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}})
+	if !errors.Is(err, vault.ErrNoManifest) {
+		t.Fatalf("expected ErrNoManifest, got %v", err)
+	}
+
+	var errBuf bytes.Buffer
+	if err := verifyCommand(th.Setup, true, UI{Out: &bytes.Buffer{}, Err: &errBuf}); err != nil {
+		t.Fatalf("verifyCommand -sign failed: %v", err)
+	}
+	if !strings.Contains(errBuf.String(), "Signed the manifest .privage-manifest of 1 files") {
+		t.Errorf("unexpected output %q", errBuf.String())
+	}
+
+	if err := verifyCommand(th.Setup, false, UI{Out: &bytes.Buffer{}, Err: &bytes.Buffer{}}); err != nil {
+		t.Errorf("expected the signed files to match, got %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
 	"github.com/pelletier/go-toml/v2"
@@ -23,18 +24,40 @@ type Config struct {
 	Path string `toml:"-"`
 
 	// Identity settings
-	IdentityPath    string `toml:"identity_path" comment:"Path to the age identity file (supports ~/)"`
-	IdentityType    string `toml:"identity_type" comment:"Type of identity: AGE or PIV"`
-	IdentityPivSlot string `toml:"identity_piv_slot" comment:"Hex string for the Yubikey PIV slot (e.g., 9a)"`
+	IdentityPath      string `toml:"identity_path" comment:"Path to the age identity file (supports ~/)"`
+	IdentityType      string `toml:"identity_type" comment:"Type of identity: AGE, PIV or PASSPHRASE"`
+	IdentityPivSlot   string `toml:"identity_piv_slot" comment:"Hex string for the Yubikey PIV slot (e.g., 9a)"`
+	IdentityPivSerial string `toml:"identity_piv_serial" comment:"Serial number of the Yubikey, if several are plugged in (empty means the first one)"`
 
 	// Repository settings
 	RepositoryPath string `toml:"repository_path" comment:"Directory containing encrypted files (supports ~/)"`
+	Parallelism    int    `toml:"parallelism" comment:"Number of headers decrypted in parallel (0 means number of CPUs)"`
+	Index          bool   `toml:"index" comment:"Keep an encrypted index of the headers in the repository for fast lookups"`
+
+	// Recipients are age public keys, besides the one of the identity, the
+	// files are encrypted to.
+	Recipients []string `toml:"recipients" comment:"Additional age public keys the files are encrypted to"`
+
+	// Categories are the recipient policies of categories, by name.
+	Categories map[string]CategoryPolicy `toml:"categories" comment:"Recipient policies of categories"`
+
+	// ManifestSigners are the public keys, besides the one of the identity,
+	// whose signed manifests are trusted, f. ex. of the teammates.
+	ManifestSigners []string `toml:"manifest_signers" comment:"Public keys of the trusted signers of the manifest, as printed by privage verify"`
 
 	// Default fields for credentials
 	Login string `toml:"login" comment:"Default username/login for new credentials"`
 	Email string `toml:"email" comment:"Default email for new credentials"`
 }
 
+// A CategoryPolicy restricts the recipients of the content of the files of
+// a category.
+type CategoryPolicy struct {
+	// Recipients are the age public keys, besides the one of the writer,
+	// the content of the files of the category is encrypted to.
+	Recipients []string `toml:"recipients" comment:"Age public keys the content of the category is encrypted to"`
+}
+
 // decode decodes a configuration from an io.Reader.
 func decode(r io.Reader) (*Config, error) {
 	var conf Config
@@ -94,6 +117,22 @@ func (c *Config) validate() error {
 		return fmt.Errorf("repository directory %s does not exist", c.RepositoryPath)
 	}
 
+	if c.IdentityPivSerial != "" {
+		if _, err := strconv.ParseUint(c.IdentityPivSerial, 10, 32); err != nil {
+			return fmt.Errorf("invalid identity_piv_serial %q: %w", c.IdentityPivSerial, err)
+		}
+	}
+
+	if c.Parallelism < 0 {
+		return fmt.Errorf("parallelism must not be negative, got %d", c.Parallelism)
+	}
+
+	for name, policy := range c.Categories {
+		if len(policy.Recipients) == 0 {
+			return fmt.Errorf("categories.%s: recipients are required", name)
+		}
+	}
+
 	return nil
 }
 
@@ -85,6 +85,42 @@ func TestValidate(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "Negative parallelism",
+			conf: &Config{
+				IdentityPath:   existingFile,
+				RepositoryPath: tmpDir,
+				Parallelism:    -1,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid piv serial",
+			conf: &Config{
+				IdentityPath:      existingFile,
+				RepositoryPath:    tmpDir,
+				IdentityPivSerial: "12345678",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid piv serial",
+			conf: &Config{
+				IdentityPath:      existingFile,
+				RepositoryPath:    tmpDir,
+				IdentityPivSerial: "0x1234",
+			},
+			wantErr: true,
+		},
+		{
+			name: "Category policy without recipients",
+			conf: &Config{
+				IdentityPath:   existingFile,
+				RepositoryPath: tmpDir,
+				Categories:     map[string]CategoryPolicy{"prod-db": {}},
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -190,6 +226,20 @@ func TestLoad(t *testing.T) {
 identity_path = '%s'
 repository_path = '%s'
 login = 'user'
+`, idPath, tmpDir),
+			wantErr: false,
+		},
+		{
+			name: "Valid config with category policies",
+			toml: fmt.Sprintf(`
+identity_path = '%s'
+repository_path = '%s'
+
+[categories.prod-db]
+recipients = ['age1ops']
+
+[categories.credential]
+recipients = ['age1ops', 'age1dev']
 `, idPath, tmpDir),
 			wantErr: false,
 		},
@@ -3,18 +3,35 @@ package header
 import (
 	"bytes"
 	"crypto/sha256"
+	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
 	BlockSize          = 512 // bytes
+	MaxBlocks          = 64  // maximum number of blocks of a header
 	MaxLenghtCategory  = 40
 	MaxLenghtLabel     = 200
 	CategoryCredential = "credential"
+	// CategorySeparator separates the category and the label of a
+	// qualified label, as category/label.
+	CategorySeparator = "/"
+
+	// Version1 is the original header layout: version, category and label
+	// in fixed size fields.
+	Version1 = "v1"
+	// Version2 is the extensible header layout, with timestamps, size,
+	// checksum, content type and tags of the content.
+	Version2 = "v2"
+	// CurrentVersion is the version of newly written headers.
+	CurrentVersion = Version2
 
 	maxLenghtVersion = 5
-	version          = "v1"
 	// ageHeaderPrefix is the magic string that marks the start of an age binary file header.
 	//
 	// Per the Age specification (https://age-encryption.org/v1):
@@ -24,6 +41,32 @@ const (
 	// version-line = %s"age-encryption.org/" version LF"
 	ageHeaderPrefix = "age-encryption.org/"
 	paddingChar     = ' '
+	// blocksPrefix starts the line with the number of blocks of headers
+	// that do not fit in one BlockSize, for example with many recipients.
+	blocksPrefix = "privage-blocks:"
+)
+
+// Field tags of the v2 header.
+const (
+	tagCategory byte = iota + 1
+	tagLabel
+	tagCreated
+	tagModified
+	tagSize
+	tagSHA256
+	tagContentType
+	tagTag
+)
+
+var (
+	// ErrVersion is returned for headers of an unknown version.
+	ErrVersion = errors.New("unsupported header version")
+
+	// ErrTooLarge is returned when a header does not fit in BlockSize.
+	ErrTooLarge = errors.New("header too large")
+
+	// ErrInvalidLabel is returned for a label that can not name a file.
+	ErrInvalidLabel = errors.New("invalid label")
 )
 
 // IsCredential returns true if the header belongs to the credential category.
@@ -31,13 +74,38 @@ func (h *Header) IsCredential() bool {
 	return h.Category == CategoryCredential
 }
 
+// QualifiedLabel returns the label qualified with the category, as
+// category/label.
+func (h *Header) QualifiedLabel() string {
+	return h.Category + CategorySeparator + h.Label
+}
+
+// ValidateLabel returns ErrInvalidLabel if label can not be the label of a
+// new file: it is empty, "." or "..", or contains the category separator or
+// a path separator, so that it is also the name of its decrypted file.
+func ValidateLabel(label string) error {
+	switch {
+	case label == "":
+		return fmt.Errorf("%w: empty label", ErrInvalidLabel)
+	case label == "." || label == "..":
+		return fmt.Errorf("%w: %q", ErrInvalidLabel, label)
+	case strings.ContainsAny(label, CategorySeparator+`/\`):
+		return fmt.Errorf("%w: %q can not contain %q or a path separator", ErrInvalidLabel, label, CategorySeparator)
+	}
+
+	return nil
+}
+
 // Hash generates a deterministic hash of the header and the age identity.
 //
 // ageIdentity is the string representation of the age public key (recipient).
 // This hash is used to generate unique filenames for encrypted content, ensuring
 // that the same content encrypted for different identities results in different files.
+//
+// Only the category and label are hashed, in the v1 layout, so that the file
+// name does not change with the content or the version of the header.
 func (h *Header) Hash(ageIdentity string) (string, error) {
-	padded, err := h.Pad()
+	padded, err := h.padV1()
 	if err != nil {
 		return "", fmt.Errorf("failed to pad header for hashing: %w", err)
 	}
@@ -56,6 +124,25 @@ type Header struct {
 	Category string
 	Label    string
 
+	// Fields of the v2 header, zero for v1 headers.
+
+	// Created and Modified are the creation and last modification times of
+	// the content, with second precision.
+	Created  time.Time
+	Modified time.Time
+
+	// Size is the size in bytes of the plaintext content.
+	Size int64
+
+	// SHA256 is the SHA-256 hash of the plaintext content.
+	SHA256 []byte
+
+	// ContentType is the MIME type of the content.
+	ContentType string
+
+	// Tags are free-form tags of the content.
+	Tags []string
+
 	// Path of the privage file containing the header
 	Path string
 
@@ -72,76 +159,291 @@ func (h *Header) String() string {
 	return fmt.Sprintf("💼 %s  🔖%s", h.Label, h.Category)
 }
 
-// Pad returns a serialized version of the header using LEFT padding
-// to maintain backward compatibility with existing files.
+// Pad returns a serialized version of the header, in the layout of its
+// Version. Headers without Version are serialized in the current version.
 // It returns an error if any field exceeds its maximum allowed byte length.
 func (h *Header) Pad() ([]byte, error) {
-    buf := new(bytes.Buffer)
-
-    // 1. Version
-    vBytes := []byte(version)
-    padLen := maxLenghtVersion - len(vBytes)
-    if padLen < 0 {
-        return nil, fmt.Errorf("version constant exceeds maximum length of %d bytes", maxLenghtVersion)
-    }
-    buf.Write(bytes.Repeat([]byte{paddingChar}, padLen))
-    buf.Write(vBytes)
-
-    // 2. Category
-    catBytes := []byte(h.Category)
-    padLen = MaxLenghtCategory - len(catBytes)
-    if padLen < 0 {
-        return nil, fmt.Errorf("category exceeds maximum length of %d bytes", MaxLenghtCategory)
-    }
-    buf.Write(bytes.Repeat([]byte{paddingChar}, padLen))
-    buf.Write(catBytes)
-
-    // 3. Label
-    labelBytes := []byte(h.Label)
-    padLen = MaxLenghtLabel - len(labelBytes)
-    if padLen < 0 {
-        return nil, fmt.Errorf("label exceeds maximum length of %d bytes", MaxLenghtLabel)
-    }
-    buf.Write(bytes.Repeat([]byte{paddingChar}, padLen))
-    buf.Write(labelBytes)
-
-    // 4. Safety Check
-    if buf.Len() > BlockSize {
-        return nil, fmt.Errorf("internal error: padded header size %d exceeds BlockSize %d", buf.Len(), BlockSize)
-    }
-
-    return buf.Bytes(), nil
-}
-
-// Parse parses a serialized version of a header.
+	switch h.Version {
+	case Version1:
+		return h.padV1()
+	case "", Version2:
+		return h.padV2()
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrVersion, h.Version)
+	}
+}
+
+// padV1 returns the v1 serialization of the header: fixed size fields using
+// LEFT padding.
+func (h *Header) padV1() ([]byte, error) {
+	buf := new(bytes.Buffer)
+
+	// 1. Version
+	writeVersion(buf, Version1)
+
+	// 2. Category
+	catBytes := []byte(h.Category)
+	padLen := MaxLenghtCategory - len(catBytes)
+	if padLen < 0 {
+		return nil, fmt.Errorf("category exceeds maximum length of %d bytes", MaxLenghtCategory)
+	}
+	buf.Write(bytes.Repeat([]byte{paddingChar}, padLen))
+	buf.Write(catBytes)
+
+	// 3. Label
+	labelBytes := []byte(h.Label)
+	padLen = MaxLenghtLabel - len(labelBytes)
+	if padLen < 0 {
+		return nil, fmt.Errorf("label exceeds maximum length of %d bytes", MaxLenghtLabel)
+	}
+	buf.Write(bytes.Repeat([]byte{paddingChar}, padLen))
+	buf.Write(labelBytes)
+
+	// 4. Safety Check
+	if buf.Len() > BlockSize {
+		return nil, fmt.Errorf("internal error: padded header size %d exceeds BlockSize %d", buf.Len(), BlockSize)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// padV2 returns the v2 serialization of the header: the left padded version
+// followed by a sequence of fields. Each field is a one byte tag, the
+// uvarint length of the value and the value. Empty fields are omitted.
+func (h *Header) padV2() ([]byte, error) {
+	if len(h.Category) > MaxLenghtCategory {
+		return nil, fmt.Errorf("category exceeds maximum length of %d bytes", MaxLenghtCategory)
+	}
+	if len(h.Label) > MaxLenghtLabel {
+		return nil, fmt.Errorf("label exceeds maximum length of %d bytes", MaxLenghtLabel)
+	}
+	if h.Size < 0 {
+		return nil, fmt.Errorf("invalid negative size %d", h.Size)
+	}
+	if h.SHA256 != nil && len(h.SHA256) != sha256.Size {
+		return nil, fmt.Errorf("invalid SHA-256 length %d", len(h.SHA256))
+	}
+
+	buf := new(bytes.Buffer)
+	writeVersion(buf, Version2)
+
+	writeField(buf, tagCategory, []byte(h.Category))
+	writeField(buf, tagLabel, []byte(h.Label))
+	if !h.Created.IsZero() {
+		writeField(buf, tagCreated, binary.AppendVarint(nil, h.Created.Unix()))
+	}
+	if !h.Modified.IsZero() {
+		writeField(buf, tagModified, binary.AppendVarint(nil, h.Modified.Unix()))
+	}
+	writeField(buf, tagSize, binary.AppendUvarint(nil, uint64(h.Size)))
+	writeField(buf, tagSHA256, h.SHA256)
+	writeField(buf, tagContentType, []byte(h.ContentType))
+	for _, tag := range h.Tags {
+		writeField(buf, tagTag, []byte(tag))
+	}
+
+	if buf.Len() > BlockSize {
+		return nil, fmt.Errorf("%w: serialized size %d exceeds BlockSize %d", ErrTooLarge, buf.Len(), BlockSize)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeVersion(buf *bytes.Buffer, v string) {
+	buf.Write(bytes.Repeat([]byte{paddingChar}, maxLenghtVersion-len(v)))
+	buf.WriteString(v)
+}
+
+func writeField(buf *bytes.Buffer, tag byte, value []byte) {
+	if len(value) == 0 {
+		return
+	}
+	buf.WriteByte(tag)
+	buf.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	buf.Write(value)
+}
+
+// Parse parses a serialized version of a header, dispatching on its
+// version.
+//
+// Errors are not returned but set in the Err field of the header.
 func Parse(h []byte) *Header {
-    res := &Header{}
+	if len(h) < maxLenghtVersion {
+		return &Header{Err: fmt.Errorf("header too short: %d bytes", len(h))}
+	}
+
+	v := string(bytes.TrimLeft(h[:maxLenghtVersion], string(paddingChar)))
+	switch v {
+	case Version1:
+		return parseV1(h)
+	case Version2:
+		res, err := parseV2(h[maxLenghtVersion:])
+		if err != nil {
+			return &Header{Version: v, Err: err}
+		}
+		return res
+	default:
+		return &Header{Version: v, Err: fmt.Errorf("%w: %q", ErrVersion, v)}
+	}
+}
+
+func parseV1(h []byte) *Header {
+	res := &Header{}
 
-    // Slice strictly by byte offsets
-    res.Version = string(bytes.TrimLeft(h[:maxLenghtVersion], string(paddingChar)))
+	// Slice strictly by byte offsets
+	res.Version = string(bytes.TrimLeft(h[:maxLenghtVersion], string(paddingChar)))
 
-    offset := maxLenghtVersion
-    res.Category = string(bytes.TrimLeft(h[offset:offset+MaxLenghtCategory], string(paddingChar)))
+	offset := maxLenghtVersion
+	res.Category = string(bytes.TrimLeft(h[offset:offset+MaxLenghtCategory], string(paddingChar)))
 
-    offset += MaxLenghtCategory
-    res.Label = string(bytes.TrimLeft(h[offset:], string(paddingChar)))
+	offset += MaxLenghtCategory
+	res.Label = string(bytes.TrimLeft(h[offset:], string(paddingChar)))
 
-    return res
+	return res
 }
 
-// PadEncrypted fills the encrypted (with age) header up to BlockSize with paddingChar
-// characters
+// parseV2 parses the fields of a v2 header. Unknown fields, written by
+// later versions, are skipped.
+func parseV2(b []byte) (*Header, error) {
+	res := &Header{Version: Version2}
+
+	for len(b) > 0 {
+		tag := b[0]
+		n, k := binary.Uvarint(b[1:])
+		if k <= 0 || n > uint64(len(b)-1-k) {
+			return nil, fmt.Errorf("truncated header field %d", tag)
+		}
+		value := b[1+k : 1+k+int(n)]
+		b = b[1+k+int(n):]
+
+		switch tag {
+		case tagCategory:
+			res.Category = string(value)
+		case tagLabel:
+			res.Label = string(value)
+		case tagCreated, tagModified:
+			sec, k := binary.Varint(value)
+			if k != len(value) {
+				return nil, fmt.Errorf("invalid timestamp in header field %d", tag)
+			}
+			if tag == tagCreated {
+				res.Created = time.Unix(sec, 0)
+			} else {
+				res.Modified = time.Unix(sec, 0)
+			}
+		case tagSize:
+			size, k := binary.Uvarint(value)
+			if k != len(value) || size > math.MaxInt64 {
+				return nil, errors.New("invalid size in header")
+			}
+			res.Size = int64(size)
+		case tagSHA256:
+			if len(value) != sha256.Size {
+				return nil, fmt.Errorf("invalid SHA-256 length %d in header", len(value))
+			}
+			res.SHA256 = value
+		case tagContentType:
+			res.ContentType = string(value)
+		case tagTag:
+			res.Tags = append(res.Tags, string(value))
+		}
+	}
+
+	return res, nil
+}
+
+// PadEncrypted fills the encrypted (with age) header up to EncryptedSize
+// with paddingChar characters.
 func PadEncrypted(header []byte) ([]byte, error) {
-	diff := BlockSize - len(header)
-	pad := bytes.Repeat([]byte{paddingChar}, diff)
-	padded := append(pad, header...)
+	return PadEncryptedSize(header, EncryptedSize(len(header)))
+}
+
+// PadEncryptedSize fills the encrypted (with age) header up to size with
+// paddingChar characters. size must be a multiple of BlockSize.
+//
+// Headers of more than one block start with a line with the number of
+// blocks. It returns ErrTooLarge if the encrypted header does not fit in
+// size or size exceeds MaxBlocks blocks.
+func PadEncryptedSize(header []byte, size int) ([]byte, error) {
+	if size%BlockSize != 0 || size < BlockSize {
+		return nil, fmt.Errorf("invalid header size %d, must be a multiple of BlockSize %d", size, BlockSize)
+	}
+
+	blocks := size / BlockSize
+	if blocks > MaxBlocks {
+		return nil, fmt.Errorf("%w: %d blocks exceed the maximum of %d", ErrTooLarge, blocks, MaxBlocks)
+	}
+
+	var marker []byte
+	if blocks > 1 {
+		marker = blocksMarker(blocks)
+	}
+
+	diff := size - len(marker) - len(header)
+	if diff < 0 {
+		return nil, fmt.Errorf("%w: encrypted size %d exceeds header size %d", ErrTooLarge, len(header), size)
+	}
+
+	padded := make([]byte, 0, size)
+	padded = append(padded, marker...)
+	padded = append(padded, bytes.Repeat([]byte{paddingChar}, diff)...)
+	padded = append(padded, header...)
 	return padded, nil
 }
 
+// EncryptedSize returns the size of the padded header for an encrypted
+// header of n bytes: the smallest multiple of BlockSize that fits it.
+func EncryptedSize(n int) int {
+	if n <= BlockSize {
+		return BlockSize
+	}
+
+	blocks := 2
+	for len(blocksMarker(blocks))+n > blocks*BlockSize {
+		blocks++
+	}
+
+	return blocks * BlockSize
+}
+
+// Size returns the size of the padded header, given its first BlockSize
+// bytes.
+func Size(block []byte) (int, error) {
+	if !bytes.HasPrefix(block, []byte(blocksPrefix)) {
+		return BlockSize, nil
+	}
+
+	line, _, ok := bytes.Cut(block[len(blocksPrefix):], []byte{'\n'})
+	if !ok {
+		return 0, errors.New("header corruption: unterminated block count")
+	}
+
+	blocks, err := strconv.Atoi(string(line))
+	if err != nil || blocks < 2 || blocks > MaxBlocks {
+		return 0, fmt.Errorf("header corruption: invalid block count %q", line)
+	}
+
+	return blocks * BlockSize, nil
+}
+
+func blocksMarker(blocks int) []byte {
+	return fmt.Appendf(nil, "%s%d\n", blocksPrefix, blocks)
+}
+
 // Unpad removes the filled characters of a encrypted header.
-// It strictly verifies that all bytes preceding the age header prefix are valid padding characters.
+// It strictly verifies that all bytes preceding the age header prefix are
+// valid padding characters, after the block count line of multi block
+// headers.
 func Unpad(header []byte) ([]byte, error) {
 
+	if bytes.HasPrefix(header, []byte(blocksPrefix)) {
+		_, rest, ok := bytes.Cut(header, []byte{'\n'})
+		if !ok {
+			return nil, errors.New("header corruption: unterminated block count")
+		}
+		header = rest
+	}
+
 	idx := bytes.Index(header, []byte(ageHeaderPrefix))
 	if idx == -1 {
 		return nil, errors.New("could not unpad header, age prefix not found")
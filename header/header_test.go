@@ -2,8 +2,11 @@ package header
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestHeader_PadAndParse(t *testing.T) {
@@ -132,7 +135,7 @@ func TestHeader_Hash(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Hash() failed: %v", err)
 	}
-	
+
 	hash2, err := h.Hash(ageIdentity)
 	if err != nil {
 		t.Fatalf("Hash() failed second time: %v", err)
@@ -262,12 +265,12 @@ func TestHeader_Pad_UTF8_Overflow(t *testing.T) {
 	// Calculate how many 3-byte characters fit in Category (Max 40 bytes)
 	// 13 chars * 3 bytes = 39 bytes (Fits)
 	// 14 chars * 3 bytes = 42 bytes (Overflows)
-	// Note: 14 chars would fit if we counted characters (length 14 < 40), 
+	// Note: 14 chars would fit if we counted characters (length 14 < 40),
 	// but fails correctly because we count bytes.
-	
+
 	overflowCategory := strings.Repeat("字", 14) // 14 Chinese chars
-	
-h := &Header{
+
+	h := &Header{
 		Version:  "v1",
 		Category: overflowCategory,
 		Label:    "test",
@@ -287,12 +290,12 @@ h := &Header{
 func TestHeader_Pad_Emoji_Boundary(t *testing.T) {
 	// Test a label that is exactly the max length in bytes using emojis
 	// MaxLenghtLabel = 200
-	// 🔒 is 4 bytes. 
+	// 🔒 is 4 bytes.
 	// 50 * 4 = 200 bytes.
-	
+
 	exactLabel := strings.Repeat("🔒", 50)
-	
-h := &Header{
+
+	h := &Header{
 		Version:  "v1",
 		Category: "boundary",
 		Label:    exactLabel,
@@ -301,9 +304,245 @@ h := &Header{
 	if err != nil {
 		t.Fatalf("Header.Pad() failed on exact boundary: %v", err)
 	}
-	
+
 	parsed := Parse(padded)
 	if parsed.Label != exactLabel {
 		t.Errorf("Label mismatch on boundary.\nGot length: %d\nWant length: %d", len(parsed.Label), len(exactLabel))
 	}
 }
+
+func TestHeader_PadAndParse_V2(t *testing.T) {
+	created := time.Unix(1700000000, 0)
+	modified := time.Unix(1700001234, 0)
+	sum := sha256.Sum256([]byte("content"))
+
+	original := &Header{
+		Category:    "work",
+		Label:       "plan.pdf",
+		Created:     created,
+		Modified:    modified,
+		Size:        7,
+		SHA256:      sum[:],
+		ContentType: "application/pdf",
+		Tags:        []string{"q3", "draft"},
+	}
+
+	padded, err := original.Pad()
+	if err != nil {
+		t.Fatalf("unexpected error during Pad(): %v", err)
+	}
+
+	parsed := Parse(padded)
+	if parsed.Err != nil {
+		t.Fatalf("unexpected parse error: %v", parsed.Err)
+	}
+
+	if parsed.Version != Version2 {
+		t.Errorf("expected Version %q, got %q", Version2, parsed.Version)
+	}
+	if parsed.Category != original.Category || parsed.Label != original.Label {
+		t.Errorf("expected %s/%s, got %s/%s", original.Category, original.Label, parsed.Category, parsed.Label)
+	}
+	if !parsed.Created.Equal(created) || !parsed.Modified.Equal(modified) {
+		t.Errorf("timestamps mismatch: got %v %v", parsed.Created, parsed.Modified)
+	}
+	if parsed.Size != original.Size {
+		t.Errorf("expected Size %d, got %d", original.Size, parsed.Size)
+	}
+	if !bytes.Equal(parsed.SHA256, original.SHA256) {
+		t.Errorf("SHA256 mismatch")
+	}
+	if parsed.ContentType != original.ContentType {
+		t.Errorf("expected ContentType %q, got %q", original.ContentType, parsed.ContentType)
+	}
+	if strings.Join(parsed.Tags, ",") != "q3,draft" {
+		t.Errorf("expected tags q3,draft, got %v", parsed.Tags)
+	}
+}
+
+func TestParse_Dispatch(t *testing.T) {
+	v1, err := (&Header{Version: Version1, Category: "cat", Label: "label"}).Pad()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v2, err := (&Header{Category: "cat", Label: "label"}).Pad()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A field with an unknown tag, written by a later version
+	unknown := append(bytes.Clone(v2), 0xf0, 3, 'x', 'y', 'z')
+
+	tests := []struct {
+		name    string
+		input   []byte
+		version string
+		wantErr error
+	}{
+		{name: "V1", input: v1, version: Version1},
+		{name: "V2", input: v2, version: Version2},
+		{name: "V2_UnknownField", input: unknown, version: Version2},
+		{name: "V2_Truncated", input: v2[:len(v2)-2], version: Version2, wantErr: errors.New("truncated")},
+		{name: "UnknownVersion", input: []byte("   v9payload"), version: "v9", wantErr: ErrVersion},
+		{name: "TooShort", input: []byte("v1"), wantErr: errors.New("too short")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := Parse(tt.input)
+			if tt.wantErr != nil {
+				if h.Err == nil {
+					t.Fatalf("expected error, got nil")
+				}
+				if !errors.Is(h.Err, tt.wantErr) && !strings.Contains(h.Err.Error(), tt.wantErr.Error()) {
+					t.Errorf("expected error %v, got %v", tt.wantErr, h.Err)
+				}
+				return
+			}
+			if h.Err != nil {
+				t.Fatalf("unexpected error: %v", h.Err)
+			}
+			if h.Version != tt.version {
+				t.Errorf("expected version %q, got %q", tt.version, h.Version)
+			}
+			if h.Category != "cat" || h.Label != "label" {
+				t.Errorf("expected cat/label, got %s/%s", h.Category, h.Label)
+			}
+		})
+	}
+}
+
+func TestHeader_Hash_StableAcrossVersions(t *testing.T) {
+	v1 := &Header{Version: Version1, Category: "cat", Label: "label"}
+	v2 := &Header{
+		Version:  Version2,
+		Category: "cat",
+		Label:    "label",
+		Created:  time.Now(),
+		Size:     42,
+		Tags:     []string{"tag"},
+	}
+
+	h1, err := v1.Hash("age1recipient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, err := v2.Hash("age1recipient")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h1 != h2 {
+		t.Errorf("expected the same hash for both versions, got %s and %s", h1, h2)
+	}
+}
+
+func TestPadEncrypted_MultiBlock(t *testing.T) {
+	payload := append([]byte(ageHeaderPrefix), bytes.Repeat([]byte{'x'}, BlockSize)...)
+
+	padded, err := PadEncrypted(payload)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(padded) != 2*BlockSize {
+		t.Fatalf("expected length %d, got %d", 2*BlockSize, len(padded))
+	}
+
+	size, err := Size(padded[:BlockSize])
+	if err != nil {
+		t.Fatalf("Size failed: %v", err)
+	}
+	if size != len(padded) {
+		t.Errorf("expected size %d, got %d", len(padded), size)
+	}
+
+	unpadded, err := Unpad(padded)
+	if err != nil {
+		t.Fatalf("Unpad failed: %v", err)
+	}
+	if !bytes.Equal(unpadded, payload) {
+		t.Error("payload was not preserved")
+	}
+}
+
+func TestPadEncrypted_TooLarge(t *testing.T) {
+	_, err := PadEncrypted(make([]byte, MaxBlocks*BlockSize))
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	_, err = PadEncryptedSize(make([]byte, BlockSize+1), 2*BlockSize-10)
+	if err == nil {
+		t.Fatal("expected error for size not multiple of BlockSize")
+	}
+
+	_, err = PadEncryptedSize(make([]byte, BlockSize+1), BlockSize)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestSize(t *testing.T) {
+	tests := []struct {
+		name    string
+		block   string
+		want    int
+		wantErr bool
+	}{
+		{name: "SingleBlock", block: "      age-encryption.org/v1", want: BlockSize},
+		{name: "MultiBlock", block: blocksPrefix + "3\n   age-encryption.org/v1", want: 3 * BlockSize},
+		{name: "Unterminated", block: blocksPrefix + "3", wantErr: true},
+		{name: "NotANumber", block: blocksPrefix + "x\n", wantErr: true},
+		{name: "OneBlock", block: blocksPrefix + "1\n", wantErr: true},
+		{name: "TooMany", block: blocksPrefix + "65\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Size([]byte(tt.block))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Size() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Size() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHeader_Pad_UnknownVersion(t *testing.T) {
+	_, err := (&Header{Version: "v9", Category: "cat", Label: "label"}).Pad()
+	if !errors.Is(err, ErrVersion) {
+		t.Fatalf("expected ErrVersion, got %v", err)
+	}
+}
+
+func TestValidateLabel(t *testing.T) {
+	tests := []struct {
+		label   string
+		wantErr bool
+	}{
+		{label: "secret-plan.doc"},
+		{label: "somewebsite.com@loginname"},
+		{label: "a..b"},
+		{label: "", wantErr: true},
+		{label: ".", wantErr: true},
+		{label: "..", wantErr: true},
+		{label: "work/plan.doc", wantErr: true},
+		{label: "../plan.doc", wantErr: true},
+		{label: `..\plan.doc`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			err := ValidateLabel(tt.label)
+			if tt.wantErr && !errors.Is(err, ErrInvalidLabel) {
+				t.Errorf("expected ErrInvalidLabel, got %v", err)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package identity
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrNoCard is returned when no PIV smart card is detected.
+var ErrNoCard = errors.New("no cards detected")
+
+// CardNotFoundError is returned when no detected PIV smart card has the
+// requested serial number.
+type CardNotFoundError struct {
+	// Serial is the requested serial number.
+	Serial uint32
+	// Found are the serial numbers of the detected cards.
+	Found []uint32
+}
+
+func (e *CardNotFoundError) Error() string {
+	found := make([]string, len(e.Found))
+	for i, s := range e.Found {
+		found[i] = fmt.Sprint(s)
+	}
+	return fmt.Sprintf("no card with serial %d detected, found serials [%s]", e.Serial, strings.Join(found, ", "))
+}
+
+// Cards lists the PIV smart cards connected to the system.
+type Cards interface {
+	// Names returns the names of the detected cards.
+	Names() ([]string, error)
+	// Serial returns the serial number of the named card.
+	Serial(name string) (uint32, error)
+}
+
+// CardInfo describes a PIV smart card.
+type CardInfo struct {
+	Name    string
+	Serial  uint32
+	Version string
+	Slots   []SlotInfo
+}
+
+// SlotInfo describes the certificate of a slot of a PIV smart card.
+type SlotInfo struct {
+	Slot      uint32
+	Subject   string
+	Algorithm string
+	NotAfter  time.Time
+}
+
+// SelectCard returns the name of the card with the serial number, or of the
+// first detected card if serial is zero. Cards whose serial number can not
+// be read are skipped.
+func SelectCard(cards Cards, serial uint32) (string, error) {
+	names, err := cards.Names()
+	if err != nil {
+		return "", fmt.Errorf("could not list cards: %w", err)
+	}
+	if len(names) == 0 {
+		return "", ErrNoCard
+	}
+
+	if serial == 0 {
+		return names[0], nil
+	}
+
+	var found []uint32
+	for _, name := range names {
+		s, err := cards.Serial(name)
+		if err != nil {
+			continue
+		}
+		if s == serial {
+			return name, nil
+		}
+		found = append(found, s)
+	}
+
+	return "", &CardNotFoundError{Serial: serial, Found: found}
+}
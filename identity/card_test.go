@@ -0,0 +1,82 @@
+package identity
+
+import (
+	"errors"
+	"testing"
+)
+
+// mockCards implements the Cards interface with the serial numbers of the
+// cards by name. Cards without serial number fail to report it.
+type mockCards struct {
+	names   []string
+	serials map[string]uint32
+	err     error
+}
+
+func (m *mockCards) Names() ([]string, error) {
+	return m.names, m.err
+}
+
+func (m *mockCards) Serial(name string) (uint32, error) {
+	s, ok := m.serials[name]
+	if !ok {
+		return 0, errors.New("no serial")
+	}
+	return s, nil
+}
+
+func TestSelectCard(t *testing.T) {
+	cards := &mockCards{
+		names:   []string{"reader", "primary", "backup"},
+		serials: map[string]uint32{"primary": 111, "backup": 222},
+	}
+
+	tests := []struct {
+		name    string
+		cards   *mockCards
+		serial  uint32
+		want    string
+		wantErr error
+	}{
+		{"First", cards, 0, "reader", nil},
+		{"Primary", cards, 111, "primary", nil},
+		{"Backup", cards, 222, "backup", nil},
+		{"Absent", cards, 333, "", &CardNotFoundError{}},
+		{"NoCards", &mockCards{}, 0, "", ErrNoCard},
+		{"NoCardsSerial", &mockCards{}, 111, "", ErrNoCard},
+		{"ListError", &mockCards{err: errors.New("pcsc")}, 0, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SelectCard(tt.cards, tt.serial)
+			if tt.want != "" {
+				if err != nil {
+					t.Fatalf("SelectCard failed: %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("expected card %q, got %q", tt.want, got)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected error, got card %q", got)
+			}
+			var notFound *CardNotFoundError
+			if errors.As(tt.wantErr, &notFound) {
+				if !errors.As(err, &notFound) {
+					t.Fatalf("expected CardNotFoundError, got %v", err)
+				}
+				if notFound.Serial != tt.serial || len(notFound.Found) != 2 {
+					t.Errorf("unexpected error %v", err)
+				}
+				if want := "no card with serial 333 detected, found serials [111, 222]"; err.Error() != want {
+					t.Errorf("expected %q, got %q", want, err)
+				}
+			} else if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
@@ -1,11 +1,17 @@
 package identity
 
 import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hkdf"
+	"crypto/sha256"
 	"fmt"
 	"io"
+	"strings"
 	"time"
 
 	"filippo.io/age"
+	"filippo.io/age/plugin"
 )
 
 const (
@@ -15,13 +21,35 @@ const (
 	DefaultFileName = "privage-key.txt"
 	TypePiv         = "PIV"
 	TypeAge         = "AGE"
+
+	// TypePassphrase is an age key encrypted with a passphrase.
+	TypePassphrase = "PASSPHRASE"
+
+	// hybridRecipientPrefix is the prefix of the post-quantum hybrid age
+	// public keys.
+	hybridRecipientPrefix = "age1pq1"
+
+	// signingKeyInfo is the HKDF info of the signing key derived from the age
+	// secret key.
+	signingKeyInfo = "privage manifest signing key"
 )
 
+// A Recipient is an age recipient that can be encoded as a public key: an
+// X25519, a post-quantum hybrid, an SSH ed25519 or an age plugin recipient.
+type Recipient interface {
+	age.Recipient
+	String() string
+}
+
 // An Identity is a wrapper for the age Identity.
 type Identity struct {
 
-	// The age identity
-	Id *age.X25519Identity
+	// The age identity, an X25519, a post-quantum hybrid, an SSH ed25519 or
+	// an age plugin identity.
+	Id age.Identity
+
+	// Recipient is the age recipient of Id.
+	Recipient Recipient
 
 	// Path of the found key.
 	// Path can contain a normal age key or a PIV encrypted one.
@@ -36,6 +64,81 @@ type Identity struct {
 	Err error
 }
 
+// New returns the Identity of the age identity k, found in path. k must be
+// an X25519 or a post-quantum hybrid identity.
+func New(k age.Identity, path string) Identity {
+	switch k := k.(type) {
+	case *age.X25519Identity:
+		return Identity{Id: k, Recipient: k.Recipient(), Path: path}
+	case *age.HybridIdentity:
+		return Identity{Id: k, Recipient: k.Recipient(), Path: path}
+	default:
+		return Identity{Path: path, Err: fmt.Errorf("expected X25519 or hybrid identity, got %T", k)}
+	}
+}
+
+// PostQuantum reports whether the identity is a post-quantum hybrid one.
+func (i Identity) PostQuantum() bool {
+	return i.Recipient != nil && IsPostQuantum(i.Recipient.String())
+}
+
+// Plugin reports whether the identity is an age plugin identity. Each
+// decryption with it executes the plugin binary.
+func (i Identity) Plugin() bool {
+	_, ok := i.Id.(*plugin.Identity)
+	return ok
+}
+
+// Kind returns the kind of the identity: X25519, post-quantum hybrid, SSH
+// ed25519 or the name of the plugin binary. It is empty if the identity was
+// not loaded.
+func (i Identity) Kind() string {
+	if k, ok := i.Id.(*plugin.Identity); ok {
+		return "age-plugin-" + k.Name()
+	}
+
+	switch i.Recipient.(type) {
+	case *age.X25519Recipient:
+		return "X25519"
+	case *age.HybridRecipient:
+		return "post-quantum hybrid"
+	case *sshRecipient:
+		return "SSH ed25519"
+	default:
+		return ""
+	}
+}
+
+// SecretKey returns the age secret key of an X25519 or a post-quantum
+// hybrid identity, f. ex. AGE-SECRET-KEY-1...
+func (i Identity) SecretKey() (string, error) {
+	switch k := i.Id.(type) {
+	case *age.X25519Identity:
+		return k.String(), nil
+	case *age.HybridIdentity:
+		return k.String(), nil
+	default:
+		return "", fmt.Errorf("the %s identity has no age secret key", i.Kind())
+	}
+}
+
+// SigningKey returns the ed25519 key derived from the age secret key of an
+// X25519 or a post-quantum hybrid identity, to sign the manifest of the
+// repository.
+func (i Identity) SigningKey() (ed25519.PrivateKey, error) {
+	secret, err := i.SecretKey()
+	if err != nil {
+		return nil, err
+	}
+
+	seed, err := hkdf.Key(sha256.New, []byte(secret), nil, signingKeyInfo, ed25519.SeedSize)
+	if err != nil {
+		return nil, fmt.Errorf("could not derive signing key: %w", err)
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
 // LoadAge returns an Age identity from an io.Reader.
 // The path parameter is used for error messages and tracking.
 func LoadAge(r io.Reader, path string) Identity {
@@ -43,20 +146,45 @@ func LoadAge(r io.Reader, path string) Identity {
 }
 
 func parseIdentity(f io.Reader, path string) Identity {
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return Identity{Path: path, Err: err}
+	}
 
-	identity := Identity{}
-	identity.Path = path
+	if identity, ok := parsePlugin(data, path); ok {
+		return identity
+	}
 
-	identities, err := age.ParseIdentities(f)
+	identities, err := age.ParseIdentities(bytes.NewReader(data))
 	if err != nil {
-		identity.Err = err
-	} else if id, ok := identities[0].(*age.X25519Identity); ok {
-		identity.Id = id
-	} else {
-		identity.Err = fmt.Errorf("expected X25519Identity, got %T", identities[0])
+		return Identity{Path: path, Err: err}
 	}
 
-	return identity
+	return New(identities[0], path)
+}
+
+// ParseRecipient parses an age public key, X25519, post-quantum hybrid or of
+// an age plugin, or an SSH ed25519 public key in the authorized_keys format.
+//
+// The String method of the returned recipient is the canonical encoding of
+// the key, f. ex. without the comment of an SSH public key.
+func ParseRecipient(s string) (Recipient, error) {
+	switch {
+	case IsPostQuantum(s):
+		return age.ParseHybridRecipient(s)
+	case strings.HasPrefix(s, sshRecipientPrefix):
+		return parseSshRecipient(s)
+	case isPluginRecipient(s):
+		return plugin.NewRecipient(s, PluginUI)
+	default:
+		return age.ParseX25519Recipient(s)
+	}
+}
+
+// IsPostQuantum reports whether the age public key s is a post-quantum
+// hybrid one.
+func IsPostQuantum(s string) bool {
+	return strings.HasPrefix(s, hybridRecipientPrefix)
 }
 
 func FmtType(slot string) string {
@@ -68,25 +196,50 @@ func FmtType(slot string) string {
 	return "🔐 age key"
 }
 
-// GenerateAge generates an age Identity and writes it to the writer.
-func GenerateAge(w io.Writer) error {
-	k, err := age.GenerateX25519Identity()
+// GenerateAge generates an age Identity and writes it to the writer. The
+// identity is a post-quantum hybrid one if pq, otherwise an X25519 one.
+func GenerateAge(w io.Writer, pq bool) error {
+	k, err := generate(pq)
 	if err != nil {
 		return err
 	}
 
+	return WriteAge(w, k)
+}
+
+// WriteAge writes the identity file of the X25519 or post-quantum hybrid
+// identity k to w, with the creation time and the public key as comments.
+func WriteAge(w io.Writer, k Identity) error {
 	if _, err := fmt.Fprintf(w, "# created: %s\n", time.Now().Format(time.RFC3339)); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintf(w, "# public key: %s\n", k.Recipient()); err != nil {
+	if _, err := fmt.Fprintf(w, "# public key: %s\n", k.Recipient); err != nil {
 		return err
 	}
-	if _, err := fmt.Fprintf(w, "%s\n", k); err != nil {
+	if _, err := fmt.Fprintf(w, "%s\n", k.Id); err != nil {
 		return err
 	}
 	return nil
 }
 
+// generate returns a new age identity, a post-quantum hybrid one if pq,
+// otherwise an X25519 one.
+func generate(pq bool) (Identity, error) {
+	if pq {
+		k, err := age.GenerateHybridIdentity()
+		if err != nil {
+			return Identity{}, err
+		}
+		return New(k, ""), nil
+	}
+
+	k, err := age.GenerateX25519Identity()
+	if err != nil {
+		return Identity{}, err
+	}
+	return New(k, ""), nil
+}
+
 // BackupFilePath returns a path for a backup identity file.
 func BackupFilePath(dir string) string {
 
@@ -72,7 +72,7 @@ func TestLoadAge_EmptyReader(t *testing.T) {
 func TestGenerateAge_Success(t *testing.T) {
 	var buf bytes.Buffer
 
-	err := GenerateAge(&buf)
+	err := GenerateAge(&buf, false)
 	if err != nil {
 		t.Fatalf("GenerateAge failed: %v", err)
 	}
@@ -116,12 +116,136 @@ func TestGenerateAge_WriterError(t *testing.T) {
 	// Create a writer that always fails
 	errWriter := &errorWriter{}
 
-	err := GenerateAge(errWriter)
+	err := GenerateAge(errWriter, false)
 	if err == nil {
 		t.Error("Expected error from failing writer, got nil")
 	}
 }
 
+// TestGenerateAge_PostQuantum tests that GenerateAge writes a post-quantum
+// hybrid identity if pq
+func TestGenerateAge_PostQuantum(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GenerateAge(&buf, true); err != nil {
+		t.Fatalf("GenerateAge failed: %v", err)
+	}
+
+	if !strings.Contains(buf.String(), "# public key: age1pq1") {
+		t.Errorf("expected post-quantum public key, got %q", buf.String())
+	}
+
+	result := LoadAge(&buf, "pq.txt")
+	if result.Err != nil {
+		t.Fatalf("Failed to parse generated identity: %v", result.Err)
+	}
+	if _, ok := result.Id.(*age.HybridIdentity); !ok {
+		t.Errorf("expected hybrid identity, got %T", result.Id)
+	}
+	if !result.PostQuantum() {
+		t.Error("expected PostQuantum identity")
+	}
+}
+
+// TestSecretKey_WriteAge tests that the secret key of an identity is
+// written back as the same identity
+func TestSecretKey_WriteAge(t *testing.T) {
+	for _, pq := range []bool{false, true} {
+		k, err := generate(pq)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		secret, err := k.SecretKey()
+		if err != nil {
+			t.Fatalf("SecretKey failed: %v", err)
+		}
+		if !strings.HasPrefix(secret, "AGE-SECRET-KEY-") {
+			t.Errorf("unexpected secret key %q", secret)
+		}
+
+		var buf bytes.Buffer
+		if err := WriteAge(&buf, k); err != nil {
+			t.Fatalf("WriteAge failed: %v", err)
+		}
+		result := LoadAge(&buf, "key.txt")
+		if result.Err != nil {
+			t.Fatalf("LoadAge failed: %v", result.Err)
+		}
+		if got, _ := result.SecretKey(); got != secret {
+			t.Errorf("expected secret key %q, got %q", secret, got)
+		}
+	}
+
+	if _, err := (Identity{}).SecretKey(); err == nil {
+		t.Error("expected error for an identity without secret key")
+	}
+}
+
+func TestSigningKey(t *testing.T) {
+	k, err := generate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := generate(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := k.SigningKey()
+	if err != nil {
+		t.Fatalf("SigningKey failed: %v", err)
+	}
+	again, _ := k.SigningKey()
+	if !key.Equal(again) {
+		t.Error("expected the same signing key for the same identity")
+	}
+	if otherKey, _ := other.SigningKey(); key.Equal(otherKey) {
+		t.Error("expected a different signing key for another identity")
+	}
+
+	if _, err := (Identity{}).SigningKey(); err == nil {
+		t.Error("expected error for an identity without secret key")
+	}
+}
+
+func TestParseRecipient(t *testing.T) {
+	x25519, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hybrid, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		pq      bool
+		wantErr bool
+	}{
+		{"X25519", x25519.Recipient().String(), false, false},
+		{"Hybrid", hybrid.Recipient().String(), true, false},
+		{"Invalid", "age1invalid", false, true},
+		{"InvalidHybrid", "age1pq1invalid", true, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRecipient(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRecipient(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if err == nil && r.String() != tt.key {
+				t.Errorf("ParseRecipient(%q) = %s", tt.key, r)
+			}
+			if got := IsPostQuantum(tt.key); got != tt.pq {
+				t.Errorf("IsPostQuantum(%q) = %v, want %v", tt.key, got, tt.pq)
+			}
+		})
+	}
+}
+
 // errorWriter is an io.Writer that always returns an error
 type errorWriter struct{}
 
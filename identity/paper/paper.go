@@ -0,0 +1,190 @@
+// Package paper implements a printable paper backup of the age secret key,
+// to be typed in, or read with OCR, to restore the key file.
+//
+// The secret key is split in numbered lines of groups of characters, each
+// line with a checksum of its number and characters, so that a typo is
+// detected in its line. A last END line has the number of lines and the
+// checksum of the whole key, to detect missing lines:
+//
+//	# privage paper key
+//	# public key: age1...
+//	01  AGE-S ECRET -KEY- 1QQQQ  7KX2
+//	...
+//	04  QQQQQ QQQQQ QQQQ  M3DA
+//	END 04  9GQ4Z3W
+//
+// The checksums use the upper case Bech32 characters of the age keys: digits
+// and letters without B, I and O, that are easy to confuse.
+package paper
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	// header is the first line of a paper key.
+	header = "# privage paper key"
+
+	// groupSize and lineGroups are the characters of a group and the groups
+	// of a line of the key.
+	groupSize  = 5
+	lineGroups = 4
+
+	// endPrefix starts the END line.
+	endPrefix = "END"
+
+	// secretKeyPrefix is the start of the age secret keys.
+	secretKeyPrefix = "AGE-SECRET-KEY-"
+)
+
+// charset is the upper case Bech32 character set of the checksums.
+const charset = "QPZRY9X8GF2TVDW0S3JN54KHCE6MUA7L"
+
+// ErrChecksum is returned for a line whose checksum does not match.
+var ErrChecksum = errors.New("the checksum does not match, check the line for a typo")
+
+// LineError is an error in a line of a paper key.
+type LineError struct {
+	// Line is the line number, from 1, of the input.
+	Line int
+	// Text is the text of the line.
+	Text string
+	// Err is the error.
+	Err error
+}
+
+func (e *LineError) Error() string {
+	return fmt.Sprintf("line %d %q: %v", e.Line, e.Text, e.Err)
+}
+
+func (e *LineError) Unwrap() error {
+	return e.Err
+}
+
+// Write writes the paper key of the age secret key to w, with the public
+// key in a comment.
+func Write(w io.Writer, secretKey, publicKey string) error {
+	var b strings.Builder
+	b.WriteString(header + "\n")
+	fmt.Fprintf(&b, "# public key: %s\n", publicKey)
+	b.WriteString("# Restore it with \"privage key import --paper\", typing the lines below.\n")
+
+	lineSize := groupSize * lineGroups
+	n := 0
+	for i := 0; i < len(secretKey); i += lineSize {
+		n++
+		chunk := secretKey[i:min(i+lineSize, len(secretKey))]
+
+		var groups []string
+		for j := 0; j < len(chunk); j += groupSize {
+			groups = append(groups, chunk[j:min(j+groupSize, len(chunk))])
+		}
+		fmt.Fprintf(&b, "%02d  %s  %s\n", n, strings.Join(groups, " "), checksum(fmt.Sprintf("%02d%s", n, chunk), 4))
+	}
+	fmt.Fprintf(&b, "%s %02d  %s\n", endPrefix, n, checksum(secretKey, 7))
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Read reads a paper key from r and returns the age secret key. The lines
+// can be in any case, with any spacing, and the letter O is read as the
+// digit 0. Comment and empty lines are ignored. A line with an age secret
+// key, f. ex. scanned from the QR code, is the whole key. Reading stops at
+// the END line.
+//
+// An error in a line is returned as a *LineError, with ErrChecksum if the
+// line has a typo.
+func Read(r io.Reader) (string, error) {
+	var chunks []string
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		text := scanner.Text()
+		line := normalize(text)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, secretKeyPrefix) && !strings.ContainsRune(line, ' ') {
+			return line, nil
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return "", &LineError{Line: lineNum, Text: text, Err: errors.New("expected the line number, the key characters and the checksum")}
+		}
+
+		if fields[0] == endPrefix {
+			key := strings.Join(chunks, "")
+			if len(fields) != 3 || fields[1] != fmt.Sprintf("%02d", len(chunks)) {
+				return "", &LineError{Line: lineNum, Text: text, Err: fmt.Errorf("found %d key lines, expected %s", len(chunks), fields[1])}
+			}
+			if fields[2] != checksum(key, 7) {
+				return "", &LineError{Line: lineNum, Text: text, Err: errors.New("the checksum of the key does not match, a line is missing or wrong")}
+			}
+			return key, nil
+		}
+
+		if len(fields) < 3 {
+			return "", &LineError{Line: lineNum, Text: text, Err: errors.New("expected the line number, the key characters and the checksum")}
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return "", &LineError{Line: lineNum, Text: text, Err: fmt.Errorf("invalid line number %q", fields[0])}
+		}
+		if n != len(chunks)+1 {
+			return "", &LineError{Line: lineNum, Text: text, Err: fmt.Errorf("found key line %d, expected %d", n, len(chunks)+1)}
+		}
+
+		chunk := strings.Join(fields[1:len(fields)-1], "")
+		if fields[len(fields)-1] != checksum(fmt.Sprintf("%02d%s", n, chunk), 4) {
+			return "", &LineError{Line: lineNum, Text: text, Err: ErrChecksum}
+		}
+		chunks = append(chunks, chunk)
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("could not read the paper key: %w", err)
+	}
+
+	return "", fmt.Errorf("found no %s line after %d key lines", endPrefix, len(chunks))
+}
+
+// WriteQR writes the QR code of the age secret key to w, for a terminal or,
+// with ascii, for printing.
+func WriteQR(w io.Writer, secretKey string, ascii bool) error {
+	q, err := newQR(secretKey)
+	if err != nil {
+		return err
+	}
+
+	return q.render(w, ascii)
+}
+
+// normalize returns the line in upper case, without leading and trailing
+// spaces, and with the letter O, not in the checksum characters, as 0.
+func normalize(line string) string {
+	line = strings.ToUpper(strings.TrimSpace(line))
+	if strings.HasPrefix(line, "#") {
+		return line
+	}
+	return strings.ReplaceAll(line, "O", "0")
+}
+
+// checksum returns the CRC-32 of s as n characters of the checksum set.
+func checksum(s string, n int) string {
+	sum := crc32.ChecksumIEEE([]byte(s))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = charset[sum%32]
+		sum /= 32
+	}
+	return string(b)
+}
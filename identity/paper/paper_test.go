@@ -0,0 +1,127 @@
+package paper
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const (
+	testSecretKey = "AGE-SECRET-KEY-1GQ9778VQXMMJVE8SK7J6VT8UJ4HDQAJUVSFCWCM02D8GEWQ72PVQ2Y5J33"
+	testPublicKey = "age1lvyvwawkr0mcnnnncaghunadrqkmuf9e6507x9y920xxpp866cnql7dp2z"
+)
+
+// paperLines returns the key lines of the paper key of testSecretKey.
+func paperLines(t *testing.T) []string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, testSecretKey, testPublicKey); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	var lines []string
+	for _, l := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	return lines
+}
+
+func TestWrite(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Write(&buf, testSecretKey, testPublicKey); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	for _, want := range []string{
+		header + "\n",
+		"# public key: " + testPublicKey + "\n",
+		"\n01  AGE-S ECRET -KEY- 1GQ97  ",
+		"\n02  78VQX MMJVE 8SK7J 6VT8U  ",
+		"\n03  J4HDQ AJUVS FCWCM 02D8G  ",
+		"\n04  EWQ72 PVQ2Y 5J33  ",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in paper key:\n%s", want, buf.String())
+		}
+	}
+
+	lines := paperLines(t)
+	if len(lines) != 5 || !strings.HasPrefix(lines[4], "END 04  ") {
+		t.Errorf("expected 4 key lines and the END line, got %q", lines)
+	}
+}
+
+func TestRead(t *testing.T) {
+	lines := paperLines(t)
+
+	typo := strings.Replace(lines[1], "Q", "P", 1)
+	if typo == lines[1] {
+		t.Fatal("could not introduce a typo")
+	}
+
+	tests := []struct {
+		name     string
+		input    string
+		wantLine int
+		wantErr  error
+	}{
+		{"Exact", strings.Join(lines, "\n"), 0, nil},
+		{"Sloppy", "# comment\n\n" + strings.ToLower(strings.ReplaceAll(strings.Join(lines, "\n"), " ", "   ")) + "\nignored", 0, nil},
+		{"OCR", strings.ReplaceAll(strings.Join(lines, "\n"), "0", "O"), 0, nil},
+		{"QR", testSecretKey + "\n", 0, nil},
+		{"Typo", strings.Join([]string{lines[0], typo, lines[2], lines[3], lines[4]}, "\n"), 2, ErrChecksum},
+		{"Missing", strings.Join([]string{lines[0], lines[2], lines[3], lines[4]}, "\n"), 2, nil},
+		{"MissingLast", strings.Join([]string{lines[0], lines[1], lines[2], lines[4]}, "\n"), 4, nil},
+		{"NoEnd", strings.Join(lines[:4], "\n"), 0, nil},
+		{"Garbage", "hello", 1, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := Read(strings.NewReader(tt.input))
+
+			wantOK := tt.wantLine == 0 && tt.name != "NoEnd"
+			if wantOK {
+				if err != nil {
+					t.Fatalf("Read failed: %v", err)
+				}
+				if key != testSecretKey {
+					t.Errorf("expected %q, got %q", testSecretKey, key)
+				}
+				return
+			}
+
+			if err == nil {
+				t.Fatalf("expected error, got key %q", key)
+			}
+			if tt.wantLine == 0 {
+				return
+			}
+			var lineErr *LineError
+			if !errors.As(err, &lineErr) || lineErr.Line != tt.wantLine {
+				t.Fatalf("expected error in line %d, got %v", tt.wantLine, err)
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("expected %v, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	sum := checksum("01AGE-SECRET-KEY-1GQ97", 4)
+	if len(sum) != 4 {
+		t.Fatalf("expected 4 characters, got %q", sum)
+	}
+	for _, c := range sum {
+		if !strings.ContainsRune(charset, c) || strings.ContainsRune("BIO", c) {
+			t.Errorf("unexpected checksum character %q", c)
+		}
+	}
+	if checksum("02AGE-SECRET-KEY-1GQ97", 4) == sum {
+		t.Errorf("expected the line number to change the checksum")
+	}
+}
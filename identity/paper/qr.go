@@ -0,0 +1,470 @@
+package paper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// This file implements a minimal QR code encoder for the age secret keys:
+// alphanumeric mode, error correction level M, versions 1 to 6, enough for
+// 154 characters. The structure follows ISO/IEC 18004.
+
+// qrAlphanumeric is the character set of the alphanumeric mode, the index
+// is the value of a character.
+const qrAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ $%*+-./:"
+
+// qrVersion is the error correction level M structure of a version.
+type qrVersion struct {
+	// blocks is the number of error correction blocks.
+	blocks int
+	// data is the number of data codewords per block.
+	data int
+	// ec is the number of error correction codewords per block.
+	ec int
+	// align is the position of the bottom right alignment pattern, 0 if
+	// none.
+	align int
+}
+
+// qrVersions are the versions 1 to 6 with error correction level M.
+var qrVersions = []qrVersion{
+	{blocks: 1, data: 16, ec: 10},
+	{blocks: 1, data: 28, ec: 16, align: 18},
+	{blocks: 1, data: 44, ec: 26, align: 22},
+	{blocks: 2, data: 32, ec: 18, align: 26},
+	{blocks: 2, data: 43, ec: 24, align: 30},
+	{blocks: 4, data: 27, ec: 16, align: 34},
+}
+
+// qrCode is a QR code symbol. The modules are indexed by row and column,
+// true is dark.
+type qrCode struct {
+	size     int
+	modules  [][]bool
+	function [][]bool
+}
+
+// newQR returns the QR code of text, in the smallest version that holds it.
+// text must contain only characters of the alphanumeric mode.
+func newQR(text string) (*qrCode, error) {
+	for _, c := range text {
+		if !strings.ContainsRune(qrAlphanumeric, c) {
+			return nil, fmt.Errorf("can not encode %q in a QR code", c)
+		}
+	}
+
+	for i, v := range qrVersions {
+		bits := qrData(text, v.blocks*v.data)
+		if bits == nil {
+			continue
+		}
+
+		q := &qrCode{size: 17 + 4*(i+1)}
+		q.modules = make([][]bool, q.size)
+		q.function = make([][]bool, q.size)
+		for r := range q.size {
+			q.modules[r] = make([]bool, q.size)
+			q.function[r] = make([]bool, q.size)
+		}
+
+		q.drawFunctionPatterns(v)
+		q.drawCodewords(qrInterleave(bits, v))
+		q.applyBestMask()
+		return q, nil
+	}
+
+	return nil, errors.New("text too long for a QR code")
+}
+
+// qrData returns the data codewords of text in the alphanumeric mode, padded
+// to capacity codewords, or nil if text does not fit.
+func qrData(text string, capacity int) []byte {
+	var bb bitBuffer
+	bb.append(0b0010, 4)
+	bb.append(uint32(len(text)), 9)
+	for i := 0; i+1 < len(text); i += 2 {
+		v := strings.IndexByte(qrAlphanumeric, text[i])*45 + strings.IndexByte(qrAlphanumeric, text[i+1])
+		bb.append(uint32(v), 11)
+	}
+	if len(text)%2 == 1 {
+		bb.append(uint32(strings.IndexByte(qrAlphanumeric, text[len(text)-1])), 6)
+	}
+
+	capacityBits := capacity * 8
+	if len(bb) > capacityBits {
+		return nil
+	}
+
+	// Terminator and padding to a byte
+	bb.append(0, min(4, capacityBits-len(bb)))
+	bb.append(0, (8-len(bb)%8)%8)
+
+	data := bb.bytes()
+	for pad := byte(0xec); len(data) < capacity; pad ^= 0xec ^ 0x11 {
+		data = append(data, pad)
+	}
+
+	return data
+}
+
+// qrInterleave splits the data codewords in blocks, adds the error
+// correction codewords of each block, and interleaves them.
+func qrInterleave(data []byte, v qrVersion) []byte {
+	gen := rsGenerator(v.ec)
+	blocks := make([][]byte, v.blocks)
+	ecs := make([][]byte, v.blocks)
+	for i := range blocks {
+		blocks[i] = data[i*v.data : (i+1)*v.data]
+		ecs[i] = rsRemainder(blocks[i], gen)
+	}
+
+	result := make([]byte, 0, v.blocks*(v.data+v.ec))
+	for i := range v.data {
+		for _, b := range blocks {
+			result = append(result, b[i])
+		}
+	}
+	for i := range v.ec {
+		for _, ec := range ecs {
+			result = append(result, ec[i])
+		}
+	}
+
+	return result
+}
+
+func (q *qrCode) set(row, col int, dark bool) {
+	q.modules[row][col] = dark
+	q.function[row][col] = true
+}
+
+// drawFunctionPatterns draws the finder, timing and alignment patterns, and
+// reserves the format information modules.
+func (q *qrCode) drawFunctionPatterns(v qrVersion) {
+	for i := range q.size {
+		q.set(6, i, i%2 == 0)
+		q.set(i, 6, i%2 == 0)
+	}
+
+	for _, c := range [][2]int{{3, 3}, {3, q.size - 4}, {q.size - 4, 3}} {
+		for dr := -4; dr <= 4; dr++ {
+			for dc := -4; dc <= 4; dc++ {
+				r, col := c[0]+dr, c[1]+dc
+				if r < 0 || r >= q.size || col < 0 || col >= q.size {
+					continue
+				}
+				dist := max(abs(dr), abs(dc))
+				q.set(r, col, dist != 2 && dist != 4)
+			}
+		}
+	}
+
+	if v.align > 0 {
+		for dr := -2; dr <= 2; dr++ {
+			for dc := -2; dc <= 2; dc++ {
+				q.set(v.align+dr, v.align+dc, max(abs(dr), abs(dc)) != 1)
+			}
+		}
+	}
+
+	q.drawFormat(0)
+}
+
+// drawFormat draws the two copies of the format information of the mask.
+func (q *qrCode) drawFormat(mask int) {
+	bits := qrFormatBits(mask)
+	bit := func(i int) bool { return bits>>i&1 != 0 }
+
+	for i := 0; i <= 5; i++ {
+		q.set(i, 8, bit(i))
+	}
+	q.set(7, 8, bit(6))
+	q.set(8, 8, bit(7))
+	q.set(8, 7, bit(8))
+	for i := 9; i < 15; i++ {
+		q.set(8, 14-i, bit(i))
+	}
+
+	for i := range 8 {
+		q.set(8, q.size-1-i, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.set(q.size-15+i, 8, bit(i))
+	}
+	q.set(q.size-8, 8, true)
+}
+
+// qrFormatBits returns the 15 format information bits of error correction
+// level M and the mask, with their BCH code.
+func qrFormatBits(mask int) uint32 {
+	// Level M is 00
+	data := uint32(mask)
+	rem := data
+	for range 10 {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	return (data<<10 | rem) ^ 0x5412
+}
+
+// drawCodewords places the codewords in the zigzag order, from the bottom
+// right corner, in two columns wide strips.
+func (q *qrCode) drawCodewords(data []byte) {
+	i := 0
+	for right := q.size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := range q.size {
+			row := vert
+			if upward {
+				row = q.size - 1 - vert
+			}
+			for j := range 2 {
+				col := right - j
+				if q.function[row][col] || i >= len(data)*8 {
+					continue
+				}
+				q.modules[row][col] = data[i>>3]>>(7-i&7)&1 != 0
+				i++
+			}
+		}
+	}
+}
+
+// qrMask reports whether the mask inverts the module.
+func qrMask(mask, row, col int) bool {
+	switch mask {
+	case 0:
+		return (row+col)%2 == 0
+	case 1:
+		return row%2 == 0
+	case 2:
+		return col%3 == 0
+	case 3:
+		return (row+col)%3 == 0
+	case 4:
+		return (row/2+col/3)%2 == 0
+	case 5:
+		return row*col%2+row*col%3 == 0
+	case 6:
+		return (row*col%2+row*col%3)%2 == 0
+	default:
+		return ((row+col)%2+row*col%3)%2 == 0
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for r := range q.size {
+		for c := range q.size {
+			if !q.function[r][c] && qrMask(mask, r, c) {
+				q.modules[r][c] = !q.modules[r][c]
+			}
+		}
+	}
+}
+
+// applyBestMask applies the mask with the lowest penalty.
+func (q *qrCode) applyBestMask() {
+	best, bestPenalty := 0, -1
+	for mask := range 8 {
+		q.applyMask(mask)
+		q.drawFormat(mask)
+		if p := q.penalty(); bestPenalty < 0 || p < bestPenalty {
+			best, bestPenalty = mask, p
+		}
+		// The mask is its own inverse
+		q.applyMask(mask)
+	}
+
+	q.applyMask(best)
+	q.drawFormat(best)
+}
+
+// penalty returns the penalty score of the symbol, of the rules of the
+// mask evaluation: runs, 2x2 blocks, finder like patterns and dark balance.
+func (q *qrCode) penalty() int {
+	penalty := 0
+	finder := []bool{true, false, true, true, true, false, true}
+
+	for _, transpose := range []bool{false, true} {
+		at := func(i, j int) bool {
+			if transpose {
+				return q.modules[j][i]
+			}
+			return q.modules[i][j]
+		}
+		light := func(i, from, to int) bool {
+			for j := from; j < to; j++ {
+				if j >= 0 && j < q.size && at(i, j) {
+					return false
+				}
+			}
+			return true
+		}
+
+		for i := range q.size {
+			run := 1
+			for j := 1; j <= q.size; j++ {
+				if j < q.size && at(i, j) == at(i, j-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += run - 2
+				}
+				run = 1
+			}
+
+			for j := 0; j+len(finder) <= q.size; j++ {
+				match := true
+				for k, dark := range finder {
+					if at(i, j+k) != dark {
+						match = false
+						break
+					}
+				}
+				if match && (light(i, j-4, j) || light(i, j+7, j+11)) {
+					penalty += 40
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for r := range q.size {
+		for c := range q.size {
+			if q.modules[r][c] {
+				dark++
+			}
+			if r > 0 && c > 0 {
+				m := q.modules[r][c]
+				if q.modules[r-1][c] == m && q.modules[r][c-1] == m && q.modules[r-1][c-1] == m {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := q.size * q.size
+	penalty += abs(dark*20-total*10) / total * 10
+
+	return penalty
+}
+
+// qrQuietZone is the width of the light border around the symbol.
+const qrQuietZone = 4
+
+// render writes the QR code to w. With ascii, the dark modules are two
+// number signs, for printing on paper. Otherwise the symbol is drawn with
+// UTF-8 half blocks for a terminal with a dark background: the blocks are
+// the light modules, and each line holds two rows.
+func (q *qrCode) render(w io.Writer, ascii bool) error {
+	dark := func(r, c int) bool {
+		r, c = r-qrQuietZone, c-qrQuietZone
+		return r >= 0 && r < q.size && c >= 0 && c < q.size && q.modules[r][c]
+	}
+	size := q.size + 2*qrQuietZone
+
+	var b strings.Builder
+	if ascii {
+		for r := range size {
+			for c := range size {
+				if dark(r, c) {
+					b.WriteString("##")
+				} else {
+					b.WriteString("  ")
+				}
+			}
+			b.WriteString("\n")
+		}
+	} else {
+		for r := 0; r < size; r += 2 {
+			for c := range size {
+				top, bottom := !dark(r, c), r+1 < size && !dark(r+1, c)
+				switch {
+				case top && bottom:
+					b.WriteString("█")
+				case top:
+					b.WriteString("▀")
+				case bottom:
+					b.WriteString("▄")
+				default:
+					b.WriteString(" ")
+				}
+			}
+			b.WriteString("\n")
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// rsGenerator returns the Reed-Solomon generator polynomial of degree n,
+// without the leading coefficient, highest degree first.
+func rsGenerator(n int) []byte {
+	gen := make([]byte, n)
+	gen[n-1] = 1
+	root := byte(1)
+	for range n {
+		for j := range gen {
+			gen[j] = rsMul(gen[j], root)
+			if j+1 < n {
+				gen[j] ^= gen[j+1]
+			}
+		}
+		root = rsMul(root, 0x02)
+	}
+	return gen
+}
+
+// rsRemainder returns the Reed-Solomon error correction codewords of data.
+func rsRemainder(data, gen []byte) []byte {
+	rem := make([]byte, len(gen))
+	for _, b := range data {
+		factor := b ^ rem[0]
+		copy(rem, rem[1:])
+		rem[len(rem)-1] = 0
+		for i, g := range gen {
+			rem[i] ^= rsMul(g, factor)
+		}
+	}
+	return rem
+}
+
+// rsMul multiplies in GF(2^8) with the QR polynomial x^8 + x^4 + x^3 + x^2
+// + 1.
+func rsMul(x, y byte) byte {
+	var z byte
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x1d
+		z ^= (y >> i & 1) * x
+	}
+	return z
+}
+
+// bitBuffer is a sequence of bits, one per byte.
+type bitBuffer []byte
+
+func (bb *bitBuffer) append(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		*bb = append(*bb, byte(v>>i&1))
+	}
+}
+
+func (bb bitBuffer) bytes() []byte {
+	result := make([]byte, len(bb)/8)
+	for i, bit := range bb {
+		result[i/8] |= bit << (7 - i%8)
+	}
+	return result
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
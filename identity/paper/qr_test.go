@@ -0,0 +1,167 @@
+package paper
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// TestRSRemainder tests the error correction codewords of the example of
+// ISO/IEC 18004, 01234567 in version 1-M.
+func TestRSRemainder(t *testing.T) {
+	data := []byte{0x10, 0x20, 0x0c, 0x56, 0x61, 0x80, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11, 0xec, 0x11}
+	want := []byte{0xa5, 0x24, 0xd4, 0xc1, 0xed, 0x36, 0xc7, 0x87, 0x2c, 0x55}
+
+	if got := rsRemainder(data, rsGenerator(10)); !bytes.Equal(got, want) {
+		t.Errorf("expected %x, got %x", want, got)
+	}
+}
+
+// TestQRData tests the alphanumeric encoding of the example of ISO/IEC
+// 18004, AC-42.
+func TestQRData(t *testing.T) {
+	data := qrData("AC-42", 16)
+
+	// 0010 000000101 00111001110 11100111001 000010, terminator and padding
+	want := []byte{0b00100000, 0b00101001, 0b11001110, 0b11100111, 0b00100001, 0b00000000, 0xec, 0x11}
+	if !bytes.Equal(data[:len(want)], want) || len(data) != 16 {
+		t.Errorf("expected %08b..., got %08b", want, data)
+	}
+
+	if qrData(strings.Repeat("A", 30), 16) != nil {
+		t.Errorf("expected nil for a text longer than the capacity")
+	}
+}
+
+func TestQRFormatBits(t *testing.T) {
+	// Level M and mask 0, from the format information table of the
+	// standard
+	if got := qrFormatBits(0); got != 0b101010000010010 {
+		t.Errorf("expected 101010000010010, got %015b", got)
+	}
+}
+
+// TestQR_Decode tests that the symbol of a secret key decodes back to it:
+// the format information, the unmasked codewords, their error correction
+// and the alphanumeric data.
+func TestQR_Decode(t *testing.T) {
+	for _, text := range []string{"AC-42", testSecretKey, "AGE-SECRET-KEY-PQ-14CTD4NZ7ZWXG34YQ5R2359Z83RVF9X349P3DFP3C5E3MNEDPYZ9Q6H7ZEE"} {
+		q, err := newQR(text)
+		if err != nil {
+			t.Fatalf("newQR failed: %v", err)
+		}
+		version := (q.size - 17) / 4
+		v := qrVersions[version-1]
+
+		// Finder pattern of the top left corner
+		for i := range 7 {
+			if !q.modules[0][i] || !q.modules[i][0] || q.modules[1][1+i%5] {
+				t.Fatalf("%s: invalid finder pattern", text)
+			}
+		}
+		if !q.modules[q.size-8][8] {
+			t.Errorf("%s: expected the dark module", text)
+		}
+
+		// Both copies of the format information
+		var first, second uint32
+		for i := 0; i <= 5; i++ {
+			first |= b2u(q.modules[i][8]) << i
+		}
+		first |= b2u(q.modules[7][8])<<6 | b2u(q.modules[8][8])<<7 | b2u(q.modules[8][7])<<8
+		for i := 9; i < 15; i++ {
+			first |= b2u(q.modules[8][14-i]) << i
+		}
+		for i := range 8 {
+			second |= b2u(q.modules[8][q.size-1-i]) << i
+		}
+		for i := 8; i < 15; i++ {
+			second |= b2u(q.modules[q.size-15+i][8]) << i
+		}
+		mask := -1
+		for m := range 8 {
+			if qrFormatBits(m) == first {
+				mask = m
+			}
+		}
+		if mask < 0 || first != second {
+			t.Fatalf("%s: invalid format information %015b, %015b", text, first, second)
+		}
+
+		// Unmask and read the codewords
+		q.applyMask(mask)
+		var bb bitBuffer
+		for right := q.size - 1; right >= 1; right -= 2 {
+			if right == 6 {
+				right = 5
+			}
+			for vert := range q.size {
+				row := vert
+				if (right+1)&2 == 0 {
+					row = q.size - 1 - vert
+				}
+				for j := range 2 {
+					if !q.function[row][right-j] {
+						bb = append(bb, byte(b2u(q.modules[row][right-j])))
+					}
+				}
+			}
+		}
+		codewords := bb[:len(bb)/8*8].bytes()
+
+		// Deinterleave and check the error correction
+		var data []byte
+		for b := range v.blocks {
+			var block, ec []byte
+			for i := range v.data {
+				block = append(block, codewords[i*v.blocks+b])
+			}
+			for i := range v.ec {
+				ec = append(ec, codewords[v.blocks*v.data+i*v.blocks+b])
+			}
+			if !bytes.Equal(rsRemainder(block, rsGenerator(v.ec)), ec) {
+				t.Fatalf("%s: invalid error correction of block %d", text, b)
+			}
+			data = append(data, block...)
+		}
+
+		if !bytes.Equal(data, qrData(text, v.blocks*v.data)) {
+			t.Errorf("%s: expected the data codewords of the text", text)
+		}
+	}
+}
+
+func TestWriteQR(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteQR(&buf, testSecretKey, true); err != nil {
+		t.Fatalf("WriteQR failed: %v", err)
+	}
+
+	// Version 4, 33 modules, and the quiet zone
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 33+2*qrQuietZone || len(lines[0]) != 2*len(lines) {
+		t.Errorf("unexpected ascii QR code size %d x %d", len(lines), len(lines[0]))
+	}
+	if !strings.HasPrefix(lines[qrQuietZone], strings.Repeat("  ", qrQuietZone)+strings.Repeat("##", 7)+"  ") {
+		t.Errorf("expected finder pattern, got %q", lines[qrQuietZone])
+	}
+
+	buf.Reset()
+	if err := WriteQR(&buf, testSecretKey, false); err != nil {
+		t.Fatalf("WriteQR failed: %v", err)
+	}
+	if !strings.Contains(buf.String(), "█") {
+		t.Errorf("expected UTF-8 blocks, got %q", buf.String())
+	}
+
+	if err := WriteQR(&buf, "lower case", false); err == nil {
+		t.Errorf("expected error for characters out of the alphanumeric mode")
+	}
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
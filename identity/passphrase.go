@@ -0,0 +1,114 @@
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// ScryptWorkFactor is the log2 of the scrypt work factor of the passphrase
+// protected identity files. Lower values are only meant for tests.
+var ScryptWorkFactor = 18
+
+// ageHeader is the start of the binary age format.
+const ageHeader = "age-encryption.org/"
+
+// ErrPassphrase is returned when the passphrase of an identity file is
+// wrong.
+var ErrPassphrase = errors.New("incorrect passphrase")
+
+// GeneratePassphrase generates an age Identity and writes it to w,
+// encrypted with an age scrypt recipient for the passphrase. The identity
+// is a post-quantum hybrid one if pq, otherwise an X25519 one.
+func GeneratePassphrase(w io.Writer, passphrase string, pq bool) error {
+	buf := new(bytes.Buffer)
+	if err := GenerateAge(buf, pq); err != nil {
+		return err
+	}
+
+	return EncryptPassphrase(w, buf.Bytes(), passphrase)
+}
+
+// EncryptPassphrase writes the identity file contents key to w, encrypted
+// with an age scrypt recipient for the passphrase, in the armored age
+// format.
+func EncryptPassphrase(w io.Writer, key []byte, passphrase string) (err error) {
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return fmt.Errorf("could not create scrypt recipient: %w", err)
+	}
+	r.SetWorkFactor(ScryptWorkFactor)
+
+	aw := armor.NewWriter(w)
+	defer func() {
+		if cerr := aw.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	ew, err := age.Encrypt(aw, r)
+	if err != nil {
+		return fmt.Errorf("could not encrypt key file: %w", err)
+	}
+
+	if _, err := ew.Write(key); err != nil {
+		return errors.Join(fmt.Errorf("could not encrypt key file: %w", err), ew.Close())
+	}
+
+	return ew.Close()
+}
+
+// LoadPassphrase returns the age identity read from r that is encrypted
+// with the passphrase. The path parameter is used for error messages and
+// tracking.
+func LoadPassphrase(r io.Reader, path string, passphrase string) Identity {
+	raw, err := DecryptPassphrase(r, passphrase)
+	if err != nil {
+		return Identity{Path: path, Err: err}
+	}
+
+	return parseIdentity(bytes.NewReader(raw), path)
+}
+
+// DecryptPassphrase returns the identity file contents read from r, that
+// are encrypted with the passphrase. It returns ErrPassphrase if the
+// passphrase is wrong.
+func DecryptPassphrase(r io.Reader, passphrase string) ([]byte, error) {
+	si, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("could not create scrypt identity: %w", err)
+	}
+
+	br := bufio.NewReader(r)
+	var src io.Reader = br
+	if start, _ := br.Peek(len(armor.Header)); string(start) == armor.Header {
+		src = armor.NewReader(br)
+	}
+
+	d, err := age.Decrypt(src, si)
+	if err != nil {
+		var e *age.NoIdentityMatchError
+		if errors.As(err, &e) {
+			return nil, ErrPassphrase
+		}
+		return nil, fmt.Errorf("could not decrypt key file: %w", err)
+	}
+
+	raw, err := io.ReadAll(d)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt key file: %w", err)
+	}
+
+	return raw, nil
+}
+
+// IsPassphrase reports whether the identity file starting with b is
+// encrypted with a passphrase.
+func IsPassphrase(b []byte) bool {
+	return bytes.HasPrefix(b, []byte(armor.Header)) || bytes.HasPrefix(b, []byte(ageHeader))
+}
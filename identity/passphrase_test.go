@@ -0,0 +1,101 @@
+package identity
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"filippo.io/age/armor"
+)
+
+func init() {
+	// Keep the scrypt work factor low in tests
+	ScryptWorkFactor = 10
+}
+
+// TestGeneratePassphrase_RoundTrip tests that a generated passphrase
+// protected key loads with the passphrase
+func TestGeneratePassphrase_RoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := GeneratePassphrase(&buf, "correct horse", false); err != nil {
+		t.Fatalf("GeneratePassphrase failed: %v", err)
+	}
+
+	if !strings.HasPrefix(buf.String(), armor.Header) {
+		t.Errorf("expected armored key file, got %q", buf.String())
+	}
+	if !IsPassphrase(buf.Bytes()) {
+		t.Errorf("expected IsPassphrase to detect the key file")
+	}
+	if strings.Contains(buf.String(), "AGE-SECRET-KEY") {
+		t.Errorf("expected secret key not to be in plain text")
+	}
+
+	result := LoadPassphrase(bytes.NewReader(buf.Bytes()), "key.txt", "correct horse")
+	if result.Err != nil {
+		t.Fatalf("LoadPassphrase failed: %v", result.Err)
+	}
+	if result.Id == nil || result.Path != "key.txt" {
+		t.Errorf("unexpected identity %+v", result)
+	}
+
+	result = LoadPassphrase(bytes.NewReader(buf.Bytes()), "key.txt", "wrong")
+	if !errors.Is(result.Err, ErrPassphrase) {
+		t.Errorf("expected ErrPassphrase, got %v", result.Err)
+	}
+	if result.Id != nil || result.Path != "key.txt" {
+		t.Errorf("unexpected identity %+v", result)
+	}
+}
+
+// TestDecryptPassphrase_KeepsContents tests that the key file contents,
+// comments included, survive a change of passphrase
+func TestDecryptPassphrase_KeepsContents(t *testing.T) {
+	var plain bytes.Buffer
+	if err := GenerateAge(&plain, false); err != nil {
+		t.Fatal(err)
+	}
+
+	var first, second bytes.Buffer
+	if err := EncryptPassphrase(&first, plain.Bytes(), "one"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err := DecryptPassphrase(&first, "one")
+	if err != nil {
+		t.Fatalf("DecryptPassphrase failed: %v", err)
+	}
+	if err := EncryptPassphrase(&second, raw, "two"); err != nil {
+		t.Fatal(err)
+	}
+	raw, err = DecryptPassphrase(&second, "two")
+	if err != nil {
+		t.Fatalf("DecryptPassphrase failed: %v", err)
+	}
+
+	if !bytes.Equal(raw, plain.Bytes()) {
+		t.Errorf("expected %q, got %q", plain.String(), raw)
+	}
+}
+
+func TestIsPassphrase(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"Armored", armor.Header + "\n", true},
+		{"Binary", "age-encryption.org/v1\n-> scrypt", true},
+		{"Plain", "# created: 2024-01-01\nAGE-SECRET-KEY-1", false},
+		{"PIV", "<~9jqo^BlbD-BleB1DJ+*~>", false},
+		{"Empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsPassphrase([]byte(tt.data)); got != tt.want {
+				t.Errorf("IsPassphrase(%q) = %v, want %v", tt.data, got, tt.want)
+			}
+		})
+	}
+}
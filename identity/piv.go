@@ -1,80 +1,332 @@
 package identity
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"encoding/ascii85"
 
 	"filippo.io/age"
 )
 
+var (
+	// ErrPinBlocked is returned when the PIN of the PIV device is blocked,
+	// after too many wrong attempts.
+	ErrPinBlocked = errors.New("the PIN of the PIV device is blocked, unblock it with the PUK")
+
+	// ErrTouchTimeout is returned when the PIV device was not touched in
+	// time for an operation with a key that requires touch.
+	ErrTouchTimeout = errors.New("timed out waiting for the PIV device to be touched")
+)
+
+// PinError is returned when the PIN of the PIV device is wrong.
+type PinError struct {
+	// Retries is the number of PIN attempts left before the PIN is blocked.
+	Retries int
+}
+
+func (e *PinError) Error() string {
+	return fmt.Sprintf("incorrect PIN of the PIV device, %d retries left", e.Retries)
+}
+
+// Auth holds the functions called by a PIV device when the key of a slot
+// requires the user: a PIN or a touch of the device.
+type Auth struct {
+	// PIN returns the PIN of the device. It is called only if the PIN
+	// policy of the key requires it. If nil, operations that need a PIN
+	// fail.
+	PIN func() (string, error)
+
+	// Touch is called before an operation with a key that requires the
+	// device to be touched, so that the user can be told. It may be nil.
+	Touch func()
+}
+
 // Device represents a PIV-compatible hardware device that can perform
 // cryptographic operations like decryption.
 type Device interface {
-	// Decrypt decrypts ciphertext using the key in the specified slot.
-	Decrypt(ciphertext []byte, slot uint32) ([]byte, error)
+	// Decrypt decrypts ciphertext using the key in the specified slot. The
+	// auth functions are called if the key requires a PIN or a touch. A
+	// wrong PIN is reported as a *PinError, a blocked PIN as ErrPinBlocked
+	// and a missing touch as ErrTouchTimeout.
+	Decrypt(ciphertext []byte, slot uint32, auth Auth) ([]byte, error)
 	// Encrypt encrypts plaintext using the key in the specified slot and writes
 	// the result to w.
 	Encrypt(w io.Writer, plaintext []byte, slot uint32) error
+	// Serial returns the serial number of the device.
+	Serial() (uint32, error)
 	// Close releases any resources associated with the device.
 	Close() error
 }
 
+// OpenDevice opens the PIV device with the serial number, or any device if
+// serial is zero. It returns ErrNoCard or a *CardNotFoundError if the
+// device is not present.
+type OpenDevice func(serial uint32) (Device, error)
+
+// PivStanza is the age key encrypted with the PIV key of a slot of a
+// device.
+type PivStanza struct {
+	// Serial is the serial number of the device, zero if unknown.
+	Serial uint32
+	// Slot is the slot of the PIV key, zero if unknown.
+	Slot uint32
+	// Body is the encrypted age key.
+	Body []byte
+}
+
+// PivKey is the content of a PIV key file: the same age key encrypted with
+// the PIV keys of one or more devices, f. ex. a primary and a backup
+// yubikey.
+//
+// The key file is a header line followed by a stanza per device: a line
+// "-> piv <serial> <slot in hex>" and the ascii85 encoded encrypted age key.
+// Key files of older versions, with only the ascii85 encoded encrypted age
+// key, are read as a stanza of an unknown device and slot.
+type PivKey struct {
+	Stanzas []PivStanza
+}
+
+const (
+	// pivHeader is the first line of a PIV key file.
+	pivHeader = "# privage PIV key file"
+
+	// pivStanzaPrefix starts the line of a stanza.
+	pivStanzaPrefix = "-> piv "
+
+	// pivColumns is the line length of the ascii85 encoded stanza bodies.
+	pivColumns = 64
+)
+
 // GeneratePiv generates a new age identity, encrypts it using the PIV device
-// at the specified slot, and writes the ascii85-encoded result to w.
-func GeneratePiv(w io.Writer, device Device, slot uint32) (err error) {
-	k, err := age.GenerateX25519Identity()
+// at the specified slot, and writes the PIV key file to w. The identity is
+// a post-quantum hybrid one if pq, otherwise an X25519 one.
+func GeneratePiv(w io.Writer, device Device, slot uint32, pq bool) error {
+	k, err := generate(pq)
 	if err != nil {
 		return fmt.Errorf("could not generate age identity: %w", err)
 	}
 
-	encoder := ascii85.NewEncoder(w)
-	defer func() {
-		if cerr := encoder.Close(); cerr != nil && err == nil {
-			err = cerr
+	var pk PivKey
+	if err := pk.Enroll([]byte(fmt.Sprint(k.Id)), device, slot); err != nil {
+		return err
+	}
+
+	return pk.Encode(w)
+}
+
+// ReadPivKey reads a PIV key file from r.
+func ReadPivKey(r io.Reader) (*PivKey, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+
+	if !bytes.HasPrefix(data, []byte(pivHeader)) {
+		body, err := io.ReadAll(ascii85.NewDecoder(bytes.NewReader(data)))
+		if err != nil {
+			return nil, fmt.Errorf("could not read message file: %w", err)
+		}
+		return &PivKey{Stanzas: []PivStanza{{Body: body}}}, nil
+	}
+
+	var pk PivKey
+	var body bytes.Buffer
+	flush := func() error {
+		if len(pk.Stanzas) == 0 {
+			return nil
+		}
+		b, err := io.ReadAll(ascii85.NewDecoder(&body))
+		if err != nil {
+			return fmt.Errorf("invalid stanza body: %w", err)
+		}
+		pk.Stanzas[len(pk.Stanzas)-1].Body = b
+		body.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if rest, ok := strings.CutPrefix(line, pivStanzaPrefix); ok {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+			st, err := parsePivStanza(rest)
+			if err != nil {
+				return nil, err
+			}
+			pk.Stanzas = append(pk.Stanzas, st)
+			continue
+		}
+
+		// The header and comments before the first stanza
+		if len(pk.Stanzas) == 0 {
+			if line != "" && !strings.HasPrefix(line, "#") {
+				return nil, fmt.Errorf("invalid key file line %q", line)
+			}
+			continue
+		}
+
+		body.WriteString(line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read key file: %w", err)
+	}
+	if err := flush(); err != nil {
+		return nil, err
+	}
+
+	if len(pk.Stanzas) == 0 {
+		return nil, errors.New("found no stanzas in the PIV key file")
+	}
+
+	return &pk, nil
+}
+
+// parsePivStanza parses the serial number and slot of a stanza line, after
+// the prefix.
+func parsePivStanza(s string) (PivStanza, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return PivStanza{}, fmt.Errorf("invalid stanza %q", pivStanzaPrefix+s)
+	}
+
+	serial, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return PivStanza{}, fmt.Errorf("invalid serial of stanza %q: %w", pivStanzaPrefix+s, err)
+	}
+	slot, err := strconv.ParseUint(fields[1], 16, 32)
+	if err != nil {
+		return PivStanza{}, fmt.Errorf("invalid slot of stanza %q: %w", pivStanzaPrefix+s, err)
+	}
+
+	return PivStanza{Serial: uint32(serial), Slot: uint32(slot)}, nil
+}
+
+// Encode writes the PIV key file to w.
+func (pk *PivKey) Encode(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString(pivHeader + "\n")
+	for _, st := range pk.Stanzas {
+		fmt.Fprintf(&b, "%s%d %x\n", pivStanzaPrefix, st.Serial, st.Slot)
+
+		encoded := make([]byte, ascii85.MaxEncodedLen(len(st.Body)))
+		encoded = encoded[:ascii85.Encode(encoded, st.Body)]
+		for len(encoded) > pivColumns {
+			b.Write(encoded[:pivColumns])
+			b.WriteString("\n")
+			encoded = encoded[pivColumns:]
+		}
+		b.Write(encoded)
+		b.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Enroll adds a stanza with the age key encrypted with the PIV key of the
+// slot of the device. The key is the decrypted age key of the key file, or
+// a new one.
+func (pk *PivKey) Enroll(key []byte, device Device, slot uint32) error {
+	serial, err := device.Serial()
+	if err != nil {
+		return fmt.Errorf("could not get serial of the PIV device: %w", err)
+	}
+
+	for _, st := range pk.Stanzas {
+		if st.Serial == serial && st.Slot == slot {
+			return fmt.Errorf("the slot %x of the device %d is already enrolled", slot, serial)
 		}
-	}()
+	}
 
-	if err := device.Encrypt(encoder, []byte(k.String()), slot); err != nil {
+	var body bytes.Buffer
+	if err := device.Encrypt(&body, key, slot); err != nil {
 		return fmt.Errorf("could not encrypt identity: %w", err)
 	}
 
+	pk.Stanzas = append(pk.Stanzas, PivStanza{Serial: serial, Slot: slot, Body: body.Bytes()})
 	return nil
 }
 
-// LoadPiv returns the age identity read from r that is encrypted with PIV.
-// The path parameter is used for error messages and tracking (no filesystem operations).
+// Decrypt returns the age key of the key file, decrypted with the first
+// present device of the stanzas. A stanza of an unknown device is decrypted
+// with any device, and one of an unknown slot with the key of slot. The
+// opened devices are closed.
+func (pk *PivKey) Decrypt(open OpenDevice, slot uint32, auth Auth) ([]byte, error) {
+	var absent []string
+	var absentErr error
+	for _, st := range pk.Stanzas {
+		device, err := open(st.Serial)
+		var notFound *CardNotFoundError
+		if errors.Is(err, ErrNoCard) || errors.As(err, &notFound) {
+			absent = append(absent, fmtSerial(st.Serial))
+			absentErr = err
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stSlot := st.Slot
+		if stSlot == 0 {
+			stSlot = slot
+		}
+
+		decrypted, err := device.Decrypt(st.Body, stSlot, auth)
+		if cerr := device.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not decrypt key file: %w", err)
+		}
+
+		return decrypted, nil
+	}
+
+	return nil, fmt.Errorf("found none of the PIV devices of the key file [%s]: %w", strings.Join(absent, ", "), absentErr)
+}
+
+func fmtSerial(serial uint32) string {
+	if serial == 0 {
+		return "any"
+	}
+	return strconv.FormatUint(uint64(serial), 10)
+}
+
+// LoadPiv returns the age identity read from r that is encrypted with PIV,
+// decrypted with the first present device of the key file. The auth
+// functions are called if the key of the slot requires a PIN or a touch.
+// The path parameter is used for error messages and tracking (no
+// filesystem operations).
 // TODO: Revisit signature - consider whether path should be part of Identity struct.
-func LoadPiv(r io.Reader, path string, device Device, slot uint32) Identity {
-	raw, err := DecryptPiv(r, device, slot)
+func LoadPiv(r io.Reader, path string, open OpenDevice, slot uint32, auth Auth) Identity {
+	raw, err := DecryptPiv(r, open, slot, auth)
 	if err != nil {
 		return Identity{Err: err}
 	}
 
-	identity, err := age.ParseX25519Identity(string(raw))
+	identities, err := age.ParseIdentities(bytes.NewReader(raw))
 	if err != nil {
 		return Identity{Err: fmt.Errorf("could not parse identity as age key: %w", err)}
 	}
 
-	ident := Identity{}
-	ident.Id = identity
-	ident.Path = path
-	return ident
+	return New(identities[0], path)
 }
 
-// DecryptPiv returns the decrypted contents read from r, using the
-// PIV device to decrypt with the key in the specified slot.
-func DecryptPiv(r io.Reader, device Device, slot uint32) ([]byte, error) {
-	decoded, err := io.ReadAll(ascii85.NewDecoder(r))
-	if err != nil {
-		return nil, fmt.Errorf("could not read message file: %w", err)
-	}
-
-	decrypted, err := device.Decrypt(decoded, slot)
+// DecryptPiv returns the decrypted age key of the PIV key file read from r,
+// see PivKey.Decrypt.
+func DecryptPiv(r io.Reader, open OpenDevice, slot uint32, auth Auth) ([]byte, error) {
+	pk, err := ReadPivKey(r)
 	if err != nil {
-		return nil, fmt.Errorf("could not decrypt key file: %w", err)
+		return nil, err
 	}
 
-	return decrypted, nil
+	return pk.Decrypt(open, slot, auth)
 }
@@ -0,0 +1,255 @@
+// Package softpiv implements a software PIV device, meant for tests and CI
+// where no yubikey is plugged in.
+//
+// The devices are the subdirectories of a directory, named by their serial
+// number. The RSA private key of a slot is a PEM file in the device
+// directory, named by the slot in hex, f. ex. 1234/9a.pem. The keys are not
+// protected: the software device offers none of the security of a yubikey.
+package softpiv
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/revelaction/privage/identity"
+)
+
+const (
+	// keySize is the size of the generated RSA keys.
+	keySize = 2048
+
+	// pemType is the PEM block type of the slot key files.
+	pemType = "RSA PRIVATE KEY"
+
+	// keyExt is the extension of the slot key files.
+	keyExt = ".pem"
+)
+
+// device implements identity.Device with the slot key files of a device
+// directory.
+type device struct {
+	path   string
+	serial uint32
+}
+
+// Generate generates an RSA key for the slot of the device with the serial
+// number in dir, creating the device if needed.
+func Generate(dir string, serial, slot uint32) error {
+	if serial == 0 {
+		return errors.New("the serial number of the software PIV device can not be zero")
+	}
+
+	path := filepath.Join(dir, strconv.FormatUint(uint64(serial), 10))
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return fmt.Errorf("could not create software PIV device: %w", err)
+	}
+
+	key, err := rsa.GenerateKey(rand.Reader, keySize)
+	if err != nil {
+		return fmt.Errorf("could not generate RSA key: %w", err)
+	}
+
+	f, err := os.OpenFile(keyPath(path, slot), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create key of slot %x: %w", slot, err)
+	}
+
+	err = pem.Encode(f, &pem.Block{Type: pemType, Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	return errors.Join(err, f.Close())
+}
+
+// New returns the software PIV device in dir with the serial number, or
+// the first one if serial is zero. Like yubikey.New, it returns
+// identity.ErrNoCard if there are no devices, and a
+// *identity.CardNotFoundError if none has the serial number.
+func New(dir string, serial uint32) (identity.Device, error) {
+	name, err := identity.SelectCard(cards{dir: dir}, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := cards{dir: dir}.Serial(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &device{path: filepath.Join(dir, name), serial: s}, nil
+}
+
+// Devices returns the description of the software PIV devices in dir.
+func Devices(dir string) ([]identity.CardInfo, error) {
+	c := cards{dir: dir}
+	names, err := c.Names()
+	if err != nil {
+		return nil, err
+	}
+
+	infos := make([]identity.CardInfo, 0, len(names))
+	for _, name := range names {
+		serial, err := c.Serial(name)
+		if err != nil {
+			continue
+		}
+		info := identity.CardInfo{Name: "Software PIV device " + name, Serial: serial, Version: "software"}
+
+		d := &device{path: filepath.Join(dir, name), serial: serial}
+		slots, err := d.slots()
+		if err != nil {
+			return nil, err
+		}
+		for _, slot := range slots {
+			key, err := d.key(slot)
+			if err != nil {
+				return nil, err
+			}
+			info.Slots = append(info.Slots, identity.SlotInfo{
+				Slot:      slot,
+				Subject:   "CN=softpiv",
+				Algorithm: fmt.Sprintf("RSA-%d", key.N.BitLen()),
+			})
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+// Decrypt decrypts ciphertext with the key of the slot. The software keys
+// require no PIN or touch, so auth is not used.
+func (d *device) Decrypt(ciphertext []byte, slot uint32, auth identity.Auth) ([]byte, error) {
+	key, err := d.key(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	decrypted, err := rsa.DecryptPKCS1v15(nil, key, ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt with the key in slot %x: %w", slot, err)
+	}
+
+	return decrypted, nil
+}
+
+// Encrypt encrypts plaintext with the public key of the slot and writes the
+// result to w.
+func (d *device) Encrypt(w io.Writer, plaintext []byte, slot uint32) error {
+	key, err := d.key(slot)
+	if err != nil {
+		return err
+	}
+
+	encrypted, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, plaintext)
+	if err != nil {
+		return fmt.Errorf("could not encrypt private key: %w", err)
+	}
+
+	if _, err := w.Write(encrypted); err != nil {
+		return fmt.Errorf("could not write encrypted payload: %w", err)
+	}
+
+	return nil
+}
+
+// Serial returns the serial number of the device.
+func (d *device) Serial() (uint32, error) {
+	return d.serial, nil
+}
+
+// Close does nothing, the software device holds no resources.
+func (d *device) Close() error {
+	return nil
+}
+
+// key reads the RSA private key of the slot.
+func (d *device) key(slot uint32) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(keyPath(d.path, slot))
+	if err != nil {
+		return nil, fmt.Errorf("could not get key in slot %x: %w", slot, err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != pemType {
+		return nil, fmt.Errorf("found no %s in the key file of slot %x", pemType, slot)
+	}
+
+	key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key in slot %x: %w", slot, err)
+	}
+
+	return key, nil
+}
+
+// slots returns the slots of the device with a key file, sorted.
+func (d *device) slots() ([]uint32, error) {
+	entries, err := os.ReadDir(d.path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read software PIV device: %w", err)
+	}
+
+	var slots []uint32
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), keyExt)
+		if !ok {
+			continue
+		}
+		slot, err := strconv.ParseUint(name, 16, 32)
+		if err != nil {
+			continue
+		}
+		slots = append(slots, uint32(slot))
+	}
+	slices.Sort(slots)
+
+	return slots, nil
+}
+
+func keyPath(path string, slot uint32) string {
+	return filepath.Join(path, strconv.FormatUint(uint64(slot), 16)+keyExt)
+}
+
+// cards implements identity.Cards with the device directories of dir.
+type cards struct {
+	dir string
+}
+
+// Names returns the names of the device directories, sorted. A missing dir
+// has no devices.
+func (c cards) Names() ([]string, error) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("could not read software PIV devices: %w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := c.Serial(e.Name()); err != nil {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+
+	return names, nil
+}
+
+func (c cards) Serial(name string) (uint32, error) {
+	serial, err := strconv.ParseUint(name, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid software PIV device %s: %w", name, err)
+	}
+
+	return uint32(serial), nil
+}
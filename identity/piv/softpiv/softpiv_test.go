@@ -0,0 +1,111 @@
+package softpiv
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/revelaction/privage/identity"
+)
+
+func TestDevice_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	if err := Generate(dir, 1111, 0x9a); err != nil {
+		t.Fatalf("Generate failed: %v", err)
+	}
+	if err := Generate(dir, 1111, 0x9a); err == nil {
+		t.Errorf("expected error generating the key of a slot twice")
+	}
+
+	d, err := New(dir, 0)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	defer func() {
+		_ = d.Close()
+	}()
+
+	serial, err := d.Serial()
+	if err != nil || serial != 1111 {
+		t.Errorf("expected serial 1111, got %d, %v", serial, err)
+	}
+
+	var buf bytes.Buffer
+	if err := d.Encrypt(&buf, []byte("AGE-SECRET-KEY-TEST"), 0x9a); err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	got, err := d.Decrypt(buf.Bytes(), 0x9a, identity.Auth{})
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	if string(got) != "AGE-SECRET-KEY-TEST" {
+		t.Errorf("expected the plaintext, got %q", got)
+	}
+
+	if err := d.Encrypt(&buf, []byte("x"), 0x9b); err == nil {
+		t.Errorf("expected error encrypting with an empty slot")
+	}
+}
+
+func TestNew_Select(t *testing.T) {
+	dir := t.TempDir()
+
+	var notFound *identity.CardNotFoundError
+	if _, err := New(dir, 0); !errors.Is(err, identity.ErrNoCard) {
+		t.Errorf("expected ErrNoCard, got %v", err)
+	}
+
+	for _, serial := range []uint32{2222, 1111} {
+		if err := Generate(dir, serial, 0x82); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		serial uint32
+		want   uint32
+	}{
+		{"Any", 0, 1111},
+		{"First", 1111, 1111},
+		{"Second", 2222, 2222},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := New(dir, tt.serial)
+			if err != nil {
+				t.Fatalf("New failed: %v", err)
+			}
+			if got, _ := d.Serial(); got != tt.want {
+				t.Errorf("expected serial %d, got %d", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := New(dir, 3333); !errors.As(err, &notFound) {
+		t.Errorf("expected CardNotFoundError, got %v", err)
+	}
+}
+
+func TestDevices(t *testing.T) {
+	dir := t.TempDir()
+	for _, slot := range []uint32{0x9a, 0x82} {
+		if err := Generate(dir, 1111, slot); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	infos, err := Devices(dir)
+	if err != nil {
+		t.Fatalf("Devices failed: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Serial != 1111 {
+		t.Fatalf("unexpected devices %+v", infos)
+	}
+
+	slots := infos[0].Slots
+	if len(slots) != 2 || slots[0].Slot != 0x82 || slots[1].Slot != 0x9a || slots[0].Algorithm != "RSA-2048" {
+		t.Errorf("unexpected slots %+v", slots)
+	}
+}
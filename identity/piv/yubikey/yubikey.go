@@ -26,8 +26,12 @@ package yubikey
 
 import (
 	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"io"
 
@@ -35,38 +39,154 @@ import (
 	"github.com/revelaction/privage/identity"
 )
 
+// swSecurityStatusNotSatisfied is the status word of the card when an
+// operation is not authorized, f. ex. because the key was not touched in
+// time.
+const swSecurityStatusNotSatisfied = 0x6982
+
+// firstRetiredSlot and lastRetiredSlot are the first and last retired key
+// management slots, that hold the RSA keys privage uses.
+const (
+	firstRetiredSlot = 0x82
+	lastRetiredSlot  = 0x95
+)
+
 // yubiDevice implements the piv.Device interface for YubiKey hardware.
 type yubiDevice struct {
 	yk *piv.YubiKey
 }
 
-// New returns a new YubiKey device that implements the identity.Device interface.
-func New() (identity.Device, error) {
+// New returns a new YubiKey device that implements the identity.Device
+// interface. The YubiKey is the one with the serial number, or the first
+// detected card if serial is zero.
+func New(serial uint32) (identity.Device, error) {
+	card, err := identity.SelectCard(pcscCards{}, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	yk, err := piv.Open(card)
+	if err != nil {
+		return nil, fmt.Errorf("could not open card %s: %w", card, err)
+	}
+
+	return &yubiDevice{yk: yk}, nil
+}
+
+// Devices returns the description of the detected cards: serial number,
+// firmware version and the certificates of the retired key management
+// slots.
+func Devices() ([]identity.CardInfo, error) {
 	cards, err := piv.Cards()
 	if err != nil {
 		return nil, fmt.Errorf("could not list cards: %w", err)
 	}
 
-	if len(cards) == 0 {
-		return nil, fmt.Errorf("no cards detected")
+	infos := make([]identity.CardInfo, 0, len(cards))
+	for _, card := range cards {
+		info, err := cardInfo(card)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+
+	return infos, nil
+}
+
+func cardInfo(card string) (info identity.CardInfo, err error) {
+	yk, err := piv.Open(card)
+	if err != nil {
+		return identity.CardInfo{}, fmt.Errorf("could not open card %s: %w", card, err)
 	}
+	defer func() {
+		if cerr := yk.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
 
-	yk, err := piv.Open(cards[0])
+	serial, err := yk.Serial()
 	if err != nil {
-		return nil, fmt.Errorf("could not open card %s: %w", cards[0], err)
+		return identity.CardInfo{}, fmt.Errorf("could not get serial of card %s: %w", card, err)
 	}
+	v := yk.Version()
 
-	return &yubiDevice{yk: yk}, nil
+	info = identity.CardInfo{
+		Name:    card,
+		Serial:  serial,
+		Version: fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch),
+	}
+
+	for key := uint32(firstRetiredSlot); key <= lastRetiredSlot; key++ {
+		slot, _ := piv.RetiredKeyManagementSlot(key)
+		cert, err := yk.Certificate(slot)
+		if err != nil {
+			// Empty slot
+			continue
+		}
+		info.Slots = append(info.Slots, identity.SlotInfo{
+			Slot:      key,
+			Subject:   cert.Subject.String(),
+			Algorithm: keyAlgorithm(cert.PublicKey),
+			NotAfter:  cert.NotAfter,
+		})
+	}
+
+	return info, nil
+}
+
+// keyAlgorithm returns the algorithm and size of the public key, f. ex.
+// RSA-2048.
+func keyAlgorithm(pub crypto.PublicKey) string {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA-%d", pub.N.BitLen())
+	case *ecdsa.PublicKey:
+		return "ECDSA-" + pub.Curve.Params().Name
+	case ed25519.PublicKey:
+		return "Ed25519"
+	case *ecdh.PublicKey:
+		return "X25519"
+	default:
+		return fmt.Sprintf("%T", pub)
+	}
+}
+
+// pcscCards implements identity.Cards with the cards of the PC/SC layer.
+type pcscCards struct{}
+
+func (pcscCards) Names() ([]string, error) {
+	return piv.Cards()
+}
+
+func (pcscCards) Serial(name string) (serial uint32, err error) {
+	yk, err := piv.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if cerr := yk.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return yk.Serial()
 }
 
 // Decrypt decrypts ciphertext using the key in the specified slot.
 //
 // REQUIREMENTS:
 // - The slot must contain an RSA key that was used for encryption
-// - The YubiKey must be present and unlocked (PIN may be required)
+// - The YubiKey must be present
+//
+// The PIN is requested with auth.PIN if the PIN policy of the key requires
+// it, and auth.Touch is called before decrypting with a key that requires a
+// touch.
 //
 // Returns an error if the slot contains a non-RSA key or if decryption fails.
-func (d *yubiDevice) Decrypt(ciphertext []byte, slot uint32) ([]byte, error) {
+// A wrong PIN is returned as *identity.PinError, a blocked PIN as
+// identity.ErrPinBlocked and a missing touch as identity.ErrTouchTimeout.
+func (d *yubiDevice) Decrypt(ciphertext []byte, slot uint32, auth identity.Auth) ([]byte, error) {
 	retiredSlot, ok := piv.RetiredKeyManagementSlot(slot)
 	if !ok {
 		return nil, fmt.Errorf("could not access slot %x in the PIV device", slot)
@@ -84,8 +204,9 @@ func (d *yubiDevice) Decrypt(ciphertext []byte, slot uint32) ([]byte, error) {
 			slot, cert.PublicKey)
 	}
 
-	// TODO: Support KeyAuth for PIN-protected operations
-	priv, err := d.yk.PrivateKey(retiredSlot, cert.PublicKey, piv.KeyAuth{})
+	pinPolicy, touchPolicy := d.keyPolicy(retiredSlot)
+	keyAuth := piv.KeyAuth{PINPrompt: auth.PIN, PINPolicy: pinPolicy}
+	priv, err := d.yk.PrivateKey(retiredSlot, cert.PublicKey, keyAuth)
 	if err != nil {
 		return nil, fmt.Errorf("could not setup private key: %w", err)
 	}
@@ -98,14 +219,67 @@ func (d *yubiDevice) Decrypt(ciphertext []byte, slot uint32) ([]byte, error) {
 			"This indicates an unexpected key type in the slot", priv)
 	}
 
+	touch := touchPolicy == piv.TouchPolicyAlways || touchPolicy == piv.TouchPolicyCached
+	if touch && auth.Touch != nil {
+		auth.Touch()
+	}
+
 	decrypted, err := decrypter.Decrypt(rand.Reader, ciphertext, nil)
 	if err != nil {
+		if aerr := authError(err, touch); aerr != nil {
+			return nil, aerr
+		}
 		return nil, fmt.Errorf("could not decrypt: %w (verify correct YubiKey and slot are being used)", err)
 	}
 
 	return decrypted, nil
 }
 
+// keyPolicy returns the PIN and touch policies of the key in the slot, from
+// the key metadata (YubiKey 5.3 or newer) or from the attestation
+// certificate. Keys imported into the slot have no attestation: the PIN is
+// then requested only if the YubiKey is not yet unlocked, and no touch is
+// expected.
+func (d *yubiDevice) keyPolicy(slot piv.Slot) (piv.PINPolicy, piv.TouchPolicy) {
+	if info, err := d.yk.KeyInfo(slot); err == nil {
+		return info.PINPolicy, info.TouchPolicy
+	}
+
+	attestationCert, err := d.yk.AttestationCertificate()
+	if err != nil {
+		return piv.PINPolicyOnce, piv.TouchPolicyNever
+	}
+	slotCert, err := d.yk.Attest(slot)
+	if err != nil {
+		return piv.PINPolicyOnce, piv.TouchPolicyNever
+	}
+	a, err := piv.Verify(attestationCert, slotCert)
+	if err != nil {
+		return piv.PINPolicyOnce, piv.TouchPolicyNever
+	}
+
+	return a.PINPolicy, a.TouchPolicy
+}
+
+// authError returns the identity error of a wrong or blocked PIN in err, or
+// of a missing touch if the key requires touch. Otherwise it returns nil.
+func authError(err error, touch bool) error {
+	var aerr piv.AuthErr
+	if errors.As(err, &aerr) {
+		if aerr.Retries == 0 {
+			return identity.ErrPinBlocked
+		}
+		return &identity.PinError{Retries: aerr.Retries}
+	}
+
+	var serr interface{ Status() uint16 }
+	if touch && errors.As(err, &serr) && serr.Status() == swSecurityStatusNotSatisfied {
+		return identity.ErrTouchTimeout
+	}
+
+	return nil
+}
+
 // Encrypt encrypts plaintext using the key in the specified slot and writes
 // the result to w.
 //
@@ -154,6 +328,11 @@ func (d *yubiDevice) Encrypt(w io.Writer, plaintext []byte, slot uint32) error {
 	return nil
 }
 
+// Serial returns the serial number of the YubiKey.
+func (d *yubiDevice) Serial() (uint32, error) {
+	return d.yk.Serial()
+}
+
 // Close closes the YubiKey device.
 func (d *yubiDevice) Close() error {
 	return d.yk.Close()
@@ -8,7 +8,15 @@ import (
 	"github.com/revelaction/privage/identity"
 )
 
+// errDisabled is returned when Yubikey support is disabled.
+var errDisabled = errors.New("yubikey support is disabled in this build")
+
 // New returns an error indicating that Yubikey support is disabled.
-func New() (identity.Device, error) {
-	return nil, errors.New("yubikey support is disabled in this build")
+func New(serial uint32) (identity.Device, error) {
+	return nil, errDisabled
+}
+
+// Devices returns an error indicating that Yubikey support is disabled.
+func Devices() ([]identity.CardInfo, error) {
+	return nil, errDisabled
 }
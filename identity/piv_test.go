@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/ascii85"
 	"errors"
+	"fmt"
 	"io"
 	"strings"
 	"testing"
@@ -11,12 +12,21 @@ import (
 
 // mockDevice implements the Device interface for testing purposes.
 // It uses a simple reversible transformation (XOR) to simulate encryption/decryption.
+// If pin is set, the key of the slot requires the PIN, with retries attempts
+// left. If touch is set, the key requires a touch, that times out if
+// touchTimeout is set.
 type mockDevice struct {
-	encryptErr error
-	decryptErr error
-	closeErr   error
-	lastSlot   uint32
-	closed     bool
+	encryptErr   error
+	decryptErr   error
+	closeErr     error
+	lastSlot     uint32
+	closed       bool
+	pin          string
+	retries      int
+	touch        bool
+	touchTimeout bool
+	touched      int
+	serial       uint32
 }
 
 func (m *mockDevice) Encrypt(w io.Writer, plaintext []byte, slot uint32) error {
@@ -33,11 +43,39 @@ func (m *mockDevice) Encrypt(w io.Writer, plaintext []byte, slot uint32) error {
 	return err
 }
 
-func (m *mockDevice) Decrypt(ciphertext []byte, slot uint32) ([]byte, error) {
+func (m *mockDevice) Decrypt(ciphertext []byte, slot uint32, auth Auth) ([]byte, error) {
 	m.lastSlot = slot
 	if m.decryptErr != nil {
 		return nil, m.decryptErr
 	}
+	if m.pin != "" {
+		if m.retries == 0 {
+			return nil, ErrPinBlocked
+		}
+		if auth.PIN == nil {
+			return nil, errors.New("PIN required")
+		}
+		pin, err := auth.PIN()
+		if err != nil {
+			return nil, err
+		}
+		if pin != m.pin {
+			m.retries--
+			if m.retries == 0 {
+				return nil, ErrPinBlocked
+			}
+			return nil, &PinError{Retries: m.retries}
+		}
+	}
+	if m.touch {
+		m.touched++
+		if auth.Touch != nil {
+			auth.Touch()
+		}
+		if m.touchTimeout {
+			return nil, ErrTouchTimeout
+		}
+	}
 	// Simulate decryption: XOR with 0xFF (reverses encryption)
 	decrypted := make([]byte, len(ciphertext))
 	for i, b := range ciphertext {
@@ -46,19 +84,23 @@ func (m *mockDevice) Decrypt(ciphertext []byte, slot uint32) ([]byte, error) {
 	return decrypted, nil
 }
 
+func (m *mockDevice) Serial() (uint32, error) {
+	return m.serial, nil
+}
+
 func (m *mockDevice) Close() error {
 	m.closed = true
 	return m.closeErr
 }
 
 // TestGeneratePiv_Success verifies that GeneratePiv correctly generates an identity,
-// encrypts it using the device, and writes it ascii85-encoded to the writer.
+// encrypts it using the device, and writes it as a key file stanza to the writer.
 func TestGeneratePiv_Success(t *testing.T) {
-	mock := &mockDevice{}
+	mock := &mockDevice{serial: 1234}
 	var buf bytes.Buffer
 	slot := uint32(0x9a)
 
-	err := GeneratePiv(&buf, mock, slot)
+	err := GeneratePiv(&buf, mock, slot, false)
 	if err != nil {
 		t.Fatalf("GeneratePiv failed: %v", err)
 	}
@@ -68,15 +110,20 @@ func TestGeneratePiv_Success(t *testing.T) {
 		t.Errorf("Expected slot %x, got %x", slot, mock.lastSlot)
 	}
 
-	// Verify output is ascii85 encoded
+	// Verify output is a key file with a stanza of the device
 	// We can't verify the exact content easily because GeneratePiv creates a random key,
 	// but we can decode it and verify it looks like a wrapped key.
-	output := buf.String()
-	decoder := ascii85.NewDecoder(strings.NewReader(output))
-	decoded, err := io.ReadAll(decoder)
+	if !strings.HasPrefix(buf.String(), pivHeader+"\n") {
+		t.Errorf("Expected key file header, got %q", buf.String())
+	}
+	pk, err := ReadPivKey(&buf)
 	if err != nil {
-		t.Fatalf("Output is not valid ascii85: %v", err)
+		t.Fatalf("Output is not a valid key file: %v", err)
+	}
+	if len(pk.Stanzas) != 1 || pk.Stanzas[0].Serial != mock.serial || pk.Stanzas[0].Slot != slot {
+		t.Fatalf("Unexpected stanzas %+v", pk.Stanzas)
 	}
+	decoded := pk.Stanzas[0].Body
 
 	// The mock "encrypts" by XORing with 0xFF. Let's "decrypt" it to see if it looks like an age key.
 	decrypted := make([]byte, len(decoded))
@@ -90,13 +137,32 @@ func TestGeneratePiv_Success(t *testing.T) {
 	}
 }
 
+// TestGeneratePiv_PostQuantum verifies that a post-quantum hybrid identity
+// is wrapped by the device and loaded back.
+func TestGeneratePiv_PostQuantum(t *testing.T) {
+	mock := &mockDevice{}
+	var buf bytes.Buffer
+
+	if err := GeneratePiv(&buf, mock, 0x9a, true); err != nil {
+		t.Fatalf("GeneratePiv failed: %v", err)
+	}
+
+	ident := LoadPiv(&buf, "key.piv", open(mock), 0x9a, Auth{})
+	if ident.Err != nil {
+		t.Fatalf("LoadPiv returned error: %v", ident.Err)
+	}
+	if !ident.PostQuantum() {
+		t.Errorf("expected post-quantum identity, got %T", ident.Id)
+	}
+}
+
 // TestGeneratePiv_DeviceError verifies error propagation from device encryption.
 func TestGeneratePiv_DeviceError(t *testing.T) {
 	expectedErr := errors.New("hardware failure")
 	mock := &mockDevice{encryptErr: expectedErr}
 	var buf bytes.Buffer
 
-	err := GeneratePiv(&buf, mock, 0x9a)
+	err := GeneratePiv(&buf, mock, 0x9a, false)
 
 	if err == nil {
 		t.Error("Expected error, got nil")
@@ -130,7 +196,7 @@ func TestDecryptPiv_Success(t *testing.T) {
 	mock := &mockDevice{}
 	slot := uint32(0x9c)
 
-	result, err := DecryptPiv(&buf, mock, slot)
+	result, err := DecryptPiv(&buf, open(mock), slot, Auth{})
 	if err != nil {
 		t.Fatalf("DecryptPiv failed: %v", err)
 	}
@@ -156,7 +222,7 @@ func TestDecryptPiv_InvalidAscii85(t *testing.T) {
 	// Valid ascii85 input
 	input := strings.NewReader("BadData")
 
-	_, err := DecryptPiv(input, mock, 0x9a)
+	_, err := DecryptPiv(input, open(mock), 0x9a, Auth{})
 
 	if err == nil {
 		t.Error("Expected error, got nil")
@@ -175,7 +241,7 @@ func TestLoadPiv_Success(t *testing.T) {
 
 	// (This is a dummy key format, but let's use a generated one to be safe)
 	var buf bytes.Buffer
-	if err := GenerateAge(&buf); err != nil {
+	if err := GenerateAge(&buf, false); err != nil {
 		t.Fatalf("GenerateAge failed: %v", err)
 	}
 	// LoadPiv calls age.ParseX25519Identity(string(raw)).
@@ -193,7 +259,7 @@ func TestLoadPiv_Success(t *testing.T) {
 	// but we need a valid key for the final parse step to succeed.
 	// Let's use the helper to get one.
 	tmpBuf := &bytes.Buffer{}
-	if err := GenerateAge(tmpBuf); err != nil {
+	if err := GenerateAge(tmpBuf, false); err != nil {
 		t.Fatalf("GenerateAge failed: %v", err)
 	}
 	// Parse out just the key line
@@ -226,7 +292,7 @@ func TestLoadPiv_Success(t *testing.T) {
 	// 3. Test
 	mock := &mockDevice{}
 	path := "/home/user/key.piv"
-	ident := LoadPiv(encodedBuf, path, mock, 0x9a)
+	ident := LoadPiv(encodedBuf, path, open(mock), 0x9a, Auth{})
 
 	// 4. Verify
 	if ident.Err != nil {
@@ -238,8 +304,8 @@ func TestLoadPiv_Success(t *testing.T) {
 	if ident.Id == nil {
 		t.Error("Expected valid identity, got nil")
 	}
-	if ident.Id.String() != realKey {
-		t.Errorf("Key mismatch. Got %s, want %s", ident.Id.String(), realKey)
+	if fmt.Sprint(ident.Id) != realKey {
+		t.Errorf("Key mismatch. Got %s, want %s", ident.Id, realKey)
 	}
 }
 
@@ -261,7 +327,7 @@ func TestLoadPiv_ParseError(t *testing.T) {
 	}
 
 	mock := &mockDevice{}
-	ident := LoadPiv(encodedBuf, "test", mock, 0x9a)
+	ident := LoadPiv(encodedBuf, "test", open(mock), 0x9a, Auth{})
 
 	if ident.Err == nil {
 		t.Error("Expected error parsing garbage key, got nil")
@@ -274,9 +340,230 @@ func TestLoadPiv_ParseError(t *testing.T) {
 // TestLoadPiv_DecryptError verifies bubbling of decryption errors.
 func TestLoadPiv_DecryptError(t *testing.T) {
 	mock := &mockDevice{decryptErr: errors.New("fail")}
-	ident := LoadPiv(&bytes.Buffer{}, "test", mock, 0x9a)
+	ident := LoadPiv(&bytes.Buffer{}, "test", open(mock), 0x9a, Auth{})
 
 	if ident.Err == nil {
 		t.Error("Expected decryption error, got nil")
 	}
 }
+
+// TestLoadPiv_Auth verifies the PIN and touch requests of the device and
+// their errors.
+func TestLoadPiv_Auth(t *testing.T) {
+	var key bytes.Buffer
+	if err := GeneratePiv(&key, &mockDevice{}, 0x9a, false); err != nil {
+		t.Fatal(err)
+	}
+
+	errCancel := errors.New("cancelled")
+
+	tests := []struct {
+		name        string
+		device      *mockDevice
+		pin         func() (string, error)
+		wantErr     error
+		wantRetries int
+		wantTouched int
+	}{
+		{"NoPolicy", &mockDevice{}, nil, nil, 0, 0},
+		{"Pin", &mockDevice{pin: "123456", retries: 3}, pin("123456"), nil, 0, 0},
+		{"WrongPin", &mockDevice{pin: "123456", retries: 3}, pin("000000"), &PinError{}, 2, 0},
+		{"LastWrongPin", &mockDevice{pin: "123456", retries: 1}, pin("000000"), ErrPinBlocked, 0, 0},
+		{"Blocked", &mockDevice{pin: "123456", retries: 0}, pin("123456"), ErrPinBlocked, 0, 0},
+		{"PinCancelled", &mockDevice{pin: "123456", retries: 3}, func() (string, error) { return "", errCancel }, errCancel, 0, 0},
+		{"Touch", &mockDevice{touch: true}, nil, nil, 0, 1},
+		{"TouchTimeout", &mockDevice{touch: true, touchTimeout: true}, nil, ErrTouchTimeout, 0, 1},
+		{"PinAndTouch", &mockDevice{pin: "123456", retries: 3, touch: true}, pin("123456"), nil, 0, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			touched := 0
+			auth := Auth{PIN: tt.pin, Touch: func() { touched++ }}
+
+			ident := LoadPiv(bytes.NewReader(key.Bytes()), "key.piv", open(tt.device), 0x9a, auth)
+
+			var pinErr *PinError
+			switch {
+			case tt.wantErr == nil:
+				if ident.Err != nil {
+					t.Fatalf("LoadPiv failed: %v", ident.Err)
+				}
+			case errors.As(tt.wantErr, &pinErr):
+				if !errors.As(ident.Err, &pinErr) {
+					t.Fatalf("expected PinError, got %v", ident.Err)
+				}
+				if pinErr.Retries != tt.wantRetries {
+					t.Errorf("expected %d retries, got %d", tt.wantRetries, pinErr.Retries)
+				}
+			default:
+				if !errors.Is(ident.Err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, ident.Err)
+				}
+			}
+
+			if touched != tt.wantTouched || tt.device.touched != tt.wantTouched {
+				t.Errorf("expected %d touch requests, got %d", tt.wantTouched, touched)
+			}
+		})
+	}
+}
+
+func pin(p string) func() (string, error) {
+	return func() (string, error) { return p, nil }
+}
+
+// open returns an OpenDevice that always opens the device.
+func open(d Device) OpenDevice {
+	return func(uint32) (Device, error) { return d, nil }
+}
+
+// openPresent returns an OpenDevice that opens the present devices by
+// serial number, and the first one for serial zero.
+func openPresent(devices ...*mockDevice) OpenDevice {
+	return func(serial uint32) (Device, error) {
+		var found []uint32
+		for _, d := range devices {
+			if serial == 0 || d.serial == serial {
+				return d, nil
+			}
+			found = append(found, d.serial)
+		}
+		if len(found) == 0 {
+			return nil, ErrNoCard
+		}
+		return nil, &CardNotFoundError{Serial: serial, Found: found}
+	}
+}
+
+// TestPivKey_Enroll verifies that the age key of a key file is wrapped for
+// a backup device and is decrypted with whichever device is present.
+func TestPivKey_Enroll(t *testing.T) {
+	primary := &mockDevice{serial: 1111}
+	backup := &mockDevice{serial: 2222}
+
+	var buf bytes.Buffer
+	if err := GeneratePiv(&buf, primary, 0x9a, false); err != nil {
+		t.Fatalf("GeneratePiv failed: %v", err)
+	}
+
+	pk, err := ReadPivKey(&buf)
+	if err != nil {
+		t.Fatalf("ReadPivKey failed: %v", err)
+	}
+	key, err := pk.Decrypt(openPresent(primary), 0, Auth{})
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+
+	if err := pk.Enroll(key, backup, 0x9b); err != nil {
+		t.Fatalf("Enroll failed: %v", err)
+	}
+	if err := pk.Enroll(key, backup, 0x9b); err == nil {
+		t.Errorf("expected error enrolling the slot of the device twice")
+	}
+
+	buf.Reset()
+	if err := pk.Encode(&buf); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		present  []*mockDevice
+		want     *mockDevice
+		wantSlot uint32
+		wantErr  error
+	}{
+		{"Primary", []*mockDevice{primary}, primary, 0x9a, nil},
+		{"Backup", []*mockDevice{backup}, backup, 0x9b, nil},
+		{"Both", []*mockDevice{backup, primary}, primary, 0x9a, nil},
+		{"Other", []*mockDevice{{serial: 3333}}, nil, 0, &CardNotFoundError{}},
+		{"None", nil, nil, 0, ErrNoCard},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, d := range tt.present {
+				d.lastSlot, d.closed = 0, false
+			}
+
+			ident := LoadPiv(bytes.NewReader(buf.Bytes()), "key.piv", openPresent(tt.present...), 0, Auth{})
+
+			var notFound *CardNotFoundError
+			switch {
+			case tt.wantErr == nil:
+				if ident.Err != nil {
+					t.Fatalf("LoadPiv failed: %v", ident.Err)
+				}
+				if fmt.Sprint(ident.Id) != string(key) {
+					t.Errorf("expected key %s, got %s", key, ident.Id)
+				}
+			case errors.As(tt.wantErr, &notFound):
+				if !errors.As(ident.Err, &notFound) {
+					t.Fatalf("expected CardNotFoundError, got %v", ident.Err)
+				}
+			default:
+				if !errors.Is(ident.Err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, ident.Err)
+				}
+			}
+
+			if tt.want != nil && (tt.want.lastSlot != tt.wantSlot || !tt.want.closed) {
+				t.Errorf("expected device %d to decrypt with slot %x and be closed, got slot %x, closed %v", tt.want.serial, tt.wantSlot, tt.want.lastSlot, tt.want.closed)
+			}
+		})
+	}
+}
+
+func TestReadPivKey(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	encoded := make([]byte, ascii85.MaxEncodedLen(len(body)))
+	encoded = encoded[:ascii85.Encode(encoded, []byte(body))]
+
+	tests := []struct {
+		name    string
+		data    string
+		want    []PivStanza
+		wantErr bool
+	}{
+		{"Legacy", string(encoded), []PivStanza{{Body: []byte(body)}}, false},
+		{"Stanzas", pivHeader + "\n# comment\n-> piv 1111 9a\n" + string(encoded[:64]) + "\n" + string(encoded[64:]) + "\n-> piv 2222 82\n" + string(encoded) + "\n",
+			[]PivStanza{{Serial: 1111, Slot: 0x9a, Body: []byte(body)}, {Serial: 2222, Slot: 0x82, Body: []byte(body)}}, false},
+		{"NoStanzas", pivHeader + "\n", nil, true},
+		{"InvalidLine", pivHeader + "\n" + string(encoded) + "\n", nil, true},
+		{"InvalidSerial", pivHeader + "\n-> piv x 9a\n" + string(encoded) + "\n", nil, true},
+		{"InvalidSlot", pivHeader + "\n-> piv 1111 zz\n" + string(encoded) + "\n", nil, true},
+		{"MissingSlot", pivHeader + "\n-> piv 1111\n" + string(encoded) + "\n", nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pk, err := ReadPivKey(strings.NewReader(tt.data))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected error, got %+v", pk)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ReadPivKey failed: %v", err)
+			}
+			if fmt.Sprint(pk.Stanzas) != fmt.Sprint(tt.want) {
+				t.Errorf("expected %+v, got %+v", tt.want, pk.Stanzas)
+			}
+
+			var buf bytes.Buffer
+			if err := pk.Encode(&buf); err != nil {
+				t.Fatalf("Encode failed: %v", err)
+			}
+			again, err := ReadPivKey(&buf)
+			if err != nil {
+				t.Fatalf("ReadPivKey of encoded failed: %v", err)
+			}
+			if fmt.Sprint(again.Stanzas) != fmt.Sprint(pk.Stanzas) {
+				t.Errorf("expected %+v after round trip, got %+v", pk.Stanzas, again.Stanzas)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"filippo.io/age/plugin"
+)
+
+// pluginIdentityPrefix is the prefix of the age plugin identities, f. ex.
+// AGE-PLUGIN-YUBIKEY-1...
+const pluginIdentityPrefix = "AGE-PLUGIN-"
+
+// pluginRecipientComment matches the comment line of an identity file with
+// the recipient of the identity, as written by the plugins, f. ex.
+// "#    Recipient: age1yubikey1..." or "# public key: age1se1...".
+var pluginRecipientComment = regexp.MustCompile(`(?i)^#\s*(?:recipient|public key):\s*(age1\S+)\s*$`)
+
+// PluginUI is the user interface of the age plugins, to display messages and
+// to request values, like the PIN of a hardware key. By default, it uses the
+// terminal.
+var PluginUI = NewPluginUI(true)
+
+// NewPluginUI returns a terminal user interface for the age plugins. If
+// prompt is false, the plugins can not request values or confirmations from
+// the user, and the operations that need them fail.
+func NewPluginUI(prompt bool) *plugin.ClientUI {
+	ui := plugin.NewTerminalUI(printStderr, printStderr)
+	if !prompt {
+		ui.RequestValue = nil
+		ui.Confirm = nil
+	}
+	return ui
+}
+
+func printStderr(format string, v ...any) {
+	_, _ = fmt.Fprintf(os.Stderr, format+"\n", v...)
+}
+
+// parsePlugin returns the age plugin identity of the identity file data, and
+// whether data has one. The recipient of the identity, needed to encrypt,
+// is read from the comment lines of the identity file.
+//
+// The plugin binary, f. ex. age-plugin-yubikey, is executed from the PATH
+// for each encryption and decryption.
+func parsePlugin(data []byte, path string) (Identity, bool) {
+	var key, recipient string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if m := pluginRecipientComment.FindStringSubmatch(line); m != nil {
+			recipient = m[1]
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.HasPrefix(line, pluginIdentityPrefix) {
+			return Identity{}, false
+		}
+		key = line
+		break
+	}
+	if key == "" {
+		return Identity{}, false
+	}
+
+	k, err := plugin.NewIdentity(key, PluginUI)
+	if err != nil {
+		return Identity{Path: path, Err: fmt.Errorf("invalid plugin identity: %w", err)}, true
+	}
+	if recipient == "" {
+		return Identity{Path: path, Err: fmt.Errorf("found no recipient of the %s plugin identity, add a \"# recipient: age1...\" line to the key file", k.Name())}, true
+	}
+	r, err := ParseRecipient(recipient)
+	if err != nil {
+		return Identity{Path: path, Err: fmt.Errorf("invalid recipient of the plugin identity: %w", err)}, true
+	}
+
+	return Identity{Id: k, Recipient: r, Path: path}, true
+}
+
+// isPluginRecipient reports whether s is an age plugin recipient, f. ex.
+// age1yubikey1... The Bech32 human readable part of a plugin recipient
+// contains the plugin name after age1.
+func isPluginRecipient(s string) bool {
+	i := strings.LastIndex(s, "1")
+	return i > 0 && strings.HasPrefix(s[:i], "age1")
+}
@@ -0,0 +1,108 @@
+package identity
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/identity/plugintest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(plugintest.Run(m))
+}
+
+func newPluginKey(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := plugintest.Generate(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// TestLoadAge_Plugin tests that a plugin identity file loads and that
+// the plugin binary encrypts and decrypts
+func TestLoadAge_Plugin(t *testing.T) {
+	key := newPluginKey(t)
+
+	ident := LoadAge(bytes.NewReader(key), "key.txt")
+	if ident.Err != nil {
+		t.Fatalf("LoadAge failed: %v", ident.Err)
+	}
+	if !ident.Plugin() || ident.PostQuantum() {
+		t.Errorf("expected a plugin identity, got %+v", ident)
+	}
+	if got, want := ident.Kind(), plugintest.BinaryName; got != want {
+		t.Errorf("expected kind %q, got %q", want, got)
+	}
+	if !strings.HasPrefix(ident.Recipient.String(), "age1"+plugintest.Name+"1") {
+		t.Errorf("unexpected recipient %q", ident.Recipient)
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, ident.Recipient)
+	if err != nil {
+		t.Fatalf("Encrypt failed: %v", err)
+	}
+	if _, err := w.Write([]byte("secret")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := age.Decrypt(&buf, ident.Id)
+	if err != nil {
+		t.Fatalf("Decrypt failed: %v", err)
+	}
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "secret" {
+		t.Errorf("expected %q, got %q", "secret", got)
+	}
+}
+
+func TestLoadAge_PluginNoRecipient(t *testing.T) {
+	var key []byte
+	for _, line := range bytes.SplitAfter(newPluginKey(t), []byte("\n")) {
+		if !bytes.Contains(line, []byte("Recipient:")) {
+			key = append(key, line...)
+		}
+	}
+
+	ident := LoadAge(bytes.NewReader(key), "key.txt")
+	if ident.Err == nil || !strings.Contains(ident.Err.Error(), "# recipient:") {
+		t.Errorf("expected missing recipient error, got %v", ident.Err)
+	}
+	if ident.Id != nil || ident.Path != "key.txt" {
+		t.Errorf("unexpected identity %+v", ident)
+	}
+}
+
+func TestIsPluginRecipient(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want bool
+	}{
+		{"Plugin", "age1yubikey1q2w3e4r", true},
+		{"X25519", "age1zvkyg2lqzraa2lnjvqej32nkuu0ues2s82hzrye869xeexvn73equnujwj", false},
+		{"Ssh", "ssh-ed25519 AAAAC3Nza", false},
+		{"Empty", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isPluginRecipient(tt.s); got != tt.want {
+				t.Errorf("isPluginRecipient(%q) = %v, want %v", tt.s, got, tt.want)
+			}
+		})
+	}
+}
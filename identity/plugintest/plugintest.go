@@ -0,0 +1,113 @@
+// Package plugintest implements age-plugin-privagetest, a stand-in age
+// plugin for the tests of the age plugin support.
+//
+// The plugin wraps an X25519 identity: its identities and recipients carry
+// the encoding of the X25519 identity and recipient as data. The test binary
+// itself is used as the plugin binary, see Run.
+package plugintest
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"filippo.io/age/plugin"
+)
+
+// Name is the name of the stand-in plugin.
+const Name = "privagetest"
+
+// BinaryName is the name of the stand-in plugin binary.
+const BinaryName = "age-plugin-" + Name
+
+// Run runs the tests of m with the test binary in the PATH as the plugin
+// binary, and returns the exit code. If the test binary is executed as the
+// plugin binary, it runs the plugin instead. It is meant to be called from
+// TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(plugintest.Run(m))
+//	}
+func Run(m *testing.M) int {
+	if filepath.Base(os.Args[0]) == BinaryName {
+		return Main()
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not find the test binary: %v\n", err)
+		return 1
+	}
+
+	dir, err := os.MkdirTemp("", "privage-plugin")
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not create plugin dir: %v\n", err)
+		return 1
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+
+	if err := os.Symlink(exe, filepath.Join(dir, BinaryName)); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not install plugin: %v\n", err)
+		return 1
+	}
+	if err := os.Setenv("PATH", dir+string(filepath.ListSeparator)+os.Getenv("PATH")); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "could not set PATH: %v\n", err)
+		return 1
+	}
+
+	return m.Run()
+}
+
+// Main runs the plugin protocol and returns the exit code. With the flag
+// --generate, it writes a new identity file to stdout instead.
+func Main() int {
+	if len(os.Args) > 1 && os.Args[1] == "--generate" {
+		if err := Generate(os.Stdout); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+
+	p, err := plugin.New(Name)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	p.HandleRecipient(func(data []byte) (age.Recipient, error) {
+		return age.ParseX25519Recipient(string(data))
+	})
+	p.HandleIdentityAsRecipient(func(data []byte) (age.Recipient, error) {
+		k, err := age.ParseX25519Identity(string(data))
+		if err != nil {
+			return nil, err
+		}
+		return k.Recipient(), nil
+	})
+	p.HandleIdentity(func(data []byte) (age.Identity, error) {
+		return age.ParseX25519Identity(string(data))
+	})
+
+	return p.Main()
+}
+
+// Generate writes a new plugin identity file to w, with the recipient in a
+// comment line, like age-plugin-yubikey does.
+func Generate(w io.Writer) error {
+	k, err := age.GenerateX25519Identity()
+	if err != nil {
+		return err
+	}
+
+	recipient := plugin.EncodeRecipient(Name, []byte(k.Recipient().String()))
+	identity := plugin.EncodeIdentity(Name, []byte(k.String()))
+
+	_, err = fmt.Fprintf(w, "#    Created: %s\n#  Recipient: %s\n%s\n", time.Now().Format(time.RFC3339), recipient, identity)
+	return err
+}
@@ -0,0 +1,186 @@
+// Package shamir implements Shamir's secret sharing over GF(2^8), to split
+// the age secret key in shares, any threshold of them recovering it.
+//
+// Each byte of the secret is the constant term of a random polynomial of
+// degree threshold-1, and a share is the evaluation of the polynomials at
+// the share index. The field arithmetic avoids lookup tables, so that it
+// does not depend on the secret through the memory access pattern.
+package shamir
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// sharePrefix is the start of the text form of a share.
+const sharePrefix = "PRIVAGE-SHARE-"
+
+// encoding is the encoding of the share values in the text form: upper case
+// letters and digits, easy to read from paper.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Share is a share of a split secret.
+type Share struct {
+	// Threshold is the number of shares that recover the secret.
+	Threshold int
+	// Index is the x coordinate of the share, from 1.
+	Index byte
+	// Value is the evaluation of the polynomials at Index, as long as the
+	// secret.
+	Value []byte
+}
+
+// Split splits secret in n shares, any k of them recovering the secret.
+func Split(secret []byte, n, k int) ([]Share, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("can not split an empty secret")
+	}
+	if k < 2 || k > n || n > 255 {
+		return nil, fmt.Errorf("invalid %d of %d shares: the threshold must be at least 2, and at most the shares, at most 255", k, n)
+	}
+
+	shares := make([]Share, n)
+	for i := range shares {
+		shares[i] = Share{Threshold: k, Index: byte(i + 1), Value: make([]byte, len(secret))}
+	}
+
+	coeffs := make([]byte, k)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, fmt.Errorf("could not generate random polynomial: %w", err)
+		}
+
+		for i := range shares {
+			shares[i].Value[b] = eval(coeffs, shares[i].Index)
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine returns the secret of the shares. There must be at least as many
+// shares as their threshold, of the same split.
+func Combine(shares []Share) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("found no shares")
+	}
+
+	k, size := shares[0].Threshold, len(shares[0].Value)
+	if len(shares) < k {
+		return nil, fmt.Errorf("found %d shares, %d are needed", len(shares), k)
+	}
+
+	seen := make(map[byte]bool, len(shares))
+	for _, s := range shares {
+		if s.Threshold != k || len(s.Value) != size {
+			return nil, errors.New("the shares are not of the same split")
+		}
+		if s.Index == 0 {
+			return nil, errors.New("invalid share index 0")
+		}
+		if seen[s.Index] {
+			return nil, fmt.Errorf("found share %d twice", s.Index)
+		}
+		seen[s.Index] = true
+	}
+
+	// Lagrange interpolation at x = 0
+	secret := make([]byte, size)
+	for i, si := range shares {
+		basis := byte(1)
+		for j, sj := range shares {
+			if i == j {
+				continue
+			}
+			basis = mul(basis, div(sj.Index, sj.Index^si.Index))
+		}
+		for b := range secret {
+			secret[b] ^= mul(si.Value[b], basis)
+		}
+	}
+
+	return secret, nil
+}
+
+// String returns the text form of the share:
+//
+//	PRIVAGE-SHARE-<threshold>-<index>-<value in base32>-<checksum>
+//
+// The checksum is the CRC-32 of the text before it, in hex.
+func (s Share) String() string {
+	body := fmt.Sprintf("%s%d-%d-%s", sharePrefix, s.Threshold, s.Index, encoding.EncodeToString(s.Value))
+	return fmt.Sprintf("%s-%08X", body, crc32.ChecksumIEEE([]byte(body)))
+}
+
+// ParseShare parses the text form of a share. It returns an error if the
+// checksum does not match, f. ex. because of a typo.
+func ParseShare(text string) (Share, error) {
+	text = strings.ToUpper(strings.TrimSpace(text))
+	if !strings.HasPrefix(text, sharePrefix) {
+		return Share{}, fmt.Errorf("invalid share %q, expected prefix %s", text, sharePrefix)
+	}
+
+	i := strings.LastIndexByte(text, '-')
+	body, sum := text[:i], text[i+1:]
+	if fmt.Sprintf("%08X", crc32.ChecksumIEEE([]byte(body))) != sum {
+		return Share{}, fmt.Errorf("invalid checksum of share %q", text)
+	}
+
+	fields := strings.Split(strings.TrimPrefix(body, sharePrefix), "-")
+	if len(fields) != 3 {
+		return Share{}, fmt.Errorf("invalid share %q", text)
+	}
+
+	k, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return Share{}, fmt.Errorf("invalid threshold of share %q: %w", text, err)
+	}
+	index, err := strconv.ParseUint(fields[1], 10, 8)
+	if err != nil {
+		return Share{}, fmt.Errorf("invalid index of share %q: %w", text, err)
+	}
+	value, err := encoding.DecodeString(fields[2])
+	if err != nil {
+		return Share{}, fmt.Errorf("invalid value of share %q: %w", text, err)
+	}
+
+	return Share{Threshold: k, Index: byte(index), Value: value}, nil
+}
+
+// eval evaluates the polynomial of coeffs, from the constant term, at x.
+func eval(coeffs []byte, x byte) byte {
+	var y byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		y = mul(y, x) ^ coeffs[i]
+	}
+	return y
+}
+
+// mul multiplies a and b in GF(2^8) with the AES polynomial
+// x^8 + x^4 + x^3 + x + 1.
+func mul(a, b byte) byte {
+	var p byte
+	for range 8 {
+		// p ^= a if the low bit of b is set, without branching
+		p ^= a & -(b & 1)
+		carry := -(a >> 7)
+		a = a<<1 ^ 0x1b&carry
+		b >>= 1
+	}
+	return p
+}
+
+// div divides a by b in GF(2^8), b not zero: a * b^254.
+func div(a, b byte) byte {
+	inv := byte(1)
+	for range 254 {
+		inv = mul(inv, b)
+	}
+	return mul(a, inv)
+}
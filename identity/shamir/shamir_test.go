@@ -0,0 +1,195 @@
+package shamir
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+// subsets calls f with every subset of the shares, as a new slice.
+func subsets(shares []Share, f func([]Share)) {
+	for mask := 1; mask < 1<<len(shares); mask++ {
+		var sub []Share
+		for i, s := range shares {
+			if mask&(1<<i) != 0 {
+				sub = append(sub, s)
+			}
+		}
+		f(sub)
+	}
+}
+
+// TestCombine_EveryCombination tests that every subset of at least k of n
+// shares recovers the secret, and that smaller subsets do not, for every
+// k-of-n split up to 7 shares.
+func TestCombine_EveryCombination(t *testing.T) {
+	secret := []byte("AGE-SECRET-KEY-1QQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQQ")
+
+	for n := 2; n <= 7; n++ {
+		for k := 2; k <= n; k++ {
+			shares, err := Split(secret, n, k)
+			if err != nil {
+				t.Fatalf("Split %d of %d failed: %v", k, n, err)
+			}
+
+			subsets(shares, func(sub []Share) {
+				got, err := Combine(sub)
+				if len(sub) < k {
+					if err == nil {
+						t.Errorf("%d of %d: expected error combining %d shares", k, n, len(sub))
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("%d of %d: Combine of %d shares failed: %v", k, n, len(sub), err)
+				}
+				if !bytes.Equal(got, secret) {
+					t.Errorf("%d of %d: Combine of %d shares returned %q", k, n, len(sub), got)
+				}
+			})
+		}
+	}
+}
+
+// TestCombine_FewerShares tests that fewer than k shares, with the threshold
+// forged, do not recover the secret.
+func TestCombine_FewerShares(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	shares, err := Split(secret, 5, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := []Share{shares[0], shares[3]}
+	for i := range sub {
+		sub[i].Threshold = 2
+	}
+	got, err := Combine(sub)
+	if err != nil {
+		t.Fatalf("Combine failed: %v", err)
+	}
+	if bytes.Equal(got, secret) {
+		t.Errorf("expected 2 of 3 shares not to recover the secret")
+	}
+}
+
+// TestSplit_Property tests with random secrets and splits that the first k
+// and the last k shares recover the secret, and that the shares survive
+// their text form.
+func TestSplit_Property(t *testing.T) {
+	f := func(secret []byte, n, k uint8) bool {
+		if len(secret) == 0 {
+			secret = []byte{0}
+		}
+		nn := int(n%20) + 2
+		kk := int(k)%(nn-1) + 2
+
+		shares, err := Split(secret, nn, kk)
+		if err != nil {
+			t.Logf("Split %d of %d failed: %v", kk, nn, err)
+			return false
+		}
+
+		parsed := make([]Share, len(shares))
+		for i, s := range shares {
+			if parsed[i], err = ParseShare(s.String()); err != nil {
+				t.Logf("ParseShare failed: %v", err)
+				return false
+			}
+		}
+
+		first, err1 := Combine(parsed[:kk])
+		last, err2 := Combine(parsed[nn-kk:])
+		return err1 == nil && err2 == nil && bytes.Equal(first, secret) && bytes.Equal(last, secret)
+	}
+
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSplit_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret []byte
+		n, k   int
+	}{
+		{"Empty", nil, 3, 2},
+		{"ThresholdOne", []byte("s"), 3, 1},
+		{"ThresholdAboveShares", []byte("s"), 3, 4},
+		{"TooManyShares", []byte("s"), 256, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Split(tt.secret, tt.n, tt.k); err == nil {
+				t.Errorf("expected error")
+			}
+		})
+	}
+}
+
+func TestCombine_Invalid(t *testing.T) {
+	a, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Split([]byte("other secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		shares []Share
+		want   string
+	}{
+		{"None", nil, "no shares"},
+		{"TooFew", a[:1], "2 are needed"},
+		{"Twice", []Share{a[0], a[0]}, "twice"},
+		{"OtherSplit", []Share{a[0], b[1]}, "same split"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Combine(tt.shares)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("expected error with %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestParseShare(t *testing.T) {
+	shares, err := Split([]byte("secret"), 3, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := shares[1].String()
+
+	if !strings.HasPrefix(text, "PRIVAGE-SHARE-2-2-") {
+		t.Errorf("unexpected share %q", text)
+	}
+
+	got, err := ParseShare("  " + strings.ToLower(text) + "\n")
+	if err != nil {
+		t.Fatalf("ParseShare failed: %v", err)
+	}
+	if got.Threshold != 2 || got.Index != 2 || !bytes.Equal(got.Value, shares[1].Value) {
+		t.Errorf("expected %+v, got %+v", shares[1], got)
+	}
+
+	// A typo in the value
+	i := len("PRIVAGE-SHARE-2-2-")
+	typo := text[:i] + string(text[i]^1) + text[i+1:]
+	if _, err := ParseShare(typo); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+
+	for _, invalid := range []string{"", "AGE-SECRET-KEY-1", "PRIVAGE-SHARE-", "PRIVAGE-SHARE-2-2"} {
+		if _, err := ParseShare(invalid); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package identity
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// newSshKey returns a new OpenSSH ed25519 private key file, protected with
+// the passphrase if not empty, and its public key.
+func newSshKey(t *testing.T, passphrase string) ([]byte, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, "user@host")
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, "user@host", []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pk, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return pem.EncodeToMemory(block), strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pk)))
+}
+
+func TestLoadSsh(t *testing.T) {
+	noPassphrase := func() (string, error) {
+		t.Fatal("unexpected passphrase request")
+		return "", nil
+	}
+
+	t.Run("Plain", func(t *testing.T) {
+		key, pub := newSshKey(t, "")
+		if !IsSsh(key) {
+			t.Fatalf("expected IsSsh to detect the key file")
+		}
+
+		result := LoadSsh(bytes.NewReader(key), "id_ed25519", noPassphrase)
+		if result.Err != nil {
+			t.Fatalf("LoadSsh failed: %v", result.Err)
+		}
+		if result.Recipient.String() != pub {
+			t.Errorf("expected recipient %q, got %q", pub, result.Recipient)
+		}
+		if result.Kind() != "SSH ed25519" || result.PostQuantum() {
+			t.Errorf("unexpected kind %q", result.Kind())
+		}
+	})
+
+	t.Run("Passphrase", func(t *testing.T) {
+		key, pub := newSshKey(t, "secret")
+
+		result := LoadSsh(bytes.NewReader(key), "id_ed25519", func() (string, error) { return "secret", nil })
+		if result.Err != nil {
+			t.Fatalf("LoadSsh failed: %v", result.Err)
+		}
+		if result.Recipient.String() != pub {
+			t.Errorf("expected recipient %q, got %q", pub, result.Recipient)
+		}
+
+		result = LoadSsh(bytes.NewReader(key), "id_ed25519", func() (string, error) { return "wrong", nil })
+		if !errors.Is(result.Err, ErrPassphrase) {
+			t.Errorf("expected ErrPassphrase, got %v", result.Err)
+		}
+
+		errNo := errors.New("no passphrase")
+		result = LoadSsh(bytes.NewReader(key), "id_ed25519", func() (string, error) { return "", errNo })
+		if !errors.Is(result.Err, errNo) || result.Path != "id_ed25519" {
+			t.Errorf("expected passphrase error, got %+v", result)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		result := LoadSsh(strings.NewReader(sshHeader+"\ngarbage\n"), "id_ed25519", noPassphrase)
+		if result.Err == nil || result.Id != nil {
+			t.Errorf("expected error, got %+v", result)
+		}
+	})
+}
+
+func TestParseRecipient_Ssh(t *testing.T) {
+	_, pub := newSshKey(t, "")
+
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{"Plain", pub, false},
+		{"Comment", pub + " user@host", false},
+		{"RSA", "ssh-rsa AAAAB3NzaC1yc2EAAAADAQABAAAAgQDJ", true},
+		{"Malformed", "ssh-ed25519 invalid", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseRecipient(tt.key)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRecipient(%q) error = %v, wantErr %v", tt.key, err, tt.wantErr)
+			}
+			if err == nil && r.String() != pub {
+				t.Errorf("expected canonical key %q, got %q", pub, r)
+			}
+		})
+	}
+}
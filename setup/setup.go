@@ -2,6 +2,7 @@ package setup
 
 import (
 	"errors"
+	"time"
 
 	"github.com/revelaction/privage/config"
 	id "github.com/revelaction/privage/identity"
@@ -31,7 +32,7 @@ type Setup struct {
 // 1. WithKeyRepo(): -k and -r flags (with optional -p)
 //   - KeyFile and RepoPath must both be set
 //   - ConfigFile must be empty
-//   - PivSlot is optional
+//   - PivSlot and PivSerial are optional
 //
 // 2. WithConfig(): -c flag only
 //   - ConfigFile must be set
@@ -48,6 +49,18 @@ type Options struct {
 	ConfigFile string
 	RepoPath   string
 	PivSlot    string
+
+	// PivSerial selects the yubikey by serial number, if several are
+	// plugged in. It takes precedence over the serial of the config file.
+	PivSerial string
+
+	// NoPrompt loads a passphrase protected identity only if the passphrase
+	// is in the environment, without prompting for it.
+	NoPrompt bool
+
+	// Wait is how long the commands that modify the repository wait for
+	// the lock of another privage process to be released.
+	Wait time.Duration
 }
 
 // Validate checks that the Options are in a valid state.
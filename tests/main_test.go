@@ -10,14 +10,24 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"testing"
 
 	"github.com/rogpeppe/go-internal/testscript"
+
+	"github.com/revelaction/privage/identity/piv/softpiv"
+	"github.com/revelaction/privage/identity/plugintest"
 )
 
 var binDir string
 
 func TestMain(m *testing.M) {
+	// 0. The test binary runs as the commands of the scripts, without
+	// building privage
+	if cmd, ok := commands[filepath.Base(os.Args[0])]; ok {
+		cmd()
+	}
+
 	// 1. Setup: Build binary
 	var err error
 	binDir, err = os.MkdirTemp("", "privage-test-bin")
@@ -36,14 +46,48 @@ func TestMain(m *testing.M) {
 
 	// Build from ../cmd/privage relative to this file
 	// We assume "go" is in the path
-	cmd := exec.Command("go", "build", "-o", binPath, "../cmd/privage")
+	cmd := exec.Command("go", "build", "-tags", buildTags, "-o", binPath, "../cmd/privage")
 	if out, err := cmd.CombinedOutput(); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to build privage: %v\n%s\n", err, out)
 		os.Exit(1)
 	}
 
-	// 2. Run
-	testscript.Main(m, map[string]func(){})
+	// 2. Run, with the commands in the PATH of the scripts
+	testscript.Main(m, commands)
+}
+
+// commands are the commands of the scripts run by the test binary: the
+// stand-in age plugin and the software PIV device generator. They exit.
+var commands = map[string]func(){
+	plugintest.BinaryName: func() { os.Exit(plugintest.Main()) },
+	"softpiv":             softpivMain,
+}
+
+// softpivMain generates the key of a slot of a software PIV device:
+//
+//	softpiv dir serial slot
+func softpivMain() {
+	if len(os.Args) != 4 {
+		fmt.Fprintln(os.Stderr, "usage: softpiv dir serial slot")
+		os.Exit(2)
+	}
+
+	serial, err := strconv.ParseUint(os.Args[2], 10, 32)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	slot, err := strconv.ParseUint(os.Args[3], 16, 32)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	if err := softpiv.Generate(os.Args[1], uint32(serial), uint32(slot)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(0)
 }
 
 func TestScript(t *testing.T) {
@@ -0,0 +1,7 @@
+//go:build integration && noyubikey
+
+package tests
+
+// buildTags are the tags privage is built with for the scripts: softpiv
+// enables the software PIV devices of piv.txt.
+const buildTags = "softpiv,noyubikey"
@@ -0,0 +1,372 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/revelaction/privage/header"
+)
+
+// The kinds of the problems found by Check.
+const (
+	// ProblemHeader is a file whose header can not be read, unpadded or
+	// decrypted.
+	ProblemHeader = "header"
+
+	// ProblemContent is a file whose content can not be decrypted fully, or
+	// does not match the size and checksum of the header.
+	ProblemContent = "content"
+
+	// ProblemName is a file whose name does not match its header and the
+	// recipients, f. ex. renamed or copied.
+	ProblemName = "name"
+
+	// ProblemDuplicate is a file with the label of another file.
+	ProblemDuplicate = "duplicate"
+
+	// ProblemTemp is a temporary file left by an interrupted write.
+	ProblemTemp = "temp"
+
+	// ProblemRotate is a file left by an interrupted rotation.
+	ProblemRotate = "rotate"
+
+	// ProblemPlaintext is a decrypted file of a label left in the
+	// repository.
+	ProblemPlaintext = "plaintext"
+)
+
+// tmpExtension is the extension of the temporary files of the atomic
+// writes.
+const tmpExtension = ".tmp"
+
+// ErrNotRepairable is returned when repairing a problem that can not be
+// repaired safely.
+var ErrNotRepairable = errors.New("the problem can not be repaired safely")
+
+// A Problem is an inconsistency of a file of the repository found by Check.
+type Problem struct {
+	// Kind is one of the Problem constants.
+	Kind string
+
+	// Path is the path of the file.
+	Path string
+
+	// Header is the header of the file, nil if it could not be decrypted.
+	Header *header.Header
+
+	// Err describes the problem.
+	Err error
+
+	// repair fixes the problem. It is nil if the problem can not be fixed
+	// safely.
+	repair func() error
+}
+
+// Repairable reports whether the problem can be repaired safely.
+func (p *Problem) Repairable() bool {
+	return p.repair != nil
+}
+
+// Repair fixes the problem. It returns ErrNotRepairable if the problem can
+// not be repaired safely.
+func (p *Problem) Repair() error {
+	if p.repair == nil {
+		return ErrNotRepairable
+	}
+
+	return p.repair()
+}
+
+// Check checks every file of the repository and returns the problems found.
+//
+// The headers and contents of the .privage files are decrypted fully, without
+// the index. Their names are checked against their headers and the
+// recipients of the vault, and their labels must be unique. Temporary files
+// of interrupted writes, files of interrupted rotations and decrypted files
+// of the labels left in the repository are also reported.
+//
+// Some problems can be repaired safely: temporary files are removed,
+// misnamed files are reencrypted under their name, copies of files and
+// decrypted files that were not modified are removed.
+func (v *Vault) Check() ([]*Problem, error) {
+	problems, err := tempProblems(v.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	paths, err := privagePaths(v.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	var headers []*header.Header
+	sums := map[string]string{}
+	for h := range readHeaders(paths, v.id, v.workers) {
+		if fileSuffix(h.Path) == RotateSuffix {
+			problems = append(problems, v.rotateProblem(h))
+			continue
+		}
+		if h.Err != nil {
+			problems = append(problems, &Problem{Kind: ProblemHeader, Path: h.Path, Err: h.Err})
+			continue
+		}
+
+		sum, err := v.contentSum(h)
+		if err != nil {
+			problems = append(problems, &Problem{Kind: ProblemContent, Path: h.Path, Header: h, Err: err})
+			continue
+		}
+		sums[h.Path] = sum
+		headers = append(headers, h)
+	}
+
+	byName := map[string]*header.Header{}
+	for _, h := range headers {
+		byName[filepath.Base(h.Path)] = h
+	}
+
+	labels := map[string][]*header.Header{}
+	for _, h := range headers {
+		labels[h.Label] = append(labels[h.Label], h)
+
+		if p := v.nameProblem(h, byName, sums); p != nil {
+			problems = append(problems, p)
+		}
+		if p := v.plaintextProblem(h, sums[h.Path]); p != nil {
+			problems = append(problems, p)
+		}
+	}
+
+	for _, label := range slices.Sorted(maps.Keys(labels)) {
+		hs := labels[label]
+		if len(hs) < 2 {
+			continue
+		}
+		for _, h := range hs {
+			var others []string
+			for _, o := range hs {
+				if o != h {
+					others = append(others, filepath.Base(o.Path))
+				}
+			}
+			problems = append(problems, &Problem{
+				Kind:   ProblemDuplicate,
+				Path:   h.Path,
+				Header: h,
+				Err:    fmt.Errorf("the label %q is also in %s", label, strings.Join(others, ", ")),
+			})
+		}
+	}
+
+	return problems, nil
+}
+
+// tempProblems returns the temporary files of the repository left by
+// interrupted writes of encrypted files, the index, the manifest, the
+// recipients file or the rotate journal.
+func tempProblems(repoDir string) ([]*Problem, error) {
+	entries, err := os.ReadDir(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %w", repoDir, err)
+	}
+
+	var problems []*Problem
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), tmpExtension)
+		if !ok || e.IsDir() {
+			continue
+		}
+		if !IsPrivageFile(name) && !isIndexTemp(name) && name != ManifestFileName && name != RecipientsFileName && name != RotateJournalFileName {
+			continue
+		}
+
+		path := filepath.Join(repoDir, e.Name())
+		problems = append(problems, &Problem{
+			Kind:   ProblemTemp,
+			Path:   path,
+			Err:    errors.New("temporary file of an interrupted write"),
+			repair: func() error { return os.Remove(path) },
+		})
+	}
+
+	return problems, nil
+}
+
+// isIndexTemp reports whether name, without the temporary extension, is
+// the name of a temporary file of the index, written by saveIndex.
+func isIndexTemp(name string) bool {
+	return name == IndexFileName || strings.HasPrefix(name, IndexFileName+".")
+}
+
+// rotateProblem returns the problem of the file h of a rotation. A file
+// encrypted to the vault identity was not renamed by an interrupted clean
+// of the rotation, and is renamed if the standard name is free.
+func (v *Vault) rotateProblem(h *header.Header) *Problem {
+	if h.Err != nil {
+		return &Problem{
+			Kind: ProblemRotate,
+			Path: h.Path,
+			Err:  fmt.Errorf("file of a rotation not encrypted with the key, finish the rotation or remove it: %w", h.Err),
+		}
+	}
+
+	p := &Problem{
+		Kind:   ProblemRotate,
+		Path:   h.Path,
+		Header: h,
+		Err:    errors.New("file of a rotation encrypted with the key, not renamed"),
+	}
+
+	target := strings.TrimSuffix(h.Path, RotateSuffix+Extension) + Extension
+	if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+		p.repair = func() error { return v.moveFile(h.Path, target) }
+	}
+
+	return p
+}
+
+// contentSum decrypts the content of the file of header h fully, checks it
+// against the size and checksum of the header, if any, and returns its hex
+// encoded SHA-256. The content restricted by a category policy is not
+// checked, and has no sum.
+func (v *Vault) contentSum(h *header.Header) (sum string, err error) {
+	r, err := v.Open(h)
+	if errors.Is(err, ErrRestricted) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt the content: %w", err)
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return "", fmt.Errorf("could not decrypt the content fully: %w", err)
+	}
+
+	if len(h.SHA256) > 0 && (n != h.Size || !bytes.Equal(hash.Sum(nil), h.SHA256)) {
+		return "", errors.New("the content does not match the size and checksum of the header")
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// nameProblem returns the problem of the file of header h if its name is
+// not the one of its header and the recipients of the vault. The file is
+// reencrypted under the name if it is free, or removed if it is a copy of
+// the file with the name.
+//
+// The file is reencrypted, and not only renamed, as its name may not match
+// because it was encrypted to other recipients, which can not be read from
+// the file.
+func (v *Vault) nameProblem(h *header.Header, byName map[string]*header.Header, sums map[string]string) *Problem {
+	want, err := fileName(h, v.Recipients(), fileSuffix(h.Path))
+	if err != nil {
+		return &Problem{Kind: ProblemName, Path: h.Path, Header: h, Err: err}
+	}
+	if filepath.Base(h.Path) == want {
+		return nil
+	}
+
+	p := &Problem{
+		Kind:   ProblemName,
+		Path:   h.Path,
+		Header: h,
+		Err:    fmt.Errorf("the name does not match the header and recipients, expected %s", want),
+	}
+
+	target := filepath.Join(v.repository, want)
+	other, ok := byName[want]
+	switch {
+	case !ok:
+		if _, err := os.Stat(target); errors.Is(err, os.ErrNotExist) {
+			p.repair = func() error { return v.reencryptFile(h) }
+		}
+	case other.Category == h.Category && sums[h.Path] != "" && sums[h.Path] == sums[other.Path]:
+		p.Err = fmt.Errorf("copy of the file %s", want)
+		p.repair = func() error { return v.removeFile(h.Path) }
+	}
+
+	return p
+}
+
+// plaintextProblem returns the problem of the decrypted file of the label
+// of header h, if present in the repository. It is removed if it was not
+// modified.
+func (v *Vault) plaintextProblem(h *header.Header, sum string) *Problem {
+	path, ok := v.PlaintextPath(h)
+	if !ok {
+		return nil
+	}
+	info, err := os.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil
+	}
+
+	p := &Problem{
+		Kind:   ProblemPlaintext,
+		Path:   path,
+		Header: h,
+		Err:    errors.New("decrypted file, modified: reencrypt or remove it"),
+	}
+
+	if plainSum, err := fileSum(path); err == nil && sum != "" && plainSum == sum {
+		p.Err = errors.New("decrypted file, not modified")
+		p.repair = func() error { return os.Remove(path) }
+	}
+
+	return p
+}
+
+// moveFile renames the encrypted file in path to target, and its entry in
+// the manifest.
+func (v *Vault) moveFile(path, target string) error {
+	if err := v.checkManifest(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, target); err != nil {
+		return err
+	}
+
+	return v.renameManifestEntry(filepath.Base(path), filepath.Base(target))
+}
+
+// reencryptFile reencrypts the file of header h to the recipients of the
+// vault, under the name of its header and the recipients, and removes the
+// file.
+func (v *Vault) reencryptFile(h *header.Header) error {
+	if err := v.reencrypt(v, h, fileSuffix(h.Path)); err != nil {
+		return err
+	}
+
+	return v.removeFile(h.Path)
+}
+
+// removeFile removes the encrypted file in path, and its entry in the
+// manifest.
+func (v *Vault) removeFile(path string) error {
+	if err := v.checkManifest(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+
+	return v.recordFile(path)
+}
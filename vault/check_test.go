@@ -0,0 +1,273 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/header"
+	id "github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+)
+
+// kinds returns the kinds of the problems, with a trailing + for the
+// repairable ones.
+func kinds(problems []*Problem) []string {
+	var ks []string
+	for _, p := range problems {
+		k := p.Kind
+		if p.Repairable() {
+			k += "+"
+		}
+		ks = append(ks, k)
+	}
+	return ks
+}
+
+func TestCheck(t *testing.T) {
+	otherName := strings.Repeat("e", hexLen) + Extension
+
+	tests := []struct {
+		name string
+		// damage damages the repository with the files "a" and "b".
+		damage func(t *testing.T, v *Vault, a string)
+		want   []string
+		// after are the kinds of the problems left after the repair.
+		after []string
+	}{
+		{
+			name:   "Clean",
+			damage: func(t *testing.T, v *Vault, a string) {},
+		},
+		{
+			name: "Temp",
+			damage: func(t *testing.T, v *Vault, a string) {
+				for _, name := range []string{filepath.Base(a) + tmpExtension, ManifestFileName + tmpExtension, IndexFileName + ".123456" + tmpExtension, "notes.tmp"} {
+					if err := os.WriteFile(filepath.Join(v.Repository(), name), []byte("partial"), 0600); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+			want: []string{"temp+", "temp+", "temp+"},
+		},
+		{
+			name: "Renamed",
+			damage: func(t *testing.T, v *Vault, a string) {
+				if err := os.Rename(a, filepath.Join(v.Repository(), otherName)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"name+"},
+		},
+		{
+			name: "Copied",
+			damage: func(t *testing.T, v *Vault, a string) {
+				copyFile(t, a, filepath.Join(v.Repository(), otherName))
+			},
+			want: []string{"name+", "duplicate", "duplicate"},
+		},
+		{
+			name: "Padding",
+			damage: func(t *testing.T, v *Vault, a string) {
+				data := readFile(t, v, filepath.Base(a))
+				copy(data, "garbage")
+				if err := os.WriteFile(a, data, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:  []string{"header"},
+			after: []string{"header"},
+		},
+		{
+			name: "Truncated",
+			damage: func(t *testing.T, v *Vault, a string) {
+				data := readFile(t, v, filepath.Base(a))
+				if err := os.WriteFile(a, data[:len(data)-10], 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:  []string{"content"},
+			after: []string{"content"},
+		},
+		{
+			name: "Rotate",
+			damage: func(t *testing.T, v *Vault, a string) {
+				next, err := age.GenerateX25519Identity()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if _, err := v.Rotate(id.New(next, "next-key")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:  []string{"rotate", "rotate"},
+			after: []string{"rotate", "rotate"},
+		},
+		{
+			name: "PlaintextUnmodified",
+			damage: func(t *testing.T, v *Vault, a string) {
+				if err := os.WriteFile(filepath.Join(v.Repository(), "a"), []byte("content a"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: []string{"plaintext+"},
+		},
+		{
+			name: "PlaintextModified",
+			damage: func(t *testing.T, v *Vault, a string) {
+				if err := os.WriteFile(filepath.Join(v.Repository(), "a"), []byte("edited content a"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want:  []string{"plaintext"},
+			after: []string{"plaintext"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVault(t, keyX25519, t.TempDir())
+			put(t, v, "a", "work", "content a")
+			put(t, v, "b", "work", "content b")
+			h, err := v.Get("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.damage(t, v, h.Path)
+
+			problems, err := v.Check()
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if got := kinds(problems); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Fatalf("expected problems %v, got %v", tt.want, got)
+			}
+
+			for _, p := range problems {
+				err := p.Repair()
+				if p.Repairable() && err != nil {
+					t.Fatalf("Repair of %s failed: %v", p.Kind, err)
+				}
+				if !p.Repairable() && !errors.Is(err, ErrNotRepairable) {
+					t.Errorf("expected ErrNotRepairable, got %v", err)
+				}
+			}
+
+			problems, err = v.Check()
+			if err != nil {
+				t.Fatalf("Check failed: %v", err)
+			}
+			if got := kinds(problems); strings.Join(got, " ") != strings.Join(tt.after, " ") {
+				t.Fatalf("expected problems %v after repair, got %v", tt.after, got)
+			}
+
+			if len(tt.after) == 0 {
+				if r := verify(t, v); !r.OK() {
+					t.Errorf("expected the repaired files to match the manifest, got %+v", r)
+				}
+				if got := readAll(t, v, mustGet(t, v, "a")); got != "content a" {
+					t.Errorf("unexpected content %q", got)
+				}
+			}
+		})
+	}
+}
+
+// TestCheck_RotateRenamed tests that a file of a rotation encrypted with the
+// key, after the key files were swapped, is renamed.
+func TestCheck_RotateRenamed(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+	h := mustGet(t, v, "a")
+
+	rotated := strings.TrimSuffix(h.Path, Extension) + RotateSuffix + Extension
+	if err := os.Rename(h.Path, rotated); err != nil {
+		t.Fatal(err)
+	}
+
+	problems, err := v.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(problems) != 1 || problems[0].Kind != ProblemRotate || !problems[0].Repairable() {
+		t.Fatalf("expected a repairable rotate problem, got %v", kinds(problems))
+	}
+	if err := problems[0].Repair(); err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+	if _, err := os.Stat(h.Path); err != nil {
+		t.Errorf("expected the file renamed to %s: %v", h.Path, err)
+	}
+}
+
+// TestCheck_RecipientsChanged tests that a file encrypted to other
+// recipients than the ones of the vault is reencrypted to them.
+func TestCheck_RecipientsChanged(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+	old := mustGet(t, v, "a").Path
+
+	mate := newVault(t, keyX25519, v.Repository(), v)
+	mateRecipient := mate.Recipients()[0]
+	if err := WriteRecipientsFile(v.Repository(), []string{v.Recipients()[0], mateRecipient}); err != nil {
+		t.Fatal(err)
+	}
+	nv, err := New(&setup.Setup{Id: v.id, Repository: v.Repository()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	problems, err := nv.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if got := kinds(problems); strings.Join(got, " ") != "name+" {
+		t.Fatalf("expected a repairable name problem, got %v", got)
+	}
+	if err := problems[0].Repair(); err != nil {
+		t.Fatalf("Repair failed: %v", err)
+	}
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the old file to be removed, got %v", err)
+	}
+	problems, err = nv.Check()
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no problems after repair, got %v", kinds(problems))
+	}
+
+	// The file is readable by the new recipient
+	if got := readAll(t, mate, mustGet(t, mate, "a")); got != "content a" {
+		t.Errorf("unexpected content %q", got)
+	}
+}
+
+// mustGet returns the header of the label.
+func mustGet(t *testing.T, v *Vault, label string) *header.Header {
+	t.Helper()
+	h, err := v.Get(label)
+	if err != nil {
+		t.Fatalf("Get %s failed: %v", label, err)
+	}
+	return h
+}
+
+// copyFile copies the file src to dst.
+func copyFile(t *testing.T, src, dst string) {
+	t.Helper()
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
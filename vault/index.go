@@ -0,0 +1,225 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"filippo.io/age"
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/revelaction/privage/header"
+)
+
+const (
+	// IndexFileName is the name of the encrypted header index in the
+	// repository. It does not follow the privage naming convention, so it is
+	// never taken for an encrypted file.
+	IndexFileName = ".privage-index"
+)
+
+// indexEntry is the cached header of a privage file, together with the
+// size and modification time of the file when the header was decrypted.
+type indexEntry struct {
+	File        string `toml:"file"`
+	FileSize    int64  `toml:"file_size"`
+	FileModTime int64  `toml:"file_mod_time"`
+
+	Version     string    `toml:"version"`
+	Category    string    `toml:"category"`
+	Label       string    `toml:"label"`
+	Created     time.Time `toml:"created"`
+	Modified    time.Time `toml:"modified"`
+	Size        int64     `toml:"size"`
+	SHA256      string    `toml:"sha256"`
+	ContentType string    `toml:"content_type"`
+	Tags        []string  `toml:"tags"`
+}
+
+// newIndexEntry returns the index entry of the header h of a file with
+// info.
+func newIndexEntry(h *header.Header, info os.FileInfo) indexEntry {
+	return indexEntry{
+		File:        filepath.Base(h.Path),
+		FileSize:    info.Size(),
+		FileModTime: info.ModTime().UnixNano(),
+		Version:     h.Version,
+		Category:    h.Category,
+		Label:       h.Label,
+		Created:     h.Created,
+		Modified:    h.Modified,
+		Size:        h.Size,
+		SHA256:      hex.EncodeToString(h.SHA256),
+		ContentType: h.ContentType,
+		Tags:        h.Tags,
+	}
+}
+
+// header returns the cached header of the file in path.
+func (e indexEntry) header(path string) *header.Header {
+	h := &header.Header{
+		Version:     e.Version,
+		Category:    e.Category,
+		Label:       e.Label,
+		Created:     e.Created,
+		Modified:    e.Modified,
+		Size:        e.Size,
+		ContentType: e.ContentType,
+		Tags:        e.Tags,
+		Path:        path,
+	}
+
+	if sum, err := hex.DecodeString(e.SHA256); err == nil && len(sum) > 0 {
+		h.SHA256 = sum
+	}
+
+	return h
+}
+
+// index maps the headers (label and category) to the hashed file names of
+// the repository.
+type index struct {
+	Entries []indexEntry `toml:"entries"`
+}
+
+// indexedHeaders returns the headers of all the files in the repository,
+// in the lexical order of the file names.
+//
+// Headers of files whose size and modification time match the index are
+// taken from it. The rest are decrypted, and the index is saved again if it
+// was stale. Files that can not be decrypted are never cached.
+func (v *Vault) indexedHeaders() ([]*header.Header, error) {
+	paths, err := privagePaths(v.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := v.loadIndex()
+
+	headers := make([]*header.Header, len(paths))
+	stats := make([]os.FileInfo, len(paths))
+	var stale []int
+	for i, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			headers[i] = &header.Header{Path: path, Err: fmt.Errorf("could not stat file %s: %w", path, err)}
+			continue
+		}
+		stats[i] = info
+
+		e, ok := cached[filepath.Base(path)]
+		if ok && e.FileSize == info.Size() && e.FileModTime == info.ModTime().UnixNano() {
+			headers[i] = e.header(path)
+			continue
+		}
+
+		stale = append(stale, i)
+	}
+
+	stalePaths := make([]string, len(stale))
+	for j, i := range stale {
+		stalePaths[j] = paths[i]
+	}
+
+	j := 0
+	for h := range readHeaders(stalePaths, v.id, v.workers) {
+		headers[stale[j]] = h
+		j++
+	}
+
+	fresh := index{}
+	for i, h := range headers {
+		if h.Err != nil {
+			continue
+		}
+		fresh.Entries = append(fresh.Entries, newIndexEntry(h, stats[i]))
+	}
+
+	if len(fresh.Entries) != len(cached) || len(stale) > 0 {
+		// The index is a cache: failing to save it must not fail a
+		// (possibly read-only) command.
+		_ = v.saveIndex(fresh)
+	}
+
+	return headers, nil
+}
+
+// loadIndex returns the entries of the index by file name.
+//
+// A missing, unreadable or not decryptable index (for example after a key
+// rotation) results in an empty map, so the index is rebuilt.
+func (v *Vault) loadIndex() map[string]indexEntry {
+	entries := map[string]indexEntry{}
+
+	f, err := os.Open(filepath.Join(v.repository, IndexFileName))
+	if err != nil {
+		return entries
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	r, err := age.Decrypt(f, v.id.Id)
+	if err != nil {
+		return entries
+	}
+
+	var idx index
+	if err := toml.NewDecoder(r).Decode(&idx); err != nil {
+		return entries
+	}
+
+	for _, e := range idx.Entries {
+		entries[e.File] = e
+	}
+
+	return entries
+}
+
+// saveIndex encrypts the index to the vault identity and writes it
+// atomically in the repository.
+//
+// The index is also written by the commands that do not take the lock of
+// the repository, so each write uses its own temporary file.
+func (v *Vault) saveIndex(idx index) (err error) {
+	buf := new(bytes.Buffer)
+	if err := toml.NewEncoder(buf).Encode(idx); err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
+	}
+
+	path := filepath.Join(v.repository, IndexFileName)
+
+	f, err := os.CreateTemp(v.repository, IndexFileName+".*"+tmpExtension)
+	if err != nil {
+		return fmt.Errorf("failed to create temporary index file: %w", err)
+	}
+	tmpPath := f.Name()
+	defer func() {
+		if err != nil {
+			err = errors.Join(err, os.Remove(tmpPath))
+		}
+	}()
+
+	ageWr, err := age.Encrypt(f, v.id.Recipient)
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to create age encryptor for index: %w", err), f.Close())
+	}
+
+	if _, err := buf.WriteTo(ageWr); err != nil {
+		return errors.Join(fmt.Errorf("failed to write index: %w", err), ageWr.Close(), f.Close())
+	}
+
+	if err := ageWr.Close(); err != nil {
+		return errors.Join(fmt.Errorf("failed to close index encryptor: %w", err), f.Close())
+	}
+
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to close index file: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
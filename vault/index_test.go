@@ -0,0 +1,243 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+
+	id "github.com/revelaction/privage/identity"
+)
+
+// newIndexedVault returns a test vault with the header index enabled.
+func newIndexedVault(t *testing.T) *Vault {
+	t.Helper()
+	v := newVault(t, keyX25519, t.TempDir())
+	v.index = true
+	return v
+}
+
+func TestIndex_Created(t *testing.T) {
+	v := newIndexedVault(t)
+	put(t, v, "a", "work", "content a")
+	put(t, v, "b", "credential", "content b")
+
+	headers, err := v.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(headers) != 2 {
+		t.Fatalf("expected 2 headers, got %d", len(headers))
+	}
+
+	if _, err := os.Stat(filepath.Join(v.Repository(), IndexFileName)); err != nil {
+		t.Fatalf("expected index file: %v", err)
+	}
+	if IsPrivageFile(IndexFileName) {
+		t.Errorf("index file must not be a privage file")
+	}
+
+	entries := v.loadIndex()
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 index entries, got %d", len(entries))
+	}
+	for _, h := range headers {
+		e, ok := entries[filepath.Base(h.Path)]
+		if !ok {
+			t.Fatalf("no index entry for %s", h.Path)
+		}
+		if e.Label != h.Label || e.Category != h.Category {
+			t.Errorf("index entry %+v does not match header %s/%s", e, h.Category, h.Label)
+		}
+	}
+}
+
+func TestIndex_Cached(t *testing.T) {
+	v := newIndexedVault(t)
+	put(t, v, "a", "work", "content a")
+
+	h, err := v.Get("a")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	// Garble the file keeping its size and modification time: only the
+	// index can provide the header now.
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(h.Path, make([]byte, info.Size()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(h.Path, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	cached, err := v.Get("a")
+	if err != nil {
+		t.Fatalf("expected header from index, got %v", err)
+	}
+	if cached.Category != "work" || cached.Path != h.Path {
+		t.Errorf("unexpected cached header %+v", cached)
+	}
+	if cached.Size != h.Size || !bytes.Equal(cached.SHA256, h.SHA256) || !cached.Created.Equal(h.Created) {
+		t.Errorf("cached header %+v does not match %+v", cached, h)
+	}
+
+	// Without the index the file can not be decrypted
+	v.index = false
+	if _, err := v.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound without index, got %v", err)
+	}
+}
+
+func TestIndex_Stale(t *testing.T) {
+	v := newIndexedVault(t)
+	put(t, v, "a", "work", "content a")
+	put(t, v, "b", "work", "content b")
+
+	if _, err := v.List(); err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	t.Run("Add", func(t *testing.T) {
+		put(t, v, "c", "personal", "content c")
+		h, err := v.Get("c")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if h.Category != "personal" {
+			t.Errorf("expected category personal, got %q", h.Category)
+		}
+		if n := len(v.loadIndex()); n != 3 {
+			t.Errorf("expected 3 index entries, got %d", n)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		h, err := v.Get("b")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if err := v.Delete(h); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := v.Get("b"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+		if n := len(v.loadIndex()); n != 2 {
+			t.Errorf("expected 2 index entries, got %d", n)
+		}
+	})
+
+	t.Run("Modified", func(t *testing.T) {
+		h, err := v.Get("a")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+
+		// Overwrite the file of a with the one of d (same size) and move
+		// its modification time.
+		put(t, v, "d", "work", "content d")
+		dh, err := v.Get("d")
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := os.ReadFile(dh.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(h.Path, content, 0600); err != nil {
+			t.Fatal(err)
+		}
+		later := time.Now().Add(time.Hour)
+		if err := os.Chtimes(h.Path, later, later); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := v.Get("a"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected stale entry for a to be rebuilt, got %v", err)
+		}
+	})
+}
+
+func TestIndex_OtherIdentity(t *testing.T) {
+	v := newIndexedVault(t)
+	put(t, v, "a", "work", "content a")
+	if _, err := v.List(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Same repository, new key: the index can not be decrypted and is
+	// rebuilt for the new identity.
+	next, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nv := &Vault{repository: v.Repository(), id: id.New(next, "next-key"), workers: 1, index: true}
+
+	headers, err := nv.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(headers) != 1 || headers[0].Err == nil {
+		t.Fatalf("expected 1 header with error, got %v", headers)
+	}
+
+	if n := len(nv.loadIndex()); n != 0 {
+		t.Errorf("expected empty index for the new identity, got %d entries", n)
+	}
+
+	// Failures are not cached: the error type survives for Rotate
+	var e *age.NoIdentityMatchError
+	headers, err = nv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.As(headers[0].Err, &e) {
+		t.Errorf("expected NoIdentityMatchError, got %v", headers[0].Err)
+	}
+}
+
+// TestIndex_ConcurrentSave tests that concurrent writes of the index, as of
+// several commands that do not take the lock, do not fail or leave
+// temporary files.
+func TestIndex_ConcurrentSave(t *testing.T) {
+	v := newIndexedVault(t)
+	put(t, v, "a", "work", "content a")
+	if _, err := v.List(); err != nil {
+		t.Fatal(err)
+	}
+
+	var idx index
+	for _, e := range v.loadIndex() {
+		idx.Entries = append(idx.Entries, e)
+	}
+
+	const n = 32
+	errs := make(chan error, n)
+	for range n {
+		go func() { errs <- v.saveIndex(idx) }()
+	}
+	for range n {
+		if err := <-errs; err != nil {
+			t.Errorf("saveIndex failed: %v", err)
+		}
+	}
+
+	if entries := v.loadIndex(); len(entries) != 1 {
+		t.Errorf("expected 1 index entry, got %d", len(entries))
+	}
+	problems, err := tempProblems(v.Repository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Errorf("expected no temporary files, got %v", kinds(problems))
+	}
+}
@@ -0,0 +1,163 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LockFileName is the name of the advisory lock file of the repository,
+// created by the commands that modify it.
+const LockFileName = ".privage-lock"
+
+// lockPollInterval is the interval between the attempts to take a held lock.
+const lockPollInterval = 100 * time.Millisecond
+
+// ErrLocked is returned when the repository is locked by another process.
+var ErrLocked = errors.New("the repository is locked")
+
+// Lock is an advisory lock of a repository, held by this process.
+type Lock struct {
+	path string
+
+	// f is the lock file, open while the lock is held, so that a
+	// concurrent takeover of a stale lock can not remove it.
+	f *os.File
+}
+
+// lockOwner is the process that holds a lock, as written in the lock file.
+type lockOwner struct {
+	pid      int
+	hostname string
+	since    time.Time
+}
+
+func (o lockOwner) String() string {
+	return fmt.Sprintf("process %d on %s since %s", o.pid, o.hostname, o.since.Format(time.DateTime))
+}
+
+// LockRepository takes the lock of the repository in repoDir. If another
+// process holds it, LockRepository waits for it to be released up to wait,
+// and then returns ErrLocked.
+//
+// A lock held by a process of this host that is not running anymore is
+// stale, and is taken over. The processes of other hosts, f. ex. with a
+// repository in a network file system, can not be checked. The lock file is
+// kept open while the lock is held: a stale lock file is only removed if no
+// process holds it open, so that of several processes taking over the same
+// stale lock, only one takes the lock.
+func LockRepository(repoDir string, wait time.Duration) (*Lock, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("could not get the hostname: %w", err)
+	}
+
+	l := &Lock{path: filepath.Join(repoDir, LockFileName)}
+	self := lockOwner{pid: os.Getpid(), hostname: hostname, since: time.Now()}
+	deadline := time.Now().Add(wait)
+	for {
+		err := l.create(self)
+		if err == nil {
+			return l, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, fmt.Errorf("could not create lock file %s: %w", l.path, err)
+		}
+
+		owner := l.owner()
+
+		if owner != nil && owner.hostname == hostname && !processRunning(owner.pid) {
+			// Stale lock of a process killed before releasing it
+			removed, err := removeStale(l.path)
+			if err != nil {
+				return nil, fmt.Errorf("could not remove stale lock file %s: %w", l.path, err)
+			}
+			if removed {
+				continue
+			}
+		}
+
+		if time.Now().After(deadline) {
+			if owner == nil {
+				return nil, fmt.Errorf("%w by the file %s", ErrLocked, l.path)
+			}
+			return nil, fmt.Errorf("%w by privage %s", ErrLocked, owner)
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// create creates the lock file with the owner self, and keeps it open. It
+// returns an error matching os.ErrExist if the lock file exists.
+func (l *Lock) create(self lockOwner) error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	err = holdFile(f)
+	if err == nil {
+		_, err = fmt.Fprintf(f, "%d %s %s\n", self.pid, self.hostname, self.since.Format(time.RFC3339))
+	}
+	if err != nil {
+		return errors.Join(err, f.Close(), os.Remove(l.path))
+	}
+
+	l.f = f
+	return nil
+}
+
+// owner returns the owner of the existing lock file, or nil if it can not be
+// read or parsed, f. ex. while it is being written.
+func (l *Lock) owner() *lockOwner {
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil
+	}
+
+	owner, err := parseLockOwner(string(data))
+	if err != nil {
+		return nil
+	}
+
+	return owner
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	// The file is closed first, as an open file can not be removed in
+	// Windows.
+	if err := l.f.Close(); err != nil {
+		return errors.Join(fmt.Errorf("could not close lock file %s: %w", l.path, err), os.Remove(l.path))
+	}
+	if err := os.Remove(l.path); err != nil {
+		return fmt.Errorf("could not remove lock file %s: %w", l.path, err)
+	}
+
+	return nil
+}
+
+// parseLockOwner parses the content of a lock file: the pid, hostname and
+// time of the process that holds the lock.
+func parseLockOwner(data string) (*lockOwner, error) {
+	fields := strings.Fields(data)
+	if len(fields) != 3 {
+		return nil, errors.New("invalid lock file")
+	}
+
+	pid, err := strconv.Atoi(fields[0])
+	if err != nil || pid <= 0 {
+		return nil, errors.New("invalid pid in lock file")
+	}
+
+	since, err := time.Parse(time.RFC3339, fields[2])
+	if err != nil {
+		return nil, fmt.Errorf("invalid time in lock file: %w", err)
+	}
+
+	return &lockOwner{pid: pid, hostname: fields[1], since: since}, nil
+}
@@ -0,0 +1,34 @@
+//go:build !unix && !windows
+
+package vault
+
+import (
+	"errors"
+	"os"
+)
+
+// processRunning reports whether the process pid of this host is running.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
+
+// holdFile does nothing, as the lock file can not be held open in this
+// system.
+func holdFile(f *os.File) error {
+	return nil
+}
+
+// removeStale removes the lock file in path of a process that is not
+// running.
+func removeStale(path string) (bool, error) {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	return true, nil
+}
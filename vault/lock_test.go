@@ -0,0 +1,171 @@
+package vault
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeLock writes a lock file of the process pid of hostname in dir.
+func writeLock(t *testing.T, dir string, pid int, hostname string) {
+	t.Helper()
+	content := fmt.Sprintf("%d %s %s\n", pid, hostname, time.Now().Format(time.RFC3339))
+	if err := os.WriteFile(filepath.Join(dir, LockFileName), []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// exitedPid returns the pid of a process that is not running anymore.
+func exitedPid(t *testing.T) int {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestLockRepository(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// lock prepares the lock file of the repository dir.
+		lock    func(t *testing.T, dir string)
+		wantErr string
+	}{
+		{
+			name: "Free",
+			lock: func(t *testing.T, dir string) {},
+		},
+		{
+			name:    "Held",
+			lock:    func(t *testing.T, dir string) { writeLock(t, dir, os.Getppid(), hostname) },
+			wantErr: fmt.Sprintf("locked by privage process %d on %s", os.Getppid(), hostname),
+		},
+		{
+			name: "Stale",
+			lock: func(t *testing.T, dir string) { writeLock(t, dir, exitedPid(t), hostname) },
+		},
+		{
+			name:    "OtherHost",
+			lock:    func(t *testing.T, dir string) { writeLock(t, dir, exitedPid(t), "other-host") },
+			wantErr: "on other-host",
+		},
+		{
+			name: "Invalid",
+			lock: func(t *testing.T, dir string) {
+				if err := os.WriteFile(filepath.Join(dir, LockFileName), nil, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: "locked by the file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			tt.lock(t, dir)
+
+			l, err := LockRepository(dir, 0)
+			if tt.wantErr != "" {
+				if !errors.Is(err, ErrLocked) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected ErrLocked with %q, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LockRepository failed: %v", err)
+			}
+
+			data, err := os.ReadFile(filepath.Join(dir, LockFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(data), fmt.Sprintf("%d %s ", os.Getpid(), hostname)) {
+				t.Errorf("unexpected lock file %q", data)
+			}
+
+			if err := l.Unlock(); err != nil {
+				t.Fatalf("Unlock failed: %v", err)
+			}
+			if _, err := os.Stat(filepath.Join(dir, LockFileName)); !errors.Is(err, os.ErrNotExist) {
+				t.Errorf("expected the lock file to be removed, got %v", err)
+			}
+		})
+	}
+}
+
+// TestLockRepository_Wait tests that a lock released while waiting is taken.
+func TestLockRepository_Wait(t *testing.T) {
+	dir := t.TempDir()
+	held, err := LockRepository(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LockRepository(dir, 2*lockPollInterval); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected ErrLocked after waiting, got %v", err)
+	}
+
+	go func() {
+		time.Sleep(3 * lockPollInterval)
+		_ = held.Unlock()
+	}()
+
+	l, err := LockRepository(dir, 10*time.Second)
+	if err != nil {
+		t.Fatalf("LockRepository failed: %v", err)
+	}
+	if err := l.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestLockRepository_StaleTakeover tests that of several processes taking
+// over the same stale lock, only one takes it.
+func TestLockRepository_StaleTakeover(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeLock(t, dir, exitedPid(t), hostname)
+
+	const n = 8
+	locks := make(chan *Lock, n)
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l, err := LockRepository(dir, 0)
+			if err != nil {
+				if !errors.Is(err, ErrLocked) {
+					t.Errorf("expected ErrLocked, got %v", err)
+				}
+				return
+			}
+			locks <- l
+		}()
+	}
+	wg.Wait()
+	close(locks)
+
+	if len(locks) != 1 {
+		t.Fatalf("expected the lock to be taken once, got %d", len(locks))
+	}
+	if err := (<-locks).Unlock(); err != nil {
+		t.Fatal(err)
+	}
+}
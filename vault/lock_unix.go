@@ -0,0 +1,72 @@
+//go:build unix
+
+package vault
+
+import (
+	"errors"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// processRunning reports whether the process pid of this host is running.
+func processRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// holdFile takes the flock of the lock file f, released when f is closed or
+// the process exits.
+func holdFile(f *os.File) error {
+	return unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+}
+
+// removeStale removes the lock file in path of a process that is not
+// running. It returns false if another process holds the lock file, f. ex.
+// after taking it over.
+//
+// The file is removed with its flock taken, and only if it is still the
+// file in path, so that a lock file created meanwhile is not removed.
+func removeStale(path string) (removed bool, err error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if err := holdFile(f); err != nil {
+		if errors.Is(err, unix.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, err
+	}
+
+	held, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+	current, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if !os.SameFile(held, current) {
+		return false, nil
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -0,0 +1,41 @@
+//go:build windows
+
+package vault
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// processRunning reports whether the process pid of this host is running.
+func processRunning(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = p.Release()
+	return true
+}
+
+// holdFile does nothing: the open lock file f can not be removed by other
+// processes.
+func holdFile(f *os.File) error {
+	return nil
+}
+
+// removeStale removes the lock file in path of a process that is not
+// running. It returns false if another process holds the lock file open,
+// f. ex. after taking it over.
+func removeStale(path string) (bool, error) {
+	err := os.Remove(path)
+	if errors.Is(err, windows.ERROR_SHARING_VIOLATION) {
+		return false, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	return true, nil
+}
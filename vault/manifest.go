@@ -0,0 +1,494 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ManifestFileName is the name of the signed manifest of the repository:
+	// the names of the encrypted files and the SHA-256 of their content. It
+	// is shared with the files, f. ex. in git.
+	ManifestFileName = ".privage-manifest"
+
+	// manifestSeenFileName is the name of the file with the highest
+	// generation of the manifest seen in this copy of the repository, to
+	// detect a rolled back manifest. It is not shared.
+	manifestSeenFileName = ".privage-manifest-seen"
+
+	// manifestHeader is the first line of the manifest.
+	manifestHeader = "# privage manifest"
+)
+
+var (
+	// ErrNoManifest is returned when the repository has no manifest.
+	ErrNoManifest = errors.New("found no manifest")
+
+	// ErrManifest is returned when the manifest can not be parsed, its
+	// signature does not match, or it was rolled back or removed.
+	ErrManifest = errors.New("invalid manifest")
+
+	// ErrUntrustedManifest is returned when the manifest to be updated is
+	// signed by another key than the one of the vault identity.
+	ErrUntrustedManifest = errors.New("the manifest is signed by another key")
+)
+
+// manifest is the parsed manifest of the repository.
+type manifest struct {
+	// generation is incremented each time the manifest is signed.
+	generation uint64
+	signer     ed25519.PublicKey
+	// files maps the file names to the hex encoded SHA-256 of the files.
+	files map[string]string
+}
+
+// ManifestReport is the result of the verification of the files of the
+// repository against the manifest.
+type ManifestReport struct {
+	// Generation is the generation of the manifest.
+	Generation uint64
+
+	// Signer is the public key that signed the manifest.
+	Signer ed25519.PublicKey
+
+	// Trusted reports whether the manifest is signed by the key of the
+	// vault identity, or by a trusted signer.
+	Trusted bool
+
+	// Unknown are the paths of the files not in the manifest, f. ex. forged.
+	Unknown []string
+
+	// Missing are the paths of the files of the manifest not in the
+	// repository, f. ex. deleted.
+	Missing []string
+
+	// Changed are the paths of the files whose content does not match the
+	// manifest, f. ex. rolled back to a previous version.
+	Changed []string
+}
+
+// OK reports whether the manifest is trusted and matches all the files of
+// the repository.
+func (r *ManifestReport) OK() bool {
+	return r.Trusted && len(r.Unknown)+len(r.Missing)+len(r.Changed) == 0
+}
+
+// VerifyManifest verifies the files of the repository against the
+// manifest. It returns ErrNoManifest if the repository has no manifest, and
+// ErrManifest if the manifest is not valid, or was rolled back to a previous
+// generation or removed since it was last seen in this copy of the
+// repository.
+func (v *Vault) VerifyManifest() (*ManifestReport, error) {
+	m, err := v.loadManifest()
+	if err != nil {
+		return nil, err
+	}
+
+	seen, err := v.seenGeneration()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &ManifestReport{
+		Generation: m.generation,
+		Signer:     m.signer,
+		Trusted:    v.trusts(m.signer),
+	}
+
+	paths, err := privagePaths(v.repository)
+	if err != nil {
+		return nil, err
+	}
+
+	present := map[string]bool{}
+	for _, path := range paths {
+		name := filepath.Base(path)
+		present[name] = true
+
+		want, ok := m.files[name]
+		if !ok {
+			r.Unknown = append(r.Unknown, path)
+			continue
+		}
+
+		sum, err := fileSum(path)
+		if err != nil {
+			return nil, err
+		}
+		if sum != want {
+			r.Changed = append(r.Changed, path)
+		}
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(m.files)) {
+		if !present[name] {
+			r.Missing = append(r.Missing, filepath.Join(v.repository, name))
+		}
+	}
+
+	if r.Trusted && m.generation > seen {
+		if err := v.saveSeenGeneration(m.generation); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// SignManifest signs the manifest of all the files currently in the
+// repository, replacing an existing one, and returns the number of files.
+func (v *Vault) SignManifest() (int, error) {
+	if v.signer == nil {
+		return 0, fmt.Errorf("the %s key can not sign the manifest", v.id.Kind())
+	}
+
+	paths, err := privagePaths(v.repository)
+	if err != nil {
+		return 0, err
+	}
+
+	m := &manifest{files: map[string]string{}}
+	if old, err := v.loadManifest(); err == nil {
+		m.generation = old.generation
+	}
+
+	for _, path := range paths {
+		sum, err := fileSum(path)
+		if err != nil {
+			return 0, err
+		}
+		m.files[filepath.Base(path)] = sum
+	}
+
+	if err := v.saveManifest(m); err != nil {
+		return 0, err
+	}
+
+	return len(paths), nil
+}
+
+// recordFile updates the entry of the file in path in the manifest, or
+// removes it if the file does not exist.
+//
+// The manifest is not kept if the vault identity can not sign it. A
+// missing manifest is created only if the repository has no other files, as
+// those would be accepted without verification: see SignManifest. A
+// manifest signed by another key is not updated: see trustedManifest, and
+// checkManifest to check it before changing the file.
+func (v *Vault) recordFile(path string) error {
+	if v.signer == nil {
+		return nil
+	}
+
+	m, err := v.trustedManifest()
+	if errors.Is(err, ErrNoManifest) {
+		paths, err := privagePaths(v.repository)
+		if err != nil {
+			return err
+		}
+		if slices.ContainsFunc(paths, func(p string) bool { return p != path }) {
+			return nil
+		}
+		m = &manifest{files: map[string]string{}}
+	} else if err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	sum, err := fileSum(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		delete(m.files, name)
+	case err != nil:
+		return err
+	default:
+		m.files[name] = sum
+	}
+
+	return v.saveManifest(m)
+}
+
+// renameManifestEntry renames the entry of the file name in the manifest to
+// target, keeping its checksum. A file not in the manifest is not added.
+func (v *Vault) renameManifestEntry(name, target string) error {
+	if v.signer == nil {
+		return nil
+	}
+
+	m, err := v.trustedManifest()
+	if errors.Is(err, ErrNoManifest) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	sum, ok := m.files[name]
+	if !ok {
+		return nil
+	}
+	delete(m.files, name)
+	m.files[target] = sum
+
+	return v.saveManifest(m)
+}
+
+// trustedManifest returns the manifest of the repository to be updated. It
+// returns ErrUntrustedManifest if it is not signed by a trusted key, as its
+// entries would be signed again with the key of the vault identity without
+// being verified.
+func (v *Vault) trustedManifest() (*manifest, error) {
+	m, err := v.loadManifest()
+	if errors.Is(err, ErrNoManifest) {
+		return nil, err
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not update the manifest: %w", err)
+	}
+
+	if !v.trusts(m.signer) {
+		return nil, fmt.Errorf("%w and was not updated: check the files with \"privage verify\" and sign them with \"privage verify --sign\"", ErrUntrustedManifest)
+	}
+
+	return m, nil
+}
+
+// checkManifest returns the error of trustedManifest, if any, when the
+// manifest of the repository can not be updated. It is called before a
+// file is written or removed, so that the repository is left unchanged.
+func (v *Vault) checkManifest() error {
+	if v.signer == nil {
+		return nil
+	}
+
+	_, err := v.trustedManifest()
+	if errors.Is(err, ErrNoManifest) {
+		return nil
+	}
+
+	return err
+}
+
+// trusts reports whether a manifest signed by the public key signer is
+// trusted: signed by the key of the vault identity or by a trusted signer.
+func (v *Vault) trusts(signer ed25519.PublicKey) bool {
+	if v.signer != nil && signer.Equal(v.signer.Public()) {
+		return true
+	}
+
+	return slices.ContainsFunc(v.trustedSigners, func(k ed25519.PublicKey) bool {
+		return k.Equal(signer)
+	})
+}
+
+// SignerKey returns the public key, base64 encoded, that signs the manifest
+// of the repository, or "" if the identity can not sign it. It is added to
+// the manifest signers of the configuration of the teammates.
+func (v *Vault) SignerKey() string {
+	if v.signer == nil {
+		return ""
+	}
+
+	return base64.StdEncoding.EncodeToString(v.signer.Public().(ed25519.PublicKey))
+}
+
+// setTrustedSigners sets the trusted signers of the manifest from the base64
+// encoded public keys.
+func (v *Vault) setTrustedSigners(keys []string) error {
+	v.trustedSigners = nil
+	for _, k := range keys {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("invalid manifest signer %q", k)
+		}
+		v.trustedSigners = append(v.trustedSigners, key)
+	}
+
+	return nil
+}
+
+// loadManifest returns the manifest of the repository, with a valid
+// signature of any key. It returns ErrNoManifest if there is none, and
+// ErrManifest if it is not valid, if a higher generation was seen, or if it
+// was removed after being seen.
+func (v *Vault) loadManifest() (*manifest, error) {
+	seen, err := v.seenGeneration()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(v.repository, ManifestFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		if seen > 0 {
+			return nil, fmt.Errorf("%w: the manifest of generation %d was removed", ErrManifest, seen)
+		}
+		return nil, ErrNoManifest
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read manifest: %w", err)
+	}
+
+	m, err := parseManifest(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if seen > m.generation {
+		return nil, fmt.Errorf("%w: the manifest was rolled back from generation %d to %d", ErrManifest, seen, m.generation)
+	}
+
+	return m, nil
+}
+
+// saveManifest signs the manifest m, with the next generation, and writes it
+// atomically in the repository.
+func (v *Vault) saveManifest(m *manifest) error {
+	seen, err := v.seenGeneration()
+	if err != nil {
+		return err
+	}
+
+	m.generation = max(m.generation, seen) + 1
+	m.signer = v.signer.Public().(ed25519.PublicKey)
+
+	data := m.body()
+	sig := ed25519.Sign(v.signer, data)
+	data = fmt.Appendf(data, "signature %s\n", base64.StdEncoding.EncodeToString(sig))
+
+	path := filepath.Join(v.repository, ManifestFileName)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("could not write manifest: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return errors.Join(fmt.Errorf("could not write manifest: %w", err), os.Remove(tmpPath))
+	}
+
+	return v.saveSeenGeneration(m.generation)
+}
+
+// body returns the signed part of the manifest, with the files sorted by
+// name.
+func (m *manifest) body() []byte {
+	var b bytes.Buffer
+	b.WriteString(manifestHeader + "\n")
+	fmt.Fprintf(&b, "generation %d\n", m.generation)
+	fmt.Fprintf(&b, "signer %s\n", base64.StdEncoding.EncodeToString(m.signer))
+	for _, name := range slices.Sorted(maps.Keys(m.files)) {
+		fmt.Fprintf(&b, "file %s %s\n", m.files[name], name)
+	}
+
+	return b.Bytes()
+}
+
+// parseManifest parses the manifest in data and verifies its signature with
+// the key of its signer line.
+func parseManifest(data []byte) (*manifest, error) {
+	i := bytes.LastIndex(data, []byte("\nsignature "))
+	if i < 0 {
+		return nil, fmt.Errorf("%w: found no signature", ErrManifest)
+	}
+	body, sigLine := data[:i+1], strings.TrimSpace(string(data[i+1:]))
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sigLine, "signature "))
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signature: %v", ErrManifest, err)
+	}
+
+	m := &manifest{files: map[string]string{}}
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if lineNum == 1 {
+			if line != manifestHeader {
+				return nil, fmt.Errorf("%w: expected header %q", ErrManifest, manifestHeader)
+			}
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 2 && fields[0] == "generation":
+			m.generation, err = strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid generation in line %d", ErrManifest, lineNum)
+			}
+		case len(fields) == 2 && fields[0] == "signer":
+			key, err := base64.StdEncoding.DecodeString(fields[1])
+			if err != nil || len(key) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("%w: invalid signer in line %d", ErrManifest, lineNum)
+			}
+			m.signer = key
+		case len(fields) == 3 && fields[0] == "file" && IsPrivageFile(fields[2]):
+			m.files[fields[2]] = fields[1]
+		default:
+			return nil, fmt.Errorf("%w: invalid line %d", ErrManifest, lineNum)
+		}
+	}
+
+	if m.signer == nil {
+		return nil, fmt.Errorf("%w: found no signer", ErrManifest)
+	}
+	if !ed25519.Verify(m.signer, body, sig) {
+		return nil, fmt.Errorf("%w: the signature does not match", ErrManifest)
+	}
+
+	return m, nil
+}
+
+// seenGeneration returns the highest generation of the manifest seen in
+// this copy of the repository, or 0.
+func (v *Vault) seenGeneration() (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(v.repository, manifestSeenFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	seen, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid generation in %s: %w", manifestSeenFileName, err)
+	}
+
+	return seen, nil
+}
+
+func (v *Vault) saveSeenGeneration(generation uint64) error {
+	path := filepath.Join(v.repository, manifestSeenFileName)
+	return os.WriteFile(path, []byte(strconv.FormatUint(generation, 10)+"\n"), 0600)
+}
+
+// fileSum returns the hex encoded SHA-256 of the file in path.
+func fileSum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return "", fmt.Errorf("could not read file %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(sum.Sum(nil)), nil
+}
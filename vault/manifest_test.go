@@ -0,0 +1,339 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/config"
+	"github.com/revelaction/privage/header"
+	id "github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+)
+
+// verify returns the manifest report of the vault.
+func verify(t *testing.T, v *Vault) *ManifestReport {
+	t.Helper()
+	r, err := v.VerifyManifest()
+	if err != nil {
+		t.Fatalf("VerifyManifest failed: %v", err)
+	}
+	return r
+}
+
+// readFile returns the content of the file in the repository of the vault.
+func readFile(t *testing.T, v *Vault, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(v.Repository(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestManifest_Recorded(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+	put(t, v, "b", "work", "content b")
+
+	r := verify(t, v)
+	if !r.OK() || r.Generation != 2 {
+		t.Fatalf("expected a trusted manifest of generation 2, got %+v", r)
+	}
+
+	h, err := v.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Delete(h); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	put(t, v, "b", "work", "new content b")
+
+	r = verify(t, v)
+	if !r.OK() || r.Generation != 4 {
+		t.Errorf("expected a trusted manifest of generation 4, got %+v", r)
+	}
+	if !strings.Contains(string(readFile(t, v, ManifestFileName)), "\nsignature ") {
+		t.Errorf("expected a signed manifest")
+	}
+}
+
+func TestManifest_Files(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, v *Vault, path string)
+		check  func(r *ManifestReport) bool
+	}{
+		{
+			name: "Unknown",
+			tamper: func(t *testing.T, v *Vault, path string) {
+				forged := filepath.Join(v.Repository(), strings.Repeat("f", hexLen)+Extension)
+				if err := os.WriteFile(forged, readFile(t, v, filepath.Base(path)), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(r *ManifestReport) bool { return len(r.Unknown) == 1 && len(r.Missing)+len(r.Changed) == 0 },
+		},
+		{
+			name: "Missing",
+			tamper: func(t *testing.T, v *Vault, path string) {
+				if err := os.Remove(path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(r *ManifestReport) bool { return len(r.Missing) == 1 && len(r.Unknown)+len(r.Changed) == 0 },
+		},
+		{
+			name: "RolledBack",
+			tamper: func(t *testing.T, v *Vault, path string) {
+				old := readFile(t, v, filepath.Base(path))
+				put(t, v, "a", "work", "new content a")
+				if err := os.WriteFile(path, old, 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			check: func(r *ManifestReport) bool { return len(r.Changed) == 1 && len(r.Unknown)+len(r.Missing) == 0 },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVault(t, keyX25519, t.TempDir())
+			put(t, v, "a", "work", "content a")
+			put(t, v, "b", "work", "content b")
+			h, err := v.Get("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.tamper(t, v, h.Path)
+
+			r := verify(t, v)
+			if r.OK() || !r.Trusted || !tt.check(r) {
+				t.Errorf("unexpected report %+v", r)
+			}
+		})
+	}
+}
+
+func TestManifest_Invalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(t *testing.T, v *Vault, manifest []byte)
+		want   string
+	}{
+		{
+			name: "Forged",
+			tamper: func(t *testing.T, v *Vault, manifest []byte) {
+				forged := strings.Replace(string(manifest), "generation 2", "generation 3", 1)
+				if err := os.WriteFile(filepath.Join(v.Repository(), ManifestFileName), []byte(forged), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "signature does not match",
+		},
+		{
+			name: "RolledBack",
+			tamper: func(t *testing.T, v *Vault, manifest []byte) {
+				put(t, v, "c", "work", "content c")
+				if err := os.WriteFile(filepath.Join(v.Repository(), ManifestFileName), manifest, 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "rolled back from generation 3 to 2",
+		},
+		{
+			name: "Removed",
+			tamper: func(t *testing.T, v *Vault, manifest []byte) {
+				if err := os.Remove(filepath.Join(v.Repository(), ManifestFileName)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: "generation 2 was removed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newVault(t, keyX25519, t.TempDir())
+			put(t, v, "a", "work", "content a")
+			put(t, v, "b", "work", "content b")
+
+			tt.tamper(t, v, readFile(t, v, ManifestFileName))
+
+			_, err := v.VerifyManifest()
+			if !errors.Is(err, ErrManifest) || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected ErrManifest with %q, got %v", tt.want, err)
+			}
+
+			// A file is not saved silently with an invalid manifest
+			err = v.Put(&header.Header{Label: "d", Category: "work"}, strings.NewReader("content d"))
+			if !errors.Is(err, ErrManifest) {
+				t.Errorf("expected ErrManifest on Put, got %v", err)
+			}
+
+			// Signing accepts the current files
+			if _, err := v.SignManifest(); err != nil {
+				t.Fatalf("SignManifest failed: %v", err)
+			}
+			if r := verify(t, v); !r.OK() {
+				t.Errorf("expected a valid manifest after signing, got %+v", r)
+			}
+		})
+	}
+}
+
+// TestManifest_Legacy tests that the files of a repository without manifest
+// are not accepted until the manifest is signed.
+func TestManifest_Legacy(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	signer := v.signer
+	v.signer = nil
+	put(t, v, "a", "work", "content a")
+	v.signer = signer
+
+	put(t, v, "b", "work", "content b")
+	if _, err := v.VerifyManifest(); !errors.Is(err, ErrNoManifest) {
+		t.Fatalf("expected ErrNoManifest, got %v", err)
+	}
+
+	num, err := v.SignManifest()
+	if err != nil {
+		t.Fatalf("SignManifest failed: %v", err)
+	}
+	if num != 2 {
+		t.Errorf("expected 2 signed files, got %d", num)
+	}
+	if r := verify(t, v); !r.OK() {
+		t.Errorf("expected a valid manifest, got %+v", r)
+	}
+}
+
+// TestManifest_OtherSigner tests that a manifest signed by another key is
+// valid but not trusted.
+func TestManifest_OtherSigner(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(&setup.Setup{Id: id.New(identity, "other-key"), Repository: v.Repository()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.SignManifest(); err != nil {
+		t.Fatalf("SignManifest failed: %v", err)
+	}
+
+	r := verify(t, v)
+	if r.Trusted || r.OK() || len(r.Unknown)+len(r.Missing)+len(r.Changed) != 0 {
+		t.Errorf("expected a valid, not trusted manifest, got %+v", r)
+	}
+}
+
+// TestManifest_OtherSignerNotUpdated tests that a manifest signed by another
+// key is not updated, and so not signed again with the key of the vault.
+func TestManifest_OtherSignerNotUpdated(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := New(&setup.Setup{Id: id.New(identity, "other-key"), Repository: v.Repository()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := other.signer
+	other.signer = nil
+	put(t, other, "forged", "work", "forged content")
+	other.signer = signer
+	if _, err := other.SignManifest(); err != nil {
+		t.Fatalf("SignManifest failed: %v", err)
+	}
+	planted := readFile(t, v, ManifestFileName)
+	files, err := privagePaths(v.Repository())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The files are not changed, as the manifest can not be updated
+	h := &header.Header{Label: "b", Category: "work"}
+	if err := v.Put(h, strings.NewReader("content b")); !errors.Is(err, ErrUntrustedManifest) {
+		t.Fatalf("expected ErrUntrustedManifest, got %v", err)
+	}
+	if err := v.Delete(mustGet(t, v, "a")); !errors.Is(err, ErrUntrustedManifest) {
+		t.Fatalf("expected ErrUntrustedManifest, got %v", err)
+	}
+	if err := v.Rename(mustGet(t, v, "a"), &header.Header{Label: "c", Category: "work"}); !errors.Is(err, ErrUntrustedManifest) {
+		t.Fatalf("expected ErrUntrustedManifest, got %v", err)
+	}
+	if got := readFile(t, v, ManifestFileName); string(got) != string(planted) {
+		t.Errorf("expected the manifest not to be updated")
+	}
+	got, err := privagePaths(v.Repository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(got, files) {
+		t.Errorf("expected the files %v, got %v", files, got)
+	}
+	if r := verify(t, v); r.Trusted {
+		t.Errorf("expected a not trusted manifest, got %+v", r)
+	}
+
+	// Signing it after verification trusts it
+	if _, err := v.SignManifest(); err != nil {
+		t.Fatalf("SignManifest failed: %v", err)
+	}
+	if err := v.Put(h, strings.NewReader("content b")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if r := verify(t, v); !r.OK() {
+		t.Errorf("expected a valid manifest, got %+v", r)
+	}
+}
+
+// TestManifest_TrustedSigner tests that a manifest signed by a trusted
+// signer of the configuration is trusted and updated.
+func TestManifest_TrustedSigner(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &config.Config{ManifestSigners: []string{v.SignerKey()}}
+	mate, err := New(&setup.Setup{Id: id.New(identity, "mate-key"), Repository: v.Repository(), C: c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	put(t, mate, "b", "work", "content b")
+
+	// The manifest signed by the teammate is not trusted until configured
+	if r := verify(t, v); r.Trusted {
+		t.Errorf("expected a not trusted manifest, got %+v", r)
+	}
+	if err := v.setTrustedSigners([]string{mate.SignerKey()}); err != nil {
+		t.Fatal(err)
+	}
+	if r := verify(t, v); !r.OK() {
+		t.Errorf("expected a valid manifest, got %+v", r)
+	}
+	put(t, v, "c", "work", "content c")
+
+	c.ManifestSigners = []string{"invalid"}
+	if _, err := New(&setup.Setup{Id: id.New(identity, "mate-key"), Repository: v.Repository(), C: c}); err == nil {
+		t.Errorf("expected an error for an invalid manifest signer")
+	}
+}
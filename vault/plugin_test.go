@@ -0,0 +1,42 @@
+package vault
+
+import (
+	"os"
+	"testing"
+
+	"github.com/revelaction/privage/identity/plugintest"
+)
+
+func TestMain(m *testing.M) {
+	os.Exit(plugintest.Run(m))
+}
+
+func TestVault_Plugin(t *testing.T) {
+	v := newVault(t, keyPlugin, t.TempDir())
+	if v.workers != 1 {
+		t.Errorf("expected one worker for a plugin identity, got %d", v.workers)
+	}
+
+	mate := newVault(t, keyX25519, v.Repository(), v)
+	mateKey := mate.Recipients()[0]
+	pluginKey := v.Recipients()[0]
+	if err := v.setRecipients([]string{mateKey}); err != nil {
+		t.Fatal(err)
+	}
+	if err := mate.setRecipients([]string{pluginKey}); err != nil {
+		t.Fatal(err)
+	}
+
+	put(t, v, "a", "work", "content a")
+	put(t, mate, "b", "work", "content b")
+
+	for label, want := range map[string]string{"a": "content a", "b": "content b"} {
+		h, err := v.Get(label)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", label, err)
+		}
+		if got := readAll(t, v, h); got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package vault
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/header"
+	id "github.com/revelaction/privage/identity"
+)
+
+// setPolicies sets the category policies of the vault: for each category,
+// the public keys, in their canonical encoding, the content of its files is
+// encrypted to. It returns an error if a recipient can not be parsed or is
+// not of the kind of the vault identity.
+func (v *Vault) setPolicies(policies map[string][]string) error {
+	v.policies = nil
+	for category, recipients := range policies {
+		canonical := make([]string, 0, len(recipients))
+		for _, s := range recipients {
+			r, err := v.parseRecipient(s)
+			if err != nil {
+				return fmt.Errorf("category %q: %w", category, err)
+			}
+			canonical = append(canonical, r)
+		}
+
+		if v.policies == nil {
+			v.policies = map[string][]string{}
+		}
+		v.policies[category] = slices.Compact(slices.Sorted(slices.Values(canonical)))
+	}
+
+	return nil
+}
+
+// rotatePolicies returns the category policies of the vault, with the
+// recipient of the vault identity replaced by the one of next.
+func (v *Vault) rotatePolicies(next id.Identity) map[string][]string {
+	own := v.id.Recipient.String()
+	policies := maps.Clone(v.policies)
+	for category, recipients := range policies {
+		if i := slices.Index(recipients, own); i >= 0 {
+			recipients = slices.Clone(recipients)
+			recipients[i] = next.Recipient.String()
+			policies[category] = recipients
+		}
+	}
+
+	return policies
+}
+
+// contentRecipients returns the parsed recipients the content of a file of
+// the category is encrypted to.
+//
+// If the category has a policy, those are the recipients of the policy and
+// the vault identity, so that the writer of a file can always read it back.
+// Otherwise, those are all the recipients of the vault.
+func (v *Vault) contentRecipients(category string) ([]age.Recipient, error) {
+	policy, ok := v.policies[category]
+	if !ok {
+		return v.ageRecipients()
+	}
+
+	own := v.id.Recipient
+	recipients := []age.Recipient{own}
+	for _, s := range policy {
+		if s == own.String() {
+			continue
+		}
+		r, err := id.ParseRecipient(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", s, err)
+		}
+		recipients = append(recipients, r)
+	}
+
+	return recipients, nil
+}
+
+// Restricted reports whether the category policy of header h excludes the
+// vault identity. The content of such files can only be decrypted by the
+// identity if it wrote them.
+func (v *Vault) Restricted(h *header.Header) bool {
+	policy, ok := v.policies[h.Category]
+	return ok && !slices.Contains(policy, v.id.Recipient.String())
+}
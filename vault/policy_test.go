@@ -0,0 +1,208 @@
+package vault
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	id "github.com/revelaction/privage/identity"
+)
+
+// newPolicyTeam returns the vaults of two teammates sharing a repository,
+// ops and dev, where the content of the prod-db category is only encrypted
+// to ops.
+func newPolicyTeam(t *testing.T) (ops, dev *Vault) {
+	t.Helper()
+	ops = newVault(t, keyX25519, t.TempDir())
+	dev = newVault(t, keyX25519, ops.Repository(), ops)
+	devKey := dev.Recipients()[0]
+	opsKey := ops.Recipients()[0]
+
+	policies := map[string][]string{"prod-db": {opsKey}}
+	for _, v := range []*Vault{ops, dev} {
+		if err := v.setRecipients([]string{opsKey, devKey}); err != nil {
+			t.Fatal(err)
+		}
+		if err := v.setPolicies(policies); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return ops, dev
+}
+
+func TestVault_CategoryPolicy(t *testing.T) {
+	ops, dev := newPolicyTeam(t)
+	put(t, ops, "db", "prod-db", "content db")
+	put(t, ops, "c", "credential", "content c")
+
+	// Both read the header, only ops the content
+	h, err := dev.Get("db")
+	if err != nil {
+		t.Fatalf("dev Get failed: %v", err)
+	}
+	if !dev.Restricted(h) {
+		t.Errorf("expected prod-db to be restricted for dev")
+	}
+	if _, err := dev.Open(h); !errors.Is(err, ErrRestricted) {
+		t.Errorf("expected ErrRestricted, got %v", err)
+	}
+
+	h, err = ops.Get("db")
+	if err != nil {
+		t.Fatalf("ops Get failed: %v", err)
+	}
+	if ops.Restricted(h) {
+		t.Errorf("expected prod-db not to be restricted for ops")
+	}
+	if got := readAll(t, ops, h); got != "content db" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	h, err = dev.Get("c")
+	if err != nil {
+		t.Fatalf("dev Get failed: %v", err)
+	}
+	if dev.Restricted(h) {
+		t.Errorf("expected credential not to be restricted")
+	}
+	if got := readAll(t, dev, h); got != "content c" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	t.Run("Writer", func(t *testing.T) {
+		put(t, dev, "dev-db", "prod-db", "content dev-db")
+		for _, v := range []*Vault{ops, dev} {
+			h, err := v.Get("dev-db")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+			if got := readAll(t, v, h); got != "content dev-db" {
+				t.Errorf("unexpected content %q", got)
+			}
+		}
+	})
+}
+
+func TestVault_CategoryPolicy_SetRecipients(t *testing.T) {
+	ops, dev := newPolicyTeam(t)
+	put(t, ops, "db", "prod-db", "content db")
+
+	// dev can not decrypt the content, but reencrypts the header to the
+	// new recipient
+	qa := newVault(t, keyX25519, ops.Repository(), ops)
+	qaKey := qa.Recipients()[0]
+	num, err := dev.SetRecipients(append(dev.Recipients(), qaKey))
+	if err != nil {
+		t.Fatalf("SetRecipients failed: %v", err)
+	}
+	if num != 1 {
+		t.Errorf("expected 1 reencrypted file, got %d", num)
+	}
+
+	for _, v := range []*Vault{ops, qa} {
+		if err := v.setRecipients(dev.Recipients()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h, err := qa.Get("db")
+	if err != nil {
+		t.Fatalf("qa Get failed: %v", err)
+	}
+	if _, err := qa.Open(h); !errors.Is(err, ErrRestricted) {
+		t.Errorf("expected ErrRestricted, got %v", err)
+	}
+
+	h, err = ops.Get("db")
+	if err != nil {
+		t.Fatalf("ops Get failed: %v", err)
+	}
+	if got := readAll(t, ops, h); got != "content db" {
+		t.Errorf("unexpected content %q", got)
+	}
+}
+
+func TestVault_CategoryPolicy_Rotate(t *testing.T) {
+	ops, _ := newPolicyTeam(t)
+	put(t, ops, "db", "prod-db", "content db")
+
+	next, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextId := id.New(next, "next-key")
+
+	if _, err := ops.Rotate(nextId); err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+
+	nv := &Vault{repository: ops.Repository(), id: nextId, workers: 1}
+	if err := nv.setRecipients(ops.recipients); err != nil {
+		t.Fatal(err)
+	}
+	if err := nv.setPolicies(ops.rotatePolicies(nextId)); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := nv.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	rotated := 0
+	for _, h := range headers {
+		if h.Err != nil {
+			continue
+		}
+		if nv.Restricted(h) {
+			t.Errorf("expected rotated key in the policy of %s", h.Category)
+		}
+		if got := readAll(t, nv, h); got != "content db" {
+			t.Errorf("unexpected content %q", got)
+		}
+		rotated++
+	}
+	if rotated != 1 {
+		t.Errorf("expected 1 rotated file, got %d", rotated)
+	}
+
+	t.Run("Restricted", func(t *testing.T) {
+		// dev rotates the restricted file: ops keeps reading it
+		ops, dev := newPolicyTeam(t)
+		put(t, ops, "db", "prod-db", "content db")
+
+		devNext, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		devNextId := id.New(devNext, "dev-next-key")
+
+		num, err := dev.Rotate(devNextId)
+		if err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+		if num != 1 {
+			t.Errorf("expected 1 reencrypted file, got %d", num)
+		}
+
+		headers, err := ops.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rotated := 0
+		for _, h := range headers {
+			if h.Err != nil || !strings.HasSuffix(h.Path, RotateSuffix+Extension) {
+				continue
+			}
+			if got := readAll(t, ops, h); got != "content db" {
+				t.Errorf("unexpected content %q", got)
+			}
+			rotated++
+		}
+		if rotated != 1 {
+			t.Errorf("expected 1 rotated file, got %d", rotated)
+		}
+	})
+}
@@ -0,0 +1,329 @@
+package vault
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"iter"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/header"
+	id "github.com/revelaction/privage/identity"
+)
+
+const (
+	// Extension is the file extension of privage encrypted files.
+	Extension = ".privage"
+
+	// hexLen is the length of the hex encoded hash of privage file names.
+	hexLen = 64
+)
+
+// Headers returns an iterator over the decrypted headers of all .privage
+// files in the repository.
+//
+// Files that could not be read or decrypted are yielded with the Err field
+// of the header set, and the iteration continues. Directory-level errors
+// (the repository can not be accessed or walked) are yielded as a nil header
+// and a non nil error, and end the iteration.
+//
+// The headers are decrypted in parallel by the vault workers, but they are
+// always yielded in the lexical order of the file names.
+//
+// If the index is enabled, the headers of unchanged files are taken from
+// it instead of being decrypted.
+//
+// The iteration stops cleanly when the consumer stops (break or return).
+func (v *Vault) Headers() iter.Seq2[*header.Header, error] {
+	if !v.index {
+		return headerGenerator(v.repository, v.id, v.workers)
+	}
+
+	return func(yield func(*header.Header, error) bool) {
+		headers, err := v.indexedHeaders()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for _, h := range headers {
+			if !yield(h, nil) {
+				return
+			}
+		}
+	}
+}
+
+// headerGenerator returns an iterator over the decrypted headers of the
+// .privage files in the directory.
+func headerGenerator(repoDir string, identity id.Identity, workers int) iter.Seq2[*header.Header, error] {
+
+	return func(yield func(*header.Header, error) bool) {
+
+		paths, err := privagePaths(repoDir)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		for h := range readHeaders(paths, identity, workers) {
+			if !yield(h, nil) {
+				return
+			}
+		}
+	}
+}
+
+// readHeaders returns an iterator over the decrypted headers of the files
+// in paths, in the same order.
+//
+// The headers are decrypted by a pool of workers goroutines. One buffered
+// channel per file keeps the yield order independent of the order in which
+// the workers finish. When the consumer stops, the dispatch of files is
+// stopped and the workers are waited for, so no goroutine outlives the
+// iteration.
+func readHeaders(paths []string, identity id.Identity, workers int) iter.Seq[*header.Header] {
+
+	return func(yield func(*header.Header) bool) {
+
+		if workers <= 1 {
+			for _, path := range paths {
+				if !yield(readHeader(path, identity)) {
+					return
+				}
+			}
+			return
+		}
+
+		results := make([]chan *header.Header, len(paths))
+		for i := range results {
+			results[i] = make(chan *header.Header, 1)
+		}
+
+		jobs := make(chan int)
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+
+		// Deferred calls run in reverse order: first stop the dispatcher,
+		// then wait for all goroutines.
+		defer wg.Wait()
+		defer close(done)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(jobs)
+			for i := range paths {
+				select {
+				case jobs <- i:
+				case <-done:
+					return
+				}
+			}
+		}()
+
+		for range min(workers, len(paths)) {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range jobs {
+					// never blocks, the channel is buffered and receives
+					// only one header
+					results[i] <- readHeader(paths[i], identity)
+				}
+			}()
+		}
+
+		for _, r := range results {
+			if !yield(<-r) {
+				return
+			}
+		}
+	}
+}
+
+// privagePaths returns the paths of the .privage files in the directory, in
+// lexical order.
+//
+// The directory is expected to be flat; subdirectories are ignored.
+func privagePaths(repoDir string) ([]string, error) {
+
+	// 1. Check for directory
+	info, err := os.Stat(repoDir)
+	if err != nil {
+		return nil, fmt.Errorf("could not access directory: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("path '%s' is not a directory", repoDir)
+	}
+
+	var paths []string
+	err = filepath.WalkDir(repoDir, func(path string, d fs.DirEntry, err error) error {
+
+		if err != nil {
+			// If WalkDir encounters an error accessing a path (e.g. permission denied),
+			// returning the error here will abort the entire walk.
+			return err
+		}
+
+		// Flat repository: skip subdirectories
+		if d.IsDir() {
+			if path != repoDir {
+				return filepath.SkipDir
+			}
+			// In a WalkDir callback, 'return nil' is the equivalent of 'continue'
+			// in a standard for loop, moving to the next entry.
+			return nil
+		}
+
+		// Only process files that match the privage naming convention:
+		// Must end in .privage and start with 64 hex characters.
+		if !IsPrivageFile(d.Name()) {
+			return nil
+		}
+
+		paths = append(paths, path)
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("could not walk directory %s: %w", repoDir, err)
+	}
+
+	return paths, nil
+}
+
+// readHeader reads and decrypts the header of the privage file in path.
+//
+// Errors are not returned but set in the Err field of the header.
+func readHeader(path string, identity id.Identity) *header.Header {
+
+	h := &header.Header{Path: path}
+
+	f, err := os.Open(path)
+	if err != nil {
+		h.Err = fmt.Errorf("could not open file %s: %w", path, err)
+		return h
+	}
+
+	// 1. Read the header
+	headerBlock, readErr := readHeaderBlock(f)
+
+	// 2. Always capture the close error
+	closeErr := f.Close()
+
+	// 3. Prioritize the read error if it exists
+	if readErr != nil {
+		h.Err = fmt.Errorf("could not read header in file %s: %w", path, readErr)
+		return h
+	}
+
+	// 4. If read succeeded, check if the close failed
+	if closeErr != nil {
+		h.Err = fmt.Errorf("could not close file %s: %w", path, closeErr)
+		return h
+	}
+
+	// first remove the pad
+	unpadded, err := header.Unpad(headerBlock)
+	if err != nil {
+		h.Err = fmt.Errorf("could not unpad header in file %s: %w", path, err)
+		return h
+	}
+
+	uReader := bytes.NewReader(unpadded)
+	r, err := age.Decrypt(uReader, identity.Id)
+	if err != nil {
+		h.Err = fmt.Errorf("could not Decrypt header in file %s with identity %s: %w", path, identity.Path, err)
+		return h
+	}
+
+	out := &bytes.Buffer{}
+	if _, err := io.Copy(out, r); err != nil {
+		h.Err = fmt.Errorf("could not copy to buffer the header in file %s: %w", path, err)
+		return h
+	}
+
+	h = header.Parse(out.Bytes())
+	h.Path = path
+
+	return h
+}
+
+// readHeaderBlock reads the padded encrypted header at the start of src:
+// one block, or more if the first block says so.
+func readHeaderBlock(src io.Reader) ([]byte, error) {
+	block := make([]byte, header.BlockSize)
+	if _, err := io.ReadFull(src, block); err != nil {
+		return nil, err
+	}
+
+	size, err := header.Size(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if size == header.BlockSize {
+		return block, nil
+	}
+
+	block = append(block, make([]byte, size-header.BlockSize)...)
+	if _, err := io.ReadFull(src, block[header.BlockSize:]); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// contentReader returns an `age` reader that provides the decrypted content
+// from an existing reader by skipping the privage header.
+func contentReader(src io.Reader, identity id.Identity) (io.Reader, error) {
+
+	// skip header
+	if _, err := readHeaderBlock(src); err != nil {
+		return nil, err
+	}
+
+	return age.Decrypt(src, identity.Id)
+}
+
+// IsPrivageFile reports whether name follows the privage naming convention:
+// 64 hex characters, an optional suffix and the .privage extension.
+func IsPrivageFile(name string) bool {
+	// 1. Check minimum length
+	if len(name) < hexLen+len(Extension) {
+		return false
+	}
+
+	// 2. Check extension
+	if !strings.HasSuffix(name, Extension) {
+		return false
+	}
+
+	// 3. Check Hex Prefix
+	// Iterate over bytes, not runes (avoids UTF-8 decoding overhead)
+	// We only check the first 64 bytes of the original string
+	for i := 0; i < hexLen; i++ {
+		c := name[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+
+	// 4. No path separators allowed between prefix and extension (prevent
+	// directory traversal in filename)
+	for i := hexLen; i < len(name)-len(Extension); i++ {
+		c := name[i]
+		if c == '/' || c == '\\' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -1,11 +1,14 @@
-package main
+package vault
 
 import (
 	"bytes"
 	"crypto/sha256"
 	"fmt"
+	"iter"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	"filippo.io/age"
@@ -46,7 +49,7 @@ func createTestAgeFile(t *testing.T, dir, filename string, h *header.Header, ide
 	}
 
 	// 3. Write to file
-	path := filepath.Join(dir, filename+PrivageExtension)
+	path := filepath.Join(dir, filename+Extension)
 	if err := os.WriteFile(path, padded, 0600); err != nil {
 		t.Fatalf("failed to write test file: %v", err)
 	}
@@ -54,6 +57,19 @@ func createTestAgeFile(t *testing.T, dir, filename string, h *header.Header, ide
 	return path
 }
 
+// firstHeader returns the first header yielded by seq, or nil if seq
+// yields none.
+func firstHeader(t *testing.T, seq iter.Seq2[*header.Header, error]) *header.Header {
+	t.Helper()
+	for h, err := range seq {
+		if err != nil {
+			t.Fatalf("headerGenerator failed: %v", err)
+		}
+		return h
+	}
+	return nil
+}
+
 func TestIsPrivageFile(t *testing.T) {
 	validHash := validHexName("test")
 
@@ -63,9 +79,9 @@ func TestIsPrivageFile(t *testing.T) {
 		want     bool
 	}{
 		// Valid cases
-		{"Valid hash", validHash + PrivageExtension, true},
-		{"Valid hash with suffix", validHash + ".rotate" + PrivageExtension, true},
-		{"Valid hash with alphanumeric suffix", validHash + ".v1-backup" + PrivageExtension, true},
+		{"Valid hash", validHash + Extension, true},
+		{"Valid hash with suffix", validHash + ".rotate" + Extension, true},
+		{"Valid hash with alphanumeric suffix", validHash + ".v1-backup" + Extension, true},
 
 		// Invalid lengths / extensions
 		{"Too short", "abc.privage", false},
@@ -74,21 +90,21 @@ func TestIsPrivageFile(t *testing.T) {
 		{"Empty string", "", false},
 
 		// Invalid hex prefix
-		{"Invalid hex (non-hex char)", "g" + validHash[1:] + PrivageExtension, false},
-		{"Invalid hex (uppercase)", "A" + validHash[1:] + PrivageExtension, false},
+		{"Invalid hex (non-hex char)", "g" + validHash[1:] + Extension, false},
+		{"Invalid hex (uppercase)", "A" + validHash[1:] + Extension, false},
 		{"Invalid hex (too short prefix)", validHash[:63] + ".privage", false},
 
 		// Path traversal and security
-		{"Path separator /", validHash + "/suffix" + PrivageExtension, false},
-		{"Path separator \\", validHash + "\\suffix" + PrivageExtension, false},
-		{"Path traversal ..", validHash + "..suffix" + PrivageExtension, true}, // Dots are OK
-		{"Root path", "/" + validHash + PrivageExtension, false},
+		{"Path separator /", validHash + "/suffix" + Extension, false},
+		{"Path separator \\", validHash + "\\suffix" + Extension, false},
+		{"Path traversal ..", validHash + "..suffix" + Extension, true}, // Dots are OK
+		{"Root path", "/" + validHash + Extension, false},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := isPrivageFile(tt.filename); got != tt.want {
-				t.Errorf("isPrivageFile(%q) = %v, want %v", tt.filename, got, tt.want)
+			if got := IsPrivageFile(tt.filename); got != tt.want {
+				t.Errorf("IsPrivageFile(%q) = %v, want %v", tt.filename, got, tt.want)
 			}
 		})
 	}
@@ -100,7 +116,7 @@ func TestHeaderGenerator(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to generate identity: %v", err)
 	}
-	privageId := id.Identity{Id: identity, Path: "test-key"}
+	privageId := id.New(identity, "test-key")
 
 	t.Run("Success_MultipleFiles", func(t *testing.T) {
 		tmpDir := t.TempDir()
@@ -115,12 +131,11 @@ func TestHeaderGenerator(t *testing.T) {
 			createTestAgeFile(t, tmpDir, filename, h, identity)
 		}
 
-		gen, err := headerGenerator(tmpDir, privageId)
-		if err != nil {
-			t.Fatalf("headerGenerator failed: %v", err)
-		}
 		count := 0
-		for h := range gen {
+		for h, err := range headerGenerator(tmpDir, privageId, 1) {
+			if err != nil {
+				t.Fatalf("headerGenerator failed: %v", err)
+			}
 			if h.Err != nil {
 				t.Errorf("unexpected error for %s: %v", h.Path, h.Err)
 			}
@@ -157,12 +172,11 @@ func TestHeaderGenerator(t *testing.T) {
 		subName := validHexName("sub")
 		createTestAgeFile(t, subDir, subName, &header.Header{Label: "sub"}, identity)
 
-		gen, err := headerGenerator(tmpDir, privageId)
-		if err != nil {
-			t.Fatalf("headerGenerator failed: %v", err)
-		}
 		count := 0
-		for h := range gen {
+		for h, err := range headerGenerator(tmpDir, privageId, 1) {
+			if err != nil {
+				t.Fatalf("headerGenerator failed: %v", err)
+			}
 			if h.Label != "root" {
 				t.Errorf("expected only root file, got: %s", h.Label)
 			}
@@ -183,7 +197,7 @@ func TestHeaderGenerator(t *testing.T) {
 
 		// 2. Malformed file (too short)
 		shortName := validHexName("short")
-		shortPath := filepath.Join(tmpDir, shortName+PrivageExtension)
+		shortPath := filepath.Join(tmpDir, shortName+Extension)
 		if err := os.WriteFile(shortPath, []byte("too short"), 0600); err != nil {
 			t.Fatalf("failed to write short test file: %v", err)
 		}
@@ -193,13 +207,11 @@ func TestHeaderGenerator(t *testing.T) {
 		otherIdentity, _ := age.GenerateX25519Identity()
 		createTestAgeFile(t, tmpDir, wrongKeyName, &header.Header{Label: "wrong"}, otherIdentity)
 
-		gen, err := headerGenerator(tmpDir, privageId)
-		if err != nil {
-			t.Fatalf("headerGenerator failed: %v", err)
-		}
-
 		results := make(map[string]*header.Header)
-		for h := range gen {
+		for h, err := range headerGenerator(tmpDir, privageId, 1) {
+			if err != nil {
+				t.Fatalf("headerGenerator failed: %v", err)
+			}
 			results[filepath.Base(h.Path)] = h
 		}
 
@@ -208,17 +220,17 @@ func TestHeaderGenerator(t *testing.T) {
 		}
 
 		// Verify valid
-		if results[validName+PrivageExtension].Err != nil {
-			t.Errorf("valid file should not have error: %v", results[validName+PrivageExtension].Err)
+		if results[validName+Extension].Err != nil {
+			t.Errorf("valid file should not have error: %v", results[validName+Extension].Err)
 		}
 
 		// Verify short
-		if results[shortName+PrivageExtension].Err == nil {
+		if results[shortName+Extension].Err == nil {
 			t.Error("short file should have error")
 		}
 
 		// Verify wrong key
-		if results[wrongKeyName+PrivageExtension].Err == nil {
+		if results[wrongKeyName+Extension].Err == nil {
 			t.Error("wrong_key file should have error")
 		}
 	})
@@ -226,16 +238,12 @@ func TestHeaderGenerator(t *testing.T) {
 	t.Run("PermissionDenied", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		name := validHexName("unreadable")
-		path := filepath.Join(tmpDir, name+PrivageExtension)
+		path := filepath.Join(tmpDir, name+Extension)
 		if err := os.WriteFile(path, []byte("data"), 0000); err != nil { // No permissions
 			t.Fatalf("failed to write unreadable test file: %v", err)
 		}
 
-		gen, err := headerGenerator(tmpDir, privageId)
-		if err != nil {
-			t.Fatalf("headerGenerator failed: %v", err)
-		}
-		h := <-gen
+		h := firstHeader(t, headerGenerator(tmpDir, privageId, 1))
 		if h == nil {
 			t.Fatal("expected at least one result")
 		}
@@ -247,13 +255,13 @@ func TestHeaderGenerator(t *testing.T) {
 	t.Run("StandardAgeFile_Collision", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		name := validHexName("standard")
-		path := filepath.Join(tmpDir, name+PrivageExtension)
-		
+		path := filepath.Join(tmpDir, name+Extension)
+
 		f, err := os.Create(path)
 		if err != nil {
 			t.Fatalf("failed to create standard age file: %v", err)
 		}
-		
+
 		aw, err := age.Encrypt(f, identity.Recipient())
 		if err != nil {
 			_ = f.Close()
@@ -262,16 +270,12 @@ func TestHeaderGenerator(t *testing.T) {
 		if _, err := aw.Write([]byte("some content")); err != nil {
 			t.Errorf("failed to write content: %v", err)
 		}
-		gen, err := headerGenerator(tmpDir, privageId)
-		if err != nil {
-			t.Fatalf("headerGenerator failed: %v", err)
-		}
-		h := <-gen
-		
+		h := firstHeader(t, headerGenerator(tmpDir, privageId, 1))
+
 		if h == nil {
 			t.Fatal("expected result for standard age file")
 		}
-		
+
 		// We expect an error because it's not a valid privage file
 		if h.Err == nil {
 			t.Errorf("expected error for standard age file, got success. Parsed header: %+v", h)
@@ -283,13 +287,13 @@ func TestHeaderGenerator(t *testing.T) {
 	t.Run("StandardAgeFile_Large_Collision", func(t *testing.T) {
 		tmpDir := t.TempDir()
 		name := validHexName("large")
-		path := filepath.Join(tmpDir, name+PrivageExtension)
-		
+		path := filepath.Join(tmpDir, name+Extension)
+
 		f, err := os.Create(path)
 		if err != nil {
 			t.Fatalf("failed to create standard age file: %v", err)
 		}
-		
+
 		aw, err := age.Encrypt(f, identity.Recipient())
 		if err != nil {
 			_ = f.Close()
@@ -307,20 +311,187 @@ func TestHeaderGenerator(t *testing.T) {
 			t.Errorf("failed to close file: %v", err)
 		}
 
-		gen, err := headerGenerator(tmpDir, privageId)
-		if err != nil {
-			t.Fatalf("headerGenerator failed: %v", err)
-		}
-		h := <-gen
-		
+		h := firstHeader(t, headerGenerator(tmpDir, privageId, 1))
+
 		if h == nil {
 			t.Fatal("expected result for large standard age file")
 		}
-		
+
 		if h.Err == nil {
 			t.Errorf("expected error for large standard age file, got success")
 		} else {
 			t.Logf("Got expected error for large standard age file: %v", h.Err)
 		}
 	})
+
+	t.Run("EarlyStop", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		for i := range 5 {
+			name := validHexName(fmt.Sprintf("stop%d", i))
+			createTestAgeFile(t, tmpDir, name, &header.Header{Label: name}, identity)
+		}
+
+		// The walk must stop when the consumer breaks; yielding after
+		// a break would panic in the range-over-func machinery.
+		count := 0
+		for _, err := range headerGenerator(tmpDir, privageId, 1) {
+			if err != nil {
+				t.Fatalf("headerGenerator failed: %v", err)
+			}
+			count++
+			if count == 2 {
+				break
+			}
+		}
+
+		if count != 2 {
+			t.Errorf("expected 2 headers before break, got %d", count)
+		}
+	})
+
+	t.Run("DirectoryErrors", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		filePath := filepath.Join(tmpDir, "file")
+		if err := os.WriteFile(filePath, []byte("data"), 0600); err != nil {
+			t.Fatalf("failed to write test file: %v", err)
+		}
+
+		for _, dir := range []string{filepath.Join(tmpDir, "missing"), filePath} {
+			var errs []error
+			for h, err := range headerGenerator(dir, privageId, 1) {
+				if h != nil {
+					t.Errorf("expected nil header with directory error, got %+v", h)
+				}
+				errs = append(errs, err)
+			}
+
+			if len(errs) != 1 || errs[0] == nil {
+				t.Errorf("expected one directory error for %s, got %v", dir, errs)
+			}
+		}
+	})
+
+	t.Run("WalkError", func(t *testing.T) {
+		if os.Getuid() == 0 {
+			t.Skip("skipping unreadable directory test when running as root")
+		}
+
+		tmpDir := t.TempDir()
+		if err := os.Chmod(tmpDir, 0300); err != nil {
+			t.Fatalf("failed to make directory unreadable: %v", err)
+		}
+		defer func() {
+			if err := os.Chmod(tmpDir, 0700); err != nil {
+				t.Errorf("failed to restore directory permissions: %v", err)
+			}
+		}()
+
+		var walkErr error
+		for _, err := range headerGenerator(tmpDir, privageId, 1) {
+			walkErr = err
+		}
+
+		if walkErr == nil {
+			t.Error("expected error walking unreadable directory")
+		}
+	})
+
+	t.Run("Parallel_Order", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		for i := range 50 {
+			name := validHexName(fmt.Sprintf("order%d", i))
+			createTestAgeFile(t, tmpDir, name, &header.Header{Label: name}, identity)
+		}
+
+		// A malformed file among valid ones
+		shortPath := filepath.Join(tmpDir, validHexName("short")+Extension)
+		if err := os.WriteFile(shortPath, []byte("too short"), 0600); err != nil {
+			t.Fatalf("failed to write short test file: %v", err)
+		}
+
+		collect := func(workers int) []*header.Header {
+			var headers []*header.Header
+			for h, err := range headerGenerator(tmpDir, privageId, workers) {
+				if err != nil {
+					t.Fatalf("headerGenerator failed: %v", err)
+				}
+				headers = append(headers, h)
+			}
+			return headers
+		}
+
+		sequential := collect(1)
+		parallel := collect(8)
+
+		if len(sequential) != 51 || len(parallel) != 51 {
+			t.Fatalf("expected 51 headers, got %d sequential and %d parallel", len(sequential), len(parallel))
+		}
+
+		for i := range sequential {
+			if sequential[i].Path != parallel[i].Path || sequential[i].Label != parallel[i].Label {
+				t.Errorf("order mismatch at %d: %s != %s", i, sequential[i].Path, parallel[i].Path)
+			}
+			if (sequential[i].Err == nil) != (parallel[i].Err == nil) {
+				t.Errorf("error mismatch at %d: %v != %v", i, sequential[i].Err, parallel[i].Err)
+			}
+		}
+	})
+
+	t.Run("Parallel_EarlyStop", func(t *testing.T) {
+		tmpDir := t.TempDir()
+		for i := range 50 {
+			name := validHexName(fmt.Sprintf("pstop%d", i))
+			createTestAgeFile(t, tmpDir, name, &header.Header{Label: name}, identity)
+		}
+
+		before := runtime.NumGoroutine()
+
+		for _, err := range headerGenerator(tmpDir, privageId, 8) {
+			if err != nil {
+				t.Fatalf("headerGenerator failed: %v", err)
+			}
+			break
+		}
+
+		// All workers are waited for before the iteration returns.
+		if after := runtime.NumGoroutine(); after > before {
+			t.Errorf("goroutines leaked: %d before, %d after", before, after)
+		}
+	})
+}
+
+// BenchmarkHeaderGenerator measures the decryption of the headers of a
+// repository with 10k files, for several numbers of workers.
+func BenchmarkHeaderGenerator(b *testing.B) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		b.Fatalf("failed to generate identity: %v", err)
+	}
+	privageId := id.New(identity, "test-key")
+
+	tmpDir := b.TempDir()
+	v := &Vault{repository: tmpDir, id: privageId}
+	for i := range 10000 {
+		h := &header.Header{Category: "bench", Label: fmt.Sprintf("label%05d", i)}
+		if err := v.encryptSave(h, "", strings.NewReader("content")); err != nil {
+			b.Fatalf("encryptSave failed: %v", err)
+		}
+	}
+
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for b.Loop() {
+				count := 0
+				for _, err := range headerGenerator(tmpDir, privageId, workers) {
+					if err != nil {
+						b.Fatalf("headerGenerator failed: %v", err)
+					}
+					count++
+				}
+				if count != 10000 {
+					b.Fatalf("expected 10000 headers, got %d", count)
+				}
+			}
+		})
+	}
 }
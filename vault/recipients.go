@@ -0,0 +1,188 @@
+package vault
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+
+	id "github.com/revelaction/privage/identity"
+)
+
+const (
+	// RecipientsFileName is the name of the file in the repository with the
+	// age public keys the files are encrypted to, one per line. Lines
+	// starting with # are comments.
+	RecipientsFileName = ".privage-recipients"
+)
+
+// Recipients returns the age public keys the files of the vault are
+// encrypted to, starting with the one of the vault identity.
+func (v *Vault) Recipients() []string {
+	return append([]string{v.id.Recipient.String()}, v.recipients...)
+}
+
+// ageRecipients returns the parsed recipients of the vault.
+func (v *Vault) ageRecipients() ([]age.Recipient, error) {
+	recipients := []age.Recipient{v.id.Recipient}
+	for _, s := range v.recipients {
+		r, err := id.ParseRecipient(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid recipient %q: %w", s, err)
+		}
+		recipients = append(recipients, r)
+	}
+
+	return recipients, nil
+}
+
+// setRecipients sets the recipients of the vault, other than the vault
+// identity, in their canonical encoding and removing duplicates. It returns
+// an error if a recipient can not be parsed, or ErrMixedRecipients if it is
+// not of the kind of the vault identity.
+func (v *Vault) setRecipients(recipients []string) error {
+	own := v.id.Recipient.String()
+
+	v.recipients = nil
+	for _, s := range recipients {
+		r, err := v.parseRecipient(s)
+		if err != nil {
+			return err
+		}
+		if r == own || slices.Contains(v.recipients, r) {
+			continue
+		}
+		v.recipients = append(v.recipients, r)
+	}
+
+	return nil
+}
+
+// parseRecipient returns the canonical encoding of the public key s, so that
+// the file names do not depend on how the key was written, f. ex. with the
+// comment of an SSH public key.
+//
+// It returns an error if s can not be parsed, or ErrMixedRecipients if it is
+// not of the kind, post-quantum hybrid or not, of the vault identity.
+func (v *Vault) parseRecipient(s string) (string, error) {
+	r, err := id.ParseRecipient(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid recipient %q: %w", s, err)
+	}
+	if id.IsPostQuantum(s) != v.id.PostQuantum() {
+		return "", fmt.Errorf("%w: recipient %q", ErrMixedRecipients, s)
+	}
+
+	return r.String(), nil
+}
+
+// SetRecipients reencrypts all the files of the vault to the identity and
+// the given recipients, and makes them the recipients of the vault. As the
+// file names depend on the recipients, the files are renamed.
+//
+// Files that can not be decrypted with the vault identity are skipped. Of
+// files restricted by a category policy, only the header is reencrypted.
+// The recipients file of the repository is not modified. It returns the
+// number of reencrypted files.
+func (v *Vault) SetRecipients(recipients []string) (int, error) {
+	nv := *v
+	if err := nv.setRecipients(recipients); err != nil {
+		return 0, err
+	}
+
+	num := 0
+	for h, err := range v.Headers() {
+		if err != nil {
+			return num, err
+		}
+		if h.Err != nil {
+			var e *age.NoIdentityMatchError
+			if errors.As(h.Err, &e) {
+				continue
+			}
+
+			return num, h.Err
+		}
+
+		suffix := fileSuffix(h.Path)
+		if err := v.reencrypt(&nv, h, suffix); err != nil {
+			return num, err
+		}
+
+		fname, err := fileName(h, nv.Recipients(), suffix)
+		if err != nil {
+			return num, err
+		}
+		if filepath.Base(h.Path) != fname {
+			if err := v.Delete(h); err != nil {
+				return num, err
+			}
+		}
+
+		num++
+	}
+
+	v.recipients = nv.recipients
+	return num, nil
+}
+
+// ReadRecipientsFile returns the recipients in the recipients file of the
+// repository. A missing file has no recipients.
+func ReadRecipientsFile(repoDir string) ([]string, error) {
+	f, err := os.Open(filepath.Join(repoDir, RecipientsFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	return parseRecipients(f)
+}
+
+// parseRecipients returns the recipients, one per line, of r. Empty lines
+// and lines starting with # are ignored.
+func parseRecipients(r io.Reader) ([]string, error) {
+	var recipients []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		recipients = append(recipients, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read recipients file: %w", err)
+	}
+
+	return recipients, nil
+}
+
+// WriteRecipientsFile writes the recipients in the recipients file of the
+// repository.
+func WriteRecipientsFile(repoDir string, recipients []string) error {
+	var b strings.Builder
+	b.WriteString("# age public keys of the privage files in this directory\n")
+	for _, r := range recipients {
+		b.WriteString(r + "\n")
+	}
+
+	path := filepath.Join(repoDir, RecipientsFileName)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("could not write recipients file: %w", err)
+	}
+
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,258 @@
+package vault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/revelaction/privage/setup"
+)
+
+func TestNew_Recipients(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	mate := newVault(t, keyX25519, v.Repository(), v).Recipients()[0]
+	own := v.Recipients()[0]
+
+	// The own recipient is listed too, as in a shared recipients file
+	if err := WriteRecipientsFile(v.Repository(), []string{own, mate}); err != nil {
+		t.Fatal(err)
+	}
+
+	nv, err := New(&setup.Setup{Id: v.id, Repository: v.Repository()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if got := nv.Recipients(); !slices.Equal(got, []string{own, mate}) {
+		t.Errorf("expected recipients [%s %s], got %v", own, mate, got)
+	}
+
+	t.Run("Invalid", func(t *testing.T) {
+		if err := WriteRecipientsFile(v.Repository(), []string{"age1invalid"}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := New(&setup.Setup{Id: v.id, Repository: v.Repository()})
+		if err == nil || !strings.Contains(err.Error(), "invalid recipient") {
+			t.Errorf("expected invalid recipient error, got %v", err)
+		}
+	})
+}
+
+func TestVault_SetRecipients(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+	put(t, v, "b", "credential", "content b")
+
+	mate := newVault(t, keyX25519, v.Repository(), v)
+	mateKey := mate.Recipients()[0]
+	if _, err := mate.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected teammate not to read the files, got %v", err)
+	}
+
+	num, err := v.SetRecipients([]string{mateKey})
+	if err != nil {
+		t.Fatalf("SetRecipients failed: %v", err)
+	}
+	if num != 2 {
+		t.Errorf("expected 2 reencrypted files, got %d", num)
+	}
+
+	entries, err := privagePaths(v.Repository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("expected old files to be removed, got %d files", len(entries))
+	}
+
+	// The teammate, with the same recipients, reads and writes the same files
+	if err := mate.setRecipients(v.Recipients()); err != nil {
+		t.Fatal(err)
+	}
+	h, err := mate.Get("a")
+	if err != nil {
+		t.Fatalf("teammate Get failed: %v", err)
+	}
+	if got := readAll(t, mate, h); got != "content a" {
+		t.Errorf("unexpected content %q", got)
+	}
+
+	put(t, mate, "a", "work", "new content a")
+	h, err = v.Get("a")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := readAll(t, v, h); got != "new content a" {
+		t.Errorf("expected teammate write to overwrite the file, got %q", got)
+	}
+
+	// Remove the teammate
+	if _, err := v.SetRecipients(nil); err != nil {
+		t.Fatalf("SetRecipients failed: %v", err)
+	}
+	if _, err := mate.Get("a"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected removed teammate not to read the files, got %v", err)
+	}
+	h, err = v.Get("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := h.Hash(v.Recipients()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(h.Path) != hash+Extension {
+		t.Errorf("expected single recipient file name, got %s", h.Path)
+	}
+}
+
+func TestVault_ManyRecipients(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+
+	var mates []*Vault
+	var keys []string
+	for range 10 {
+		mate := newVault(t, keyX25519, v.Repository(), v)
+		key := mate.Recipients()[0]
+		mates = append(mates, mate)
+		keys = append(keys, key)
+	}
+
+	if err := v.setRecipients(keys); err != nil {
+		t.Fatal(err)
+	}
+	put(t, v, "shared", "team", "shared content")
+
+	// The header does not fit in one block
+	h, err := v.Get("shared")
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() < 1024 {
+		t.Errorf("expected a multi block header, file size %d", info.Size())
+	}
+
+	for _, mate := range mates {
+		h, err := mate.Get("shared")
+		if err != nil {
+			t.Fatalf("teammate Get failed: %v", err)
+		}
+		if got := readAll(t, mate, h); got != "shared content" {
+			t.Errorf("unexpected content %q", got)
+		}
+	}
+}
+
+func TestVault_PostQuantum(t *testing.T) {
+	v := newVault(t, keyHybrid, t.TempDir())
+	mate := newVault(t, keyHybrid, v.Repository(), v)
+
+	if err := v.setRecipients(mate.Recipients()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mate.setRecipients(v.Recipients()); err != nil {
+		t.Fatal(err)
+	}
+	put(t, v, "a", "work", "content a")
+
+	for _, vv := range []*Vault{v, mate} {
+		h, err := vv.Get("a")
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		if got := readAll(t, vv, h); got != "content a" {
+			t.Errorf("unexpected content %q", got)
+		}
+	}
+
+	t.Run("Mixed", func(t *testing.T) {
+		x25519Key := newVault(t, keyX25519, v.Repository(), v).Recipients()[0]
+		if err := v.setRecipients([]string{x25519Key}); !errors.Is(err, ErrMixedRecipients) {
+			t.Errorf("expected ErrMixedRecipients, got %v", err)
+		}
+
+		x25519 := newVault(t, keyX25519, t.TempDir())
+		if err := x25519.setRecipients(mate.Recipients()); !errors.Is(err, ErrMixedRecipients) {
+			t.Errorf("expected ErrMixedRecipients, got %v", err)
+		}
+		if err := x25519.setPolicies(map[string][]string{"work": mate.Recipients()}); !errors.Is(err, ErrMixedRecipients) {
+			t.Errorf("expected ErrMixedRecipients, got %v", err)
+		}
+	})
+}
+
+func TestVault_Ssh(t *testing.T) {
+	v := newVault(t, keySsh, t.TempDir())
+	mate := newVault(t, keyX25519, v.Repository(), v)
+	mateKey := mate.Recipients()[0]
+	sshKey := v.Recipients()[0]
+
+	if err := v.setRecipients([]string{mateKey}); err != nil {
+		t.Fatal(err)
+	}
+	// The comment of the SSH public key does not change the file names
+	if err := mate.setRecipients([]string{sshKey + " user@host"}); err != nil {
+		t.Fatal(err)
+	}
+	if got := mate.Recipients(); !slices.Equal(got, []string{mateKey, sshKey}) {
+		t.Errorf("expected canonical recipients, got %v", got)
+	}
+
+	put(t, v, "a", "work", "content a")
+	put(t, mate, "a", "work", "new content a")
+
+	entries, err := privagePaths(v.Repository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected the teammate to overwrite the file, got %d files", len(entries))
+	}
+
+	h, err := v.Get("a")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := readAll(t, v, h); got != "new content a" {
+		t.Errorf("unexpected content %q", got)
+	}
+}
+
+func TestRecipientsFile(t *testing.T) {
+	dir := t.TempDir()
+
+	recipients, err := ReadRecipientsFile(dir)
+	if err != nil || recipients != nil {
+		t.Fatalf("expected no recipients for missing file, got %v %v", recipients, err)
+	}
+
+	content := "# team\n\nage1aaa\n  age1bbb  \n# old\n"
+	if err := os.WriteFile(filepath.Join(dir, RecipientsFileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	recipients, err = ReadRecipientsFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(recipients, []string{"age1aaa", "age1bbb"}) {
+		t.Errorf("unexpected recipients %v", recipients)
+	}
+
+	if err := WriteRecipientsFile(dir, []string{"age1ccc"}); err != nil {
+		t.Fatal(err)
+	}
+	recipients, err = ReadRecipientsFile(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(recipients, []string{"age1ccc"}) {
+		t.Errorf("unexpected recipients %v", recipients)
+	}
+}
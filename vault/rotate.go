@@ -0,0 +1,439 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/pelletier/go-toml/v2"
+
+	id "github.com/revelaction/privage/identity"
+)
+
+const (
+	// RotateJournalFileName is the name of the journal of a rotation in the
+	// repository, encrypted to the next key. It records the phase of the
+	// rotation and the reencrypted files, so that an interrupted rotation can
+	// be resumed or aborted.
+	RotateJournalFileName = ".privage-rotate-journal"
+)
+
+// The phases of a rotation, in order.
+const (
+	// RotatePhaseReencrypt is the reencryption of the files with the next
+	// key, to files with the RotateSuffix.
+	RotatePhaseReencrypt = "reencrypt"
+
+	// RotatePhaseReencrypted is a rotation with all the files reencrypted.
+	// Nothing was removed yet.
+	RotatePhaseReencrypted = "reencrypted"
+
+	// RotatePhaseRemove is the removal of the files of the old key. From this
+	// phase on, the rotation can not be aborted anymore.
+	RotatePhaseRemove = "remove"
+
+	// RotatePhaseRename is the renaming of the reencrypted files to their
+	// standard names.
+	RotatePhaseRename = "rename"
+
+	// RotatePhaseKey is the backup of the old key and the renaming of the next
+	// key to the path of the old one.
+	RotatePhaseKey = "key"
+
+	// RotatePhaseSign is the signing of the manifest with the next key.
+	RotatePhaseSign = "sign"
+)
+
+var (
+	// ErrNoRotation is returned when the repository has no rotation in
+	// progress.
+	ErrNoRotation = errors.New("found no rotation in progress")
+
+	// ErrRotationCommitted is returned when aborting a rotation whose files of
+	// the old key were already removed.
+	ErrRotationCommitted = errors.New("the files of the old key were removed, the rotation can only be resumed")
+
+	// ErrRotationCleaning is returned when reencrypting the files of a
+	// rotation that is being cleaned.
+	ErrRotationCleaning = errors.New("the rotation is being cleaned, it can only be resumed")
+)
+
+var (
+	// osRename and osRemove are used by the steps of a rotation, and
+	// osRemove by Rename. They can be replaced in tests to inject failures.
+	osRename = os.Rename
+	osRemove = os.Remove
+)
+
+// RotateJournal is the journal of a rotation.
+type RotateJournal struct {
+	// Phase is the current phase of the rotation, one of the RotatePhase
+	// constants.
+	Phase string `toml:"phase"`
+
+	// KeyPath is the path of the key of the repository, the old key until
+	// the RotatePhaseKey.
+	KeyPath string `toml:"key"`
+
+	// NextKeyPath is the path of the next key until the RotatePhaseKey.
+	NextKeyPath string `toml:"next_key"`
+
+	// BackupKeyPath is the path of the backup of the old key, set in the
+	// RotatePhaseKey.
+	BackupKeyPath string `toml:"backup_key"`
+
+	// Files are the files of the old key and their reencrypted files.
+	Files []RotateFile `toml:"files"`
+}
+
+// RotateFile is a file of the old key and its reencrypted file, by name.
+type RotateFile struct {
+	Old string `toml:"old"`
+	New string `toml:"new"`
+}
+
+// Rotate reencrypts all the files of the vault with the identity next,
+// keeping the other recipients of the vault. In the category policies, the
+// identity is replaced by next.
+//
+// The reencrypted files are saved in the same repository with the
+// RotateSuffix, and signed in the manifest with the key of the vault
+// identity until the rotation is cleaned. Files that can not be decrypted
+// with the vault identity are skipped, as they may be the result of a
+// previous, interrupted rotation. Of files restricted by a category policy,
+// only the header is reencrypted. It returns the number of reencrypted
+// files.
+//
+// Each file is recorded in the rotate journal before it is reencrypted. An
+// interrupted Rotate can be run again, and the rotation can be cleaned with
+// CleanRotate once it returns, or aborted with AbortRotate. It returns
+// ErrRotationCleaning if the rotation is being cleaned.
+func (v *Vault) Rotate(next id.Identity) (int, error) {
+	nv, err := v.nextVault(next)
+	if err != nil {
+		return 0, err
+	}
+
+	j, err := nv.RotateJournal()
+	switch {
+	case errors.Is(err, ErrNoRotation):
+		j = &RotateJournal{Phase: RotatePhaseReencrypt, KeyPath: v.id.Path, NextKeyPath: next.Path}
+	case err != nil:
+		return 0, err
+	case j.Phase != RotatePhaseReencrypt && j.Phase != RotatePhaseReencrypted:
+		return 0, ErrRotationCleaning
+	default:
+		j.Phase = RotatePhaseReencrypt
+	}
+
+	if err := nv.saveRotateJournal(j); err != nil {
+		return 0, err
+	}
+
+	num := 0
+	for h, err := range v.Headers() {
+		if err != nil {
+			return num, err
+		}
+		if h.Err != nil {
+			var e *age.NoIdentityMatchError
+			if errors.As(h.Err, &e) {
+				continue
+			}
+
+			return num, h.Err
+		}
+
+		name, err := fileName(h, nv.Recipients(), RotateSuffix)
+		if err != nil {
+			return num, err
+		}
+		f := RotateFile{Old: filepath.Base(h.Path), New: name}
+		if !slices.Contains(j.Files, f) {
+			j.Files = append(j.Files, f)
+			if err := nv.saveRotateJournal(j); err != nil {
+				return num, err
+			}
+		}
+
+		if err := v.reencrypt(nv, h, RotateSuffix); err != nil {
+			return num, err
+		}
+		num++
+	}
+
+	j.Phase = RotatePhaseReencrypted
+	if err := nv.saveRotateJournal(j); err != nil {
+		return num, err
+	}
+
+	return num, nil
+}
+
+// nextVault returns the vault of the identity next for a rotation of the
+// vault v, with its recipients and the category policies with the
+// identity replaced by next. The manifest is signed with the key of v.
+func (v *Vault) nextVault(next id.Identity) (*Vault, error) {
+	nv := &Vault{repository: v.repository, id: next, workers: v.workers, signer: v.signer, trustedSigners: v.trustedSigners}
+	if err := nv.setRecipients(v.recipients); err != nil {
+		return nil, err
+	}
+	if err := nv.setPolicies(v.rotatePolicies(next)); err != nil {
+		return nil, err
+	}
+
+	return nv, nil
+}
+
+// CleanRotate finishes the rotation to the key of the vault, once all the
+// files were reencrypted by Rotate: it removes the files of the old key,
+// renames the reencrypted files to their standard names, backs up the old
+// key and renames the next key to its path, and signs the manifest with the
+// next key.
+//
+// Each phase is recorded in the rotate journal, and the steps of a phase
+// can be done again. An interrupted CleanRotate is resumed by running it
+// again, with the next key at its new path once renamed. It returns the
+// journal of the finished rotation, or ErrNoRotation.
+func (v *Vault) CleanRotate() (*RotateJournal, error) {
+	j, err := v.RotateJournal()
+	if err != nil {
+		return nil, err
+	}
+	if j.Phase == RotatePhaseReencrypt {
+		return nil, errors.New("the files of the rotation are not reencrypted yet")
+	}
+
+	phases := []struct {
+		phase string
+		run   func(j *RotateJournal) error
+	}{
+		{RotatePhaseRemove, v.removeRotated},
+		{RotatePhaseRename, v.renameRotated},
+		{RotatePhaseKey, v.swapRotateKeys},
+		{RotatePhaseSign, func(*RotateJournal) error {
+			_, err := v.SignManifest()
+			return err
+		}},
+	}
+
+	start := 0
+	for i, p := range phases {
+		if p.phase == j.Phase {
+			start = i
+		}
+	}
+
+	for _, p := range phases[start:] {
+		if j.Phase != p.phase {
+			j.Phase = p.phase
+			if err := v.saveRotateJournal(j); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.run(j); err != nil {
+			return nil, fmt.Errorf("could not %s the files of the rotation: %w", p.phase, err)
+		}
+	}
+
+	if err := osRemove(filepath.Join(v.repository, RotateJournalFileName)); err != nil {
+		return nil, fmt.Errorf("could not remove the rotate journal: %w", err)
+	}
+
+	return j, nil
+}
+
+// removeRotated removes the files of the old key of the journal j.
+func (v *Vault) removeRotated(j *RotateJournal) error {
+	for _, f := range j.Files {
+		err := osRemove(filepath.Join(v.repository, f.Old))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// renameRotated renames the reencrypted files of the journal j to their
+// standard names.
+func (v *Vault) renameRotated(j *RotateJournal) error {
+	for _, f := range j.Files {
+		path := filepath.Join(v.repository, f.New)
+		target := filepath.Join(v.repository, strings.TrimSuffix(f.New, RotateSuffix+Extension)+Extension)
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if _, err := os.Stat(target); err != nil {
+				return fmt.Errorf("found neither %s nor %s: %w", f.New, filepath.Base(target), err)
+			}
+			continue
+		}
+
+		if err := osRename(path, target); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// swapRotateKeys copies the old key of the journal j to a backup file, and
+// renames the next key to the path of the old key. The path of the backup
+// is recorded in the journal before it is written.
+func (v *Vault) swapRotateKeys(j *RotateJournal) error {
+	if _, err := os.Stat(j.NextKeyPath); errors.Is(err, os.ErrNotExist) {
+		// The next key was already renamed
+		return nil
+	}
+
+	if j.BackupKeyPath == "" {
+		j.BackupKeyPath = id.BackupFilePath(filepath.Dir(j.KeyPath))
+		if err := v.saveRotateJournal(j); err != nil {
+			return err
+		}
+	}
+
+	key, err := os.ReadFile(j.KeyPath)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(j.BackupKeyPath, key, 0600); err != nil {
+		return err
+	}
+
+	return osRename(j.NextKeyPath, j.KeyPath)
+}
+
+// AbortRotate aborts the rotation of the vault to the identity next, before
+// the files of the vault were removed: it removes the reencrypted files,
+// the rotate journal and the next key file. The manifest is updated with
+// the key of the vault. It returns ErrRotationCommitted if the rotation is
+// being cleaned.
+//
+// Files of the rotation not recorded in a journal, f. ex. of a previous
+// version of privage, are removed too.
+func (v *Vault) AbortRotate(next id.Identity) error {
+	nv, err := v.nextVault(next)
+	if err != nil {
+		return err
+	}
+
+	j, err := nv.RotateJournal()
+	switch {
+	case errors.Is(err, ErrNoRotation):
+		j = &RotateJournal{NextKeyPath: next.Path}
+	case err != nil:
+		return err
+	case j.Phase != RotatePhaseReencrypt && j.Phase != RotatePhaseReencrypted:
+		return ErrRotationCommitted
+	}
+
+	paths := map[string]bool{}
+	for _, f := range j.Files {
+		paths[filepath.Join(v.repository, f.New)] = true
+	}
+	for h, err := range nv.Headers() {
+		if err != nil {
+			return err
+		}
+		if h.Err == nil && fileSuffix(h.Path) == RotateSuffix {
+			paths[h.Path] = true
+		}
+	}
+
+	if err := v.checkManifest(); err != nil {
+		return err
+	}
+
+	for path := range paths {
+		err := osRemove(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := v.recordFile(path); err != nil {
+			return err
+		}
+	}
+
+	err = osRemove(filepath.Join(v.repository, RotateJournalFileName))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove the rotate journal: %w", err)
+	}
+
+	if j.NextKeyPath == "" {
+		return nil
+	}
+	err = osRemove(j.NextKeyPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// RotateJournal returns the journal of the rotation in progress to the key
+// of the vault. It returns ErrNoRotation if there is none.
+func (v *Vault) RotateJournal() (*RotateJournal, error) {
+	data, err := os.ReadFile(filepath.Join(v.repository, RotateJournalFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoRotation
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the rotate journal: %w", err)
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(data), v.id.Id)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt the rotate journal with the key %s: %w", v.id.Path, err)
+	}
+
+	var j RotateJournal
+	if err := toml.NewDecoder(r).Decode(&j); err != nil {
+		return nil, fmt.Errorf("could not parse the rotate journal: %w", err)
+	}
+
+	return &j, nil
+}
+
+// saveRotateJournal encrypts the journal j to the vault identity and writes
+// it atomically in the repository.
+func (v *Vault) saveRotateJournal(j *RotateJournal) error {
+	plain := new(bytes.Buffer)
+	if err := toml.NewEncoder(plain).Encode(j); err != nil {
+		return fmt.Errorf("failed to encode the rotate journal: %w", err)
+	}
+
+	buf := new(bytes.Buffer)
+	ageWr, err := age.Encrypt(buf, v.id.Recipient)
+	if err != nil {
+		return fmt.Errorf("failed to create age encryptor for the rotate journal: %w", err)
+	}
+	if _, err := plain.WriteTo(ageWr); err != nil {
+		return errors.Join(fmt.Errorf("failed to write the rotate journal: %w", err), ageWr.Close())
+	}
+	if err := ageWr.Close(); err != nil {
+		return fmt.Errorf("failed to close the rotate journal encryptor: %w", err)
+	}
+
+	return writeFileAtomic(filepath.Join(v.repository, RotateJournalFileName), buf.Bytes(), 0600)
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmpPath := path + tmpExtension
+	if err := os.WriteFile(tmpPath, data, perm); err != nil {
+		return err
+	}
+	if err := osRename(tmpPath, path); err != nil {
+		return errors.Join(err, os.Remove(tmpPath))
+	}
+
+	return nil
+}
@@ -0,0 +1,340 @@
+package vault
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	id "github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+)
+
+var errInjected = errors.New("injected failure")
+
+// rotation is a repository with the files of the old key and a next key,
+// both in key files of the repository.
+type rotation struct {
+	v, nv *Vault
+	next  id.Identity
+
+	// files are the encrypted files of the old key, by name.
+	files map[string][]byte
+	// key and nextKey are the contents of the key files.
+	key, nextKey []byte
+}
+
+// writeKey writes a new age key file in path and returns its identity.
+func writeKey(t *testing.T, path string) (id.Identity, []byte) {
+	t.Helper()
+	k, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity := id.New(k, path)
+
+	var buf bytes.Buffer
+	if err := id.WriteAge(&buf, identity); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return identity, buf.Bytes()
+}
+
+func newRotation(t *testing.T) *rotation {
+	t.Helper()
+	dir := t.TempDir()
+	r := &rotation{}
+
+	current, key := writeKey(t, filepath.Join(dir, id.DefaultFileName))
+	v, err := New(&setup.Setup{Id: current, Repository: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, label := range []string{"a", "b", "c"} {
+		put(t, v, label, "work", "content "+label)
+	}
+
+	next, nextKey := writeKey(t, filepath.Join(dir, "privage-key-rotate.txt"))
+	nv, err := New(&setup.Setup{Id: next, Repository: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.v, r.nv, r.next, r.key, r.nextKey = v, nv, next, key, nextKey
+	r.files = privageFiles(t, dir)
+	return r
+}
+
+// privageFiles returns the contents of the encrypted files of dir by name.
+func privageFiles(t *testing.T, dir string) map[string][]byte {
+	t.Helper()
+	paths, err := privagePaths(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[filepath.Base(path)] = data
+	}
+	return files
+}
+
+// injectFailure makes the n-th rename or removal of a rotation fail, and
+// returns a function that restores them and reports whether it failed.
+func injectFailure(n int) (restore func() bool) {
+	steps := 0
+	step := func() error {
+		steps++
+		if steps == n {
+			return errInjected
+		}
+		return nil
+	}
+	osRename = func(oldpath, newpath string) error {
+		if err := step(); err != nil {
+			return err
+		}
+		return os.Rename(oldpath, newpath)
+	}
+	osRemove = func(name string) error {
+		if err := step(); err != nil {
+			return err
+		}
+		return os.Remove(name)
+	}
+
+	return func() bool {
+		osRename, osRemove = os.Rename, os.Remove
+		return steps >= n
+	}
+}
+
+// run rotates and cleans the rotation.
+func (r *rotation) run() error {
+	if _, err := r.v.Rotate(r.next); err != nil {
+		return err
+	}
+	_, err := r.nv.CleanRotate()
+	return err
+}
+
+// resume finishes an interrupted rotation.
+func (r *rotation) resume(t *testing.T) {
+	t.Helper()
+	j, err := r.nv.RotateJournal()
+	if errors.Is(err, ErrNoRotation) || err == nil && j.Phase == RotatePhaseReencrypt {
+		if _, err := r.v.Rotate(r.next); err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+	} else if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.nv.CleanRotate(); err != nil {
+		t.Fatalf("CleanRotate failed: %v", err)
+	}
+}
+
+// checkRotated checks that the files are encrypted with the next key, with
+// their standard names, and that the next key replaced the old one.
+func (r *rotation) checkRotated(t *testing.T) {
+	t.Helper()
+	dir := r.v.Repository()
+
+	files := privageFiles(t, dir)
+	if len(files) != len(r.files) {
+		t.Fatalf("expected %d files, got %v", len(r.files), slices.Sorted(maps.Keys(files)))
+	}
+	for _, label := range []string{"a", "b", "c"} {
+		if got := readAll(t, r.nv, mustGet(t, r.nv, label)); got != "content "+label {
+			t.Errorf("unexpected content %q of %s", got, label)
+		}
+	}
+	for name := range files {
+		if fileSuffix(name) != "" {
+			t.Errorf("expected the standard name, got %s", name)
+		}
+	}
+
+	if key := readFile(t, r.v, id.DefaultFileName); !bytes.Equal(key, r.nextKey) {
+		t.Errorf("expected the next key in the key file")
+	}
+	backups, err := filepath.Glob(filepath.Join(dir, id.DefaultFileName+"-*.bak"))
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expected a backup of the old key, got %v", backups)
+	}
+	if key, _ := os.ReadFile(backups[0]); !bytes.Equal(key, r.key) {
+		t.Errorf("expected the old key in the backup")
+	}
+
+	r.checkClean(t)
+	if rep := verify(t, r.nv); !rep.OK() {
+		t.Errorf("expected a manifest signed by the next key, got %+v", rep)
+	}
+}
+
+// checkRestored checks that the repository is as before the rotation.
+func (r *rotation) checkRestored(t *testing.T) {
+	t.Helper()
+	files := privageFiles(t, r.v.Repository())
+	if !maps.EqualFunc(files, r.files, bytes.Equal) {
+		t.Errorf("expected the files %v, got %v", slices.Sorted(maps.Keys(r.files)), slices.Sorted(maps.Keys(files)))
+	}
+	if key := readFile(t, r.v, id.DefaultFileName); !bytes.Equal(key, r.key) {
+		t.Errorf("expected the old key in the key file")
+	}
+	if _, err := os.Stat(r.next.Path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the next key file to be removed, got %v", err)
+	}
+
+	r.checkClean(t)
+	if rep := verify(t, r.v); !rep.OK() {
+		t.Errorf("expected a manifest signed by the old key, got %+v", rep)
+	}
+}
+
+// checkClean checks that no journal and no temporary files are left.
+func (r *rotation) checkClean(t *testing.T) {
+	t.Helper()
+	entries, err := os.ReadDir(r.v.Repository())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if e.Name() == RotateJournalFileName || strings.HasSuffix(e.Name(), tmpExtension) {
+			t.Errorf("unexpected file %s", e.Name())
+		}
+	}
+}
+
+func TestRotate(t *testing.T) {
+	r := newRotation(t)
+
+	num, err := r.v.Rotate(r.next)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if num != 3 {
+		t.Errorf("expected 3 reencrypted files, got %d", num)
+	}
+
+	j, err := r.nv.RotateJournal()
+	if err != nil {
+		t.Fatalf("RotateJournal failed: %v", err)
+	}
+	if j.Phase != RotatePhaseReencrypted || len(j.Files) != 3 || j.KeyPath != r.v.id.Path || j.NextKeyPath != r.next.Path {
+		t.Errorf("unexpected journal %+v", j)
+	}
+	if _, err := r.v.RotateJournal(); err == nil {
+		t.Errorf("expected the journal not to be decrypted with the old key")
+	}
+
+	// Rotate again reencrypts the files again
+	if num, err := r.v.Rotate(r.next); err != nil || num != 3 {
+		t.Fatalf("expected 3 reencrypted files, got %d, %v", num, err)
+	}
+	if j, _ := r.nv.RotateJournal(); len(j.Files) != 3 {
+		t.Errorf("expected 3 files in the journal, got %d", len(j.Files))
+	}
+
+	j, err = r.nv.CleanRotate()
+	if err != nil {
+		t.Fatalf("CleanRotate failed: %v", err)
+	}
+	if j.Phase != RotatePhaseSign || j.BackupKeyPath == "" {
+		t.Errorf("unexpected journal %+v", j)
+	}
+	r.checkRotated(t)
+
+	if _, err := r.nv.CleanRotate(); !errors.Is(err, ErrNoRotation) {
+		t.Errorf("expected ErrNoRotation, got %v", err)
+	}
+}
+
+func TestAbortRotate(t *testing.T) {
+	r := newRotation(t)
+	if _, err := r.v.Rotate(r.next); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.v.AbortRotate(r.next); err != nil {
+		t.Fatalf("AbortRotate failed: %v", err)
+	}
+	r.checkRestored(t)
+}
+
+// TestRotate_Failures interrupts a rotation at each of its steps, and
+// checks that it can be resumed to the rotated state, or aborted to the
+// previous state until the files of the old key are removed.
+func TestRotate_Failures(t *testing.T) {
+	// Count the steps of a rotation
+	steps := 0
+	for n := 1; ; n++ {
+		r := newRotation(t)
+		restore := injectFailure(n)
+		err := r.run()
+		if !restore() {
+			steps = n - 1
+			break
+		}
+		if !errors.Is(err, errInjected) {
+			t.Fatalf("expected the injected failure at step %d, got %v", n, err)
+		}
+	}
+	if steps < 10 {
+		t.Fatalf("expected at least 10 steps, got %d", steps)
+	}
+
+	for n := 1; n <= steps; n++ {
+		t.Run(fmt.Sprintf("Step%d/Resume", n), func(t *testing.T) {
+			r := newRotation(t)
+			restore := injectFailure(n)
+			_ = r.run()
+			restore()
+
+			r.resume(t)
+			r.checkRotated(t)
+		})
+
+		t.Run(fmt.Sprintf("Step%d/Abort", n), func(t *testing.T) {
+			r := newRotation(t)
+			restore := injectFailure(n)
+			_ = r.run()
+			restore()
+
+			committed := false
+			if j, err := r.nv.RotateJournal(); err == nil {
+				committed = j.Phase != RotatePhaseReencrypt && j.Phase != RotatePhaseReencrypted
+			}
+
+			err := r.v.AbortRotate(r.next)
+			if committed {
+				if !errors.Is(err, ErrRotationCommitted) {
+					t.Fatalf("expected ErrRotationCommitted, got %v", err)
+				}
+				r.resume(t)
+				r.checkRotated(t)
+				return
+			}
+			if err != nil {
+				t.Fatalf("AbortRotate failed: %v", err)
+			}
+			r.checkRestored(t)
+		})
+	}
+}
@@ -0,0 +1,474 @@
+// Package vault implements the privage repository: a flat directory of
+// .privage files, each one containing an encrypted header (category and
+// label) followed by the encrypted content.
+package vault
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/header"
+	id "github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/setup"
+)
+
+const (
+	// RotateSuffix is appended to the hash of files reencrypted by Rotate,
+	// so that they can coexist with the files of the current key.
+	RotateSuffix = ".rotate"
+)
+
+var (
+	// ErrNotFound is returned when a requested label does not exist in the repository.
+	ErrNotFound = errors.New("file not found in directory")
+
+	// ErrAmbiguous is returned when a label matches the files of several
+	// categories.
+	ErrAmbiguous = errors.New("ambiguous label")
+
+	// ErrDuplicate is returned when several files have the same category and
+	// label.
+	ErrDuplicate = errors.New("duplicate label")
+
+	// ErrExists is returned when a file for the header already exists in the repository.
+	ErrExists = errors.New("file already exists in directory")
+
+	// ErrNoIdentity is returned when the vault is opened without a valid identity.
+	ErrNoIdentity = errors.New("found no privage key file")
+
+	// ErrRestricted is returned when the content of a file is not encrypted
+	// to the vault identity because of the policy of its category.
+	ErrRestricted = errors.New("content restricted by category policy")
+
+	// ErrMixedRecipients is returned when post-quantum hybrid and X25519
+	// recipients are mixed, as age can not encrypt a file to both.
+	ErrMixedRecipients = errors.New("post-quantum and X25519 recipients can not be mixed")
+)
+
+// A Vault is a privage repository directory together with the age identity
+// used to encrypt and decrypt its files.
+type Vault struct {
+	repository string
+	id         id.Identity
+
+	// workers is the number of headers decrypted in parallel.
+	workers int
+
+	// index enables the encrypted header index.
+	index bool
+
+	// recipients are the age public keys, other than the one of the
+	// identity, the files are encrypted to.
+	recipients []string
+
+	// policies are the age public keys the content of the files of each
+	// category is encrypted to, instead of the recipients.
+	policies map[string][]string
+
+	// signer is the key that signs the manifest of the repository, derived
+	// from the identity. It is nil if the identity has no age secret key.
+	signer ed25519.PrivateKey
+
+	// trustedSigners are the public keys, besides the one of signer, whose
+	// signed manifests are trusted.
+	trustedSigners []ed25519.PublicKey
+}
+
+// New returns a Vault for the repository and identity of the Setup s.
+// It returns ErrNoIdentity if the identity of s could not be loaded.
+//
+// The number of headers decrypted in parallel is taken from the
+// parallelism of the configuration, defaulting to the number of CPUs. With an
+// age plugin identity, headers are decrypted one at a time, as the plugin
+// binary, f. ex. of a hardware key, is executed for each decryption. The
+// encrypted header index is used if enabled in the configuration.
+//
+// The files are encrypted to the identity and to the recipients of the
+// configuration and of the recipients file of the repository. The content
+// of the files of a category with a policy in the configuration is
+// encrypted to the recipients of the policy instead.
+//
+// The manifest of the repository is signed with a key derived from the
+// identity, if it is an X25519 or a post-quantum hybrid identity. A manifest
+// signed by one of the manifest signers of the configuration is trusted too.
+func New(s *setup.Setup) (*Vault, error) {
+	if s.Id.Id == nil {
+		return nil, fmt.Errorf("%w: %v", ErrNoIdentity, s.Id.Err)
+	}
+
+	v := &Vault{repository: s.Repository, id: s.Id, workers: runtime.NumCPU()}
+
+	var recipients []string
+	policies := map[string][]string{}
+	if s.C != nil {
+		if s.C.Parallelism > 0 {
+			v.workers = s.C.Parallelism
+		}
+		v.index = s.C.Index
+		if err := v.setTrustedSigners(s.C.ManifestSigners); err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, s.C.Recipients...)
+		for category, policy := range s.C.Categories {
+			policies[category] = policy.Recipients
+		}
+	}
+
+	if s.Id.Plugin() {
+		v.workers = 1
+	}
+
+	if signer, err := s.Id.SigningKey(); err == nil {
+		v.signer = signer
+	}
+
+	if err := v.setPolicies(policies); err != nil {
+		return nil, err
+	}
+
+	fileRecipients, err := ReadRecipientsFile(s.Repository)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := v.setRecipients(append(recipients, fileRecipients...)); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Repository returns the directory of the encrypted files.
+func (v *Vault) Repository() string {
+	return v.repository
+}
+
+// PlaintextPath returns the path in the repository of the decrypted file of
+// header h, named after its label. It returns false if the label is not a
+// local file name, f. ex. "../notes" in a file written by a teammate.
+func (v *Vault) PlaintextPath(h *header.Header) (string, bool) {
+	if !filepath.IsLocal(h.Label) {
+		return "", false
+	}
+
+	return filepath.Join(v.repository, h.Label), true
+}
+
+// List returns the headers of all the files in the repository.
+//
+// Files that could not be read or decrypted are also returned, with the
+// Err field of the header set.
+func (v *Vault) List() ([]*header.Header, error) {
+	headers := []*header.Header{}
+	for h, err := range v.Headers() {
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, h)
+	}
+
+	return headers, nil
+}
+
+// Get returns the header of the file with the given label, bare or
+// qualified with the category, as category/label: see Find.
+func (v *Vault) Get(label string) (*header.Header, error) {
+	headers, err := v.List()
+	if err != nil {
+		return nil, err
+	}
+
+	return Find(headers, label)
+}
+
+// Find returns the header of headers with the given label. A qualified
+// label, category/label, matches the file of the category with the label,
+// and takes precedence over a file whose label contains the separator.
+//
+// It returns ErrNotFound if no file has that label, ErrAmbiguous, listing
+// the qualified labels of the candidates, if a bare label matches the files
+// of several categories, and ErrDuplicate if several files have the same
+// category and label.
+func Find(headers []*header.Header, label string) (*header.Header, error) {
+	if category, name, ok := strings.Cut(label, header.CategorySeparator); ok {
+		matches := filterHeaders(headers, func(h *header.Header) bool {
+			return h.Category == category && h.Label == name
+		})
+		if len(matches) > 0 {
+			return single(label, matches)
+		}
+	}
+
+	matches := filterHeaders(headers, func(h *header.Header) bool {
+		return h.Label == label
+	})
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%w: %q", ErrNotFound, label)
+	}
+
+	return single(label, matches)
+}
+
+// filterHeaders returns the valid headers of headers that match.
+func filterHeaders(headers []*header.Header, match func(*header.Header) bool) []*header.Header {
+	var matches []*header.Header
+	for _, h := range headers {
+		if h.Err == nil && match(h) {
+			matches = append(matches, h)
+		}
+	}
+	return matches
+}
+
+// single returns the only header of matches, the files found for label.
+func single(label string, matches []*header.Header) (*header.Header, error) {
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+
+	candidates := make([]string, len(matches))
+	for i, h := range matches {
+		candidates[i] = h.QualifiedLabel()
+	}
+	slices.Sort(candidates)
+	candidates = slices.Compact(candidates)
+
+	if len(candidates) == 1 {
+		return nil, fmt.Errorf("%w: %d files are %s, check the repository with \"privage fsck\"", ErrDuplicate, len(matches), candidates[0])
+	}
+
+	return nil, fmt.Errorf("%w: %q matches %s", ErrAmbiguous, label, strings.Join(candidates, ", "))
+}
+
+// Open returns a reader of the decrypted content of the file of header h.
+// The caller must close the returned reader.
+//
+// It returns ErrRestricted if the header, but not the content, can be
+// decrypted with the vault identity.
+func (v *Vault) Open(h *header.Header) (io.ReadCloser, error) {
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := contentReader(f, v.id)
+	if err != nil {
+		var e *age.NoIdentityMatchError
+		if errors.As(err, &e) {
+			err = fmt.Errorf("%w: category %q", ErrRestricted, h.Category)
+		}
+		return nil, errors.Join(err, f.Close())
+	}
+
+	return &contentFile{Reader: r, f: f}, nil
+}
+
+// Put encrypts the header h and the content, and saves them in the
+// repository. An existing file for the same header is overwritten.
+//
+// The header is saved in the current version, with the modification time
+// set to now. The creation time of h is kept if set.
+func (v *Vault) Put(h *header.Header, content io.Reader) error {
+	hv := *h
+	hv.Modified = time.Time{}
+	return v.encryptSave(&hv, "", content)
+}
+
+// Replace encrypts the content with the header h, as Put, and removes the
+// file of h if the new file has another name, as after a change of the
+// recipients of the repository.
+func (v *Vault) Replace(h *header.Header, content io.Reader) error {
+	if err := v.Put(h, content); err != nil {
+		return err
+	}
+
+	return v.deleteRenamed(h, "")
+}
+
+// deleteRenamed deletes the file of header h if its name is not the name
+// of the header with the suffix and the recipients of the vault, after the
+// file was saved again under that name.
+func (v *Vault) deleteRenamed(h *header.Header, suffix string) error {
+	fname, err := fileName(h, v.Recipients(), suffix)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(h.Path) == fname {
+		return nil
+	}
+
+	return v.Delete(h)
+}
+
+// Delete removes the file of header h from the repository, and from the
+// manifest. The file is kept if the manifest can not be updated.
+func (v *Vault) Delete(h *header.Header) error {
+	if err := v.checkManifest(); err != nil {
+		return err
+	}
+
+	if err := os.Remove(h.Path); err != nil {
+		return err
+	}
+
+	return v.recordFile(h.Path)
+}
+
+// Rename reencrypts the content of the file of header h with the new header
+// to, and removes the old file. It returns ErrExists if a file for the
+// header to is already present. If the old file can not be removed, the new
+// file is removed, so that only one file has the label.
+//
+// The creation time and tags of h are kept, unless set in to.
+func (v *Vault) Rename(h *header.Header, to *header.Header) error {
+	hv := *to
+	if hv.Created.IsZero() {
+		hv.Created = h.Created
+	}
+
+	fname, err := fileName(&hv, v.Recipients(), "")
+	if err != nil {
+		return err
+	}
+
+	if err := v.Copy(h, &hv); err != nil {
+		return err
+	}
+
+	if err := osRemove(h.Path); err != nil {
+		return errors.Join(err, v.removeFile(filepath.Join(v.repository, fname)))
+	}
+
+	return v.recordFile(h.Path)
+}
+
+// Copy encrypts the content of the file of header h with the new header to,
+// and keeps the old file. It returns ErrExists if a file for the header to
+// is already present.
+//
+// The tags of h are kept, unless set in to.
+func (v *Vault) Copy(h *header.Header, to *header.Header) (err error) {
+	fname, err := fileName(to, v.Recipients(), "")
+	if err != nil {
+		return err
+	}
+
+	_, err = os.Stat(filepath.Join(v.repository, fname))
+	if err == nil {
+		return fmt.Errorf("%w: %q", ErrExists, to.Label)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	r, err := v.Open(h)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	hv := *to
+	if hv.Tags == nil {
+		hv.Tags = h.Tags
+	}
+
+	return v.Put(&hv, r)
+}
+
+// reencrypt saves the file of header h in the vault nv, with the given
+// suffix. If the content is restricted by a category policy, it is copied
+// still encrypted, and only the header is reencrypted.
+func (v *Vault) reencrypt(nv *Vault, h *header.Header, suffix string) (err error) {
+	r, err := v.Open(h)
+	if errors.Is(err, ErrRestricted) {
+		return nv.copySave(h, suffix)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	return nv.encryptSave(h, suffix, r)
+}
+
+// Migrate rewrites the file of header h, of a previous version, with a
+// header of the current version. The file keeps its name, unless the
+// recipients of the repository changed since it was written: it is then
+// saved under the name of the current recipients, and the old file removed.
+//
+// The creation and modification times of the new header are set to the
+// modification time of the file.
+func (v *Vault) Migrate(h *header.Header) (err error) {
+	if h.Version == header.CurrentVersion {
+		return nil
+	}
+
+	info, err := os.Stat(h.Path)
+	if err != nil {
+		return err
+	}
+
+	hv := header.Header{
+		Category: h.Category,
+		Label:    h.Label,
+		Created:  info.ModTime(),
+		Modified: info.ModTime(),
+	}
+
+	r, err := v.Open(h)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := r.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	suffix := fileSuffix(h.Path)
+	if err := v.encryptSave(&hv, suffix, r); err != nil {
+		return err
+	}
+
+	return v.deleteRenamed(h, suffix)
+}
+
+// fileSuffix returns the suffix between the hash and the extension in the
+// name of the file in path.
+func fileSuffix(path string) string {
+	name := strings.TrimSuffix(filepath.Base(path), Extension)
+	if len(name) < hexLen {
+		return ""
+	}
+
+	return name[hexLen:]
+}
+
+// contentFile is the reader returned by Open. Closing it closes the
+// underlying encrypted file.
+type contentFile struct {
+	io.Reader
+	f *os.File
+}
+
+func (c *contentFile) Close() error {
+	return c.f.Close()
+}
@@ -0,0 +1,770 @@
+package vault
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/pem"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/revelaction/privage/header"
+	id "github.com/revelaction/privage/identity"
+	"github.com/revelaction/privage/identity/plugintest"
+	"github.com/revelaction/privage/setup"
+)
+
+// The kinds of keys of the identities of the test vaults.
+const (
+	keyX25519 = "x25519"
+	keyHybrid = "hybrid"
+	keySsh    = "ssh"
+	keyPlugin = "plugin"
+)
+
+// testKeys generate a fresh identity of each kind of key.
+var testKeys = map[string]func(t *testing.T) id.Identity{
+	keyX25519: func(t *testing.T) id.Identity {
+		k, err := age.GenerateX25519Identity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id.New(k, "key.txt")
+	},
+	keyHybrid: func(t *testing.T) id.Identity {
+		k, err := age.GenerateHybridIdentity()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id.New(k, "pq-key.txt")
+	},
+	keySsh: func(t *testing.T) id.Identity {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block, err := ssh.MarshalPrivateKey(priv, "user@host")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id.LoadSsh(bytes.NewReader(pem.EncodeToMemory(block)), "id_ed25519", nil)
+	},
+	keyPlugin: func(t *testing.T) id.Identity {
+		var buf bytes.Buffer
+		if err := plugintest.Generate(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return id.LoadAge(&buf, "plugin-key.txt")
+	},
+}
+
+// newVault returns a vault with a fresh identity of the kind of key on the
+// repository dir. The vault and its teammates, vaults of the same
+// repository, trust the manifests signed by each other, as with the manifest
+// signers in their configuration.
+func newVault(t *testing.T, kind, dir string, teammates ...*Vault) *Vault {
+	t.Helper()
+	identity := testKeys[kind](t)
+	if identity.Err != nil {
+		t.Fatal(identity.Err)
+	}
+
+	v, err := New(&setup.Setup{Id: identity, Repository: dir})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	// Identities without a signing key, as plugin ones, sign no manifest
+	for _, mate := range teammates {
+		if mate.signer != nil {
+			v.trustedSigners = append(v.trustedSigners, mate.signer.Public().(ed25519.PublicKey))
+		}
+		if v.signer != nil {
+			mate.trustedSigners = append(mate.trustedSigners, v.signer.Public().(ed25519.PublicKey))
+		}
+	}
+
+	return v
+}
+
+// put saves an encrypted file in the vault.
+func put(t *testing.T, v *Vault, label, category, content string) {
+	t.Helper()
+	h := &header.Header{Label: label, Category: category}
+	if err := v.Put(h, strings.NewReader(content)); err != nil {
+		t.Fatalf("Put %s failed: %v", label, err)
+	}
+}
+
+// readAll returns the decrypted content of the file of header h.
+func readAll(t *testing.T, v *Vault, h *header.Header) string {
+	t.Helper()
+	r, err := v.Open(h)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer func() {
+		if err := r.Close(); err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	}()
+
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+
+	return string(content)
+}
+
+func TestNew_NoIdentity(t *testing.T) {
+	s := &setup.Setup{
+		Id:         id.Identity{Err: errors.New("key missing")},
+		Repository: t.TempDir(),
+	}
+
+	_, err := New(s)
+	if !errors.Is(err, ErrNoIdentity) {
+		t.Fatalf("expected ErrNoIdentity, got %v", err)
+	}
+}
+
+func TestVault_PutGetOpen(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "mycred", "credential", "login = \"me\"")
+	put(t, v, "plan.doc", "work", "secret plan")
+
+	h, err := v.Get("plan.doc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if h.Category != "work" {
+		t.Errorf("expected category work, got %q", h.Category)
+	}
+	if got := readAll(t, v, h); got != "secret plan" {
+		t.Errorf("expected content %q, got %q", "secret plan", got)
+	}
+
+	_, err = v.Get("missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestVault_List(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "cat1", "content a")
+	put(t, v, "b", "cat2", "content b")
+
+	// A file encrypted to other identity is listed with an error
+	other := newVault(t, keyX25519, t.TempDir())
+	put(t, other, "c", "cat3", "content c")
+	h, err := other.Get("c")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := os.Rename(h.Path, filepath.Join(v.Repository(), filepath.Base(h.Path))); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := v.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	if len(headers) != 3 {
+		t.Fatalf("expected 3 headers, got %d", len(headers))
+	}
+
+	failures := 0
+	for _, h := range headers {
+		if h.Err != nil {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Errorf("expected 1 header with error, got %d", failures)
+	}
+}
+
+func TestVault_Delete(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "gone", "work", "content")
+
+	h, err := v.Get("gone")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if err := v.Delete(h); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if _, err := v.Get("gone"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound after Delete, got %v", err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	headers := []*header.Header{
+		{Label: "notes", Category: "work"},
+		{Label: "notes", Category: "home"},
+		{Label: "mail", Category: "work"},
+		{Label: "work/mail", Category: "archive"},
+		{Label: "b", Category: "a"},
+		{Label: "a/b", Category: "other"},
+		{Err: errors.New("broken")},
+	}
+
+	tests := []struct {
+		label string
+		// want is the qualified label of the found header.
+		want    string
+		wantErr error
+	}{
+		{label: "mail", want: "work/mail"},
+		{label: "work/notes", want: "work/notes"},
+		{label: "home/notes", want: "home/notes"},
+		{label: "notes", wantErr: ErrAmbiguous},
+		{label: "other/notes", wantErr: ErrNotFound},
+		{label: "missing", wantErr: ErrNotFound},
+		{label: "", wantErr: ErrNotFound},
+		// A label with the separator
+		{label: "archive/work/mail", want: "archive/work/mail"},
+		{label: "work/mail", want: "work/mail"},
+		// The qualified form takes precedence
+		{label: "a/b", want: "a/b"},
+		{label: "other/a/b", want: "other/a/b"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			h, err := Find(headers, tt.label)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Find failed: %v", err)
+			}
+			if got := h.QualifiedLabel(); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	_, err := Find(headers, "notes")
+	if err == nil || !strings.Contains(err.Error(), "home/notes, work/notes") {
+		t.Errorf("expected the candidates in the error, got %v", err)
+	}
+}
+
+func TestFind_Duplicate(t *testing.T) {
+	headers := []*header.Header{
+		{Label: "notes", Category: "work"},
+		{Label: "notes", Category: "work"},
+		{Label: "notes", Category: "home"},
+		{Label: "mail", Category: "work"},
+		{Label: "mail", Category: "work"},
+	}
+
+	tests := []struct {
+		label   string
+		wantErr error
+		// wantMsg is contained in the error message.
+		wantMsg string
+	}{
+		{label: "work/notes", wantErr: ErrDuplicate, wantMsg: "2 files are work/notes, check the repository with \"privage fsck\""},
+		{label: "mail", wantErr: ErrDuplicate, wantMsg: "2 files are work/mail"},
+		{label: "notes", wantErr: ErrAmbiguous, wantMsg: `"notes" matches home/notes, work/notes`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			_, err := Find(headers, tt.label)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("expected error containing %q, got %v", tt.wantMsg, err)
+			}
+		})
+	}
+
+	h, err := Find(headers, "home/notes")
+	if err != nil {
+		t.Fatalf("Find failed: %v", err)
+	}
+	if got := h.QualifiedLabel(); got != "home/notes" {
+		t.Errorf("got %s, want home/notes", got)
+	}
+}
+
+func TestVault_PlaintextPath(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+
+	tests := []struct {
+		label  string
+		wantOK bool
+	}{
+		{label: "notes.txt", wantOK: true},
+		{label: "docs/notes.txt", wantOK: true},
+		{label: "../notes.txt"},
+		{label: "docs/../../notes.txt"},
+		{label: "/tmp/notes.txt"},
+		{label: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.label, func(t *testing.T) {
+			path, ok := v.PlaintextPath(&header.Header{Label: tt.label, Category: "work"})
+			if ok != tt.wantOK {
+				t.Fatalf("got %v for %q, want %v", ok, tt.label, tt.wantOK)
+			}
+			if ok && path != filepath.Join(v.Repository(), tt.label) {
+				t.Errorf("got path %s", path)
+			}
+		})
+	}
+}
+
+func TestVault_Rename(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "old", "work", "content")
+	put(t, v, "taken", "work", "other")
+
+	h, err := v.Get("old")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	t.Run("Collision", func(t *testing.T) {
+		err := v.Rename(h, &header.Header{Label: "taken", Category: "work"})
+		if !errors.Is(err, ErrExists) {
+			t.Fatalf("expected ErrExists, got %v", err)
+		}
+	})
+
+	t.Run("Success", func(t *testing.T) {
+		if err := v.Rename(h, &header.Header{Label: "new", Category: "personal"}); err != nil {
+			t.Fatalf("Rename failed: %v", err)
+		}
+
+		if _, err := v.Get("old"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected old label to be gone, got %v", err)
+		}
+
+		renamed, err := v.Get("new")
+		if err != nil {
+			t.Fatalf("Get new failed: %v", err)
+		}
+		if renamed.Category != "personal" {
+			t.Errorf("expected category personal, got %q", renamed.Category)
+		}
+		if got := readAll(t, v, renamed); got != "content" {
+			t.Errorf("expected content preserved, got %q", got)
+		}
+	})
+}
+
+// TestVault_RenameRemoveFails tests that the new file is removed when the
+// old file can not be removed.
+func TestVault_RenameRemoveFails(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "old", "work", "content")
+	h := mustGet(t, v, "old")
+
+	restore := injectFailure(1)
+	err := v.Rename(h, &header.Header{Label: "new", Category: "work"})
+	if !restore() {
+		t.Fatal("expected the removal of the old file to be attempted")
+	}
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected the injected error, got %v", err)
+	}
+
+	if _, err := v.Get("new"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected the new file to be removed, got %v", err)
+	}
+	if got := readAll(t, v, mustGet(t, v, "old")); got != "content" {
+		t.Errorf("expected the old file to be kept, got %q", got)
+	}
+	if r := verify(t, v); !r.OK() {
+		t.Errorf("expected a valid manifest, got %+v", r)
+	}
+}
+
+func TestVault_Copy(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "old", "work", "content")
+
+	h, err := v.Get("old")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if err := v.Copy(h, &header.Header{Label: "old", Category: "work"}); !errors.Is(err, ErrExists) {
+		t.Fatalf("expected ErrExists, got %v", err)
+	}
+
+	if err := v.Copy(h, &header.Header{Label: "new", Category: "work"}); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+
+	for _, label := range []string{"old", "new"} {
+		got, err := v.Get(label)
+		if err != nil {
+			t.Fatalf("Get %s failed: %v", label, err)
+		}
+		if content := readAll(t, v, got); content != "content" {
+			t.Errorf("expected content of %s preserved, got %q", label, content)
+		}
+	}
+}
+
+func TestVault_Replace(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+	h := mustGet(t, v, "a")
+
+	// The recipients changed after the file was written
+	mate := newVault(t, keyX25519, v.Repository(), v).Recipients()[0]
+	if err := WriteRecipientsFile(v.Repository(), []string{v.Recipients()[0], mate}); err != nil {
+		t.Fatal(err)
+	}
+	nv, err := New(&setup.Setup{Id: v.id, Repository: v.Repository()})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	if err := nv.Replace(h, strings.NewReader("edited a")); err != nil {
+		t.Fatalf("Replace failed: %v", err)
+	}
+
+	headers, err := nv.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 1 {
+		t.Fatalf("expected 1 file, got %d", len(headers))
+	}
+	if headers[0].Path == h.Path {
+		t.Errorf("expected the file renamed for the new recipients")
+	}
+	if got := readAll(t, nv, headers[0]); got != "edited a" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if r := verify(t, nv); !r.OK() {
+		t.Errorf("expected the files to match the manifest, got %+v", r)
+	}
+}
+
+func TestVault_Rotate(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+	put(t, v, "b", "credential", "content b")
+
+	next, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextId := id.New(next, "next-key")
+
+	num, err := v.Rotate(nextId)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if num != 2 {
+		t.Errorf("expected 2 reencrypted files, got %d", num)
+	}
+
+	// Rerun: files of the new key are skipped
+	num, err = v.Rotate(nextId)
+	if err != nil {
+		t.Fatalf("second Rotate failed: %v", err)
+	}
+	if num != 2 {
+		t.Errorf("expected 2 reencrypted files on rerun, got %d", num)
+	}
+
+	nv, err := New(&setup.Setup{Id: nextId, Repository: v.Repository()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := nv.List()
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+
+	rotated := 0
+	for _, h := range headers {
+		if h.Err != nil {
+			continue
+		}
+		if !strings.HasSuffix(h.Path, RotateSuffix+Extension) {
+			t.Errorf("expected rotate suffix in %s", h.Path)
+		}
+		if got := readAll(t, nv, h); got != "content "+h.Label {
+			t.Errorf("unexpected content for %s: %q", h.Label, got)
+		}
+		rotated++
+	}
+
+	if rotated != 2 {
+		t.Errorf("expected 2 rotated files, got %d", rotated)
+	}
+}
+
+func TestVault_Rotate_PostQuantum(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	put(t, v, "a", "work", "content a")
+
+	next, err := age.GenerateHybridIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextId := id.New(next, "next-key")
+
+	num, err := v.Rotate(nextId)
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if num != 1 {
+		t.Errorf("expected 1 reencrypted file, got %d", num)
+	}
+
+	nv, err := New(&setup.Setup{Id: nextId, Repository: v.Repository()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := nv.Get("a")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := readAll(t, nv, h); got != "content a" {
+		t.Errorf("unexpected content %q", got)
+	}
+}
+
+// putV1 saves an encrypted file with a v1 header in the vault, as written
+// by previous versions of privage.
+func putV1(t *testing.T, v *Vault, label, category, content string) string {
+	t.Helper()
+	h := &header.Header{Version: header.Version1, Label: label, Category: category}
+
+	buf := new(bytes.Buffer)
+	ageWr, err := age.Encrypt(buf, v.id.Recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	padded, err := h.Pad()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ageWr.Write(padded); err != nil {
+		t.Fatal(err)
+	}
+	if err := ageWr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := header.PadEncrypted(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf = bytes.NewBuffer(data)
+	ageWr, err = age.Encrypt(buf, v.id.Recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(ageWr, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := ageWr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fname, err := fileName(h, v.Recipients(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(v.Repository(), fname)
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestVault_PutMetadata(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	before := time.Now().Add(-time.Second)
+	put(t, v, "notes", "work", "some notes")
+
+	h, err := v.Get("notes")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if h.Version != header.CurrentVersion {
+		t.Errorf("expected version %s, got %s", header.CurrentVersion, h.Version)
+	}
+	if h.Size != int64(len("some notes")) {
+		t.Errorf("expected size %d, got %d", len("some notes"), h.Size)
+	}
+	sum := sha256.Sum256([]byte("some notes"))
+	if !bytes.Equal(h.SHA256, sum[:]) {
+		t.Errorf("SHA256 mismatch")
+	}
+	if h.ContentType != "text/plain" {
+		t.Errorf("expected content type text/plain, got %q", h.ContentType)
+	}
+	if h.Created.Before(before) || !h.Modified.Equal(h.Created) {
+		t.Errorf("unexpected timestamps created %v, modified %v", h.Created, h.Modified)
+	}
+
+	// Overwrite keeps the creation time
+	created := time.Unix(1600000000, 0)
+	h.Created = created
+	if err := v.Put(h, strings.NewReader("more notes")); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	h, err = v.Get("notes")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if !h.Created.Equal(created) {
+		t.Errorf("expected created %v, got %v", created, h.Created)
+	}
+	if !h.Modified.After(created) {
+		t.Errorf("expected modified after created, got %v", h.Modified)
+	}
+}
+
+func TestVault_Migrate(t *testing.T) {
+	v := newVault(t, keyX25519, t.TempDir())
+	path := putV1(t, v, "old", "work", "old content")
+
+	h, err := v.Get("old")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if h.Version != header.Version1 {
+		t.Fatalf("expected version %s, got %s", header.Version1, h.Version)
+	}
+
+	mtime := time.Unix(1600000000, 0)
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := v.Migrate(h); err != nil {
+		t.Fatalf("Migrate failed: %v", err)
+	}
+
+	h, err = v.Get("old")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if h.Path != path {
+		t.Errorf("expected file name to be kept, got %s", h.Path)
+	}
+	if h.Version != header.CurrentVersion {
+		t.Errorf("expected version %s, got %s", header.CurrentVersion, h.Version)
+	}
+	if !h.Created.Equal(mtime) || !h.Modified.Equal(mtime) {
+		t.Errorf("expected timestamps from file, got %v %v", h.Created, h.Modified)
+	}
+	if h.Size != int64(len("old content")) {
+		t.Errorf("expected size %d, got %d", len("old content"), h.Size)
+	}
+	if got := readAll(t, v, h); got != "old content" {
+		t.Errorf("expected content preserved, got %q", got)
+	}
+
+	t.Run("LongLabel", func(t *testing.T) {
+		label := strings.Repeat("l", header.MaxLenghtLabel)
+		long := putV1(t, v, label, strings.Repeat("c", header.MaxLenghtCategory), "content")
+		h, err := v.Get(label)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The v2 header does not fit in one block
+		if err := v.Migrate(h); err != nil {
+			t.Fatalf("Migrate failed: %v", err)
+		}
+
+		h, err = v.Get(label)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Path != long || h.Version != header.CurrentVersion {
+			t.Errorf("expected migrated file %s, got %s %s", long, h.Path, h.Version)
+		}
+		if got := readAll(t, v, h); got != "content" {
+			t.Errorf("expected content preserved, got %q", got)
+		}
+	})
+
+	t.Run("RecipientsChanged", func(t *testing.T) {
+		path := putV1(t, v, "shared", "work", "shared content")
+		h := mustGet(t, v, "shared")
+
+		mate := newVault(t, keyX25519, v.Repository(), v).Recipients()[0]
+		if err := WriteRecipientsFile(v.Repository(), []string{v.Recipients()[0], mate}); err != nil {
+			t.Fatal(err)
+		}
+		nv, err := New(&setup.Setup{Id: v.id, Repository: v.Repository()})
+		if err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+
+		if err := nv.Migrate(h); err != nil {
+			t.Fatalf("Migrate failed: %v", err)
+		}
+
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("expected the old file to be removed, got %v", err)
+		}
+		h = mustGet(t, nv, "shared")
+		if h.Version != header.CurrentVersion {
+			t.Errorf("expected version %s, got %s", header.CurrentVersion, h.Version)
+		}
+		if got := readAll(t, nv, h); got != "shared content" {
+			t.Errorf("expected content preserved, got %q", got)
+		}
+		if r := verify(t, nv); !r.OK() {
+			t.Errorf("expected the files to match the manifest, got %+v", r)
+		}
+	})
+}
+
+func TestFileSuffix(t *testing.T) {
+	hash := strings.Repeat("a", 64)
+	tests := []struct {
+		path string
+		want string
+	}{
+		{path: "/repo/" + hash + Extension, want: ""},
+		{path: "/repo/" + hash + RotateSuffix + Extension, want: RotateSuffix},
+		{path: "short" + Extension, want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := fileSuffix(tt.path); got != tt.want {
+			t.Errorf("fileSuffix(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
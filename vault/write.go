@@ -0,0 +1,320 @@
+package vault
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/revelaction/privage/header"
+)
+
+// encryptSave encrypts the Header h and the content separately and
+// concatenates both encrypted payloads.
+//
+// It saves the concatenated encrypted payloads on an age file atomically.
+// The header is encrypted to all the recipients of the vault, the content
+// to the recipients of the category policy of h, if any. The name of the
+// file is a hash of the header (label and category) and the recipients of
+// the vault.
+//
+// The header is saved in the current version. Its size, checksum and
+// content type are computed from the content, and the Created and Modified
+// times are set to now if zero. h is not modified.
+func (v *Vault) encryptSave(h *header.Header, suffix string, content io.Reader) error {
+	recipients, err := v.contentRecipients(h.Category)
+	if err != nil {
+		return err
+	}
+
+	bufContent := bufio.NewReaderSize(content, sniffLen)
+	// A short or failing content is detected when copying it
+	sniff, _ := bufContent.Peek(sniffLen)
+	hv := newHeader(h, sniff)
+
+	return v.save(hv, suffix, func(w io.Writer) error {
+		return encryptContent(w, hv, bufContent, recipients)
+	})
+}
+
+// copySave saves the file of header h with the header encrypted to the
+// recipients of the vault, and the encrypted content of the file copied
+// unchanged. It is used for files whose content the vault identity can not
+// decrypt.
+func (v *Vault) copySave(h *header.Header, suffix string) (err error) {
+	f, err := os.Open(h.Path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	if _, err := readHeaderBlock(f); err != nil {
+		return err
+	}
+
+	return v.save(h, suffix, func(w io.Writer) error {
+		if _, err := io.Copy(w, f); err != nil {
+			return fmt.Errorf("failed to copy encrypted content: %w", err)
+		}
+		return nil
+	})
+}
+
+// save writes the header h, encrypted to the recipients of the vault,
+// followed by the content written by writeContent. writeContent may set
+// the size and checksum of h, as the header is encrypted after it.
+//
+// Uses atomic write pattern: writes to temp file, then renames on success.
+// The saved file is then recorded in the manifest. Nothing is written if
+// the manifest can not be updated.
+func (v *Vault) save(h *header.Header, suffix string, writeContent func(w io.Writer) error) (err error) {
+
+	// Step 0: Check that the saved file can be recorded in the manifest
+	if err := v.checkManifest(); err != nil {
+		return err
+	}
+
+	// Step 1: Parse the recipients
+	recipients, err := v.ageRecipients()
+	if err != nil {
+		return err
+	}
+
+	// Step 2: Generate final and temporary file paths
+	fname, err := fileName(h, v.Recipients(), suffix)
+	if err != nil {
+		return fmt.Errorf("failed to generate filename: %w", err)
+	}
+	finalPath := filepath.Join(v.repository, fname)
+	tmpPath := finalPath + ".tmp"
+
+	// Step 3: Compute the size of the header blocks, which depends on the
+	// number of recipients.
+	headerSize, err := encryptedHeaderSize(h, recipients)
+	if err != nil {
+		return err
+	}
+
+	// Step 4: Create temporary file
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create temp file %s: %w", tmpPath, err)
+	}
+
+	// DEFER 0 (executes LAST): Record the saved file in the manifest
+	// Only runs if the file was renamed to its final path.
+	defer func() {
+		if err == nil {
+			err = v.recordFile(finalPath)
+		}
+	}()
+
+	// DEFER 1 (executes THIRD): Cleanup temp file on error
+	// Fix: Defined BEFORE Rename, so it executes AFTER Rename.
+	// If defer Rename fails, it updates 'err', and this defer will see the error and remove the file.
+	// If Rename success, it sees no error and does nothing. This is correct because the temp file is already gone.
+	defer func() {
+		if err != nil {
+			if remErr := os.Remove(tmpPath); remErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to remove temp file: %w", remErr))
+			}
+		}
+	}()
+
+	// DEFER 2 (executes SECOND): Atomic rename if successful
+	// Only runs if no errors yet.
+	// This ensures that if os.Rename fails, the 'err' is captured, and Defer 1 cleans up.
+	defer func() {
+		if err == nil {
+			if rerr := os.Rename(tmpPath, finalPath); rerr != nil {
+				err = fmt.Errorf("failed to rename temp file: %w", rerr)
+			}
+		}
+	}()
+
+	// DEFER 3 (executes FIRST): Close file
+	// If an error already exists, we keep it AND add the close error.
+	// If err is nil, Join(nil, cerr) sets err to cerr.
+	defer func() {
+		if cerr := f.Close(); cerr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close file: %w", cerr))
+		}
+	}()
+
+	// Step 5: Reserve the header blocks. The header is written after the
+	// content, as it contains its size and checksum.
+	if _, err = f.Seek(int64(headerSize), io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek temp file: %w", err)
+	}
+
+	// Step 6: Stream the content
+	if err = writeContent(f); err != nil {
+		return err
+	}
+
+	// Step 7: Encrypt and pad the header to the reserved size
+	encrypted, err := encryptHeader(h, recipients)
+	if err != nil {
+		return err
+	}
+
+	headerPadded, err := header.PadEncryptedSize(encrypted, headerSize)
+	if err != nil {
+		return fmt.Errorf("failed to pad encrypted header: %w", err)
+	}
+
+	// Step 8: Write the encrypted header at the start of the temp file
+	if _, err = f.WriteAt(headerPadded, 0); err != nil {
+		return fmt.Errorf("failed to write encrypted header to file: %w", err)
+	}
+
+	return nil
+}
+
+// newHeader returns a copy of h in the current version, with the content
+// type detected from sniff and the unset times set to now.
+func newHeader(h *header.Header, sniff []byte) *header.Header {
+	hv := *h
+	hv.Version = header.CurrentVersion
+	if hv.ContentType == "" {
+		hv.ContentType = detectContentType(sniff)
+	}
+
+	now := time.Now()
+	if hv.Created.IsZero() {
+		hv.Created = now
+	}
+	if hv.Modified.IsZero() {
+		hv.Modified = now
+	}
+
+	return &hv
+}
+
+// encryptedHeaderSize returns the size of the padded encrypted header h,
+// for any size and checksum of the content.
+func encryptedHeaderSize(h *header.Header, recipients []age.Recipient) (int, error) {
+	largest := *h
+	largest.Size = math.MaxInt64
+	largest.SHA256 = make([]byte, sha256.Size)
+
+	encrypted, err := encryptHeader(&largest, recipients)
+	if err != nil {
+		return 0, err
+	}
+
+	size := header.EncryptedSize(len(encrypted))
+	if size > header.MaxBlocks*header.BlockSize {
+		return 0, fmt.Errorf("%w: encrypted size %d exceeds %d blocks, too many recipients?", header.ErrTooLarge, len(encrypted), header.MaxBlocks)
+	}
+
+	return size, nil
+}
+
+// encryptContent encrypts the content into w, and sets the size and
+// checksum of the content in h.
+func encryptContent(w io.Writer, h *header.Header, content io.Reader, recipients []age.Recipient) error {
+	bufFile := bufio.NewWriter(w)
+
+	ageWr, err := age.Encrypt(bufFile, recipients...)
+	if err != nil {
+		return fmt.Errorf("failed to create age encryptor for content: %w", err)
+	}
+
+	sum := sha256.New()
+	n, err := io.Copy(ageWr, io.TeeReader(content, sum))
+	if err != nil {
+		return errors.Join(fmt.Errorf("failed to copy content: %w", err), ageWr.Close())
+	}
+
+	if err := ageWr.Close(); err != nil {
+		return fmt.Errorf("failed to close content encryptor: %w", err)
+	}
+
+	if err := bufFile.Flush(); err != nil {
+		return fmt.Errorf("failed to flush buffered writer: %w", err)
+	}
+
+	h.Size = n
+	h.SHA256 = sum.Sum(nil)
+
+	return nil
+}
+
+// encryptHeader returns the encrypted header h.
+func encryptHeader(h *header.Header, recipients []age.Recipient) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	ageWr, err := age.Encrypt(buf, recipients...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create age encryptor for header: %w", err)
+	}
+
+	headerBytes, err := h.Pad()
+	if err != nil {
+		// Join the error from Close (if any) with the padding error
+		if closeErr := ageWr.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close header encryptor: %w", closeErr))
+		}
+		return nil, fmt.Errorf("failed to pad header: %w", err)
+	}
+
+	_, err = ageWr.Write(headerBytes)
+	if err != nil {
+		// Join the error from Close (if any) with the write error
+		if closeErr := ageWr.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close header encryptor: %w", closeErr))
+		}
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+
+	if err = ageWr.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close header encryptor: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// sniffLen is the number of bytes of content used to detect its type.
+const sniffLen = 512
+
+// detectContentType returns the MIME type, without parameters, of the
+// content starting with data.
+func detectContentType(data []byte) string {
+	mediaType, _, err := mime.ParseMediaType(http.DetectContentType(data))
+	if err != nil {
+		return ""
+	}
+	return mediaType
+}
+
+// fileName generates the file name of a privage encrypted file.
+// The hash is a function of the header (label and category) and the age
+// public keys of the recipients.
+//
+// The recipients are sorted, so that the name does not depend on who
+// encrypts the file. For a single recipient, the name is the same as for
+// files written by previous versions of privage.
+func fileName(h *header.Header, recipients []string, suffix string) (string, error) {
+	sorted := slices.Sorted(slices.Values(recipients))
+	hashStr, err := h.Hash(strings.Join(slices.Compact(sorted), "\n"))
+	if err != nil {
+		return "", fmt.Errorf("failed to generate header hash: %w", err)
+	}
+	return hashStr + suffix + Extension, nil
+}
@@ -1,7 +1,8 @@
-package main
+package vault
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,7 +14,6 @@ import (
 
 	"github.com/revelaction/privage/header"
 	id "github.com/revelaction/privage/identity"
-	"github.com/revelaction/privage/setup"
 )
 
 // TestEncryptSave_ErrorPathCoverage documents which error paths are tested
@@ -21,42 +21,42 @@ import (
 //
 // TESTED ERROR PATHS:
 // ✓ File creation failure (invalid repository)
-// ✓ File creation failure (read-only repository) 
+// ✓ File creation failure (read-only repository)
 // ✓ File open failure (read-only existing file)
 // ✓ Content reader failure (failingReader)
 // ✓ Content copy failure (via failingReader)
 //
 // DOCUMENTED BUT NOT PREVENTABLE (panics before error handling):
-// • Nil identity (panics at s.Id.Id.Recipient())
+// • Nil identity (panics at v.id.Recipient)
 // • Nil header (panics at h.Pad())
-// • Nil setup (panics at various points)
+// • Nil vault (panics at various points)
 //
-// We trust the internal caller has validated the inputs
+// # We trust the internal caller has validated the inputs
 //
 // DIFFICULT TO TEST WITHOUT MOCKING:
 // • age.Encrypt() failure for header (requires mocking age library)
 // • ageWr.Write() failure on memory buffer (memory writes rarely fail)
 // • ageWr.Close() failure on memory buffer (memory operations rarely fail)
-// • header.PadEncrypted() failure (depends on header package implementation)
-// • ageContentWr.Close() failure in defer (age library internal errors)
-// • bufFile.Flush() failure in defer (requires disk exhaustion)
+// • ageWr.Close() failure for content (age library internal errors)
+// • bufFile.Flush() failure (requires disk exhaustion)
 // • f.Write(headerPadded) failure after successful open (requires disk exhaustion mid-write)
 //
 // PANICS:
 // We trust the internal caller has validated the inputs
 // If you want to prevent panics with nil inputs, add validation at the start:
-//   if h == nil {
-//       return fmt.Errorf("header cannot be nil")
-//   }
-//   if s == nil || s.Id.Id == nil {
-//       return fmt.Errorf("setup or identity cannot be nil")
-//   }
+//
+//	if h == nil {
+//	    return fmt.Errorf("header cannot be nil")
+//	}
+//	if v == nil || v.id.Id == nil {
+//	    return fmt.Errorf("vault or identity cannot be nil")
+//	}
 //
 // These untestable paths exist for defensive programming and would be
 // covered by integration tests or real failure scenarios (disk full, etc.).
 func TestEncryptSave_ErrorPathCoverage(t *testing.T) {
 	t.Log("See function documentation for error path coverage analysis")
-	
+
 	// Show current coverage percentage
 	// The truly untestable paths (memory operations failing, age library internals)
 	// represent edge cases that are defensive programming rather than realistic failures
@@ -80,25 +80,23 @@ func TestEncryptSave_HappyPath(t *testing.T) {
 		Category: "banking",
 	}
 
-	// Create test setup
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	// Create test vault
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	// Test content
 	content := strings.NewReader("secret password content")
 
 	// Execute: encrypt and save
-	err = encryptSave(h, "", content, s)
+	err = v.encryptSave(h, "", content)
 	if err != nil {
 		t.Fatalf("encryptSave failed: %v", err)
 	}
 
 	// Verify: file was created with expected name
-	expectedFileName, err := fileName(h, s.Id, "")
+	expectedFileName, err := fileName(h, v.Recipients(), "")
 	if err != nil {
 		t.Fatalf("fileName failed: %v", err)
 	}
@@ -128,7 +126,7 @@ func TestEncryptSave_HappyPath(t *testing.T) {
 	// The file contains: [padded encrypted header][encrypted content]
 	// We need to skip the header part and decrypt the content
 	// Header size after padding is known (from header.PadEncrypted)
-	
+
 	// For this test, we just verify the file exists and has content
 	// A more thorough test would decrypt and verify the content matches
 	t.Logf("Successfully created encrypted file: %s (%d bytes)", filePath, len(encryptedData))
@@ -148,23 +146,21 @@ func TestEncryptSave_EmptyContent(t *testing.T) {
 		Category: "test",
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	// Empty content
 	content := strings.NewReader("")
 
-	err = encryptSave(h, "", content, s)
+	err = v.encryptSave(h, "", content)
 	if err != nil {
 		t.Fatalf("encryptSave with empty content failed: %v", err)
 	}
 
 	// Verify file exists
-	expectedFileName, err := fileName(h, s.Id, "")
+	expectedFileName, err := fileName(h, v.Recipients(), "")
 	if err != nil {
 		t.Fatalf("fileName failed: %v", err)
 	}
@@ -190,23 +186,21 @@ func TestEncryptSave_LargeContent(t *testing.T) {
 		Category: "documents",
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	// Create 1MB of content
 	largeContent := bytes.Repeat([]byte("x"), 1024*1024)
 	content := bytes.NewReader(largeContent)
 
-	err = encryptSave(h, "", content, s)
+	err = v.encryptSave(h, "", content)
 	if err != nil {
 		t.Fatalf("encryptSave with large content failed: %v", err)
 	}
 
-	expectedFileName, err := fileName(h, s.Id, "")
+	expectedFileName, err := fileName(h, v.Recipients(), "")
 	if err != nil {
 		t.Fatalf("fileName failed: %v", err)
 	}
@@ -238,16 +232,14 @@ func TestEncryptSave_InvalidRepository(t *testing.T) {
 	}
 
 	// Use non-existent directory
-	s := &setup.Setup{
-		Repository: "/nonexistent/directory/that/does/not/exist",
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: "/nonexistent/directory/that/does/not/exist",
+		id:         id.New(identity, ""),
 	}
 
 	content := strings.NewReader("test content")
 
-	err = encryptSave(h, "", content, s)
+	err = v.encryptSave(h, "", content)
 	if err == nil {
 		t.Fatal("expected error with invalid repository, got nil")
 	}
@@ -286,16 +278,14 @@ func TestEncryptSave_ReadOnlyRepository(t *testing.T) {
 		Category: "test",
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	content := strings.NewReader("test content")
 
-	err = encryptSave(h, "", content, s)
+	err = v.encryptSave(h, "", content)
 	if err == nil {
 		t.Fatal("expected error with read-only repository, got nil")
 	}
@@ -350,11 +340,9 @@ func TestEncryptSave_ContentReadFailure(t *testing.T) {
 		Category: "test",
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	// Create a reader that fails after 100 bytes
@@ -363,7 +351,7 @@ func TestEncryptSave_ContentReadFailure(t *testing.T) {
 		failAfter: 100,
 	}
 
-	err = encryptSave(h, "", content, s)
+	err = v.encryptSave(h, "", content)
 	if err == nil {
 		t.Fatal("expected error when content reading fails, got nil")
 	}
@@ -374,7 +362,7 @@ func TestEncryptSave_ContentReadFailure(t *testing.T) {
 	}
 
 	// Verify partial file exists (we don't delete on error)
-	expectedFileName, err := fileName(h, s.Id, "")
+	expectedFileName, err := fileName(h, v.Recipients(), "")
 	if err != nil {
 		t.Fatalf("fileName failed: %v", err)
 	}
@@ -401,21 +389,19 @@ func TestEncryptSave_FileOverwrite(t *testing.T) {
 		Category: "test",
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	// First write
 	content1 := strings.NewReader("first content")
-	err = encryptSave(h, "", content1, s)
+	err = v.encryptSave(h, "", content1)
 	if err != nil {
 		t.Fatalf("first encryptSave failed: %v", err)
 	}
 
-	expectedFileName, err := fileName(h, s.Id, "")
+	expectedFileName, err := fileName(h, v.Recipients(), "")
 	if err != nil {
 		t.Fatalf("fileName failed: %v", err)
 	}
@@ -428,7 +414,7 @@ func TestEncryptSave_FileOverwrite(t *testing.T) {
 
 	// Second write with different content (same header = same filename)
 	content2 := strings.NewReader("second content that is much longer")
-	err = encryptSave(h, "", content2, s)
+	err = v.encryptSave(h, "", content2)
 	if err != nil {
 		t.Fatalf("second encryptSave failed: %v", err)
 	}
@@ -453,12 +439,12 @@ func TestFileName(t *testing.T) {
 		t.Fatalf("failed to generate test identity: %v", err)
 	}
 
-	testID := id.Identity{Id: identity}
+	testID := []string{identity.Recipient().String()}
 
 	tests := []struct {
-		name     string
-		header   *header.Header
-		suffix   string
+		name   string
+		header *header.Header
+		suffix string
 	}{
 		{
 			name: "simple header",
@@ -494,12 +480,12 @@ func TestFileName(t *testing.T) {
 			}
 
 			// Verify filename format: [64 hex chars][suffix]
-			if !strings.HasSuffix(name, tt.suffix+PrivageExtension) {
+			if !strings.HasSuffix(name, tt.suffix+Extension) {
 				t.Errorf("filename doesn't have expected suffix: %s", name)
 			}
 
 			// Verify it's a valid hex string before suffix
-			withoutSuffix := strings.TrimSuffix(name, tt.suffix+PrivageExtension)
+			withoutSuffix := strings.TrimSuffix(name, tt.suffix+Extension)
 			if len(withoutSuffix) != 64 {
 				t.Errorf("hash part length = %d, want 64", len(withoutSuffix))
 			}
@@ -525,7 +511,7 @@ func TestFileName_Uniqueness(t *testing.T) {
 		t.Fatalf("failed to generate test identity: %v", err)
 	}
 
-	testID := id.Identity{Id: identity}
+	testID := []string{identity.Recipient().String()}
 
 	h1 := &header.Header{Label: "password1", Category: "work"}
 	h2 := &header.Header{Label: "password2", Category: "work"}
@@ -570,11 +556,11 @@ func TestFileName_IncludesIdentity(t *testing.T) {
 
 	h := &header.Header{Label: "password", Category: "work"}
 
-	name1, err := fileName(h, id.Identity{Id: identity1}, "")
+	name1, err := fileName(h, []string{identity1.Recipient().String()}, "")
 	if err != nil {
 		t.Fatalf("fileName 1 failed: %v", err)
 	}
-	name2, err := fileName(h, id.Identity{Id: identity2}, "")
+	name2, err := fileName(h, []string{identity2.Recipient().String()}, "")
 	if err != nil {
 		t.Fatalf("fileName 2 failed: %v", err)
 	}
@@ -593,11 +579,9 @@ func TestEncryptSave_DifferentHeaders(t *testing.T) {
 		t.Fatalf("failed to generate test identity: %v", err)
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	headers := []*header.Header{
@@ -611,12 +595,12 @@ func TestEncryptSave_DifferentHeaders(t *testing.T) {
 		// Reset reader for each iteration
 		content := strings.NewReader("test content")
 
-		err := encryptSave(h, "", content, s)
+		err := v.encryptSave(h, "", content)
 		if err != nil {
 			t.Fatalf("encryptSave for header %d failed: %v", i, err)
 		}
 
-		filename, err := fileName(h, s.Id, "")
+		filename, err := fileName(h, v.Recipients(), "")
 		if err != nil {
 			t.Fatalf("fileName failed: %v", err)
 		}
@@ -651,12 +635,12 @@ func TestEncryptSave_DifferentHeaders(t *testing.T) {
 // typically doesn't fail. This test documents the limitation.
 func TestEncryptSave_HeaderWriteError(t *testing.T) {
 	t.Skip("Skipping: ageWr.Write() to memory buffer rarely fails - difficult to test this path")
-	
+
 	// To properly test this, we would need:
 	// 1. A way to inject a failing writer into age.Encrypt()
 	// 2. Or a way to make the memory buffer fail (not possible with standard bytes.Buffer)
 	// 3. Or use dependency injection to mock the age encryptor
-	
+
 	// This error path exists for defensive programming but is hard to trigger in practice.
 }
 
@@ -664,32 +648,50 @@ func TestEncryptSave_HeaderWriteError(t *testing.T) {
 // Note: This is also difficult to trigger with in-memory encryption.
 func TestEncryptSave_HeaderCloseError(t *testing.T) {
 	t.Skip("Skipping: ageWr.Close() on memory buffer rarely fails - difficult to test this path")
-	
+
 	// Similar to above, age.Close() on a memory-backed writer typically succeeds.
 	// To test this we would need to:
 	// 1. Mock the age encryption library
 	// 2. Or inject a failing writer
-	
+
 	// This error path exists for robustness but is hard to test without mocking.
 }
 
-// TestEncryptSave_PadEncryptedError tests header padding failure.
-// This depends on what header.PadEncrypted() actually does and when it fails.
+// TestEncryptSave_PadEncryptedError tests that a header that does not fit
+// in the header block is rejected, and no file is left.
 func TestEncryptSave_PadEncryptedError(t *testing.T) {
-	// This test depends on the implementation of header.PadEncrypted()
-	// If that function can fail (e.g., with malformed input), we should test it.
-	
-	// Example approach if PadEncrypted fails on certain inputs:
-	t.Skip("Skipping: Requires knowledge of header.PadEncrypted() failure modes")
-	
-	// If header.PadEncrypted() can return errors for certain encrypted data,
-	// we would need to:
-	// 1. Understand what inputs cause it to fail
-	// 2. Craft a scenario that produces such inputs
-	// 3. Verify the error is properly wrapped and returned
-	
-	// Without seeing the header package implementation, this is difficult to test.
+	tempDir := t.TempDir()
+
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatalf("failed to generate test identity: %v", err)
+	}
+
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
+	}
+
+	h := &header.Header{
+		Label:    "test",
+		Category: "test",
+		Tags:     []string{strings.Repeat("t", 200), strings.Repeat("u", 200), strings.Repeat("v", 200)},
+	}
+
+	err = v.encryptSave(h, "", strings.NewReader("content"))
+	if !errors.Is(err, header.ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("expected no files left in repository, got %d", len(entries))
+	}
 }
+
 // TestEncryptSave_NilIdentity tests behavior with nil identity.
 // Currently this panics rather than returning an error - documenting actual behavior.
 func TestEncryptSave_NilIdentity(t *testing.T) {
@@ -701,28 +703,28 @@ func TestEncryptSave_NilIdentity(t *testing.T) {
 	}
 
 	// Setup with nil identity
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id:         id.Identity{}, // Empty identity, Id will be nil
+	v := &Vault{
+		repository: tempDir,
+		id:         id.Identity{}, // Empty identity, Id will be nil
 	}
 
 	content := strings.NewReader("test content")
 
-	// This currently panics because s.Id.Id.Recipient() dereferences nil
+	// This currently panics because v.id.Recipient dereferences nil
 	// We catch the panic to document this behavior
 	defer func() {
 		if r := recover(); r != nil {
 			t.Logf("Function panics with nil identity (current behavior): %v", r)
 			// This documents that the function doesn't validate inputs
 			// In production code, you might want to add validation like:
-			// if s == nil || s.Id.Id == nil {
-			//     return fmt.Errorf("invalid setup: nil identity")
+			// if v == nil || v.id.Id == nil {
+			//     return fmt.Errorf("invalid vault: nil identity")
 			// }
 		}
 	}()
 
-	err := encryptSave(h, "", content, s)
-	
+	err := v.encryptSave(h, "", content)
+
 	// If we reach here without panic, check for error
 	if err == nil {
 		t.Fatal("expected error with nil identity, got nil")
@@ -740,11 +742,9 @@ func TestEncryptSave_NilHeader(t *testing.T) {
 		t.Fatalf("failed to generate test identity: %v", err)
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	content := strings.NewReader("test content")
@@ -756,8 +756,8 @@ func TestEncryptSave_NilHeader(t *testing.T) {
 		}
 	}()
 
-	err = encryptSave(nil, "", content, s)
-	
+	err = v.encryptSave(nil, "", content)
+
 	if err == nil {
 		t.Fatal("expected error with nil header, got nil")
 	}
@@ -771,10 +771,10 @@ func TestEncryptSave_NilHeader(t *testing.T) {
 // that input validation could be added if desired.
 func TestEncryptSave_InputValidation(t *testing.T) {
 	t.Log("encryptSave() currently does not validate inputs")
-	t.Log("Passing nil header, nil identity, or nil setup will cause panics")
+	t.Log("Passing nil header, nil identity, or nil vault will cause panics")
 	t.Log("If input validation is desired, add checks like:")
 	t.Log("  if h == nil { return fmt.Errorf(\"header cannot be nil\") }")
-	t.Log("  if s == nil || s.Id.Id == nil { return fmt.Errorf(\"invalid setup\") }")
+	t.Log("  if v == nil || v.id.Id == nil { return fmt.Errorf(\"invalid vault\") }")
 }
 
 // TestEncryptSave_HeaderFileWriteError tests failure when writing header to file.
@@ -795,16 +795,14 @@ func TestEncryptSave_HeaderFileWriteError(t *testing.T) {
 		Category: "test",
 	}
 
-	s := &setup.Setup{
-		Repository: tempDir,
-		Id: id.Identity{
-			Id: identity,
-		},
+	v := &Vault{
+		repository: tempDir,
+		id:         id.New(identity, ""),
 	}
 
 	// Pre-create the temp file and make it read-only
 	// This simulates a collision or permission issue with the temporary file
-	expectedFileName, err := fileName(h, s.Id, "")
+	expectedFileName, err := fileName(h, v.Recipients(), "")
 	if err != nil {
 		t.Fatalf("fileName failed: %v", err)
 	}
@@ -831,7 +829,7 @@ func TestEncryptSave_HeaderFileWriteError(t *testing.T) {
 
 	content := strings.NewReader("test content")
 
-	err = encryptSave(h, "", content, s)
+	err = v.encryptSave(h, "", content)
 	if err == nil {
 		t.Fatal("expected error when writing to read-only file, got nil")
 	}
@@ -841,3 +839,37 @@ func TestEncryptSave_HeaderFileWriteError(t *testing.T) {
 		t.Logf("Got error (may vary by OS): %v", err)
 	}
 }
+
+// TestFileName_Recipients verifies that the file name does not depend on the
+// order of the recipients, and is the legacy one for a single recipient.
+func TestFileName_Recipients(t *testing.T) {
+	h := &header.Header{Label: "password", Category: "work"}
+	a, b := "age1aaa", "age1bbb"
+
+	ab, err := fileName(h, []string{a, b}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ba, err := fileName(h, []string{b, a, b}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ab != ba {
+		t.Errorf("file name depends on the order of the recipients: %s != %s", ab, ba)
+	}
+
+	single, err := fileName(h, []string{a}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := h.Hash(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if single != hash+Extension {
+		t.Errorf("expected legacy file name %s, got %s", hash+Extension, single)
+	}
+	if single == ab {
+		t.Error("different recipients produced same filename")
+	}
+}